@@ -0,0 +1,161 @@
+package asset
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// RpcPrecision is the number of decimals elementsd uses for amounts of all assets
+const RpcPrecision = 8
+
+var (
+	ErrInvalidAmount  = errors.New("invalid amount")
+	ErrAmountOverflow = errors.New("amount overflows")
+	ErrAssetMismatch  = errors.New("asset mismatch")
+)
+
+// AssetAmount is an amount of an asset in base units, the precision is used for display and parsing
+type AssetAmount struct {
+	AssetId   string
+	Precision uint8
+	Amount    uint64
+}
+
+func NewAssetAmount(assetId string, precision uint8, amount uint64) AssetAmount {
+	return AssetAmount{AssetId: assetId, Precision: precision, Amount: amount}
+}
+
+// NewAmount returns an amount of the asset in base units
+func (a *AssetEntry) NewAmount(amount uint64) AssetAmount {
+	return NewAssetAmount(a.AssetId, a.Precision, amount)
+}
+
+// ParseAmount parses a decimal amount of the asset, e.g. "12.5"
+func (a *AssetEntry) ParseAmount(amount string) (AssetAmount, error) {
+	return ParseAssetAmount(a.AssetId, a.Precision, amount)
+}
+
+// ParseAssetAmount parses a decimal amount string with at most precision decimals into base units
+func ParseAssetAmount(assetId string, precision uint8, amount string) (AssetAmount, error) {
+	baseUnits, err := ParseDecimal(amount, precision)
+	if err != nil {
+		return AssetAmount{}, err
+	}
+	return NewAssetAmount(assetId, precision, baseUnits), nil
+}
+
+// ParseDecimal parses a non negative decimal string into base units without going through floats
+func ParseDecimal(amount string, precision uint8) (uint64, error) {
+	if precision > 18 {
+		return 0, fmt.Errorf("%w: precision %v is too large", ErrInvalidAmount, precision)
+	}
+	amount = strings.TrimSpace(amount)
+	intPart, fracPart := amount, ""
+	if i := strings.IndexByte(amount, '.'); i >= 0 {
+		intPart, fracPart = amount[:i], amount[i+1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, amount)
+	}
+	if intPart == "" {
+		intPart = "0"
+	}
+	if !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, amount)
+	}
+	fracPart = strings.TrimRight(fracPart, "0")
+	if len(fracPart) > int(precision) {
+		return 0, fmt.Errorf("%w: %q has more than %v decimals", ErrInvalidAmount, amount, precision)
+	}
+	fracPart += strings.Repeat("0", int(precision)-len(fracPart))
+
+	whole, err := strconv.ParseUint(intPart, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrAmountOverflow, amount)
+	}
+	var frac uint64
+	if fracPart != "" {
+		frac, err = strconv.ParseUint(fracPart, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, amount)
+		}
+	}
+	hi, lo := bits.Mul64(whole, pow10(precision))
+	if hi != 0 {
+		return 0, fmt.Errorf("%w: %q", ErrAmountOverflow, amount)
+	}
+	total, carry := bits.Add64(lo, frac, 0)
+	if carry != 0 {
+		return 0, fmt.Errorf("%w: %q", ErrAmountOverflow, amount)
+	}
+	return total, nil
+}
+
+// FormatDecimal formats base units as a decimal string with exactly precision decimals
+func FormatDecimal(amount uint64, precision uint8) string {
+	if precision == 0 {
+		return strconv.FormatUint(amount, 10)
+	}
+	unit := pow10(precision)
+	return fmt.Sprintf("%d.%0*d", amount/unit, int(precision), amount%unit)
+}
+
+// ParseRpcAmount parses an elementsd rpc amount into base units
+func ParseRpcAmount(amount string) (uint64, error) {
+	return ParseDecimal(amount, RpcPrecision)
+}
+
+// String returns the amount as decimal string in whole units of the asset
+func (a AssetAmount) String() string {
+	return FormatDecimal(a.Amount, a.Precision)
+}
+
+// RpcString returns the amount in the format elementsd expects for rpc calls
+func (a AssetAmount) RpcString() string {
+	return FormatDecimal(a.Amount, RpcPrecision)
+}
+
+// TxAsset returns the unblinded transaction output asset
+func (a AssetAmount) TxAsset() ([]byte, error) {
+	return TxAssetFromId(a.AssetId)
+}
+
+// Add returns the sum of two amounts of the same asset
+func (a AssetAmount) Add(b AssetAmount) (AssetAmount, error) {
+	if a.AssetId != b.AssetId {
+		return AssetAmount{}, ErrAssetMismatch
+	}
+	sum, carry := bits.Add64(a.Amount, b.Amount, 0)
+	if carry != 0 {
+		return AssetAmount{}, ErrAmountOverflow
+	}
+	return NewAssetAmount(a.AssetId, a.Precision, sum), nil
+}
+
+// Sub returns the difference of two amounts of the same asset
+func (a AssetAmount) Sub(b AssetAmount) (AssetAmount, error) {
+	if a.AssetId != b.AssetId {
+		return AssetAmount{}, ErrAssetMismatch
+	}
+	if b.Amount > a.Amount {
+		return AssetAmount{}, fmt.Errorf("%w: %v is smaller than %v", ErrInvalidAmount, a, b)
+	}
+	return NewAssetAmount(a.AssetId, a.Precision, a.Amount-b.Amount), nil
+}
+
+func pow10(precision uint8) uint64 {
+	return uint64(math.Pow10(int(precision)))
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
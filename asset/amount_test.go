@@ -0,0 +1,64 @@
+package asset
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		amount    string
+		precision uint8
+		want      uint64
+		err       error
+	}{
+		{"1", 8, 100000000, nil},
+		{"0.00000050", 8, 50, nil},
+		{"0.00000001", 8, 1, nil},
+		{"12.5", 2, 1250, nil},
+		{".5", 1, 5, nil},
+		{"42", 0, 42, nil},
+		{"1.10", 1, 11, nil},
+		{"184467440737.09551615", 8, 18446744073709551615, nil},
+		{"184467440737.09551616", 8, 0, ErrAmountOverflow},
+		{"0.000000001", 8, 0, ErrInvalidAmount},
+		{"1.5", 0, 0, ErrInvalidAmount},
+		{"-1", 8, 0, ErrInvalidAmount},
+		{"1e-5", 8, 0, ErrInvalidAmount},
+		{".", 8, 0, ErrInvalidAmount},
+		{"", 8, 0, ErrInvalidAmount},
+	}
+	for _, tt := range tests {
+		got, err := ParseDecimal(tt.amount, tt.precision)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("ParseDecimal(%q, %v) error = %v, want %v", tt.amount, tt.precision, err, tt.err)
+		}
+		if got != tt.want {
+			t.Fatalf("ParseDecimal(%q, %v) = %v, want %v", tt.amount, tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestFormatDecimal(t *testing.T) {
+	tests := []struct {
+		amount    uint64
+		precision uint8
+		want      string
+	}{
+		{50, 8, "0.00000050"},
+		{123456789, 8, "1.23456789"},
+		{1250, 2, "12.50"},
+		{42, 0, "42"},
+		{18446744073709551615, 8, "184467440737.09551615"},
+	}
+	for _, tt := range tests {
+		got := FormatDecimal(tt.amount, tt.precision)
+		if got != tt.want {
+			t.Fatalf("FormatDecimal(%v, %v) = %s, want %s", tt.amount, tt.precision, got, tt.want)
+		}
+		parsed, err := ParseDecimal(got, tt.precision)
+		if err != nil || parsed != tt.amount {
+			t.Fatalf("roundtrip of %v failed: %v %v", tt.amount, parsed, err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package asset
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/network"
+)
+
+const (
+	LiquidUsdt  = "ce091c998b83c78bb71a632313ba3760f1763d9cfcffae02258ffa9865a37bd2"
+	TestnetUsdt = "38fca2d939696061a8f76d4e6b5eecd54e3b4221c846f24a6b279e79952850a5"
+)
+
+var (
+	ErrAssetNotFound = errors.New("asset not found in registry")
+)
+
+// AssetEntity is the issuer entity of an asset in the blockstream asset registry
+type AssetEntity struct {
+	Domain string `json:"domain"`
+}
+
+// AssetEntry is a single asset in the blockstream asset registry format
+type AssetEntry struct {
+	AssetId   string       `json:"asset_id"`
+	Ticker    string       `json:"ticker"`
+	Name      string       `json:"name"`
+	Precision uint8        `json:"precision"`
+	Entity    *AssetEntity `json:"entity,omitempty"`
+}
+
+// Domain returns the issuer domain of the asset
+func (a *AssetEntry) Domain() string {
+	if a.Entity == nil {
+		return ""
+	}
+	return a.Entity.Domain
+}
+
+func (a *AssetEntry) String() string {
+	return fmt.Sprintf("%s (%s)", a.Ticker, a.AssetId)
+}
+
+// AssetRegistry holds the known assets indexed by asset id and ticker
+type AssetRegistry struct {
+	byId     map[string]*AssetEntry
+	byTicker map[string]*AssetEntry
+}
+
+func NewAssetRegistry(entries ...*AssetEntry) (*AssetRegistry, error) {
+	registry := &AssetRegistry{
+		byId:     make(map[string]*AssetEntry),
+		byTicker: make(map[string]*AssetEntry),
+	}
+	for _, v := range entries {
+		err := registry.Add(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// DefaultAssetRegistry returns a registry containing the policy asset and the well known assets of the network
+func DefaultAssetRegistry(liquidNetwork *network.Network) *AssetRegistry {
+	entries := []*AssetEntry{
+		{AssetId: liquidNetwork.AssetID, Ticker: "L-BTC", Name: "Liquid Bitcoin", Precision: 8},
+	}
+	switch liquidNetwork.Name {
+	case network.Liquid.Name:
+		entries = append(entries, &AssetEntry{AssetId: LiquidUsdt, Ticker: "USDt", Name: "Tether USD", Precision: 8, Entity: &AssetEntity{Domain: "tether.to"}})
+	case network.Testnet.Name:
+		entries = append(entries, &AssetEntry{AssetId: TestnetUsdt, Ticker: "USDt", Name: "Tether USD", Precision: 8, Entity: &AssetEntity{Domain: "tether.to"}})
+	}
+	registry, _ := NewAssetRegistry(entries...)
+	return registry
+}
+
+// LoadAssetRegistry returns the default registry of the network extended with the entries from a registry file.
+// The file is either a json list of entries or a json object mapping asset ids to entries, as served by the blockstream asset registry
+func LoadAssetRegistry(path string, liquidNetwork *network.Network) (*AssetRegistry, error) {
+	registry := DefaultAssetRegistry(liquidNetwork)
+	fileBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseRegistryJson(fileBytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse asset registry %s: %w", path, err)
+	}
+	for _, v := range entries {
+		err = registry.Add(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// LoadAssetRegistryOrDefault loads the registry file if it exists and falls back to the default registry otherwise
+func LoadAssetRegistryOrDefault(path string, liquidNetwork *network.Network) (*AssetRegistry, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return DefaultAssetRegistry(liquidNetwork), nil
+	}
+	return LoadAssetRegistry(path, liquidNetwork)
+}
+
+func parseRegistryJson(fileBytes []byte) ([]*AssetEntry, error) {
+	var entryList []*AssetEntry
+	if err := json.Unmarshal(fileBytes, &entryList); err == nil {
+		return entryList, nil
+	}
+	var entryMap map[string]*AssetEntry
+	if err := json.Unmarshal(fileBytes, &entryMap); err != nil {
+		return nil, err
+	}
+	for k, v := range entryMap {
+		if v.AssetId == "" {
+			v.AssetId = k
+		}
+		if v.AssetId != k {
+			return nil, fmt.Errorf("asset id mismatch for %s", k)
+		}
+		entryList = append(entryList, v)
+	}
+	return entryList, nil
+}
+
+// Add adds an entry to the registry, an existing entry with the same asset id is replaced
+func (r *AssetRegistry) Add(entry *AssetEntry) error {
+	if len(entry.AssetId) != 64 {
+		return fmt.Errorf("invalid asset id %s", entry.AssetId)
+	}
+	if entry.Ticker == "" {
+		return fmt.Errorf("missing ticker for asset %s", entry.AssetId)
+	}
+	if entry.Precision > 8 {
+		return fmt.Errorf("invalid precision %v for asset %s", entry.Precision, entry.AssetId)
+	}
+	entry.AssetId = strings.ToLower(entry.AssetId)
+	if existing, ok := r.byTicker[strings.ToLower(entry.Ticker)]; ok && existing.AssetId != entry.AssetId {
+		return fmt.Errorf("ticker %s is already used by asset %s", entry.Ticker, existing.AssetId)
+	}
+	if existing, ok := r.byId[entry.AssetId]; ok {
+		delete(r.byTicker, strings.ToLower(existing.Ticker))
+	}
+	r.byId[entry.AssetId] = entry
+	r.byTicker[strings.ToLower(entry.Ticker)] = entry
+	return nil
+}
+
+// Get returns the entry for an asset id
+func (r *AssetRegistry) Get(assetId string) (*AssetEntry, error) {
+	if entry, ok := r.byId[strings.ToLower(assetId)]; ok {
+		return entry, nil
+	}
+	return nil, ErrAssetNotFound
+}
+
+// GetByTicker returns the entry for a ticker, tickers are case insensitive
+func (r *AssetRegistry) GetByTicker(ticker string) (*AssetEntry, error) {
+	if entry, ok := r.byTicker[strings.ToLower(ticker)]; ok {
+		return entry, nil
+	}
+	return nil, ErrAssetNotFound
+}
+
+// Lookup returns the entry for either an asset id or a ticker
+func (r *AssetRegistry) Lookup(idOrTicker string) (*AssetEntry, error) {
+	if entry, err := r.Get(idOrTicker); err == nil {
+		return entry, nil
+	}
+	return r.GetByTicker(idOrTicker)
+}
+
+// Entries returns all entries sorted by ticker
+func (r *AssetRegistry) Entries() []*AssetEntry {
+	entries := make([]*AssetEntry, 0, len(r.byId))
+	for _, v := range r.byId {
+		entries = append(entries, v)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Ticker < entries[j].Ticker
+	})
+	return entries
+}
+
+// IdFromTxAsset returns the hex asset id of an unblinded transaction output asset
+func IdFromTxAsset(txAsset []byte) (string, error) {
+	if len(txAsset) != 33 || txAsset[0] != 0x01 {
+		return "", errors.New("expected 33 byte unconfidential asset")
+	}
+	return hex.EncodeToString(elementsutil.ReverseBytes(txAsset[1:])), nil
+}
+
+// TxAssetFromId returns the unblinded transaction output asset of a hex asset id
+func TxAssetFromId(assetId string) ([]byte, error) {
+	idBytes, err := hex.DecodeString(assetId)
+	if err != nil {
+		return nil, err
+	}
+	if len(idBytes) != 32 {
+		return nil, fmt.Errorf("invalid asset id %s", assetId)
+	}
+	return append([]byte{0x01}, elementsutil.ReverseBytes(idBytes)...), nil
+}
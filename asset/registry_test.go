@@ -0,0 +1,123 @@
+package asset
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vulpemventures/go-elements/network"
+)
+
+const regtestUsdt = "2dcf5a8834645654911964ec3602426fd3b9b4017554d3f9c19403e7fc1411d3"
+
+func TestAssetRegistryLookup(t *testing.T) {
+	registry, err := LoadAssetRegistry(filepath.Join("..", "regtest-assets.json"), &network.Regtest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		idOrTicker string
+		want       string
+		err        error
+	}{
+		{regtestUsdt, regtestUsdt, nil},
+		{strings.ToUpper(regtestUsdt), regtestUsdt, nil},
+		{"USDt", regtestUsdt, nil},
+		{"usdt", regtestUsdt, nil},
+		{network.Regtest.AssetID, network.Regtest.AssetID, nil},
+		{"L-BTC", network.Regtest.AssetID, nil},
+		{"EUR", "", ErrAssetNotFound},
+		{TestnetUsdt, "", ErrAssetNotFound},
+	}
+	for _, tt := range tests {
+		entry, err := registry.Lookup(tt.idOrTicker)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("Lookup(%q) error = %v, want %v", tt.idOrTicker, err, tt.err)
+		}
+		if err == nil && entry.AssetId != tt.want {
+			t.Fatalf("Lookup(%q) = %s, want %s", tt.idOrTicker, entry.AssetId, tt.want)
+		}
+	}
+
+	// Get only accepts asset ids and GetByTicker only tickers
+	if _, err := registry.Get("USDt"); !errors.Is(err, ErrAssetNotFound) {
+		t.Fatalf("Get by ticker returned %v, want ErrAssetNotFound", err)
+	}
+	if _, err := registry.GetByTicker(regtestUsdt); !errors.Is(err, ErrAssetNotFound) {
+		t.Fatalf("GetByTicker by asset id returned %v, want ErrAssetNotFound", err)
+	}
+	entry, err := registry.Get(regtestUsdt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Precision != 8 || entry.Domain() != "tether.to" {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+}
+
+func TestAssetRegistryAdd(t *testing.T) {
+	registry := DefaultAssetRegistry(&network.Liquid)
+	if _, err := registry.Lookup("USDt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a ticker can not be used by two assets
+	err := registry.Add(&AssetEntry{AssetId: TestnetUsdt, Ticker: "usdt", Precision: 8})
+	if err == nil {
+		t.Fatal("expected the duplicate ticker to be rejected")
+	}
+
+	// replacing an entry releases its previous ticker
+	err = registry.Add(&AssetEntry{AssetId: strings.ToUpper(LiquidUsdt), Ticker: "USDT-L", Precision: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.GetByTicker("USDt"); !errors.Is(err, ErrAssetNotFound) {
+		t.Fatalf("previous ticker still resolves, got %v", err)
+	}
+	entry, err := registry.GetByTicker("usdt-l")
+	if err != nil || entry.AssetId != LiquidUsdt {
+		t.Fatalf("got %+v, %v", entry, err)
+	}
+	if len(registry.Entries()) != 2 {
+		t.Fatalf("got %v entries, want 2", len(registry.Entries()))
+	}
+
+	invalid := []*AssetEntry{
+		{AssetId: "00", Ticker: "SHORT"},
+		{AssetId: TestnetUsdt},
+		{AssetId: TestnetUsdt, Ticker: "PREC", Precision: 9},
+	}
+	for _, v := range invalid {
+		if err := registry.Add(v); err == nil {
+			t.Fatalf("expected %+v to be rejected", v)
+		}
+	}
+}
+
+func TestParseRegistryJson(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "assets.json")
+	// the blockstream asset registry maps asset ids to entries
+	err := os.WriteFile(path, []byte(`{"`+regtestUsdt+`": {"ticker": "USDt", "name": "Tether USD", "precision": 8}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err := LoadAssetRegistry(path, &network.Regtest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := registry.GetByTicker("USDt")
+	if err != nil || entry.AssetId != regtestUsdt {
+		t.Fatalf("got %+v, %v", entry, err)
+	}
+
+	err = os.WriteFile(path, []byte(`{"`+regtestUsdt+`": {"asset_id": "`+TestnetUsdt+`", "ticker": "USDt"}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAssetRegistry(path, &network.Regtest); err == nil {
+		t.Fatal("expected the asset id mismatch to be rejected")
+	}
+}
@@ -0,0 +1,991 @@
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sputn1ck/liquid-go-lightwallet/wallet"
+	elemaddr "github.com/vulpemventures/go-elements/address"
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+const (
+	electrumClientName      = "liquid-go-lightwallet"
+	electrumProtocolVersion = "1.4"
+
+	// electrumMaxBatchSize limits the requests sent in one json rpc batch
+	electrumMaxBatchSize = 100
+
+	// electrumChainTxsPerPage is the number of confirmed transactions of an address page, as in esplora
+	electrumChainTxsPerPage = 25
+
+	// elementsHeaderTimeOffset is the offset of the block time in an elements block header
+	elementsHeaderTimeOffset = 68
+)
+
+var (
+	ErrElectrumClosed = errors.New("electrum client is closed")
+)
+
+// ElectrumError is an error returned by the electrum server
+type ElectrumError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ElectrumError) Error() string {
+	return fmt.Sprintf("electrum: %s (%v)", e.Message, e.Code)
+}
+
+// ElectrumConfig configures the connection of the electrum client
+type ElectrumConfig struct {
+	// Timeout is the timeout of dialing and of a single request
+	Timeout time.Duration
+
+	// PingInterval is the interval of keepalive pings, a dropped connection is reconnected by the ping
+	PingInterval time.Duration
+
+	// TLSConfig is used for ssl:// urls, the system roots are used if it is nil
+	TLSConfig *tls.Config
+
+	// TxCacheSize is the number of fetched transactions that are kept, the least recently used ones are evicted
+	TxCacheSize int
+}
+
+func DefaultElectrumConfig() *ElectrumConfig {
+	return &ElectrumConfig{
+		Timeout:      30 * time.Second,
+		PingInterval: time.Minute,
+		TxCacheSize:  1000,
+	}
+}
+
+type electrumRequest struct {
+	JsonRpc string        `json:"jsonrpc"`
+	Id      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type electrumMessage struct {
+	Id     *uint64         `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *ElectrumError  `json:"error"`
+
+	// Method and Params are set for subscription notifications
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type electrumHeader struct {
+	Height uint32 `json:"height"`
+	Hex    string `json:"hex"`
+}
+
+type electrumUtxo struct {
+	TxHash string `json:"tx_hash"`
+	TxPos  uint32 `json:"tx_pos"`
+	Height int32  `json:"height"`
+}
+
+type electrumHistoryTx struct {
+	TxHash string `json:"tx_hash"`
+
+	// Height is 0 for mempool transactions and -1 for mempool transactions with unconfirmed inputs
+	Height int32 `json:"height"`
+}
+
+// electrumConn is a connection to the server, it is replaced on reconnect
+type electrumConn struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint64]chan *electrumMessage
+	err     error
+
+	done chan struct{}
+}
+
+// ElectrumClient is a light wallet chain backend speaking the electrum protocol, e.g. to electrs.
+// It keeps one connection open, batches requests and pushes updates of subscribed addresses and
+// new blocks, see wallet.ChainSubscriber. The url is tcp://host:port or ssl://host:port
+type ElectrumClient struct {
+	// nextId is accessed atomically and first for alignment
+	nextId uint64
+
+	url string
+	cfg *ElectrumConfig
+
+	connMu sync.Mutex
+	conn   *electrumConn
+
+	mu  sync.Mutex
+	tip *electrumHeader
+
+	// subscriptions maps the subscribed script hashes to their address and last status
+	subscriptions map[string]*electrumSubscription
+	txCache       *txCache
+
+	updates chan struct{}
+	quit    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+// txCache keeps the hex of the most recently used transactions, a transaction never changes once its id is known
+type txCache struct {
+	size int
+	// order holds the entries, most recently used first
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type txCacheEntry struct {
+	txId  string
+	txHex string
+}
+
+func newTxCache(size int) *txCache {
+	return &txCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *txCache) get(txId string) (string, bool) {
+	elem, ok := c.entries[txId]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*txCacheEntry).txHex, true
+}
+
+// add caches the transaction and evicts the least recently used one if the cache is full
+func (c *txCache) add(txId string, txHex string) {
+	if elem, ok := c.entries[txId]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	if c.size <= 0 {
+		return
+	}
+	c.entries[txId] = c.order.PushFront(&txCacheEntry{txId: txId, txHex: txHex})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*txCacheEntry).txId)
+	}
+}
+
+type electrumSubscription struct {
+	address string
+	status  string
+}
+
+// NewElectrumClient connects to an electrum server and subscribes to new blocks
+func NewElectrumClient(url string) (*ElectrumClient, error) {
+	return NewElectrumClientWithConfig(url, DefaultElectrumConfig())
+}
+
+func NewElectrumClientWithConfig(url string, cfg *ElectrumConfig) (*ElectrumClient, error) {
+	c := &ElectrumClient{
+		url:           url,
+		cfg:           cfg,
+		subscriptions: make(map[string]*electrumSubscription),
+		txCache:       newTxCache(cfg.TxCacheSize),
+		updates:       make(chan struct{}, 1),
+		quit:          make(chan struct{}),
+	}
+	_, err := c.getConn()
+	if err != nil {
+		return nil, err
+	}
+	c.wg.Add(1)
+	go c.pingLoop()
+	return c, nil
+}
+
+// Close closes the connection and stops the keepalive
+func (c *ElectrumClient) Close() error {
+	c.once.Do(func() {
+		close(c.quit)
+		c.connMu.Lock()
+		if c.conn != nil {
+			c.conn.conn.Close()
+		}
+		c.connMu.Unlock()
+	})
+	c.wg.Wait()
+	return nil
+}
+
+// ChainUpdates is signalled when a subscribed address has a new status or a new block is connected
+func (c *ElectrumClient) ChainUpdates() <-chan struct{} {
+	return c.updates
+}
+
+func (c *ElectrumClient) signalUpdate() {
+	select {
+	case c.updates <- struct{}{}:
+	default:
+	}
+}
+
+// getConn returns the open connection, it reconnects, subscribes to the headers and resubscribes
+// the addresses if the connection dropped
+func (c *ElectrumClient) getConn() (*electrumConn, error) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	select {
+	case <-c.quit:
+		return nil, ErrElectrumClosed
+	default:
+	}
+	if c.conn != nil {
+		select {
+		case <-c.conn.done:
+		default:
+			return c.conn, nil
+		}
+	}
+
+	netConn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	conn := &electrumConn{
+		conn:    netConn,
+		pending: make(map[uint64]chan *electrumMessage),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop(conn)
+
+	err = c.setupConn(conn)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	reconnect := c.conn != nil
+	c.conn = conn
+	if reconnect {
+		// activity may have been missed while the connection was down
+		c.signalUpdate()
+	}
+	return conn, nil
+}
+
+func (c *ElectrumClient) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: c.cfg.Timeout}
+	switch {
+	case strings.HasPrefix(c.url, "tcp://"):
+		return dialer.Dial("tcp", strings.TrimPrefix(c.url, "tcp://"))
+	case strings.HasPrefix(c.url, "ssl://"):
+		addr := strings.TrimPrefix(c.url, "ssl://")
+		tlsConfig := c.cfg.TLSConfig
+		if tlsConfig == nil {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig = &tls.Config{ServerName: host}
+		}
+		return tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	default:
+		return nil, fmt.Errorf("unsupported electrum url %s, expected tcp:// or ssl://", c.url)
+	}
+}
+
+// setupConn negotiates the protocol version and (re)subscribes to the headers and addresses
+func (c *ElectrumClient) setupConn(conn *electrumConn) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout)
+	defer cancel()
+	_, err := c.batchOn(ctx, conn, []*electrumRequest{c.newRequest("server.version", electrumClientName, electrumProtocolVersion)})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	var requests []*electrumRequest
+	requests = append(requests, c.newRequest("blockchain.headers.subscribe"))
+	var scriptHashes []string
+	for k := range c.subscriptions {
+		requests = append(requests, c.newRequest("blockchain.scripthash.subscribe", k))
+		scriptHashes = append(scriptHashes, k)
+	}
+	c.mu.Unlock()
+
+	results, err := c.batchOn(ctx, conn, requests)
+	if err != nil {
+		return err
+	}
+	var tip *electrumHeader
+	err = json.Unmarshal(results[0], &tip)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tip = tip
+	for i, v := range scriptHashes {
+		var status string
+		json.Unmarshal(results[i+1], &status)
+		c.subscriptions[v].status = status
+	}
+	return nil
+}
+
+func (c *ElectrumClient) newRequest(method string, params ...interface{}) *electrumRequest {
+	if params == nil {
+		params = []interface{}{}
+	}
+	return &electrumRequest{JsonRpc: "2.0", Id: atomic.AddUint64(&c.nextId, 1), Method: method, Params: params}
+}
+
+// readLoop reads the responses and notifications of a connection until it fails
+func (c *ElectrumClient) readLoop(conn *electrumConn) {
+	reader := bufio.NewReader(conn.conn)
+	var err error
+	for {
+		var line []byte
+		line, err = reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var messages []*electrumMessage
+		if line[0] == '[' {
+			err = json.Unmarshal(line, &messages)
+		} else {
+			var msg *electrumMessage
+			err = json.Unmarshal(line, &msg)
+			messages = append(messages, msg)
+		}
+		if err != nil {
+			break
+		}
+		for _, msg := range messages {
+			c.handleMessage(conn, msg)
+		}
+	}
+
+	conn.conn.Close()
+	conn.mu.Lock()
+	conn.err = err
+	for _, v := range conn.pending {
+		close(v)
+	}
+	conn.pending = nil
+	conn.mu.Unlock()
+	close(conn.done)
+}
+
+func (c *ElectrumClient) handleMessage(conn *electrumConn, msg *electrumMessage) {
+	if msg.Id != nil {
+		conn.mu.Lock()
+		ch, ok := conn.pending[*msg.Id]
+		delete(conn.pending, *msg.Id)
+		conn.mu.Unlock()
+		if ok {
+			ch <- msg
+		}
+		return
+	}
+
+	switch msg.Method {
+	case "blockchain.headers.subscribe":
+		var header *electrumHeader
+		if len(msg.Params) == 0 || json.Unmarshal(msg.Params[0], &header) != nil {
+			return
+		}
+		c.mu.Lock()
+		c.tip = header
+		c.mu.Unlock()
+		c.signalUpdate()
+	case "blockchain.scripthash.subscribe":
+		var scriptHash, status string
+		if len(msg.Params) < 2 || json.Unmarshal(msg.Params[0], &scriptHash) != nil {
+			return
+		}
+		json.Unmarshal(msg.Params[1], &status)
+		c.mu.Lock()
+		sub, ok := c.subscriptions[scriptHash]
+		changed := ok && sub.status != status
+		if changed {
+			sub.status = status
+		}
+		c.mu.Unlock()
+		if changed {
+			c.signalUpdate()
+		}
+	}
+}
+
+func (c *ElectrumClient) pingLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.cfg.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.quit:
+			return
+		case <-ticker.C:
+		}
+		_, err := c.call(context.Background(), "server.ping")
+		if err != nil && !errors.Is(err, ErrElectrumClosed) {
+			log.Printf("electrum: ping: %v", err)
+		}
+	}
+}
+
+// call sends a single request
+func (c *ElectrumClient) call(ctx context.Context, method string, params ...interface{}) (json.RawMessage, error) {
+	results, err := c.batch(ctx, []*electrumRequest{c.newRequest(method, params...)})
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// batch sends the requests in batches of electrumMaxBatchSize and returns the results in order.
+// The first error of the server fails the whole batch
+func (c *ElectrumClient) batch(ctx context.Context, requests []*electrumRequest) ([]json.RawMessage, error) {
+	conn, err := c.getConn()
+	if err != nil {
+		return nil, err
+	}
+	var results []json.RawMessage
+	for start := 0; start < len(requests); start += electrumMaxBatchSize {
+		end := start + electrumMaxBatchSize
+		if end > len(requests) {
+			end = len(requests)
+		}
+		res, err := c.batchOn(ctx, conn, requests[start:end])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res...)
+	}
+	return results, nil
+}
+
+func (c *ElectrumClient) batchOn(ctx context.Context, conn *electrumConn, requests []*electrumRequest) ([]json.RawMessage, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+
+	chans := make([]chan *electrumMessage, len(requests))
+	conn.mu.Lock()
+	if conn.pending == nil {
+		conn.mu.Unlock()
+		return nil, fmt.Errorf("electrum: connection lost: %v", conn.err)
+	}
+	for i, v := range requests {
+		chans[i] = make(chan *electrumMessage, 1)
+		conn.pending[v.Id] = chans[i]
+	}
+	conn.mu.Unlock()
+	defer func() {
+		conn.mu.Lock()
+		for _, v := range requests {
+			delete(conn.pending, v.Id)
+		}
+		conn.mu.Unlock()
+	}()
+
+	var payload []byte
+	var err error
+	if len(requests) == 1 {
+		payload, err = json.Marshal(requests[0])
+	} else {
+		payload, err = json.Marshal(requests)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.writeMu.Lock()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.conn.SetWriteDeadline(deadline)
+	}
+	_, err = conn.conn.Write(append(payload, '\n'))
+	conn.writeMu.Unlock()
+	if err != nil {
+		conn.conn.Close()
+		return nil, err
+	}
+
+	results := make([]json.RawMessage, len(requests))
+	for i, ch := range chans {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return nil, fmt.Errorf("electrum: connection lost: %v", conn.err)
+			}
+			if msg.Error != nil {
+				return nil, msg.Error
+			}
+			results[i] = msg.Result
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return results, nil
+}
+
+// scriptHash returns the electrum script hash of an address, the reversed sha256 of its output script
+func scriptHash(address string) (string, error) {
+	script, err := elemaddr.ToOutputScript(address)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(script)
+	return hex.EncodeToString(elementsutil.ReverseBytes(hash[:])), nil
+}
+
+// scriptHashRequests returns a request of the method for the script hash of each address
+func (c *ElectrumClient) scriptHashRequests(method string, addresses []string) ([]*electrumRequest, error) {
+	requests := make([]*electrumRequest, len(addresses))
+	for i, v := range addresses {
+		hash, err := scriptHash(v)
+		if err != nil {
+			return nil, err
+		}
+		requests[i] = c.newRequest(method, hash)
+	}
+	return requests, nil
+}
+
+// SubscribeAddresses subscribes to the status of the addresses, a changed status signals ChainUpdates
+func (c *ElectrumClient) SubscribeAddresses(addresses []string) error {
+	var newAddresses []string
+	var scriptHashes []string
+	c.mu.Lock()
+	for _, v := range addresses {
+		hash, err := scriptHash(v)
+		if err != nil {
+			c.mu.Unlock()
+			return err
+		}
+		if _, ok := c.subscriptions[hash]; ok {
+			continue
+		}
+		newAddresses = append(newAddresses, v)
+		scriptHashes = append(scriptHashes, hash)
+	}
+	c.mu.Unlock()
+	if len(newAddresses) == 0 {
+		return nil
+	}
+
+	requests, err := c.scriptHashRequests("blockchain.scripthash.subscribe", newAddresses)
+	if err != nil {
+		return err
+	}
+	results, err := c.batch(context.Background(), requests)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, v := range scriptHashes {
+		var status string
+		json.Unmarshal(results[i], &status)
+		c.subscriptions[v] = &electrumSubscription{address: newAddresses[i], status: status}
+	}
+	return nil
+}
+
+// GetBlockHeight returns the height of the chain tip, which the server pushes
+func (c *ElectrumClient) GetBlockHeight() (uint32, error) {
+	_, err := c.getConn()
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tip.Height, nil
+}
+
+func (c *ElectrumClient) PostRawtransaction(rawTx string) (string, error) {
+	res, err := c.call(context.Background(), "blockchain.transaction.broadcast", rawTx)
+	var electrumErr *ElectrumError
+	if errors.As(err, &electrumErr) {
+		rejected := parseTxRejected(electrumErr.Message)
+		if rejected.Code == 0 {
+			rejected.Code = electrumErr.Code
+		}
+		return "", rejected
+	}
+	if err != nil {
+		return "", err
+	}
+	var txId string
+	err = json.Unmarshal(res, &txId)
+	if err != nil || !isTxId(txId) {
+		return "", fmt.Errorf("electrum: unexpected broadcast response %s", res)
+	}
+	return txId, nil
+}
+
+func (c *ElectrumClient) GetTxHex(txId string) (string, error) {
+	txs, err := c.getTxs([]string{txId})
+	if err != nil {
+		return "", err
+	}
+	return txs[txId], nil
+}
+
+// getTxs returns the hex of the transactions by txid, fetching the ones that are not cached in one batch
+func (c *ElectrumClient) getTxs(txIds []string) (map[string]string, error) {
+	txs := make(map[string]string, len(txIds))
+	var missing []string
+	c.mu.Lock()
+	for _, v := range txIds {
+		if txHex, ok := c.txCache.get(v); ok {
+			txs[v] = txHex
+		} else if _, ok := txs[v]; !ok {
+			txs[v] = ""
+			missing = append(missing, v)
+		}
+	}
+	c.mu.Unlock()
+	if len(missing) == 0 {
+		return txs, nil
+	}
+
+	requests := make([]*electrumRequest, len(missing))
+	for i, v := range missing {
+		requests[i] = c.newRequest("blockchain.transaction.get", v)
+	}
+	results, err := c.batch(context.Background(), requests)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, v := range missing {
+		var txHex string
+		err = json.Unmarshal(results[i], &txHex)
+		if err != nil {
+			return nil, err
+		}
+		txs[v] = txHex
+		c.txCache.add(v, txHex)
+	}
+	return txs, nil
+}
+
+// decodeTxs fetches and decodes the transactions
+func (c *ElectrumClient) decodeTxs(txIds []string) (map[string]*transaction.Transaction, error) {
+	txHexes, err := c.getTxs(txIds)
+	if err != nil {
+		return nil, err
+	}
+	txs := make(map[string]*transaction.Transaction, len(txHexes))
+	for k, v := range txHexes {
+		tx, err := transaction.NewTxFromHex(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tx %s: %w", k, err)
+		}
+		txs[k] = tx
+	}
+	return txs, nil
+}
+
+func (c *ElectrumClient) GetAddressStats(address string) (*wallet.AddressStats, error) {
+	stats, err := c.GetAddressesStats([]string{address})
+	if err != nil {
+		return nil, err
+	}
+	return stats[0], nil
+}
+
+// GetAddressesStats returns the confirmed and mempool transaction counts of the addresses in one batch
+func (c *ElectrumClient) GetAddressesStats(addresses []string) ([]*wallet.AddressStats, error) {
+	histories, err := c.getHistories(addresses)
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]*wallet.AddressStats, len(addresses))
+	for i, history := range histories {
+		chainStats := &wallet.AddressUtxoInfos{}
+		mempoolStats := &wallet.AddressUtxoInfos{}
+		for _, v := range history {
+			if v.Height > 0 {
+				chainStats.TxCount++
+			} else {
+				mempoolStats.TxCount++
+			}
+		}
+		stats[i] = &wallet.AddressStats{Address: addresses[i], ChainStats: chainStats, MempoolStats: mempoolStats}
+	}
+	return stats, nil
+}
+
+func (c *ElectrumClient) getHistories(addresses []string) ([][]*electrumHistoryTx, error) {
+	requests, err := c.scriptHashRequests("blockchain.scripthash.get_history", addresses)
+	if err != nil {
+		return nil, err
+	}
+	results, err := c.batch(context.Background(), requests)
+	if err != nil {
+		return nil, err
+	}
+	histories := make([][]*electrumHistoryTx, len(results))
+	for i, v := range results {
+		err = json.Unmarshal(v, &histories[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return histories, nil
+}
+
+func (c *ElectrumClient) GetUtxosFromAddress(address string) ([]*wallet.EsploraUtxo, error) {
+	utxos, err := c.GetUtxosFromAddresses([]string{address})
+	if err != nil {
+		return nil, err
+	}
+	return utxos[0], nil
+}
+
+// GetUtxosFromAddresses returns the utxos of the addresses in one batch. The values, assets and commitments are
+// taken from the funding transactions, which are fetched in a second batch
+func (c *ElectrumClient) GetUtxosFromAddresses(addresses []string) ([][]*wallet.EsploraUtxo, error) {
+	requests, err := c.scriptHashRequests("blockchain.scripthash.listunspent", addresses)
+	if err != nil {
+		return nil, err
+	}
+	results, err := c.batch(context.Background(), requests)
+	if err != nil {
+		return nil, err
+	}
+	unspents := make([][]*electrumUtxo, len(results))
+	var txIds []string
+	for i, v := range results {
+		err = json.Unmarshal(v, &unspents[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, utxo := range unspents[i] {
+			txIds = append(txIds, utxo.TxHash)
+		}
+	}
+	txs, err := c.decodeTxs(txIds)
+	if err != nil {
+		return nil, err
+	}
+
+	utxos := make([][]*wallet.EsploraUtxo, len(addresses))
+	for i, unspent := range unspents {
+		utxos[i] = []*wallet.EsploraUtxo{}
+		for _, v := range unspent {
+			tx := txs[v.TxHash]
+			if int(v.TxPos) >= len(tx.Outputs) {
+				return nil, fmt.Errorf("vout %v not found in %s", v.TxPos, v.TxHash)
+			}
+			utxo, err := newEsploraUtxo(tx.Outputs[v.TxPos], v.TxHash, v.TxPos, addresses[i])
+			if err != nil {
+				return nil, err
+			}
+			utxo.Status = &wallet.Status{}
+			if v.Height > 0 {
+				utxo.Status.Confirmed = true
+				utxo.Status.BlockHeight = uint32(v.Height)
+			}
+			utxos[i] = append(utxos[i], utxo)
+		}
+	}
+	return utxos, nil
+}
+
+// newEsploraUtxo returns the utxo of an output as esplora returns it
+func newEsploraUtxo(out *transaction.TxOutput, txId string, vout uint32, address string) (*wallet.EsploraUtxo, error) {
+	utxo := &wallet.EsploraUtxo{TxId: txId, Vout: vout, Address: address}
+	if len(out.Value) == 9 && out.Value[0] == 1 {
+		value, err := elementsutil.ElementsToSatoshiValue(out.Value)
+		if err != nil {
+			return nil, err
+		}
+		utxo.SatAmt = value
+		utxo.Asset = hex.EncodeToString(elementsutil.ReverseBytes(out.Asset[1:]))
+		return utxo, nil
+	}
+	utxo.ValueCommitment = hex.EncodeToString(out.Value)
+	utxo.AssetCommitment = hex.EncodeToString(out.Asset)
+	utxo.NonceCommitment = hex.EncodeToString(out.Nonce)
+	return utxo, nil
+}
+
+// GetAddressTxs returns the transactions of an address paged like esplora, the mempool and the first
+// confirmed transactions, or the confirmed transactions after lastSeenTxId
+func (c *ElectrumClient) GetAddressTxs(address string, lastSeenTxId string) ([]*wallet.EsploraTx, error) {
+	histories, err := c.getHistories([]string{address})
+	if err != nil {
+		return nil, err
+	}
+
+	// electrum returns the history oldest first with the mempool at the end
+	history := histories[0]
+	var mempool, confirmed []*electrumHistoryTx
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Height > 0 {
+			confirmed = append(confirmed, history[i])
+		} else {
+			mempool = append(mempool, history[i])
+		}
+	}
+	sort.SliceStable(confirmed, func(i, j int) bool {
+		return confirmed[i].Height > confirmed[j].Height
+	})
+
+	var page []*electrumHistoryTx
+	start := 0
+	if lastSeenTxId == "" {
+		page = append(page, mempool...)
+	} else {
+		start = len(confirmed)
+		for i, v := range confirmed {
+			if v.TxHash == lastSeenTxId {
+				start = i + 1
+				break
+			}
+		}
+	}
+	end := start + electrumChainTxsPerPage
+	if end > len(confirmed) {
+		end = len(confirmed)
+	}
+	page = append(page, confirmed[start:end]...)
+	if len(page) == 0 {
+		return nil, nil
+	}
+	return c.getEsploraTxs(page)
+}
+
+// getEsploraTxs returns the transactions with their fee, status and the scripts of their prevouts
+func (c *ElectrumClient) getEsploraTxs(history []*electrumHistoryTx) ([]*wallet.EsploraTx, error) {
+	txIds := make([]string, len(history))
+	for i, v := range history {
+		txIds[i] = v.TxHash
+	}
+	txs, err := c.decodeTxs(txIds)
+	if err != nil {
+		return nil, err
+	}
+	var prevTxIds []string
+	for _, tx := range txs {
+		for _, in := range tx.Inputs {
+			if !isCoinbaseInput(in) {
+				prevTxIds = append(prevTxIds, hex.EncodeToString(elementsutil.ReverseBytes(in.Hash)))
+			}
+		}
+	}
+	prevTxs, err := c.decodeTxs(prevTxIds)
+	if err != nil {
+		return nil, err
+	}
+	blockTimes, err := c.getBlockTimes(history)
+	if err != nil {
+		return nil, err
+	}
+
+	esploraTxs := make([]*wallet.EsploraTx, len(history))
+	for i, v := range history {
+		tx := txs[v.TxHash]
+		esploraTx := &wallet.EsploraTx{TxId: v.TxHash, Status: &wallet.Status{}}
+		if v.Height > 0 {
+			esploraTx.Status.Confirmed = true
+			esploraTx.Status.BlockHeight = uint32(v.Height)
+			esploraTx.Status.BlockTime = blockTimes[uint32(v.Height)]
+		}
+		for _, out := range tx.Outputs {
+			if len(out.Script) == 0 && len(out.Value) == 9 && out.Value[0] == 1 {
+				fee, err := elementsutil.ElementsToSatoshiValue(out.Value)
+				if err != nil {
+					return nil, err
+				}
+				esploraTx.Fee += fee
+			}
+		}
+		for _, in := range tx.Inputs {
+			esploraIn := &wallet.EsploraTxIn{
+				TxId:       hex.EncodeToString(elementsutil.ReverseBytes(in.Hash)),
+				Vout:       in.Index,
+				IsCoinbase: isCoinbaseInput(in),
+			}
+			if !esploraIn.IsCoinbase {
+				prevTx := prevTxs[esploraIn.TxId]
+				if int(in.Index) >= len(prevTx.Outputs) {
+					return nil, fmt.Errorf("vout %v not found in %s", in.Index, esploraIn.TxId)
+				}
+				esploraIn.Prevout = &wallet.EsploraTxOut{ScriptPubKey: hex.EncodeToString(prevTx.Outputs[in.Index].Script)}
+			}
+			esploraTx.Vin = append(esploraTx.Vin, esploraIn)
+		}
+		esploraTxs[i] = esploraTx
+	}
+	return esploraTxs, nil
+}
+
+// getBlockTimes returns the block times of the confirmed transactions by height
+func (c *ElectrumClient) getBlockTimes(history []*electrumHistoryTx) (map[uint32]uint64, error) {
+	var heights []uint32
+	seen := make(map[uint32]bool)
+	for _, v := range history {
+		if v.Height > 0 && !seen[uint32(v.Height)] {
+			seen[uint32(v.Height)] = true
+			heights = append(heights, uint32(v.Height))
+		}
+	}
+	blockTimes := make(map[uint32]uint64, len(heights))
+	if len(heights) == 0 {
+		return blockTimes, nil
+	}
+	requests := make([]*electrumRequest, len(heights))
+	for i, v := range heights {
+		requests[i] = c.newRequest("blockchain.block.header", v)
+	}
+	results, err := c.batch(context.Background(), requests)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range heights {
+		var headerHex string
+		err = json.Unmarshal(results[i], &headerHex)
+		if err != nil {
+			return nil, err
+		}
+		header, err := hex.DecodeString(headerHex)
+		if err != nil || len(header) < elementsHeaderTimeOffset+4 {
+			return nil, fmt.Errorf("invalid block header at height %v", v)
+		}
+		blockTimes[v] = uint64(binary.LittleEndian.Uint32(header[elementsHeaderTimeOffset:]))
+	}
+	return blockTimes, nil
+}
+
+func isCoinbaseInput(in *transaction.TxInput) bool {
+	return in.Index == 0xffffffff && bytes.Equal(in.Hash, make([]byte, 32))
+}
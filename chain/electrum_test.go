@@ -0,0 +1,323 @@
+package chain
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/sputn1ck/liquid-go-lightwallet/wallet"
+	elemaddr "github.com/vulpemventures/go-elements/address"
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/network"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+// fakeElectrum is an electrum server serving the wallet requests from in memory histories
+type fakeElectrum struct {
+	listener net.Listener
+
+	mu        sync.Mutex
+	height    uint32
+	histories map[string][]*electrumHistoryTx
+	unspents  map[string][]*electrumUtxo
+	txs       map[string]string
+	batches   []int
+	conns     []net.Conn
+}
+
+func newFakeElectrum(t *testing.T) *fakeElectrum {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeElectrum{
+		listener:  listener,
+		height:    100,
+		histories: make(map[string][]*electrumHistoryTx),
+		unspents:  make(map[string][]*electrumUtxo),
+		txs:       make(map[string]string),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns = append(f.conns, conn)
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	return f
+}
+
+func (f *fakeElectrum) url() string {
+	return "tcp://" + f.listener.Addr().String()
+}
+
+func (f *fakeElectrum) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		var requests []*electrumRequest
+		batch := line[0] == '['
+		if batch {
+			json.Unmarshal(line, &requests)
+		} else {
+			var req *electrumRequest
+			json.Unmarshal(line, &req)
+			requests = append(requests, req)
+		}
+		f.mu.Lock()
+		f.batches = append(f.batches, len(requests))
+		var responses []map[string]interface{}
+		for _, req := range requests {
+			responses = append(responses, f.handle(req))
+		}
+		f.mu.Unlock()
+		var res []byte
+		if batch {
+			res, _ = json.Marshal(responses)
+		} else {
+			res, _ = json.Marshal(responses[0])
+		}
+		conn.Write(append(res, '\n'))
+	}
+}
+
+func (f *fakeElectrum) handle(req *electrumRequest) map[string]interface{} {
+	res := map[string]interface{}{"jsonrpc": "2.0", "id": req.Id}
+	var param string
+	if len(req.Params) > 0 {
+		param, _ = req.Params[0].(string)
+	}
+	switch req.Method {
+	case "server.version":
+		res["result"] = []string{"fake", electrumProtocolVersion}
+	case "server.ping":
+		res["result"] = nil
+	case "blockchain.headers.subscribe":
+		res["result"] = &electrumHeader{Height: f.height}
+	case "blockchain.scripthash.subscribe":
+		res["result"] = f.status(param)
+	case "blockchain.scripthash.get_history":
+		res["result"] = append([]*electrumHistoryTx{}, f.histories[param]...)
+	case "blockchain.scripthash.listunspent":
+		res["result"] = append([]*electrumUtxo{}, f.unspents[param]...)
+	case "blockchain.transaction.get":
+		res["result"] = f.txs[param]
+	case "blockchain.transaction.broadcast":
+		res["error"] = &ElectrumError{Code: 1, Message: `sendrawtransaction RPC error: {"code":-26,"message":"min relay fee not met"}`}
+	default:
+		res["error"] = &ElectrumError{Code: -32601, Message: "unknown method"}
+	}
+	return res
+}
+
+// status returns the electrum status of a script hash, which only has to change with its history
+func (f *fakeElectrum) status(scriptHash string) interface{} {
+	history := f.histories[scriptHash]
+	if len(history) == 0 {
+		return nil
+	}
+	var status string
+	for _, v := range history {
+		status += v.TxHash
+	}
+	return status
+}
+
+// fund adds a mempool transaction paying an explicit amount to the address and notifies the subscribers
+func (f *fakeElectrum) fund(t *testing.T, address string, assetId string, value uint64) string {
+	script, err := elemaddr.ToOutputScript(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	satValue, err := elementsutil.SatoshiToElementsValue(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := transaction.NewTx(2)
+	tx.AddInput(transaction.NewTxInput(make([]byte, 32), 0xffffffff))
+	tx.AddOutput(transaction.NewTxOutput(append([]byte{1}, elementsutil.ReverseBytes(h2b(assetId))...), satValue, script))
+	txHex, err := tx.ToHex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txId := tx.TxHash().String()
+	hash, err := scriptHash(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.txs[txId] = txHex
+	f.histories[hash] = append(f.histories[hash], &electrumHistoryTx{TxHash: txId})
+	f.unspents[hash] = append(f.unspents[hash], &electrumUtxo{TxHash: txId})
+	notification, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "blockchain.scripthash.subscribe",
+		"params":  []interface{}{hash, f.status(hash)},
+	})
+	for _, conn := range f.conns {
+		conn.Write(append(notification, '\n'))
+	}
+	return txId
+}
+
+func TestElectrumWallet(t *testing.T) {
+	server := newFakeElectrum(t)
+	client, err := NewElectrumClient(server.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	liquidNetwork := &network.Regtest
+	chainParams := chaincfg.MainNetParams
+	liquidWallet := wallet.NewLiquidWallet(client, GetChainCfgParams(liquidNetwork, &chainParams), liquidNetwork)
+	err = liquidWallet.Initialize(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the addresses up to the gap limit are fetched in one batch per request type
+	server.mu.Lock()
+	for _, v := range server.batches[2:] {
+		if v < wallet.DefaultGapLimit {
+			t.Fatalf("expected batched requests, got batch sizes %v", server.batches)
+		}
+	}
+	server.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	synced := make(chan error, 1)
+	go liquidWallet.WatchChain(ctx, func(err error) {
+		select {
+		case synced <- err:
+		default:
+		}
+	})
+
+	address, err := liquidWallet.GetAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txId := server.fund(t, address, liquidNetwork.AssetID, 100000)
+	select {
+	case err := <-synced:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected sync on address notification")
+	}
+	balances, err := liquidWallet.GetBalances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balances[liquidNetwork.AssetID] != 100000 {
+		t.Fatalf("unexpected balances %v", balances)
+	}
+
+	txs, err := client.GetAddressTxs(address, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 || txs[0].TxId != txId || txs[0].Status.Confirmed || !txs[0].Vin[0].IsCoinbase {
+		t.Fatalf("unexpected address txs %+v", txs)
+	}
+
+	_, err = client.PostRawtransaction("00")
+	var rejected *TxRejectedError
+	if !errors.As(err, &rejected) || rejected.Code != -26 || !strings.Contains(rejected.Reason, "min relay fee") {
+		t.Fatalf("expected rejected tx, got %v", err)
+	}
+}
+
+func TestElectrumTxCache(t *testing.T) {
+	server := newFakeElectrum(t)
+	cfg := DefaultElectrumConfig()
+	cfg.TxCacheSize = 2
+	client, err := NewElectrumClientWithConfig(server.url(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var txIds []string
+	for i := 0; i < 3; i++ {
+		txIds = append(txIds, server.fund(t, "ert1q6rz28mcfaxtmd6v789l9rrlrusdprr9p69dllk", network.Regtest.AssetID, uint64(1000+i)))
+	}
+	// the second transaction is the least recently used one once the third is fetched
+	for _, i := range []int{0, 1, 0, 2} {
+		txHex, err := client.GetTxHex(txIds[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if txHex != server.txs[txIds[i]] {
+			t.Fatalf("got tx %s, want %s", txHex, server.txs[txIds[i]])
+		}
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if len(client.txCache.entries) != 2 || client.txCache.order.Len() != 2 {
+		t.Fatalf("got %v cached txs, want 2", len(client.txCache.entries))
+	}
+	for i, cached := range []bool{true, false, true} {
+		if _, ok := client.txCache.get(txIds[i]); ok != cached {
+			t.Fatalf("tx %v cached: %v, want %v", i, ok, cached)
+		}
+	}
+}
+
+func TestElectrumReconnect(t *testing.T) {
+	server := newFakeElectrum(t)
+	client, err := NewElectrumClient(server.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server.mu.Lock()
+	for _, v := range server.conns {
+		v.Close()
+	}
+	server.height = 101
+	server.mu.Unlock()
+
+	// the next request reconnects and resubscribes to the headers
+	deadline := time.Now().Add(time.Second)
+	for {
+		height, err := client.GetBlockHeight()
+		if err == nil && height == 101 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected reconnect, got height %v: %v", height, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-client.ChainUpdates():
+	default:
+		t.Fatal("expected update after reconnect")
+	}
+}
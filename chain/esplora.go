@@ -1,75 +1,424 @@
 package chain
 
 import (
-	"bytes"
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/sputn1ck/liquid-go-lightwallet/wallet"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sputn1ck/liquid-go-lightwallet/wallet"
+)
+
+var (
+	ErrNotFound   = errors.New("esplora: not found")
+	ErrTxRejected = errors.New("transaction rejected")
 )
 
+// maxErrorBodySize limits how much of an error response is kept in the error
+const maxErrorBodySize = 4096
+
+// HttpError is returned if esplora answers a request with an unexpected status code
+type HttpError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *HttpError) Error() string {
+	return fmt.Sprintf("esplora: http status %v: %s", e.StatusCode, e.Message)
+}
+
+// Is lets errors.Is match a 404 error against ErrNotFound
+func (e *HttpError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// retryable returns true for rate limits and server errors, which are expected to go away
+func (e *HttpError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// TxRejectedError is returned if the node rejects a broadcast transaction, it matches ErrTxRejected
+type TxRejectedError struct {
+	// Code is the rpc error code of the node, or 0 if unknown
+	Code int
+
+	// Reason is the reject reason of the node, e.g. bad-txns-inputs-missingorspent
+	Reason string
+}
+
+func (e *TxRejectedError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrTxRejected, e.Reason)
+}
+
+func (e *TxRejectedError) Unwrap() error {
+	return ErrTxRejected
+}
+
+// EsploraConfig configures the timeouts and retries of the esplora client
+type EsploraConfig struct {
+	// Timeout is the timeout of a single http request
+	Timeout time.Duration
+
+	// MaxRetries is the number of retries of requests that failed with a network error, 429 or 5xx
+	MaxRetries int
+
+	// MinBackoff is the wait before the first retry, it doubles with every retry up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func DefaultEsploraConfig() *EsploraConfig {
+	return &EsploraConfig{
+		Timeout:    30 * time.Second,
+		MaxRetries: 3,
+		MinBackoff: 500 * time.Millisecond,
+		MaxBackoff: 10 * time.Second,
+	}
+}
+
 type EsploraApi struct {
 	baseUrl string
+	cfg     *EsploraConfig
 
 	client *http.Client
 }
 
 func NewEsploraApi(baseUrl string) *EsploraApi {
-	return &EsploraApi{baseUrl: baseUrl, client: &http.Client{}}
+	return NewEsploraApiWithConfig(baseUrl, DefaultEsploraConfig())
+}
+
+func NewEsploraApiWithConfig(baseUrl string, cfg *EsploraConfig) *EsploraApi {
+	return &EsploraApi{
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+	}
 }
 
 func (e *EsploraApi) GetUtxosFromAddress(address string) ([]*wallet.EsploraUtxo, error) {
-	resp, err := e.client.Get(fmt.Sprintf("%s/address/%s/utxo", e.baseUrl, address))
+	return e.GetUtxosFromAddressCtx(context.Background(), address)
+}
+
+func (e *EsploraApi) GetUtxosFromAddressCtx(ctx context.Context, address string) ([]*wallet.EsploraUtxo, error) {
+	var utxos []*wallet.EsploraUtxo
+	err := e.getJson(ctx, fmt.Sprintf("/address/%s/utxo", address), &utxos)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	for _, v := range utxos {
+		v.Address = address
+	}
+	return utxos, nil
+}
+
+func (e *EsploraApi) PostRawtransaction(rawTx string) (string, error) {
+	return e.PostRawtransactionCtx(context.Background(), rawTx)
+}
+
+// PostRawtransactionCtx broadcasts a transaction and returns its txid. A transaction the node refuses
+// returns a *TxRejectedError
+func (e *EsploraApi) PostRawtransactionCtx(ctx context.Context, rawTx string) (string, error) {
+	body, err := e.do(ctx, http.MethodPost, "/tx", rawTx)
 	if err != nil {
-		return nil, err
+		var httpErr *HttpError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusBadRequest {
+			return "", parseTxRejected(httpErr.Message)
+		}
+		return "", err
 	}
-	var utxos []*wallet.EsploraUtxo
-	err = json.Unmarshal(bodyBytes, &utxos)
+	txId := strings.TrimSpace(string(body))
+	if !isTxId(txId) {
+		return "", fmt.Errorf("esplora: unexpected broadcast response %q", txId)
+	}
+	return txId, nil
+}
+
+func (e *EsploraApi) GetTxHex(txId string) (string, error) {
+	return e.GetTxHexCtx(context.Background(), txId)
+}
+
+func (e *EsploraApi) GetTxHexCtx(ctx context.Context, txId string) (string, error) {
+	body, err := e.do(ctx, http.MethodGet, fmt.Sprintf("/tx/%s/hex", txId), "")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+func (e *EsploraApi) GetAddressStats(address string) (*wallet.AddressStats, error) {
+	return e.GetAddressStatsCtx(context.Background(), address)
+}
+
+func (e *EsploraApi) GetAddressStatsCtx(ctx context.Context, address string) (*wallet.AddressStats, error) {
+	var addrInfo *wallet.AddressStats
+	err := e.getJson(ctx, fmt.Sprintf("/address/%s", address), &addrInfo)
 	if err != nil {
 		return nil, err
 	}
-	for _,v := range utxos {
-		v.Address = address
+	return addrInfo, nil
+}
+
+// GetBlockHeight returns the height of the chain tip
+func (e *EsploraApi) GetBlockHeight() (uint32, error) {
+	return e.GetBlockHeightCtx(context.Background())
+}
+
+func (e *EsploraApi) GetBlockHeightCtx(ctx context.Context) (uint32, error) {
+	body, err := e.do(ctx, http.MethodGet, "/blocks/tip/height", "")
+	if err != nil {
+		return 0, err
+	}
+	height, err := strconv.ParseUint(strings.TrimSpace(string(body)), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid tip height %q: %w", body, err)
 	}
-	return utxos,nil
+	return uint32(height), nil
 }
 
+// GetBlockHash returns the hash of the block at a height of the best chain
+func (e *EsploraApi) GetBlockHash(height uint32) (string, error) {
+	return e.GetBlockHashCtx(context.Background(), height)
+}
 
-func (e *EsploraApi) PostRawtransaction(rawTx string) (string, error) {
-	resp, err := e.client.Post(fmt.Sprintf("%s/tx", e.baseUrl), "string",bytes.NewBuffer([]byte(rawTx)))
+func (e *EsploraApi) GetBlockHashCtx(ctx context.Context, height uint32) (string, error) {
+	body, err := e.do(ctx, http.MethodGet, fmt.Sprintf("/block-height/%v", height), "")
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	return strings.TrimSpace(string(body)), nil
+}
+
+// GetTx returns a transaction with its prevouts and confirmation status
+func (e *EsploraApi) GetTx(txId string) (*EsploraTransaction, error) {
+	return e.GetTxCtx(context.Background(), txId)
+}
+
+func (e *EsploraApi) GetTxCtx(ctx context.Context, txId string) (*EsploraTransaction, error) {
+	var tx *EsploraTransaction
+	err := e.getJson(ctx, fmt.Sprintf("/tx/%s", txId), &tx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	log.Printf("%s", bodyBytes)
-	return string(bodyBytes), nil
+	return tx, nil
 }
 
-func (e *EsploraApi) GetAddressStats(address string) (*wallet.AddressStats, error) {
-	resp, err := e.client.Get(fmt.Sprintf("%s/address/%s", e.baseUrl, address))
+// GetTxStatus returns the confirmation status of a transaction, an unknown transaction returns ErrNotFound
+func (e *EsploraApi) GetTxStatus(txId string) (*wallet.Status, error) {
+	return e.GetTxStatusCtx(context.Background(), txId)
+}
+
+func (e *EsploraApi) GetTxStatusCtx(ctx context.Context, txId string) (*wallet.Status, error) {
+	var status *wallet.Status
+	err := e.getJson(ctx, fmt.Sprintf("/tx/%s/status", txId), &status)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	return status, nil
+}
+
+// GetTxOutspend returns whether an output is spent and by which input
+func (e *EsploraApi) GetTxOutspend(txId string, vout uint32) (*EsploraOutspend, error) {
+	return e.GetTxOutspendCtx(context.Background(), txId, vout)
+}
+
+func (e *EsploraApi) GetTxOutspendCtx(ctx context.Context, txId string, vout uint32) (*EsploraOutspend, error) {
+	var outspend *EsploraOutspend
+	err := e.getJson(ctx, fmt.Sprintf("/tx/%s/outspend/%v", txId, vout), &outspend)
 	if err != nil {
 		return nil, err
 	}
-	var addrInfo *wallet.AddressStats
-	err = json.Unmarshal(bodyBytes, &addrInfo)
+	return outspend, nil
+}
+
+// GetBlock returns the header of a block
+func (e *EsploraApi) GetBlock(blockHash string) (*EsploraBlock, error) {
+	return e.GetBlockCtx(context.Background(), blockHash)
+}
+
+func (e *EsploraApi) GetBlockCtx(ctx context.Context, blockHash string) (*EsploraBlock, error) {
+	var block *EsploraBlock
+	err := e.getJson(ctx, fmt.Sprintf("/block/%s", blockHash), &block)
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// GetFeeEstimates returns the fee rates in sat/vbyte for confirmation targets in blocks
+func (e *EsploraApi) GetFeeEstimates() (FeeEstimates, error) {
+	return e.GetFeeEstimatesCtx(context.Background())
+}
+
+func (e *EsploraApi) GetFeeEstimatesCtx(ctx context.Context) (FeeEstimates, error) {
+	// json object keys are strings, the targets are parsed afterwards
+	var res map[string]float64
+	err := e.getJson(ctx, "/fee-estimates", &res)
+	if err != nil {
+		return nil, err
+	}
+	estimates := make(FeeEstimates, len(res))
+	for k, v := range res {
+		target, err := strconv.Atoi(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fee estimate target %q", k)
+		}
+		estimates[target] = v
+	}
+	return estimates, nil
+}
+
+// GetAsset returns the issuance and registry information of an asset
+func (e *EsploraApi) GetAsset(assetId string) (*EsploraAsset, error) {
+	return e.GetAssetCtx(context.Background(), assetId)
+}
+
+func (e *EsploraApi) GetAssetCtx(ctx context.Context, assetId string) (*EsploraAsset, error) {
+	var asset *EsploraAsset
+	err := e.getJson(ctx, fmt.Sprintf("/asset/%s", assetId), &asset)
+	if err != nil {
+		return nil, err
+	}
+	return asset, nil
+}
+
+// GetAddressTxs returns the mempool and the first confirmed transactions of an address,
+// or the confirmed transactions after lastSeenTxId
+func (e *EsploraApi) GetAddressTxs(address string, lastSeenTxId string) ([]*wallet.EsploraTx, error) {
+	return e.GetAddressTxsCtx(context.Background(), address, lastSeenTxId)
+}
+
+func (e *EsploraApi) GetAddressTxsCtx(ctx context.Context, address string, lastSeenTxId string) ([]*wallet.EsploraTx, error) {
+	path := fmt.Sprintf("/address/%s/txs", address)
+	if lastSeenTxId != "" {
+		path = fmt.Sprintf("%s/chain/%s", path, lastSeenTxId)
+	}
+	var txs []*wallet.EsploraTx
+	err := e.getJson(ctx, path, &txs)
 	if err != nil {
 		return nil, err
 	}
-	return addrInfo,nil
+	return txs, nil
+}
+
+// getJson gets the path and decodes the json response into v
+func (e *EsploraApi) getJson(ctx context.Context, path string, v interface{}) error {
+	body, err := e.do(ctx, http.MethodGet, path, "")
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return fmt.Errorf("esplora: invalid response of %s: %w", path, err)
+	}
+	return nil
+}
+
+// do sends a request and returns the body of a 200 response. Network errors, 429 and 5xx responses are
+// retried with exponential backoff, a Retry-After header of the server overrides the backoff
+func (e *EsploraApi) do(ctx context.Context, method string, path string, body string) ([]byte, error) {
+	backoff := e.cfg.MinBackoff
+	for attempt := 0; ; attempt++ {
+		resBody, retryAfter, err := e.doOnce(ctx, method, path, body)
+		if err == nil {
+			return resBody, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var httpErr *HttpError
+		if errors.As(err, &httpErr) && !httpErr.retryable() {
+			return nil, err
+		}
+		if attempt >= e.cfg.MaxRetries {
+			return nil, err
+		}
+
+		wait := backoff
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		if e.cfg.MaxBackoff > 0 && wait > e.cfg.MaxBackoff {
+			wait = e.cfg.MaxBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// doOnce sends a single request, it returns the Retry-After duration of a failed request if the server set it
+func (e *EsploraApi) doOnce(ctx context.Context, method string, path string, body string) ([]byte, time.Duration, error) {
+	var reqBody io.Reader
+	if body != "" {
+		reqBody = strings.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, e.baseUrl+path, reqBody)
+	if err != nil {
+		return nil, 0, err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "text/plain")
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		return nil, parseRetryAfter(resp.Header.Get("Retry-After")), &HttpError{
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(msg)),
+		}
+	}
+	resBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resBody, 0, nil
+}
+
+// parseRetryAfter parses a Retry-After header in seconds, http dates are ignored
+func parseRetryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// parseTxRejected parses the error esplora returns for a rejected transaction,
+// e.g. sendrawtransaction RPC error: {"code":-26,"message":"bad-txns-inputs-missingorspent"}
+func parseTxRejected(msg string) *TxRejectedError {
+	rejected := &TxRejectedError{Reason: msg}
+	i := strings.Index(msg, "{")
+	if i < 0 {
+		return rejected
+	}
+	var rpcErr struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(msg[i:]), &rpcErr); err != nil || rpcErr.Message == "" {
+		return rejected
+	}
+	rejected.Code = rpcErr.Code
+	rejected.Reason = rpcErr.Message
+	return rejected
+}
+
+func isTxId(txId string) bool {
+	b, err := hex.DecodeString(txId)
+	return err == nil && len(b) == 32
 }
@@ -1,7 +1,216 @@
 package chain
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testTxId = "be71be412b1ebf01689934adc2b9370f5cb9cb8fcac448b583f177cbd37df2ae"
+
+func newTestEsplora(t *testing.T, handler http.HandlerFunc) *EsploraApi {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return NewEsploraApiWithConfig(server.URL, &EsploraConfig{
+		Timeout:    time.Second,
+		MaxRetries: 2,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	})
+}
 
 func TestEsplora(t *testing.T) {
+	var requests int32
+	esplora := newTestEsplora(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/blocks/tip/height":
+			// the first request is rate limited, the second fails
+			if n == 1 {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			if n == 2 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte("1234"))
+		case "/tx":
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.Header.Get("Content-Type") != "text/plain" {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			switch string(body) {
+			case "spent":
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`sendrawtransaction RPC error: {"code":-26,"message":"bad-txns-inputs-missingorspent"}`))
+			case "garbage":
+				w.Write([]byte("sendrawtransaction RPC error"))
+			default:
+				w.Write([]byte(testTxId))
+			}
+		case "/address/unknown/utxo":
+			w.WriteHeader(http.StatusNotFound)
+		case "/address/broken/utxo":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/address/ok/utxo":
+			w.Write([]byte(`[{"txid":"` + testTxId + `","vout":1,"value":1000,"asset":"aa"}]`))
+		}
+	})
+
+	height, err := esplora.GetBlockHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 1234 || requests != 3 {
+		t.Fatalf("expected height 1234 after 3 requests, got %v after %v", height, requests)
+	}
+
+	txId, err := esplora.PostRawtransaction("tx")
+	if err != nil || txId != testTxId {
+		t.Fatalf("expected txid %s, got %s %v", testTxId, txId, err)
+	}
+
+	_, err = esplora.PostRawtransaction("spent")
+	var rejected *TxRejectedError
+	if !errors.Is(err, ErrTxRejected) || !errors.As(err, &rejected) {
+		t.Fatalf("expected rejected tx, got %v", err)
+	}
+	if rejected.Code != -26 || rejected.Reason != "bad-txns-inputs-missingorspent" {
+		t.Fatalf("unexpected reject reason %v %s", rejected.Code, rejected.Reason)
+	}
+
+	_, err = esplora.PostRawtransaction("garbage")
+	if err == nil || errors.Is(err, ErrTxRejected) {
+		t.Fatalf("expected invalid response error, got %v", err)
+	}
+
+	_, err = esplora.GetUtxosFromAddress("unknown")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	requests = 0
+	_, err = esplora.GetUtxosFromAddress("broken")
+	var httpErr *HttpError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected http error, got %v", err)
+	}
+	if requests != 3 {
+		t.Fatalf("expected 1 request and 2 retries, got %v", requests)
+	}
+
+	utxos, err := esplora.GetUtxosFromAddress("ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 1 || utxos[0].Address != "ok" || utxos[0].Value() != 1000 {
+		t.Fatalf("unexpected utxos %v", utxos)
+	}
+}
+
+func TestEsploraContext(t *testing.T) {
+	esplora := newTestEsplora(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	// the retry after of the server is capped by the max backoff
+	start := time.Now()
+	_, err := esplora.GetTxHex(testTxId)
+	var httpErr *HttpError
+	if !errors.As(err, &httpErr) || time.Since(start) > time.Second {
+		t.Fatalf("expected http error within the max backoff, got %v after %v", err, time.Since(start))
+	}
+
+	esplora.cfg.MaxBackoff = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = esplora.GetTxHexCtx(ctx, testTxId)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestEsploraTimeout(t *testing.T) {
+	esplora := newTestEsplora(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/hex") {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Write([]byte("00"))
+	})
+	esplora.cfg.MaxRetries = 0
+	esplora.client.Timeout = 50 * time.Millisecond
+	_, err := esplora.GetTxHex(testTxId)
+	if err == nil {
+		t.Fatal("expected timeout")
+	}
+}
+
+func TestEsploraEndpoints(t *testing.T) {
+	responses := map[string]string{
+		"/tx/" + testTxId:                 `{"txid":"` + testTxId + `","version":2,"vin":[{"txid":"aa","vout":1,"sequence":4294967293,"witness":["30","02"],"prevout":{"scriptpubkey":"0014aa","valuecommitment":"08aa","assetcommitment":"0aaa"}}],"vout":[{"scriptpubkey":"0014bb","value":1000,"asset":"bb"},{"scriptpubkey":"","value":50,"asset":"bb"}],"fee":50,"status":{"confirmed":true,"block_height":10,"block_hash":"cc"}}`,
+		"/tx/" + testTxId + "/status":     `{"confirmed":false}`,
+		"/tx/" + testTxId + "/outspend/1": `{"spent":true,"txid":"dd","vin":0,"status":{"confirmed":true,"block_height":11}}`,
+		"/block/cc":                       `{"id":"cc","height":10,"timestamp":1600000000,"tx_count":2,"previousblockhash":"bb"}`,
+		"/fee-estimates":                  `{"1":0.25,"144":0.1}`,
+		"/asset/bb":                       `{"asset_id":"bb","issuance_txin":{"txid":"ee","vin":0},"chain_stats":{"issued_amount":2100},"name":"Tether USD","ticker":"USDt","precision":8,"entity":{"domain":"tether.to"}}`,
+	}
+	esplora := newTestEsplora(t, func(w http.ResponseWriter, r *http.Request) {
+		res, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(res))
+	})
+
+	tx, err := esplora.GetTx(testTxId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Vin) != 1 || !tx.Vin[0].Prevout.IsConfidential() || tx.Vin[0].Sequence != 0xfffffffd {
+		t.Fatalf("unexpected inputs %+v", tx.Vin)
+	}
+	if len(tx.Vout) != 2 || tx.Vout[0].IsConfidential() || tx.Vout[0].Value != 1000 || tx.Fee != 50 {
+		t.Fatalf("unexpected outputs %+v", tx.Vout)
+	}
+	if !tx.Status.Confirmed || tx.Status.BlockHeight != 10 {
+		t.Fatalf("unexpected status %+v", tx.Status)
+	}
+
+	status, err := esplora.GetTxStatus(testTxId)
+	if err != nil || status.Confirmed {
+		t.Fatalf("expected unconfirmed tx, got %+v %v", status, err)
+	}
+
+	outspend, err := esplora.GetTxOutspend(testTxId, 1)
+	if err != nil || !outspend.Spent || outspend.TxId != "dd" || outspend.Status.BlockHeight != 11 {
+		t.Fatalf("unexpected outspend %+v %v", outspend, err)
+	}
+	_, err = esplora.GetTxOutspend(testTxId, 2)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	block, err := esplora.GetBlock("cc")
+	if err != nil || block.Height != 10 || block.PreviousBlockHash != "bb" {
+		t.Fatalf("unexpected block %+v %v", block, err)
+	}
+
+	estimates, err := esplora.GetFeeEstimates()
+	if err != nil || estimates[1] != 0.25 || estimates[144] != 0.1 {
+		t.Fatalf("unexpected fee estimates %v %v", estimates, err)
+	}
 
+	asset, err := esplora.GetAsset("bb")
+	if err != nil || asset.Ticker != "USDt" || asset.Precision != 8 || asset.ChainStats.IssuedAmount != 2100 {
+		t.Fatalf("unexpected asset %+v %v", asset, err)
+	}
 }
@@ -0,0 +1,124 @@
+package chain
+
+import (
+	"github.com/sputn1ck/liquid-go-lightwallet/wallet"
+)
+
+// EsploraTransaction is a transaction as returned by /tx/:txid, values and assets of
+// confidential outputs are only known as commitments
+type EsploraTransaction struct {
+	TxId     string         `json:"txid"`
+	Version  uint32         `json:"version"`
+	Locktime uint32         `json:"locktime"`
+	Vin      []*EsploraVin  `json:"vin"`
+	Vout     []*EsploraVout `json:"vout"`
+	Size     uint32         `json:"size"`
+	Weight   uint32         `json:"weight"`
+	Fee      uint64         `json:"fee"`
+	Status   *wallet.Status `json:"status"`
+}
+
+type EsploraVin struct {
+	TxId       string           `json:"txid"`
+	Vout       uint32           `json:"vout"`
+	Prevout    *EsploraVout     `json:"prevout"`
+	ScriptSig  string           `json:"scriptsig"`
+	Witness    []string         `json:"witness"`
+	Sequence   uint32           `json:"sequence"`
+	IsCoinbase bool             `json:"is_coinbase"`
+	IsPegin    bool             `json:"is_pegin"`
+	Issuance   *EsploraIssuance `json:"issuance"`
+}
+
+type EsploraIssuance struct {
+	AssetId            string `json:"asset_id"`
+	IsReissuance       bool   `json:"is_reissuance"`
+	AssetEntropy       string `json:"asset_entropy"`
+	AssetAmount        uint64 `json:"assetamount"`
+	TokenAmount        uint64 `json:"tokenamount"`
+	AssetBlindingNonce string `json:"asset_blinding_nonce"`
+}
+
+type EsploraVout struct {
+	ScriptPubKey        string `json:"scriptpubkey"`
+	ScriptPubKeyType    string `json:"scriptpubkey_type"`
+	ScriptPubKeyAddress string `json:"scriptpubkey_address"`
+
+	// Value and Asset are set for explicit outputs, the commitments for confidential outputs
+	Value           uint64 `json:"value"`
+	Asset           string `json:"asset"`
+	ValueCommitment string `json:"valuecommitment"`
+	AssetCommitment string `json:"assetcommitment"`
+}
+
+// IsConfidential returns true if the value of the output is blinded
+func (v *EsploraVout) IsConfidential() bool {
+	return v.ValueCommitment != ""
+}
+
+// EsploraOutspend is the spending status of an output as returned by /tx/:txid/outspend/:vout
+type EsploraOutspend struct {
+	Spent bool `json:"spent"`
+
+	// TxId and Vin are the spending input, they are empty if the output is unspent
+	TxId   string         `json:"txid"`
+	Vin    uint32         `json:"vin"`
+	Status *wallet.Status `json:"status"`
+}
+
+// EsploraBlock is a block header as returned by /block/:hash
+type EsploraBlock struct {
+	Id                string `json:"id"`
+	Height            uint32 `json:"height"`
+	Version           uint32 `json:"version"`
+	Timestamp         uint64 `json:"timestamp"`
+	MedianTime        uint64 `json:"mediantime"`
+	TxCount           uint32 `json:"tx_count"`
+	Size              uint32 `json:"size"`
+	Weight            uint32 `json:"weight"`
+	MerkleRoot        string `json:"merkle_root"`
+	PreviousBlockHash string `json:"previousblockhash"`
+}
+
+// FeeEstimates maps confirmation targets in blocks to fee rates in sat/vbyte
+type FeeEstimates map[int]float64
+
+// EsploraAsset is the issuance and registry information of an asset as returned by /asset/:id,
+// the registry fields are empty for unregistered assets
+type EsploraAsset struct {
+	AssetId         string               `json:"asset_id"`
+	IssuanceTxIn    *EsploraIssuanceTxIn `json:"issuance_txin"`
+	IssuancePrevout *EsploraOutpoint     `json:"issuance_prevout"`
+	ReissuanceToken string               `json:"reissuance_token"`
+	ChainStats      *EsploraAssetStats   `json:"chain_stats"`
+	MempoolStats    *EsploraAssetStats   `json:"mempool_stats"`
+
+	Name      string              `json:"name"`
+	Ticker    string              `json:"ticker"`
+	Precision uint8               `json:"precision"`
+	Entity    *EsploraAssetEntity `json:"entity"`
+}
+
+type EsploraIssuanceTxIn struct {
+	TxId string `json:"txid"`
+	Vin  uint32 `json:"vin"`
+}
+
+type EsploraOutpoint struct {
+	TxId string `json:"txid"`
+	Vout uint32 `json:"vout"`
+}
+
+type EsploraAssetStats struct {
+	TxCount                uint64 `json:"tx_count"`
+	IssuanceCount          uint64 `json:"issuance_count"`
+	IssuedAmount           uint64 `json:"issued_amount"`
+	BurnedAmount           uint64 `json:"burned_amount"`
+	HasBlindedIssuances    bool   `json:"has_blinded_issuances"`
+	ReissuanceTokens       uint64 `json:"reissuance_tokens"`
+	BurnedReissuanceTokens uint64 `json:"burned_reissuance_tokens"`
+}
+
+type EsploraAssetEntity struct {
+	Domain string `json:"domain"`
+}
@@ -4,14 +4,17 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/txscript"
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
 	"github.com/vulpemventures/go-elements/address"
 	"github.com/vulpemventures/go-elements/elementsutil"
 	"github.com/vulpemventures/go-elements/network"
 	"github.com/vulpemventures/go-elements/payment"
 	"github.com/vulpemventures/go-elements/transaction"
 	"log"
+	"math"
 )
 
 type LiquidOnchain struct {
@@ -41,18 +44,13 @@ type SwapOpeningParams struct {
 	takerPubkey []byte
 	csv uint32
 	phash []byte
-	scriptOutputs []AssetAmountTuple
+	scriptOutputs []asset.AssetAmount
 }
 
-func NewSwapOpeningParams(makerPubkey []byte, takerPubkey []byte, csv uint32, phash []byte, scriptOutputs []AssetAmountTuple) SwapOpeningParams {
+func NewSwapOpeningParams(makerPubkey []byte, takerPubkey []byte, csv uint32, phash []byte, scriptOutputs []asset.AssetAmount) SwapOpeningParams {
 	return SwapOpeningParams{makerPubkey: makerPubkey, takerPubkey: takerPubkey, csv: csv, phash: phash, scriptOutputs: scriptOutputs}
 }
 
-type AssetAmountTuple struct {
-	Asset  []byte
-	Amount uint64
-}
-
 func (s *SwapOpeningParams) ToTxScript() ([]byte, error) {
 	return GetOpeningTxScript(s.takerPubkey,s.makerPubkey, s.phash, s.csv)
 }
@@ -87,7 +85,11 @@ func (l *LiquidOnchain) CreateUnfundedOpeningTransaction(params SwapOpeningParam
 		if err != nil {
 			return "", err
 		}
-		output := transaction.NewTxOutput(v.Asset, sats, outputscript)
+		txAsset, err := v.TxAsset()
+		if err != nil {
+			return "", err
+		}
+		output := transaction.NewTxOutput(txAsset, sats, outputscript)
 		tx.Outputs = append(tx.Outputs, output)
 	}
 
@@ -102,42 +104,26 @@ func (l *LiquidOnchain) CreateUnfundedOpeningTransaction(params SwapOpeningParam
 type ClaimParams struct {
 	openingTxHex string
 	redeemAddress string
-	assetAmount uint64
+	assetAmount asset.AssetAmount
 	csv uint32
 	makerPubkey []byte
 	takerPubkey []byte
 	preimage []byte
 	paymenthash []byte
-	asset []byte
 	signingKey *btcec.PrivateKey
 }
 
-func NewClaimParams(openingTxHex string, redeemAddress string, assetAmount uint64, csv uint32, makerPubkey []byte, takerPubkey []byte, preimage []byte, paymenthash []byte, asset []byte, signingKey *btcec.PrivateKey) ClaimParams {
-	return ClaimParams{openingTxHex: openingTxHex, redeemAddress: redeemAddress, assetAmount: assetAmount, csv: csv, makerPubkey: makerPubkey, takerPubkey: takerPubkey, preimage: preimage, paymenthash: paymenthash, asset: asset, signingKey: signingKey}
+func NewClaimParams(openingTxHex string, redeemAddress string, assetAmount asset.AssetAmount, csv uint32, makerPubkey []byte, takerPubkey []byte, preimage []byte, paymenthash []byte, signingKey *btcec.PrivateKey) ClaimParams {
+	return ClaimParams{openingTxHex: openingTxHex, redeemAddress: redeemAddress, assetAmount: assetAmount, csv: csv, makerPubkey: makerPubkey, takerPubkey: takerPubkey, preimage: preimage, paymenthash: paymenthash, signingKey: signingKey}
 }
 
 func (l *LiquidOnchain) CreatePreimageSpendingTransaction(params ClaimParams) (string, error) {
-	firstTx, err := transaction.NewTxFromHex(params.openingTxHex)
-	if err != nil {
-		return "", err
-	}
-
-	// get script vouts
-	redeemScript, err := GetOpeningTxScript(params.takerPubkey, params.makerPubkey, params.paymenthash, params.csv)
-	if err != nil {
-		return "", err
-	}
-	log.Printf("redeem script %x", redeemScript)
-
-	feeVout, err := l.FindVout(firstTx.Outputs, redeemScript, l.GetAsset())
-	if err != nil {
-		return "", err
-	}
-
-	assetVout, err := l.FindVout(firstTx.Outputs, redeemScript, params.asset)
+	claim, err := l.findClaimOutputs(params)
 	if err != nil {
 		return "", err
 	}
+	firstTx, redeemScript, txAsset := claim.openingTx, claim.redeemScript, claim.txAsset
+	feeVout, assetVout := claim.feeVout, claim.assetVout
 
 	// create new transaction
 	spendingTx := transaction.NewTx(2)
@@ -166,7 +152,7 @@ func (l *LiquidOnchain) CreatePreimageSpendingTransaction(params ClaimParams) (s
 
 
 	feeOutput := transaction.NewTxOutput(l.GetAsset(), firstTx.Outputs[feeVout].Value, []byte{})
-	assetOutput := transaction.NewTxOutput(params.asset, firstTx.Outputs[assetVout].Value, outputScript)
+	assetOutput := transaction.NewTxOutput(txAsset, firstTx.Outputs[assetVout].Value, outputScript)
 
 	spendingTx.Outputs = make([]*transaction.TxOutput,2)
 	spendingTx.Outputs[feeOutputInIndex] = feeOutput
@@ -197,6 +183,206 @@ func (l *LiquidOnchain) CreatePreimageSpendingTransaction(params ClaimParams) (s
 	return txHex, nil
 }
 
+// claimOutputs are the fee and asset outputs of an opening that are claimed with the preimage
+type claimOutputs struct {
+	openingTx    *transaction.Transaction
+	redeemScript []byte
+	txAsset      []byte
+	feeVout      uint32
+	feeValue     uint64
+	assetVout    uint32
+}
+
+// findClaimOutputs returns the outputs of the opening paying to the swap script, the asset output has to hold the claimed amount
+func (l *LiquidOnchain) findClaimOutputs(params ClaimParams) (*claimOutputs, error) {
+	openingTx, err := transaction.NewTxFromHex(params.openingTxHex)
+	if err != nil {
+		return nil, err
+	}
+
+	redeemScript, err := GetOpeningTxScript(params.takerPubkey, params.makerPubkey, params.paymenthash, params.csv)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("redeem script %x", redeemScript)
+
+	feeVout, err := l.FindVout(openingTx.Outputs, redeemScript, l.GetAsset())
+	if err != nil {
+		return nil, err
+	}
+	feeValue, err := elementsutil.ElementsToSatoshiValue(openingTx.Outputs[feeVout].Value)
+	if err != nil {
+		return nil, err
+	}
+
+	txAsset, err := params.assetAmount.TxAsset()
+	if err != nil {
+		return nil, err
+	}
+
+	assetVout, err := l.FindVout(openingTx.Outputs, redeemScript, txAsset)
+	if err != nil {
+		return nil, err
+	}
+	if assetVout == feeVout {
+		// a swap of the policy asset pays the amount with the output after the fee output
+		assetVout, err = l.FindVout(openingTx.Outputs[feeVout+1:], redeemScript, txAsset)
+		if err != nil {
+			return nil, err
+		}
+		assetVout += feeVout + 1
+	}
+
+	assetValue, err := elementsutil.ElementsToSatoshiValue(openingTx.Outputs[assetVout].Value)
+	if err != nil {
+		return nil, err
+	}
+	if assetValue != params.assetAmount.Amount {
+		return nil, fmt.Errorf("opening output holds %v, expected %v", assetValue, params.assetAmount.Amount)
+	}
+	return &claimOutputs{
+		openingTx:    openingTx,
+		redeemScript: redeemScript,
+		txAsset:      txAsset,
+		feeVout:      feeVout,
+		feeValue:     feeValue,
+		assetVout:    assetVout,
+	}, nil
+}
+
+// BatchClaimParams are swaps claimed with their preimages by one transaction
+type BatchClaimParams struct {
+	redeemAddress string
+	// feeRate is the fee rate of the claim in sat/vbyte
+	feeRate float64
+	claims  []ClaimParams
+}
+
+// NewBatchClaimParams claims the swaps to the redeem address, the redeem addresses of the claims are not used
+func NewBatchClaimParams(redeemAddress string, feeRate float64, claims []ClaimParams) BatchClaimParams {
+	return BatchClaimParams{redeemAddress: redeemAddress, feeRate: feeRate, claims: claims}
+}
+
+// CreateBatchClaimTransaction returns a transaction that spends the fee and asset outputs of all claims, which may
+// belong to different openings and assets. The amounts are paid to the redeem address with one output per asset, the
+// fee outputs pay the fee at the fee rate and the rest of them is paid to the redeem address as well
+func (l *LiquidOnchain) CreateBatchClaimTransaction(params BatchClaimParams) (string, error) {
+	if len(params.claims) == 0 {
+		return "", errors.New("no claims to batch")
+	}
+	outputScript, err := address.ToOutputScript(params.redeemAddress)
+	if err != nil {
+		return "", err
+	}
+
+	type claimInput struct {
+		input        *transaction.TxInput
+		value        []byte
+		redeemScript []byte
+		claim        *ClaimParams
+	}
+	var inputs []claimInput
+	spent := make(map[Outpoint]struct{})
+	var assetIds []string
+	amounts := make(map[string]uint64)
+	addAmount := func(assetId string, amount uint64) error {
+		if _, ok := amounts[assetId]; !ok {
+			assetIds = append(assetIds, assetId)
+		}
+		if amounts[assetId]+amount < amounts[assetId] {
+			return asset.ErrAmountOverflow
+		}
+		amounts[assetId] += amount
+		return nil
+	}
+
+	for i := range params.claims {
+		claim := &params.claims[i]
+		outputs, err := l.findClaimOutputs(*claim)
+		if err != nil {
+			return "", err
+		}
+		txHash := outputs.openingTx.TxHash()
+		for _, vout := range []uint32{outputs.feeVout, outputs.assetVout} {
+			outpoint := Outpoint{TxId: txHash.String(), Vout: vout}
+			if _, ok := spent[outpoint]; ok {
+				return "", fmt.Errorf("output %s is claimed twice", outpoint)
+			}
+			spent[outpoint] = struct{}{}
+			input := transaction.NewTxInput(txHash[:], vout)
+			input.Sequence = 0
+			inputs = append(inputs, claimInput{
+				input:        input,
+				value:        outputs.openingTx.Outputs[vout].Value,
+				redeemScript: outputs.redeemScript,
+				claim:        claim,
+			})
+		}
+		err = addAmount(l.network.AssetID, outputs.feeValue)
+		if err != nil {
+			return "", err
+		}
+		err = addAmount(claim.assetAmount.AssetId, claim.assetAmount.Amount)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// build signs the transaction paying the fee, the size does not depend on the fee as all values are explicit
+	build := func(fee uint64) (*transaction.Transaction, error) {
+		if fee > amounts[l.network.AssetID] {
+			return nil, fmt.Errorf("fee %v exceeds the claimed %v sat", fee, amounts[l.network.AssetID])
+		}
+		tx := transaction.NewTx(2)
+		for _, v := range inputs {
+			tx.Inputs = append(tx.Inputs, v.input)
+		}
+		feeValue, err := elementsutil.SatoshiToElementsValue(fee)
+		if err != nil {
+			return nil, err
+		}
+		tx.Outputs = append(tx.Outputs, transaction.NewTxOutput(l.GetAsset(), feeValue, []byte{}))
+		for _, assetId := range assetIds {
+			amount := amounts[assetId]
+			if assetId == l.network.AssetID {
+				amount -= fee
+			}
+			if amount == 0 {
+				continue
+			}
+			value, err := elementsutil.SatoshiToElementsValue(amount)
+			if err != nil {
+				return nil, err
+			}
+			tx.Outputs = append(tx.Outputs, transaction.NewTxOutput(l.TranslateAsset(h2b(assetId)), value, outputScript))
+		}
+
+		for i, v := range inputs {
+			sighash := tx.HashForWitnessV0(i, v.redeemScript, v.value, txscript.SigHashAll)
+			sig, err := v.claim.signingKey.Sign(sighash[:])
+			if err != nil {
+				return nil, err
+			}
+			tx.Inputs[i].Witness = GetPreimageWitness(sig.Serialize(), v.claim.preimage, v.redeemScript)
+		}
+		return tx, nil
+	}
+
+	tx, err := build(0)
+	if err != nil {
+		return "", err
+	}
+	// the signatures of the final transaction differ and der signatures vary by up to two bytes,
+	// which is at most one vbyte per input
+	vsize := tx.VirtualSize() + len(inputs)
+	fee := uint64(math.Ceil(float64(vsize) * params.feeRate))
+	tx, err = build(fee)
+	if err != nil {
+		return "", err
+	}
+	return tx.ToHex()
+}
+
 func (l *LiquidOnchain) FindVout(outputs []*transaction.TxOutput, redeemScript []byte, asset []byte) (uint32, error) {
 	wantAddr, err := l.CreateOpeningAddress(redeemScript)
 	if err != nil {
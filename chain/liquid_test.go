@@ -0,0 +1,63 @@
+package chain
+
+import (
+	"testing"
+
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/network"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+func TestCreateBatchClaimTransaction(t *testing.T) {
+	usdtSwap := newTestSwap(t, testSwapCsv)
+	lbtcSwap := newTestSwapWithAmount(t, testSwapCsv, asset.NewAssetAmount(network.Regtest.AssetID, 8, 20000))
+	onchain := NewLiquidOnchain(&network.Regtest)
+
+	txHex, err := onchain.CreateBatchClaimTransaction(NewBatchClaimParams(usdtSwap.address, 0.1, []ClaimParams{
+		usdtSwap.claimParams(usdtSwap.preimage, usdtSwap.taker),
+		lbtcSwap.claimParams(lbtcSwap.preimage, lbtcSwap.taker),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*testSwap{usdtSwap, lbtcSwap} {
+		spends, err := VerifySwapSpend(txHex, s.openingTxHex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(spends) != 2 {
+			t.Fatalf("expected 2 spends of opening, got %v", len(spends))
+		}
+	}
+
+	tx, err := transaction.NewTxFromHex(txHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Inputs) != 4 || len(tx.Outputs) != 3 {
+		t.Fatalf("expected 4 inputs and 3 outputs, got %v and %v", len(tx.Inputs), len(tx.Outputs))
+	}
+	values := make([]uint64, len(tx.Outputs))
+	for i, out := range tx.Outputs {
+		values[i], err = elementsutil.ElementsToSatoshiValue(out.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fee := values[0]
+	if len(tx.Outputs[0].Script) != 0 || fee == 0 || fee > uint64(tx.VirtualSize()) {
+		t.Fatalf("unexpected fee %v for %v vbytes", fee, tx.VirtualSize())
+	}
+	if values[1] != 1000+20000-fee || values[2] != usdtSwap.amount.Amount {
+		t.Fatalf("unexpected claimed amounts %v", values)
+	}
+
+	_, err = onchain.CreateBatchClaimTransaction(NewBatchClaimParams(usdtSwap.address, 0.1, []ClaimParams{
+		usdtSwap.claimParams(usdtSwap.preimage, usdtSwap.taker),
+		usdtSwap.claimParams(usdtSwap.preimage, usdtSwap.taker),
+	}))
+	if err == nil {
+		t.Fatal("expected an error claiming a swap twice")
+	}
+}
@@ -0,0 +1,641 @@
+package chain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sputn1ck/liquid-go-lightwallet/wallet"
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+var (
+	ErrNotifierStopped = errors.New("chain notifier is stopped")
+)
+
+// epochBufferSize is the number of block epochs queued for a client before the notifier waits for it
+const epochBufferSize = 20
+
+// ReorgSafetyLimit is the number of recent blocks whose hashes are kept to detect reorgs. Confirmation
+// registrations are kept until the transaction is this deep, so a reorg within it is reported
+const ReorgSafetyLimit = 12
+
+// Outpoint is a transaction output
+type Outpoint struct {
+	TxId string
+	Vout uint32
+}
+
+func (o Outpoint) String() string {
+	return fmt.Sprintf("%s:%v", o.TxId, o.Vout)
+}
+
+// TxConfirmation is sent once a transaction reached the requested number of confirmations
+type TxConfirmation struct {
+	TxId string
+
+	// BlockHash and BlockHeight are the block of the transaction, they are empty for mempool transactions
+	BlockHash   string
+	BlockHeight uint32
+
+	// NumConfs is the number of confirmations when the notification was sent
+	NumConfs uint32
+}
+
+// SpendDetail is sent once a transaction spending a watched outpoint is seen in the mempool or a block
+type SpendDetail struct {
+	Outpoint          Outpoint
+	SpenderTxId       string
+	SpenderTxHex      string
+	SpenderInputIndex uint32
+
+	// SpendingHeight is the height of the block of the spending transaction, or 0 if it is in the mempool
+	SpendingHeight uint32
+}
+
+// TxConflict is a transaction spending an input of a watched transaction, it replaces or double spends
+// the watched transaction
+type TxConflict struct {
+	TxId string
+
+	// Outpoint is the input of the watched transaction that the conflicting transaction spends
+	Outpoint         Outpoint
+	ConflictingTxId  string
+	ConflictingTxHex string
+
+	// SpendingHeight is the height of the block of the conflicting transaction, or 0 if it is in the mempool
+	SpendingHeight uint32
+}
+
+// BlockEpoch is a block connected to the best chain
+type BlockEpoch struct {
+	Height uint32
+	Hash   string
+}
+
+// ConfirmationEvent delivers the confirmation of a transaction. If a reorg removes the confirmed transaction
+// from the best chain NegativeConf delivers the number of disconnected blocks, and Confirmed delivers again
+// once the transaction confirms in the new chain. Each channel holds the latest event only, a pending event
+// of the other channel is dropped when it is superseded. The registration ends once the transaction is
+// ReorgSafetyLimit blocks deep, mempool notifications with numConfs 0 are sent once. Cancel stops the registration
+type ConfirmationEvent struct {
+	Confirmed    <-chan *TxConfirmation
+	NegativeConf <-chan uint32
+	Cancel       func()
+}
+
+// SpendEvent delivers a single spend notification, Cancel stops the registration
+type SpendEvent struct {
+	Spend  <-chan *SpendDetail
+	Cancel func()
+}
+
+// ConflictEvent delivers every conflicting transaction once, Cancel stops the registration
+type ConflictEvent struct {
+	Conflicts <-chan *TxConflict
+	Cancel    func()
+}
+
+// BlockEpochEvent delivers the current best block and every block connected afterwards. After a reorg the
+// fork block is sent again, followed by the blocks of the new chain.
+// Epochs are sent in order, a client that does not read them stalls the notifier, so it has to Cancel when done
+type BlockEpochEvent struct {
+	Epochs <-chan *BlockEpoch
+	Cancel func()
+}
+
+// Notifier notifies about confirmations, spends and new blocks
+type Notifier interface {
+	Start() error
+	Stop() error
+
+	// RegisterConfirmationsNtfn notifies once the transaction has numConfs confirmations,
+	// with numConfs 0 it notifies as soon as the transaction is in the mempool
+	RegisterConfirmationsNtfn(txId string, numConfs uint32) (*ConfirmationEvent, error)
+
+	// RegisterSpendNtfn notifies once a transaction spending the outpoint is seen
+	RegisterSpendNtfn(outpoint Outpoint) (*SpendEvent, error)
+
+	// RegisterBlockEpochNtfn notifies about the current best block and every new block
+	RegisterBlockEpochNtfn() (*BlockEpochEvent, error)
+
+	// RegisterConflictNtfn notifies about the transactions that spend an input of the transaction but are
+	// not the transaction itself, e.g. a replacement in the mempool or a double spend
+	RegisterConflictNtfn(txHex string) (*ConflictEvent, error)
+}
+
+type confNtfn struct {
+	txId     string
+	numConfs uint32
+	ch       chan *TxConfirmation
+	negCh    chan uint32
+
+	// confirmed is the last confirmation sent, it is reset when the transaction is reorged out
+	confirmed *TxConfirmation
+}
+
+// sendConf sends a confirmation, replacing the pending events of the client
+func (n *confNtfn) sendConf(conf *TxConfirmation) {
+	n.confirmed = conf
+	n.drain()
+	n.ch <- conf
+}
+
+// sendNegativeConf sends a reorg of the confirmed transaction, replacing the pending events of the client
+func (n *confNtfn) sendNegativeConf(depth uint32) {
+	n.confirmed = nil
+	n.drain()
+	n.negCh <- depth
+}
+
+// drain removes the pending events, the client reads concurrently so the channels may be empty already
+func (n *confNtfn) drain() {
+	select {
+	case <-n.ch:
+	default:
+	}
+	select {
+	case <-n.negCh:
+	default:
+	}
+}
+
+type spendNtfn struct {
+	ch chan *SpendDetail
+}
+
+// conflictBufferSize is the number of conflicts queued for a client, further conflicts are dropped until it reads
+const conflictBufferSize = 10
+
+type conflictNtfn struct {
+	txId string
+	ch   chan *TxConflict
+
+	// seen holds the conflicting transactions that were sent
+	seen map[string]struct{}
+}
+
+type epochNtfn struct {
+	ch     chan *BlockEpoch
+	cancel chan struct{}
+}
+
+// ntfnRegistry keeps the registrations of a notifier and dispatches the notifications, the notifier
+// backends feed it with blocks, transaction statuses and spends
+type ntfnRegistry struct {
+	mu     sync.Mutex
+	nextId uint64
+
+	bestBlock *BlockEpoch
+	// blocks holds the hashes of the last ReorgSafetyLimit blocks of the best chain by height
+	blocks map[uint32]string
+
+	confs     map[string]map[uint64]*confNtfn
+	txStatus  map[string]*wallet.Status
+	spends    map[Outpoint]map[uint64]*spendNtfn
+	conflicts map[Outpoint]map[uint64]*conflictNtfn
+	epochs    map[uint64]*epochNtfn
+
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+func newNtfnRegistry() *ntfnRegistry {
+	return &ntfnRegistry{
+		blocks:    make(map[uint32]string),
+		confs:     make(map[string]map[uint64]*confNtfn),
+		txStatus:  make(map[string]*wallet.Status),
+		spends:    make(map[Outpoint]map[uint64]*spendNtfn),
+		conflicts: make(map[Outpoint]map[uint64]*conflictNtfn),
+		epochs:    make(map[uint64]*epochNtfn),
+		quit:      make(chan struct{}),
+	}
+}
+
+func (r *ntfnRegistry) stop() {
+	r.quitOnce.Do(func() {
+		close(r.quit)
+	})
+}
+
+func (r *ntfnRegistry) stopped() bool {
+	select {
+	case <-r.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *ntfnRegistry) registerConf(txId string, numConfs uint32) (*ConfirmationEvent, error) {
+	if r.stopped() {
+		return nil, ErrNotifierStopped
+	}
+	ntfn := &confNtfn{txId: txId, numConfs: numConfs, ch: make(chan *TxConfirmation, 1), negCh: make(chan uint32, 1)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := r.nextId
+	r.nextId++
+	if r.confs[txId] == nil {
+		r.confs[txId] = make(map[uint64]*confNtfn)
+	}
+	r.confs[txId][id] = ntfn
+	r.dispatchConfs(txId)
+
+	return &ConfirmationEvent{
+		Confirmed:    ntfn.ch,
+		NegativeConf: ntfn.negCh,
+		Cancel: func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.removeConf(txId, id)
+		},
+	}, nil
+}
+
+func (r *ntfnRegistry) registerSpend(outpoint Outpoint) (*SpendEvent, error) {
+	if r.stopped() {
+		return nil, ErrNotifierStopped
+	}
+	ntfn := &spendNtfn{ch: make(chan *SpendDetail, 1)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := r.nextId
+	r.nextId++
+	if r.spends[outpoint] == nil {
+		r.spends[outpoint] = make(map[uint64]*spendNtfn)
+	}
+	r.spends[outpoint][id] = ntfn
+
+	return &SpendEvent{
+		Spend: ntfn.ch,
+		Cancel: func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.removeSpend(outpoint, id)
+		},
+	}, nil
+}
+
+// registerConflict watches the inputs of a transaction for spends by other transactions
+func (r *ntfnRegistry) registerConflict(txHex string) (*ConflictEvent, error) {
+	if r.stopped() {
+		return nil, ErrNotifierStopped
+	}
+	tx, err := transaction.NewTxFromHex(txHex)
+	if err != nil {
+		return nil, err
+	}
+	ntfn := &conflictNtfn{
+		txId: tx.TxHash().String(),
+		ch:   make(chan *TxConflict, conflictBufferSize),
+		seen: make(map[string]struct{}),
+	}
+	var outpoints []Outpoint
+	for _, in := range tx.Inputs {
+		outpoints = append(outpoints, Outpoint{TxId: hex.EncodeToString(elementsutil.ReverseBytes(in.Hash)), Vout: in.Index})
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := r.nextId
+	r.nextId++
+	for _, v := range outpoints {
+		if r.conflicts[v] == nil {
+			r.conflicts[v] = make(map[uint64]*conflictNtfn)
+		}
+		r.conflicts[v][id] = ntfn
+	}
+
+	return &ConflictEvent{
+		Conflicts: ntfn.ch,
+		Cancel: func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			for _, v := range outpoints {
+				delete(r.conflicts[v], id)
+				if len(r.conflicts[v]) == 0 {
+					delete(r.conflicts, v)
+				}
+			}
+		},
+	}, nil
+}
+
+func (r *ntfnRegistry) registerEpoch() (*BlockEpochEvent, error) {
+	if r.stopped() {
+		return nil, ErrNotifierStopped
+	}
+	ntfn := &epochNtfn{ch: make(chan *BlockEpoch, epochBufferSize), cancel: make(chan struct{})}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := r.nextId
+	r.nextId++
+	r.epochs[id] = ntfn
+	if r.bestBlock != nil {
+		ntfn.ch <- r.bestBlock
+	}
+
+	var once sync.Once
+	return &BlockEpochEvent{
+		Epochs: ntfn.ch,
+		Cancel: func() {
+			// unblock a pending send before taking the lock
+			once.Do(func() {
+				close(ntfn.cancel)
+			})
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			delete(r.epochs, id)
+		},
+	}, nil
+}
+
+func (r *ntfnRegistry) removeConf(txId string, id uint64) {
+	delete(r.confs[txId], id)
+	if len(r.confs[txId]) == 0 {
+		delete(r.confs, txId)
+		delete(r.txStatus, txId)
+	}
+}
+
+func (r *ntfnRegistry) removeSpend(outpoint Outpoint, id uint64) {
+	delete(r.spends[outpoint], id)
+	if len(r.spends[outpoint]) == 0 {
+		delete(r.spends, outpoint)
+	}
+}
+
+// getBestBlock returns the last connected block, or nil if the notifier has not started yet
+func (r *ntfnRegistry) getBestBlock() *BlockEpoch {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.bestBlock
+}
+
+// pendingTxIds returns the transactions with registered confirmation notifications
+func (r *ntfnRegistry) pendingTxIds() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	txIds := make([]string, 0, len(r.confs))
+	for k := range r.confs {
+		txIds = append(txIds, k)
+	}
+	return txIds
+}
+
+// pendingOutpoints returns the outpoints with registered spend or conflict notifications
+func (r *ntfnRegistry) pendingOutpoints() []Outpoint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	outpoints := make([]Outpoint, 0, len(r.spends)+len(r.conflicts))
+	for k := range r.spends {
+		outpoints = append(outpoints, k)
+	}
+	for k := range r.conflicts {
+		if _, ok := r.spends[k]; !ok {
+			outpoints = append(outpoints, k)
+		}
+	}
+	return outpoints
+}
+
+// isWatchedTx returns true if the transaction has registered confirmation notifications
+func (r *ntfnRegistry) isWatchedTx(txId string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.confs[txId]
+	return ok
+}
+
+// isWatchedOutpoint returns true if the outpoint has registered spend or conflict notifications
+func (r *ntfnRegistry) isWatchedOutpoint(outpoint Outpoint) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.spends[outpoint]
+	_, conflictOk := r.conflicts[outpoint]
+	return ok || conflictOk
+}
+
+// isNewSpend returns true if a spend of the outpoint by the transaction is sent to a client, so
+// backends only fetch the spending transaction if it is needed
+func (r *ntfnRegistry) isNewSpend(outpoint Outpoint, spenderTxId string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.spends[outpoint]; ok {
+		return true
+	}
+	for _, v := range r.conflicts[outpoint] {
+		if _, seen := v.seen[spenderTxId]; !seen && v.txId != spenderTxId {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTxStatus returns true if the status of a watched transaction is known
+func (r *ntfnRegistry) hasTxStatus(txId string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.txStatus[txId]
+	return ok
+}
+
+// setTxStatus updates the status of a watched transaction and sends the confirmations it reached. A confirmed
+// transaction is only unconfirmed by disconnectBlocks, a backend may see it in the mempool before the reorg
+func (r *ntfnRegistry) setTxStatus(txId string, status *wallet.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.confs[txId]; !ok {
+		return
+	}
+	if current := r.txStatus[txId]; current != nil && current.Confirmed && !status.Confirmed {
+		return
+	}
+	r.txStatus[txId] = status
+	r.dispatchConfs(txId)
+}
+
+// spent sends the spend of a watched outpoint and removes its registrations. A spend by another
+// transaction than the one watching the outpoint for conflicts is sent as conflict
+func (r *ntfnRegistry) spent(detail *SpendDetail) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, v := range r.spends[detail.Outpoint] {
+		v.ch <- detail
+		r.removeSpend(detail.Outpoint, id)
+	}
+	for _, v := range r.conflicts[detail.Outpoint] {
+		if _, seen := v.seen[detail.SpenderTxId]; seen || v.txId == detail.SpenderTxId {
+			continue
+		}
+		v.seen[detail.SpenderTxId] = struct{}{}
+		conflict := &TxConflict{
+			TxId:             v.txId,
+			Outpoint:         detail.Outpoint,
+			ConflictingTxId:  detail.SpenderTxId,
+			ConflictingTxHex: detail.SpenderTxHex,
+			SpendingHeight:   detail.SpendingHeight,
+		}
+		select {
+		case v.ch <- conflict:
+		default:
+		}
+	}
+}
+
+// connectBlock sets the new best block, sends the epoch to the clients and the confirmations the block completes
+func (r *ntfnRegistry) connectBlock(epoch *BlockEpoch) {
+	r.mu.Lock()
+	r.bestBlock = epoch
+	r.blocks[epoch.Height] = epoch.Hash
+	if epoch.Height >= ReorgSafetyLimit {
+		delete(r.blocks, epoch.Height-ReorgSafetyLimit)
+	}
+	for txId := range r.confs {
+		r.dispatchConfs(txId)
+	}
+	r.mu.Unlock()
+	r.sendEpoch(epoch)
+}
+
+// disconnectBlocks rolls the best chain back to the fork block of a reorg. The watched transactions of the
+// disconnected blocks lose their status until the backend finds them again, their confirmed clients are
+// sent a negative confirmation
+func (r *ntfnRegistry) disconnectBlocks(fork *BlockEpoch) {
+	r.mu.Lock()
+	depth := r.bestBlock.Height - fork.Height
+	if recorded, ok := r.blocks[fork.Height]; ok && recorded != fork.Hash {
+		// the reorg is deeper than the recorded blocks, so the fork block itself is replaced
+		depth++
+	}
+	for h := range r.blocks {
+		if h > fork.Height {
+			delete(r.blocks, h)
+		}
+	}
+	r.blocks[fork.Height] = fork.Hash
+	r.bestBlock = fork
+	for txId, status := range r.txStatus {
+		if status == nil || !status.Confirmed || !isDisconnected(status, fork) {
+			continue
+		}
+		delete(r.txStatus, txId)
+		for _, v := range r.confs[txId] {
+			if v.confirmed != nil {
+				v.sendNegativeConf(depth)
+			}
+		}
+	}
+	r.mu.Unlock()
+	r.sendEpoch(fork)
+}
+
+// isDisconnected returns true if the block of a confirmed transaction is not in the chain of the fork block
+func isDisconnected(status *wallet.Status, fork *BlockEpoch) bool {
+	if status.BlockHeight == fork.Height && status.BlockHash != "" {
+		return status.BlockHash != fork.Hash
+	}
+	return status.BlockHeight > fork.Height
+}
+
+// syncBlocks connects the blocks of the backend up to its tip. A recorded block whose hash changed means
+// a reorg, the blocks after the fork are disconnected before the blocks of the new chain are connected
+func (r *ntfnRegistry) syncBlocks(tipHeight uint32, getBlockHash func(height uint32) (string, error), connect func(epoch *BlockEpoch) error) error {
+	best := r.getBestBlock()
+	fork, err := r.findFork(best, tipHeight, getBlockHash)
+	if err != nil {
+		return err
+	}
+	if fork.Height < best.Height || fork.Hash != best.Hash {
+		r.disconnectBlocks(fork)
+	}
+	for h := fork.Height + 1; h <= tipHeight; h++ {
+		hash, err := getBlockHash(h)
+		if err != nil {
+			return err
+		}
+		err = connect(&BlockEpoch{Height: h, Hash: hash})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findFork returns the last recorded block that is still in the best chain of the backend. A reorg
+// deeper than the recorded blocks forks at the oldest recorded height
+func (r *ntfnRegistry) findFork(best *BlockEpoch, tipHeight uint32, getBlockHash func(height uint32) (string, error)) (*BlockEpoch, error) {
+	h := best.Height
+	if tipHeight < h {
+		h = tipHeight
+	}
+	for {
+		hash, err := getBlockHash(h)
+		if err != nil {
+			return nil, err
+		}
+		r.mu.Lock()
+		recorded, ok := r.blocks[h]
+		_, hasPrev := r.blocks[h-1]
+		r.mu.Unlock()
+		if (ok && recorded == hash) || !hasPrev || h == 0 {
+			return &BlockEpoch{Height: h, Hash: hash}, nil
+		}
+		h--
+	}
+}
+
+// sendEpoch sends a new best block to the clients
+func (r *ntfnRegistry) sendEpoch(epoch *BlockEpoch) {
+	r.mu.Lock()
+	epochs := make([]*epochNtfn, 0, len(r.epochs))
+	for _, v := range r.epochs {
+		epochs = append(epochs, v)
+	}
+	r.mu.Unlock()
+
+	// a slow client must not block registrations, so the epochs are sent without the lock
+	for _, v := range epochs {
+		select {
+		case v.ch <- epoch:
+		case <-v.cancel:
+		case <-r.quit:
+			return
+		}
+	}
+}
+
+// dispatchConfs sends the notifications of a transaction whose confirmations are reached and ends the
+// registrations that are safe from reorgs, the lock must be held
+func (r *ntfnRegistry) dispatchConfs(txId string) {
+	status, ok := r.txStatus[txId]
+	if !ok || status == nil {
+		return
+	}
+	conf := &TxConfirmation{TxId: txId}
+	if status.Confirmed {
+		if r.bestBlock == nil || r.bestBlock.Height < status.BlockHeight {
+			return
+		}
+		conf.BlockHash = status.BlockHash
+		conf.BlockHeight = status.BlockHeight
+		conf.NumConfs = r.bestBlock.Height - status.BlockHeight + 1
+	}
+	for id, v := range r.confs[txId] {
+		if conf.NumConfs < v.numConfs {
+			continue
+		}
+		if v.confirmed == nil || v.confirmed.BlockHash != conf.BlockHash {
+			v.sendConf(conf)
+		}
+		if v.numConfs == 0 || conf.NumConfs >= ReorgSafetyLimit {
+			r.removeConf(txId, id)
+		}
+	}
+}
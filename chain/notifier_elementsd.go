@@ -0,0 +1,373 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sputn1ck/liquid-go-lightwallet/wallet"
+	"github.com/ybbus/jsonrpc"
+)
+
+// rpcInvalidAddressOrKey is the rpc error code of unknown transactions and blocks
+const rpcInvalidAddressOrKey = -5
+
+// recentTxBlocks is the number of recent blocks scanned for a confirmed transaction if elementsd has no txindex
+const recentTxBlocks = 12
+
+var (
+	ErrTxNotIndexed = errors.New("transaction is not in the mempool or the recent blocks and elementsd has no txindex")
+)
+
+// ElementsdNotifier is a Notifier that scans the blocks and the mempool of elementsd for the watched
+// transactions and outpoints
+type ElementsdNotifier struct {
+	*ntfnRegistry
+
+	client       *wallet.ElementsdClient
+	pollInterval time.Duration
+
+	// scanMu serializes the scans of blocks and mempool transactions
+	scanMu sync.Mutex
+
+	// mempool holds the mempool transactions that are already scanned
+	mempool map[string]struct{}
+
+	// txIndex is set if getrawtransaction finds confirmed transactions
+	txIndex bool
+
+	// zmqHashBlockAddr and zmqRawTxAddr are the zmq endpoints, zmqDown counts the zmq sockets that are not connected
+	zmqHashBlockAddr string
+	zmqRawTxAddr     string
+	zmqDown          int32
+
+	// poke triggers a poll, e.g. on a zmq block
+	poke chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewElementsdNotifier(client *wallet.ElementsdClient, pollInterval time.Duration) *ElementsdNotifier {
+	return &ElementsdNotifier{
+		ntfnRegistry: newNtfnRegistry(),
+		client:       client,
+		pollInterval: pollInterval,
+		mempool:      make(map[string]struct{}),
+		poke:         make(chan struct{}, 1),
+	}
+}
+
+// Start fetches the current best block and starts polling
+func (e *ElementsdNotifier) Start() error {
+	info, err := e.client.GetBlockchainInfo()
+	if err != nil {
+		return err
+	}
+	// elementsd versions without getindexinfo are treated as running without txindex
+	indexes, err := e.client.GetIndexInfo()
+	if err == nil && indexes["txindex"] != nil && indexes["txindex"].Synced {
+		e.txIndex = true
+	}
+	e.connectBlock(&BlockEpoch{Height: info.Blocks, Hash: info.BestBlockHash})
+
+	if e.zmqHashBlockAddr != "" {
+		e.startZmq()
+	}
+	e.wg.Add(1)
+	go e.pollLoop()
+	return nil
+}
+
+func (e *ElementsdNotifier) Stop() error {
+	e.stop()
+	e.wg.Wait()
+	return nil
+}
+
+// RegisterConfirmationsNtfn looks up the transaction in the mempool and the txindex, a transaction that is unknown
+// to elementsd is found once it shows up in the mempool or a block. Without txindex the last recentTxBlocks blocks
+// are scanned instead, a transaction that is in none of them fails with ErrTxNotIndexed
+func (e *ElementsdNotifier) RegisterConfirmationsNtfn(txId string, numConfs uint32) (*ConfirmationEvent, error) {
+	event, err := e.registerConf(txId, numConfs)
+	if err != nil {
+		return nil, err
+	}
+	status, err := e.lookupTxStatus(txId)
+	if err != nil {
+		event.Cancel()
+		return nil, err
+	}
+	if status != nil {
+		e.setTxStatus(txId, status)
+	}
+	return event, nil
+}
+
+// RegisterSpendNtfn checks whether the outpoint is already spent, if so the blocks since the funding
+// transaction and the mempool are scanned for the spend. The funding transaction is looked up like in
+// RegisterConfirmationsNtfn, so without txindex an old funding transaction fails with ErrTxNotIndexed
+func (e *ElementsdNotifier) RegisterSpendNtfn(outpoint Outpoint) (*SpendEvent, error) {
+	event, err := e.registerSpend(outpoint)
+	if err != nil {
+		return nil, err
+	}
+	err = e.rescanSpend(outpoint)
+	if err != nil {
+		event.Cancel()
+		return nil, err
+	}
+	return event, nil
+}
+
+// RegisterConflictNtfn rescans the mempool for conflicts that were broadcast before the registration,
+// later conflicts are found by the scans of new mempool transactions and blocks
+func (e *ElementsdNotifier) RegisterConflictNtfn(txHex string) (*ConflictEvent, error) {
+	event, err := e.registerConflict(txHex)
+	if err != nil {
+		return nil, err
+	}
+	err = e.rescanMempool()
+	if err != nil {
+		event.Cancel()
+		return nil, err
+	}
+	return event, nil
+}
+
+func (e *ElementsdNotifier) RegisterBlockEpochNtfn() (*BlockEpochEvent, error) {
+	return e.registerEpoch()
+}
+
+func (e *ElementsdNotifier) triggerPoll() {
+	select {
+	case e.poke <- struct{}{}:
+	default:
+	}
+}
+
+func (e *ElementsdNotifier) pollLoop() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(e.pollInterval)
+	defer ticker.Stop()
+	var lastPoll time.Time
+	for {
+		select {
+		case <-e.quit:
+			return
+		case <-ticker.C:
+			if e.zmqConnected() && time.Since(lastPoll) < zmqPollInterval {
+				continue
+			}
+		case <-e.poke:
+		}
+		lastPoll = time.Now()
+		err := e.poll()
+		if err != nil && !e.stopped() {
+			log.Printf("elementsd notifier: %v", err)
+		}
+	}
+}
+
+// poll scans the blocks connected since the last poll and the new mempool transactions. The transactions
+// of blocks disconnected by a reorg are found again in the new blocks or back in the mempool
+func (e *ElementsdNotifier) poll() error {
+	info, err := e.client.GetBlockchainInfo()
+	if err != nil {
+		return err
+	}
+	err = e.syncBlocks(info.Blocks, e.client.GetBlockHash, func(epoch *BlockEpoch) error {
+		return e.scanBlock(epoch.Hash)
+	})
+	if err != nil {
+		return err
+	}
+	return e.scanMempool()
+}
+
+// scanBlock scans the transactions of a block and connects it
+func (e *ElementsdNotifier) scanBlock(blockHash string) error {
+	block, err := e.client.GetBlock(blockHash)
+	if err != nil {
+		return err
+	}
+	e.scanMu.Lock()
+	defer e.scanMu.Unlock()
+	status := &wallet.Status{Confirmed: true, BlockHeight: block.Height, BlockHash: block.Hash}
+	for _, tx := range block.Tx {
+		e.scanTx(tx, status)
+		delete(e.mempool, tx.TxId)
+	}
+	e.connectBlock(&BlockEpoch{Height: block.Height, Hash: block.Hash})
+	return nil
+}
+
+// scanMempool scans the mempool transactions that were not scanned yet
+func (e *ElementsdNotifier) scanMempool() error {
+	txIds, err := e.client.GetRawMempool()
+	if err != nil {
+		return err
+	}
+	e.scanMu.Lock()
+	defer e.scanMu.Unlock()
+	current := make(map[string]struct{}, len(txIds))
+	for _, txId := range txIds {
+		current[txId] = struct{}{}
+		if _, ok := e.mempool[txId]; ok {
+			continue
+		}
+		tx, err := e.client.GetRawTransaction(txId)
+		if isRpcNotFound(err) {
+			// the transaction left the mempool in the meantime
+			delete(current, txId)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		e.scanTx(tx, &wallet.Status{})
+	}
+	e.mempool = current
+	return nil
+}
+
+// scanTx updates the watched transactions and sends the spends of watched outpoints
+func (e *ElementsdNotifier) scanTx(tx *wallet.RawTransactionRes, status *wallet.Status) {
+	if e.isWatchedTx(tx.TxId) {
+		e.setTxStatus(tx.TxId, status)
+	}
+	e.scanSpends(tx, status.BlockHeight)
+}
+
+// scanSpends sends the spends of watched outpoints by the transaction, the height is 0 for mempool transactions
+func (e *ElementsdNotifier) scanSpends(tx *wallet.RawTransactionRes, height uint32) {
+	for i, in := range tx.Vin {
+		if in.Coinbase != "" {
+			continue
+		}
+		outpoint := Outpoint{TxId: in.TxId, Vout: in.Vout}
+		if !e.isWatchedOutpoint(outpoint) {
+			continue
+		}
+		e.spent(&SpendDetail{
+			Outpoint:          outpoint,
+			SpenderTxId:       tx.TxId,
+			SpenderTxHex:      tx.Hex,
+			SpenderInputIndex: uint32(i),
+			SpendingHeight:    height,
+		})
+	}
+}
+
+// lookupTxStatus returns the status of a transaction, or nil if elementsd does not know it. getrawtransaction
+// only finds confirmed transactions with -txindex, so without it the recent blocks are scanned
+func (e *ElementsdNotifier) lookupTxStatus(txId string) (*wallet.Status, error) {
+	tx, err := e.client.GetRawTransaction(txId)
+	if isRpcNotFound(err) {
+		if e.txIndex {
+			return nil, nil
+		}
+		return e.findRecentTx(txId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tx.BlockHash == "" {
+		return &wallet.Status{}, nil
+	}
+	header, err := e.client.GetBlockHeader(tx.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+	if header.Confirmations < 0 {
+		// the block was reorged out, the transaction is found again once it confirms
+		return nil, nil
+	}
+	return &wallet.Status{Confirmed: true, BlockHeight: header.Height, BlockHash: header.Hash}, nil
+}
+
+// findRecentTx scans the last recentTxBlocks blocks for a confirmed transaction. If the chain is longer,
+// the transaction may have confirmed in an older block or be unknown, which fails with ErrTxNotIndexed
+func (e *ElementsdNotifier) findRecentTx(txId string) (*wallet.Status, error) {
+	best := e.getBestBlock()
+	if best == nil {
+		return nil, nil
+	}
+	for i := uint32(0); i < recentTxBlocks && i <= best.Height; i++ {
+		hash, err := e.client.GetBlockHash(best.Height - i)
+		if err != nil {
+			return nil, err
+		}
+		block, err := e.client.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range block.Tx {
+			if tx.TxId == txId {
+				return &wallet.Status{Confirmed: true, BlockHeight: block.Height, BlockHash: block.Hash}, nil
+			}
+		}
+	}
+	if best.Height >= recentTxBlocks {
+		return nil, fmt.Errorf("%w: %s", ErrTxNotIndexed, txId)
+	}
+	return nil, nil
+}
+
+// rescanSpend looks for the spend of an outpoint that is no longer unspent
+func (e *ElementsdNotifier) rescanSpend(outpoint Outpoint) error {
+	txOut, err := e.client.GetTxOut(outpoint.TxId, outpoint.Vout, true)
+	if err != nil {
+		return err
+	}
+	if txOut != nil {
+		return nil
+	}
+	funding, err := e.lookupTxStatus(outpoint.TxId)
+	if err != nil || funding == nil {
+		// the funding transaction is not known yet, so there is no spend either
+		return err
+	}
+
+	if funding.Confirmed {
+		best := e.getBestBlock()
+		for h := funding.BlockHeight; h <= best.Height; h++ {
+			if !e.isWatchedOutpoint(outpoint) {
+				return nil
+			}
+			hash, err := e.client.GetBlockHash(h)
+			if err != nil {
+				return err
+			}
+			block, err := e.client.GetBlock(hash)
+			if err != nil {
+				return err
+			}
+			status := &wallet.Status{Confirmed: true, BlockHeight: block.Height, BlockHash: block.Hash}
+			e.scanMu.Lock()
+			for _, tx := range block.Tx {
+				e.scanTx(tx, status)
+			}
+			e.scanMu.Unlock()
+		}
+	}
+	if !e.isWatchedOutpoint(outpoint) {
+		return nil
+	}
+
+	// the spend is in the mempool
+	return e.rescanMempool()
+}
+
+// rescanMempool scans all mempool transactions again
+func (e *ElementsdNotifier) rescanMempool() error {
+	e.scanMu.Lock()
+	e.mempool = make(map[string]struct{})
+	e.scanMu.Unlock()
+	return e.scanMempool()
+}
+
+func isRpcNotFound(err error) bool {
+	var rpcErr *jsonrpc.RPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == rpcInvalidAddressOrKey
+}
@@ -0,0 +1,210 @@
+package chain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sputn1ck/liquid-go-lightwallet/wallet"
+)
+
+// fakeElementsd serves the rpc calls of the elementsd notifier from an in memory chain
+type fakeElementsd struct {
+	mu      sync.Mutex
+	blocks  []*wallet.BlockRes
+	mempool map[string]*wallet.RawTransactionRes
+	// spent are the outpoints gettxout does not return
+	spent map[Outpoint]bool
+	// txIndex makes getindexinfo report a synced txindex, getrawtransaction still serves mempool transactions only
+	txIndex bool
+
+	// forks counts the reorgs, the blocks of a fork get different hashes
+	forks int
+}
+
+func newFakeElementsd(height uint32) *fakeElementsd {
+	f := &fakeElementsd{mempool: make(map[string]*wallet.RawTransactionRes), spent: make(map[Outpoint]bool)}
+	for h := uint32(0); h <= height; h++ {
+		f.blocks = append(f.blocks, &wallet.BlockRes{Hash: fmt.Sprintf("block%v", h), Height: h})
+	}
+	return f
+}
+
+func (f *fakeElementsd) mine(txs ...*wallet.RawTransactionRes) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	height := uint32(len(f.blocks))
+	hash := fmt.Sprintf("block%v", height)
+	if f.forks > 0 {
+		hash = fmt.Sprintf("block%v-%v", height, f.forks)
+	}
+	f.blocks = append(f.blocks, &wallet.BlockRes{Hash: hash, Height: height, Tx: txs})
+	for _, v := range txs {
+		delete(f.mempool, v.TxId)
+	}
+}
+
+// invalidate disconnects the last blocks like invalidateblock, their transactions go back to the mempool
+func (f *fakeElementsd) invalidate(depth int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, block := range f.blocks[len(f.blocks)-depth:] {
+		for _, v := range block.Tx {
+			f.mempool[v.TxId] = v
+		}
+	}
+	f.blocks = f.blocks[:len(f.blocks)-depth]
+	f.forks++
+}
+
+func (f *fakeElementsd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Id     int               `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	var param string
+	if len(req.Params) > 0 {
+		json.Unmarshal(req.Params[0], &param)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result interface{}
+	var rpcErr interface{}
+	tip := f.blocks[len(f.blocks)-1]
+	switch req.Method {
+	case "getblockchaininfo":
+		result = &wallet.BlockchainInfo{Blocks: tip.Height, BestBlockHash: tip.Hash}
+	case "getindexinfo":
+		indexes := make(map[string]*wallet.IndexInfoRes)
+		if f.txIndex {
+			indexes["txindex"] = &wallet.IndexInfoRes{Synced: true, BestBlockHeight: tip.Height}
+		}
+		result = indexes
+	case "getblockhash":
+		var height int
+		json.Unmarshal(req.Params[0], &height)
+		result = f.blocks[height].Hash
+	case "getblock":
+		for _, v := range f.blocks {
+			if v.Hash == param {
+				result = v
+			}
+		}
+	case "getrawmempool":
+		txIds := []string{}
+		for k := range f.mempool {
+			txIds = append(txIds, k)
+		}
+		result = txIds
+	case "getrawtransaction":
+		tx, ok := f.mempool[param]
+		if !ok {
+			rpcErr = map[string]interface{}{"code": rpcInvalidAddressOrKey, "message": "No such mempool transaction"}
+			break
+		}
+		result = tx
+	case "gettxout":
+		var vout uint32
+		json.Unmarshal(req.Params[1], &vout)
+		if !f.spent[Outpoint{TxId: param, Vout: vout}] {
+			result = &wallet.TxOutRes{BestBlock: tip.Hash}
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": result, "error": rpcErr})
+}
+
+// newTestElementsdNotifier starts an elementsd notifier polling the fake chain
+func newTestElementsdNotifier(t *testing.T, fakeChain *fakeElementsd, pollInterval time.Duration) *ElementsdNotifier {
+	rpcServer := httptest.NewServer(fakeChain)
+	t.Cleanup(rpcServer.Close)
+	client, err := wallet.NewElementsdClient(strings.TrimPrefix(rpcServer.URL, "http://"), "user", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := NewElementsdNotifier(client, pollInterval)
+	err = notifier.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		notifier.Stop()
+	})
+	return notifier
+}
+
+func TestElementsdNotifierConfirmedBeforeRegistration(t *testing.T) {
+	// elementsd runs without txindex, getrawtransaction does not find the confirmed transaction
+	fakeChain := newFakeElementsd(10)
+	fakeChain.mine(&wallet.RawTransactionRes{TxId: "tx1"})
+	fakeChain.mine()
+	fakeChain.mine()
+	notifier := newTestElementsdNotifier(t, fakeChain, time.Hour)
+
+	confirmed, err := notifier.RegisterConfirmationsNtfn("tx1", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case conf := <-confirmed.Confirmed:
+		if conf.BlockHash != "block11" || conf.BlockHeight != 11 || conf.NumConfs != 3 {
+			t.Fatalf("unexpected confirmation %+v", conf)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected confirmation of the transaction confirmed before the registration")
+	}
+}
+
+func TestElementsdNotifierWithoutTxIndex(t *testing.T) {
+	fakeChain := newFakeElementsd(10)
+	fakeChain.mine(&wallet.RawTransactionRes{TxId: "tx1"})
+	for i := 0; i < recentTxBlocks; i++ {
+		fakeChain.mine()
+	}
+	fakeChain.spent[Outpoint{TxId: "tx1", Vout: 0}] = true
+	notifier := newTestElementsdNotifier(t, fakeChain, time.Hour)
+
+	// tx1 is older than the scanned blocks, it can not be told apart from an unknown transaction
+	_, err := notifier.RegisterConfirmationsNtfn("tx1", 1)
+	if !errors.Is(err, ErrTxNotIndexed) {
+		t.Fatalf("expected ErrTxNotIndexed, got %v", err)
+	}
+	_, err = notifier.RegisterConfirmationsNtfn("unknown", 1)
+	if !errors.Is(err, ErrTxNotIndexed) {
+		t.Fatalf("expected ErrTxNotIndexed, got %v", err)
+	}
+	// the spend of an output of tx1 can not be rescanned without its block
+	_, err = notifier.RegisterSpendNtfn(Outpoint{TxId: "tx1", Vout: 0})
+	if !errors.Is(err, ErrTxNotIndexed) {
+		t.Fatalf("expected ErrTxNotIndexed, got %v", err)
+	}
+}
+
+func TestElementsdNotifierTxIndex(t *testing.T) {
+	fakeChain := newFakeElementsd(20)
+	fakeChain.txIndex = true
+	notifier := newTestElementsdNotifier(t, fakeChain, 10*time.Millisecond)
+
+	// with txindex an unknown transaction is not confirmed yet, it is found once it confirms
+	confirmed, err := notifier.RegisterConfirmationsNtfn("tx1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeChain.mine(&wallet.RawTransactionRes{TxId: "tx1"})
+	select {
+	case conf := <-confirmed.Confirmed:
+		if conf.BlockHash != "block21" || conf.BlockHeight != 21 || conf.NumConfs != 1 {
+			t.Fatalf("unexpected confirmation %+v", conf)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected confirmation")
+	}
+}
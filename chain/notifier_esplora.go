@@ -0,0 +1,190 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// EsploraNotifier is a Notifier that polls esplora for new blocks and the status of the watched
+// transactions and outpoints
+type EsploraNotifier struct {
+	*ntfnRegistry
+
+	esplora      *EsploraApi
+	pollInterval time.Duration
+
+	// poke triggers a poll, e.g. after a registration
+	poke chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewEsploraNotifier(esplora *EsploraApi, pollInterval time.Duration) *EsploraNotifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &EsploraNotifier{
+		ntfnRegistry: newNtfnRegistry(),
+		esplora:      esplora,
+		pollInterval: pollInterval,
+		poke:         make(chan struct{}, 1),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// Start fetches the current best block and starts polling
+func (e *EsploraNotifier) Start() error {
+	height, err := e.esplora.GetBlockHeightCtx(e.ctx)
+	if err != nil {
+		return err
+	}
+	hash, err := e.esplora.GetBlockHashCtx(e.ctx, height)
+	if err != nil {
+		return err
+	}
+	e.connectBlock(&BlockEpoch{Height: height, Hash: hash})
+
+	e.wg.Add(1)
+	go e.pollLoop()
+	return nil
+}
+
+func (e *EsploraNotifier) Stop() error {
+	e.stop()
+	e.cancel()
+	e.wg.Wait()
+	return nil
+}
+
+func (e *EsploraNotifier) RegisterConfirmationsNtfn(txId string, numConfs uint32) (*ConfirmationEvent, error) {
+	event, err := e.registerConf(txId, numConfs)
+	if err != nil {
+		return nil, err
+	}
+	e.triggerPoll()
+	return event, nil
+}
+
+func (e *EsploraNotifier) RegisterSpendNtfn(outpoint Outpoint) (*SpendEvent, error) {
+	event, err := e.registerSpend(outpoint)
+	if err != nil {
+		return nil, err
+	}
+	e.triggerPoll()
+	return event, nil
+}
+
+func (e *EsploraNotifier) RegisterConflictNtfn(txHex string) (*ConflictEvent, error) {
+	event, err := e.registerConflict(txHex)
+	if err != nil {
+		return nil, err
+	}
+	e.triggerPoll()
+	return event, nil
+}
+
+func (e *EsploraNotifier) RegisterBlockEpochNtfn() (*BlockEpochEvent, error) {
+	return e.registerEpoch()
+}
+
+func (e *EsploraNotifier) triggerPoll() {
+	select {
+	case e.poke <- struct{}{}:
+	default:
+	}
+}
+
+func (e *EsploraNotifier) pollLoop() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(e.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.quit:
+			return
+		case <-ticker.C:
+		case <-e.poke:
+		}
+		err := e.poll()
+		if err != nil && e.ctx.Err() == nil {
+			log.Printf("esplora notifier: %v", err)
+		}
+	}
+}
+
+// poll connects new blocks and checks the watched transactions and outpoints, mempool
+// transactions are checked on every poll so they are noticed before they confirm
+func (e *EsploraNotifier) poll() error {
+	err := e.pollBlocks()
+	if err != nil {
+		return err
+	}
+	for _, txId := range e.pendingTxIds() {
+		status, err := e.esplora.GetTxStatusCtx(e.ctx, txId)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		e.setTxStatus(txId, status)
+	}
+	for _, outpoint := range e.pendingOutpoints() {
+		detail, err := e.getSpend(outpoint)
+		if err != nil {
+			return err
+		}
+		if detail != nil {
+			e.spent(detail)
+		}
+	}
+	return nil
+}
+
+// pollBlocks connects the blocks between the best block and the esplora tip, after a reorg the watched
+// transactions are checked again by the poll
+func (e *EsploraNotifier) pollBlocks() error {
+	height, err := e.esplora.GetBlockHeightCtx(e.ctx)
+	if err != nil {
+		return err
+	}
+	getBlockHash := func(height uint32) (string, error) {
+		return e.esplora.GetBlockHashCtx(e.ctx, height)
+	}
+	return e.syncBlocks(height, getBlockHash, func(epoch *BlockEpoch) error {
+		e.connectBlock(epoch)
+		return nil
+	})
+}
+
+// getSpend returns the spend of an outpoint, or nil if it is unspent, unknown or already sent
+func (e *EsploraNotifier) getSpend(outpoint Outpoint) (*SpendDetail, error) {
+	outspend, err := e.esplora.GetTxOutspendCtx(e.ctx, outpoint.TxId, outpoint.Vout)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !outspend.Spent || !e.isNewSpend(outpoint, outspend.TxId) {
+		return nil, nil
+	}
+	txHex, err := e.esplora.GetTxHexCtx(e.ctx, outspend.TxId)
+	if err != nil {
+		return nil, err
+	}
+	detail := &SpendDetail{
+		Outpoint:          outpoint,
+		SpenderTxId:       outspend.TxId,
+		SpenderTxHex:      txHex,
+		SpenderInputIndex: outspend.Vin,
+	}
+	if outspend.Status != nil && outspend.Status.Confirmed {
+		detail.SpendingHeight = outspend.Status.BlockHeight
+	}
+	return detail, nil
+}
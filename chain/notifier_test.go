@@ -0,0 +1,301 @@
+package chain
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sputn1ck/liquid-go-lightwallet/wallet"
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+// fakeEsploraChain serves the esplora endpoints of the notifier from an in memory chain
+type fakeEsploraChain struct {
+	mu        sync.Mutex
+	height    uint32
+	txs       map[string]*wallet.Status
+	outspends map[Outpoint]*EsploraOutspend
+}
+
+func (f *fakeEsploraChain) mine(txIds ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.height++
+	for _, v := range txIds {
+		f.txs[v] = &wallet.Status{Confirmed: true, BlockHeight: f.height, BlockHash: fmt.Sprintf("block%v", f.height)}
+	}
+	for _, v := range f.outspends {
+		if f.txs[v.TxId] != nil {
+			v.Status = f.txs[v.TxId]
+		}
+	}
+}
+
+func (f *fakeEsploraChain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var res interface{}
+	switch {
+	case r.URL.Path == "/blocks/tip/height":
+		res = f.height
+	case path[0] == "block-height":
+		w.Write([]byte("block" + path[1]))
+		return
+	case path[0] == "tx" && len(path) == 3 && path[2] == "status":
+		status, ok := f.txs[path[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		res = status
+	case path[0] == "tx" && len(path) == 3 && path[2] == "hex":
+		w.Write([]byte("hex" + path[1]))
+		return
+	case path[0] == "tx" && len(path) == 4 && path[2] == "outspend":
+		vout, _ := strconv.Atoi(path[3])
+		res = &EsploraOutspend{}
+		if outspend, ok := f.outspends[Outpoint{TxId: path[1], Vout: uint32(vout)}]; ok {
+			res = outspend
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func TestEsploraNotifier(t *testing.T) {
+	fakeChain := &fakeEsploraChain{
+		height:    100,
+		txs:       make(map[string]*wallet.Status),
+		outspends: make(map[Outpoint]*EsploraOutspend),
+	}
+	esplora := newTestEsplora(t, fakeChain.ServeHTTP)
+	notifier := NewEsploraNotifier(esplora, 10*time.Millisecond)
+	err := notifier.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notifier.Stop()
+
+	epochs, err := notifier.RegisterBlockEpochNtfn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer epochs.Cancel()
+	expectEpoch := func(height uint32) {
+		t.Helper()
+		select {
+		case epoch := <-epochs.Epochs:
+			if epoch.Height != height || epoch.Hash != fmt.Sprintf("block%v", height) {
+				t.Fatalf("expected block %v, got %+v", height, epoch)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected block %v", height)
+		}
+	}
+	expectEpoch(100)
+
+	inMempool, err := notifier.RegisterConfirmationsNtfn("tx1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirmed, err := notifier.RegisterConfirmationsNtfn("tx1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spend, err := notifier.RegisterSpendNtfn(Outpoint{TxId: "tx1", Vout: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tx enters the mempool
+	fakeChain.mu.Lock()
+	fakeChain.txs["tx1"] = &wallet.Status{}
+	fakeChain.mu.Unlock()
+	select {
+	case conf := <-inMempool.Confirmed:
+		if conf.NumConfs != 0 || conf.BlockHeight != 0 {
+			t.Fatalf("expected mempool tx, got %+v", conf)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected mempool notification")
+	}
+
+	fakeChain.mine("tx1")
+	expectEpoch(101)
+	select {
+	case conf := <-confirmed.Confirmed:
+		t.Fatalf("unexpected confirmation %+v", conf)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the output is spent in the mempool and the tx gets its second confirmation
+	fakeChain.mu.Lock()
+	fakeChain.outspends[Outpoint{TxId: "tx1", Vout: 1}] = &EsploraOutspend{Spent: true, TxId: "tx2", Vin: 3, Status: &wallet.Status{}}
+	fakeChain.mu.Unlock()
+	fakeChain.mine()
+	expectEpoch(102)
+	select {
+	case conf := <-confirmed.Confirmed:
+		if conf.NumConfs != 2 || conf.BlockHeight != 101 || conf.BlockHash != "block101" {
+			t.Fatalf("unexpected confirmation %+v", conf)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected confirmation")
+	}
+	select {
+	case detail := <-spend.Spend:
+		if detail.SpenderTxId != "tx2" || detail.SpenderInputIndex != 3 || detail.SpenderTxHex != "hextx2" || detail.SpendingHeight != 0 {
+			t.Fatalf("unexpected spend %+v", detail)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected spend")
+	}
+
+	// a canceled registration is not notified
+	canceled, err := notifier.RegisterConfirmationsNtfn("tx2", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canceled.Cancel()
+	fakeChain.mine("tx2")
+	expectEpoch(103)
+	select {
+	case conf := <-canceled.Confirmed:
+		t.Fatalf("unexpected confirmation %+v", conf)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	err = notifier.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = notifier.RegisterSpendNtfn(Outpoint{TxId: "tx2"})
+	if err != ErrNotifierStopped {
+		t.Fatalf("expected stopped notifier, got %v", err)
+	}
+}
+
+func TestNotifierReorg(t *testing.T) {
+	fakeChain := newFakeElementsd(10)
+	rpcServer := httptest.NewServer(fakeChain)
+	defer rpcServer.Close()
+	client, err := wallet.NewElementsdClient(strings.TrimPrefix(rpcServer.URL, "http://"), "user", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := NewElementsdNotifier(client, 10*time.Millisecond)
+	err = notifier.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notifier.Stop()
+
+	confirmed, err := notifier.RegisterConfirmationsNtfn("tx1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectConf := func(blockHash string) {
+		t.Helper()
+		select {
+		case conf := <-confirmed.Confirmed:
+			if conf.BlockHash != blockHash || conf.BlockHeight != 11 || conf.NumConfs != 1 {
+				t.Fatalf("unexpected confirmation %+v", conf)
+			}
+		case depth := <-confirmed.NegativeConf:
+			t.Fatalf("unexpected reorg of depth %v", depth)
+		case <-time.After(time.Second):
+			t.Fatal("expected confirmation")
+		}
+	}
+	fakeChain.mine(&wallet.RawTransactionRes{TxId: "tx1"})
+	expectConf("block11")
+
+	// the block of tx1 is invalidated and replaced by two blocks without it
+	fakeChain.invalidate(1)
+	fakeChain.mine()
+	fakeChain.mine()
+	select {
+	case depth := <-confirmed.NegativeConf:
+		if depth != 1 {
+			t.Fatalf("expected reorg of depth 1, got %v", depth)
+		}
+	case conf := <-confirmed.Confirmed:
+		t.Fatalf("unexpected confirmation %+v", conf)
+	case <-time.After(time.Second):
+		t.Fatal("expected negative confirmation")
+	}
+
+	// tx1 confirms again in the new chain, which first has to reach its height
+	fakeChain.invalidate(2)
+	fakeChain.mine(&wallet.RawTransactionRes{TxId: "tx1"})
+	expectConf("block11-2")
+}
+
+func TestNotifierConflicts(t *testing.T) {
+	fakeChain := &fakeEsploraChain{
+		height:    100,
+		txs:       make(map[string]*wallet.Status),
+		outspends: make(map[Outpoint]*EsploraOutspend),
+	}
+	esplora := newTestEsplora(t, fakeChain.ServeHTTP)
+	notifier := NewEsploraNotifier(esplora, 10*time.Millisecond)
+	err := notifier.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notifier.Stop()
+
+	fundingTxId := strings.Repeat("ab", 32)
+	tx := transaction.NewTx(2)
+	tx.AddInput(transaction.NewTxInput(elementsutil.ReverseBytes(h2b(fundingTxId)), 1))
+	txHex, err := tx.ToHex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txId := tx.TxHash().String()
+	conflicts, err := notifier.RegisterConflictNtfn(txHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conflicts.Cancel()
+
+	// the watched tx itself is no conflict
+	outpoint := Outpoint{TxId: fundingTxId, Vout: 1}
+	fakeChain.mu.Lock()
+	fakeChain.outspends[outpoint] = &EsploraOutspend{Spent: true, TxId: txId, Status: &wallet.Status{}}
+	fakeChain.mu.Unlock()
+	select {
+	case conflict := <-conflicts.Conflicts:
+		t.Fatalf("unexpected conflict %+v", conflict)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// a replacement is sent once
+	fakeChain.mu.Lock()
+	fakeChain.outspends[outpoint] = &EsploraOutspend{Spent: true, TxId: "tx2", Status: &wallet.Status{}}
+	fakeChain.mu.Unlock()
+	select {
+	case conflict := <-conflicts.Conflicts:
+		if conflict.TxId != txId || conflict.Outpoint != outpoint || conflict.ConflictingTxId != "tx2" ||
+			conflict.ConflictingTxHex != "hextx2" || conflict.SpendingHeight != 0 {
+			t.Fatalf("unexpected conflict %+v", conflict)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected conflict")
+	}
+	select {
+	case conflict := <-conflicts.Conflicts:
+		t.Fatalf("unexpected conflict %+v", conflict)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,181 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/lightninglabs/gozmq"
+	"github.com/sputn1ck/liquid-go-lightwallet/wallet"
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+const (
+	zmqRawTx     = "rawtx"
+	zmqHashBlock = "hashblock"
+
+	// zmqReadTimeout is the read deadline of the frames of a zmq message and the wait between reconnects
+	zmqReadTimeout = 5 * time.Second
+
+	// zmqPollInterval is the rpc poll interval while zmq is connected, it catches messages zmq dropped
+	zmqPollInterval = time.Minute
+)
+
+// UseZmq subscribes the notifier to the zmqpubhashblock and zmqpubrawtx endpoints of elementsd, e.g.
+// tcp://127.0.0.1:28332. It has to be called before Start. While the socket is connected the rpc
+// is only polled as a safety net, if the socket drops the notifier polls at its poll interval
+func (e *ElementsdNotifier) UseZmq(hashBlockAddr string, rawTxAddr string) {
+	e.zmqHashBlockAddr = hashBlockAddr
+	e.zmqRawTxAddr = rawTxAddr
+}
+
+// zmqConnected returns true if all zmq subscriptions are connected
+func (e *ElementsdNotifier) zmqConnected() bool {
+	return atomic.LoadInt32(&e.zmqDown) == 0 && e.zmqHashBlockAddr != ""
+}
+
+// startZmq subscribes to the zmq topics, one socket is shared if both topics are published on the same address
+func (e *ElementsdNotifier) startZmq() {
+	subscriptions := map[string][]string{}
+	subscriptions[e.zmqHashBlockAddr] = append(subscriptions[e.zmqHashBlockAddr], zmqHashBlock)
+	if e.zmqRawTxAddr != "" {
+		subscriptions[e.zmqRawTxAddr] = append(subscriptions[e.zmqRawTxAddr], zmqRawTx)
+	}
+	for addr, topics := range subscriptions {
+		atomic.AddInt32(&e.zmqDown, 1)
+		e.wg.Add(1)
+		go e.zmqLoop(addr, topics)
+	}
+}
+
+// zmqLoop receives the messages of a zmq socket, it reconnects until the notifier is stopped
+func (e *ElementsdNotifier) zmqLoop(addr string, topics []string) {
+	defer e.wg.Done()
+	connected := false
+	setConnected := func(c bool) {
+		if c == connected {
+			return
+		}
+		connected = c
+		if c {
+			atomic.AddInt32(&e.zmqDown, -1)
+			log.Printf("elementsd notifier: zmq %v connected to %s", topics, addr)
+			// events may have been missed while the socket was down
+			e.triggerPoll()
+			return
+		}
+		atomic.AddInt32(&e.zmqDown, 1)
+		log.Printf("elementsd notifier: zmq %v disconnected from %s, polling rpc", topics, addr)
+	}
+
+	for !e.stopped() {
+		conn, err := gozmq.Subscribe(addr, topics, zmqReadTimeout)
+		if err != nil {
+			log.Printf("elementsd notifier: unable to subscribe to zmq %s: %v", addr, err)
+			select {
+			case <-e.quit:
+				return
+			case <-time.After(e.pollInterval):
+			}
+			continue
+		}
+		setConnected(true)
+
+		closed := make(chan struct{})
+		go func() {
+			select {
+			case <-e.quit:
+			case <-closed:
+			}
+			conn.Close()
+		}()
+		e.receiveZmq(conn, setConnected)
+		close(closed)
+		if e.stopped() {
+			return
+		}
+		setConnected(false)
+	}
+}
+
+// receiveZmq handles the messages of a socket until it fails. gozmq reconnects a dropped socket by
+// itself and returns a timeout error meanwhile, which marks the socket as disconnected
+func (e *ElementsdNotifier) receiveZmq(conn *gozmq.Conn, setConnected func(bool)) {
+	for {
+		msg, err := conn.Receive(nil)
+		if e.stopped() {
+			return
+		}
+		var netErr interface{ Timeout() bool }
+		if errors.As(err, &netErr) && netErr.Timeout() && !errors.Is(err, os.ErrDeadlineExceeded) {
+			setConnected(false)
+			continue
+		}
+		if err != nil {
+			// a read deadline is only exceeded within a message, so the stream is broken
+			log.Printf("elementsd notifier: zmq: %v", err)
+			return
+		}
+		setConnected(true)
+		err = e.handleZmqMessage(msg)
+		if err != nil {
+			log.Printf("elementsd notifier: zmq: %v", err)
+		}
+	}
+}
+
+// handleZmqMessage handles a message of the topic, body and sequence number parts
+func (e *ElementsdNotifier) handleZmqMessage(msg [][]byte) error {
+	if len(msg) < 2 {
+		return errors.New("invalid message")
+	}
+	switch string(msg[0]) {
+	case zmqHashBlock:
+		// the blocks are fetched by rpc, so reorgs and missed blocks are handled like in polling
+		e.triggerPoll()
+	case zmqRawTx:
+		return e.handleRawTx(msg[1])
+	}
+	return nil
+}
+
+// handleRawTx scans a transaction that entered the mempool. Transactions of new blocks are published as well,
+// they are already known or are found by the block scan
+func (e *ElementsdNotifier) handleRawTx(rawTx []byte) error {
+	tx, err := transaction.NewTxFromBuffer(bytes.NewBuffer(rawTx))
+	if err != nil {
+		return err
+	}
+	res := &wallet.RawTransactionRes{
+		TxId: tx.TxHash().String(),
+		Hex:  hex.EncodeToString(rawTx),
+	}
+	for _, in := range tx.Inputs {
+		rawIn := &wallet.RawTransactionIn{
+			TxId: hex.EncodeToString(elementsutil.ReverseBytes(in.Hash)),
+			Vout: in.Index,
+		}
+		if in.Index == 0xffffffff && bytes.Equal(in.Hash, make([]byte, 32)) {
+			rawIn.Coinbase = "coinbase"
+		}
+		res.Vin = append(res.Vin, rawIn)
+	}
+
+	e.scanMu.Lock()
+	defer e.scanMu.Unlock()
+	if _, ok := e.mempool[res.TxId]; ok {
+		return nil
+	}
+	e.mempool[res.TxId] = struct{}{}
+	// a transaction of a new block may already be marked as confirmed
+	if e.isWatchedTx(res.TxId) && !e.hasTxStatus(res.TxId) {
+		e.setTxStatus(res.TxId, &wallet.Status{})
+	}
+	e.scanSpends(res, 0)
+	return nil
+}
@@ -0,0 +1,186 @@
+package chain
+
+import (
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sputn1ck/liquid-go-lightwallet/wallet"
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+// fakeZmqPublisher is a zmq publisher speaking just enough zmtp 3.0 for gozmq
+type fakeZmqPublisher struct {
+	listener net.Listener
+	conns    chan net.Conn
+}
+
+func newFakeZmqPublisher(t *testing.T) *fakeZmqPublisher {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeZmqPublisher{listener: listener, conns: make(chan net.Conn, 1)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.handshake(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	return p
+}
+
+func (p *fakeZmqPublisher) handshake(conn net.Conn) {
+	greeting := make([]byte, 64)
+	copy(greeting, []byte{0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0x7f, 3, 0, 'N', 'U', 'L', 'L'})
+	conn.Write(greeting)
+	if _, err := io.ReadFull(conn, make([]byte, 64)); err != nil {
+		return
+	}
+	ready := append([]byte{5}, "READY"...)
+	ready = append(ready, 11)
+	ready = append(ready, "Socket-Type"...)
+	ready = append(ready, 0, 0, 0, 3)
+	ready = append(ready, "PUB"...)
+	conn.Write(append([]byte{4, byte(len(ready))}, ready...))
+	// the subscriptions are not needed, every topic is published
+	go io.Copy(io.Discard, conn)
+	p.conns <- conn
+}
+
+func (p *fakeZmqPublisher) publish(conn net.Conn, topic string, body []byte) {
+	conn.Write(append([]byte{1, byte(len(topic))}, topic...))
+	conn.Write([]byte{1, byte(len(body))})
+	conn.Write(body)
+	conn.Write([]byte{0, 4, 0, 0, 0, 0})
+}
+
+func TestElementsdNotifierZmq(t *testing.T) {
+	fakeChain := newFakeElementsd(10)
+	rpcServer := httptest.NewServer(fakeChain)
+	defer rpcServer.Close()
+	client, err := wallet.NewElementsdClient(strings.TrimPrefix(rpcServer.URL, "http://"), "user", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher := newFakeZmqPublisher(t)
+
+	// rpc is only polled on zmq events
+	notifier := NewElementsdNotifier(client, time.Hour)
+	addr := "tcp://" + publisher.listener.Addr().String()
+	notifier.UseZmq(addr, addr)
+	err = notifier.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notifier.Stop()
+	var conn net.Conn
+	select {
+	case conn = <-publisher.conns:
+	case <-time.After(time.Second):
+		t.Fatal("expected zmq subscription")
+	}
+
+	fundingTxId := strings.Repeat("ab", 32)
+	spend, err := notifier.RegisterSpendNtfn(Outpoint{TxId: fundingTxId, Vout: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spendingTx := transaction.NewTx(2)
+	spendingTx.AddInput(transaction.NewTxInput(elementsutil.ReverseBytes(h2b(fundingTxId)), 1))
+	rawTx, err := spendingTx.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher.publish(conn, zmqRawTx, rawTx)
+	select {
+	case detail := <-spend.Spend:
+		if detail.SpenderTxId != spendingTx.TxHash().String() || detail.SpenderInputIndex != 0 || detail.SpendingHeight != 0 {
+			t.Fatalf("unexpected spend %+v", detail)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected spend")
+	}
+
+	confirmed, err := notifier.RegisterConfirmationsNtfn("tx1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeChain.mine(&wallet.RawTransactionRes{TxId: "tx1"})
+	publisher.publish(conn, zmqHashBlock, make([]byte, 32))
+	select {
+	case conf := <-confirmed.Confirmed:
+		if conf.BlockHeight != 11 || conf.BlockHash != "block11" {
+			t.Fatalf("unexpected confirmation %+v", conf)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected confirmation")
+	}
+}
+
+func TestElementsdNotifierPollingFallback(t *testing.T) {
+	fakeChain := newFakeElementsd(10)
+	rpcServer := httptest.NewServer(fakeChain)
+	defer rpcServer.Close()
+	client, err := wallet.NewElementsdClient(strings.TrimPrefix(rpcServer.URL, "http://"), "user", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing listens on the zmq address, so the notifier polls
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := "tcp://" + listener.Addr().String()
+	listener.Close()
+
+	notifier := NewElementsdNotifier(client, 10*time.Millisecond)
+	notifier.UseZmq(addr, addr)
+	err = notifier.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notifier.Stop()
+
+	inMempool, err := notifier.RegisterConfirmationsNtfn("tx1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirmed, err := notifier.RegisterConfirmationsNtfn("tx1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeChain.mu.Lock()
+	fakeChain.mempool["tx1"] = &wallet.RawTransactionRes{TxId: "tx1"}
+	fakeChain.mu.Unlock()
+	select {
+	case conf := <-inMempool.Confirmed:
+		if conf.NumConfs != 0 {
+			t.Fatalf("expected mempool tx, got %+v", conf)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected mempool notification")
+	}
+
+	fakeChain.mine(&wallet.RawTransactionRes{TxId: "tx1"})
+	fakeChain.mine()
+	select {
+	case conf := <-confirmed.Confirmed:
+		if conf.NumConfs != 2 || conf.BlockHeight != 11 {
+			t.Fatalf("unexpected confirmation %+v", conf)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected confirmation")
+	}
+}
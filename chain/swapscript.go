@@ -0,0 +1,189 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+var ErrNotSwapScript = errors.New("not a swap opening script")
+
+// OpeningScript holds the parameters of a swap script as built by GetOpeningTxScript
+type OpeningScript struct {
+	MakerPubkey []byte
+	TakerPubkey []byte
+	PaymentHash []byte
+	Csv         uint32
+}
+
+// ParseOpeningTxScript decodes a swap script, scripts that differ in any byte from the one
+// GetOpeningTxScript builds for the decoded parameters are rejected
+func ParseOpeningTxScript(script []byte) (*OpeningScript, error) {
+	pushes, err := scriptPushes(script)
+	if err != nil {
+		return nil, err
+	}
+	// the pushes of the template are maker, maker, size, payment hash, taker and csv
+	if len(pushes) != 6 {
+		return nil, ErrNotSwapScript
+	}
+	csv, err := scriptNumToCsv(pushes[5])
+	if err != nil {
+		return nil, err
+	}
+	opening := &OpeningScript{
+		MakerPubkey: pushes[0],
+		TakerPubkey: pushes[4],
+		PaymentHash: pushes[3],
+		Csv:         csv,
+	}
+	want, err := opening.Script()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(want, script) {
+		return nil, ErrNotSwapScript
+	}
+	return opening, nil
+}
+
+// Script returns the swap script of the parameters
+func (o *OpeningScript) Script() ([]byte, error) {
+	return GetOpeningTxScript(o.TakerPubkey, o.MakerPubkey, o.PaymentHash, o.Csv)
+}
+
+// scriptPushes returns the data pushed by a script, small integer opcodes push their value
+// as script number. Other opcodes are skipped
+func scriptPushes(script []byte) ([][]byte, error) {
+	var pushes [][]byte
+	for i := 0; i < len(script); {
+		op := script[i]
+		i++
+		var size int
+		switch {
+		case op == txscript.OP_0:
+			pushes = append(pushes, []byte{})
+			continue
+		case op >= txscript.OP_1 && op <= txscript.OP_16:
+			pushes = append(pushes, []byte{op - txscript.OP_1 + 1})
+			continue
+		case op == txscript.OP_1NEGATE:
+			pushes = append(pushes, []byte{0x81})
+			continue
+		case op < txscript.OP_PUSHDATA1:
+			size = int(op)
+		case op == txscript.OP_PUSHDATA1 && i+1 <= len(script):
+			size = int(script[i])
+			i++
+		case op == txscript.OP_PUSHDATA2 && i+2 <= len(script):
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		case op == txscript.OP_PUSHDATA4 && i+4 <= len(script):
+			size = int(binary.LittleEndian.Uint32(script[i:]))
+			i += 4
+		case op >= txscript.OP_PUSHDATA1 && op <= txscript.OP_PUSHDATA4:
+			return nil, fmt.Errorf("%w: truncated push", ErrNotSwapScript)
+		default:
+			continue
+		}
+		if size < 0 || i+size > len(script) {
+			return nil, fmt.Errorf("%w: truncated push", ErrNotSwapScript)
+		}
+		pushes = append(pushes, script[i:i+size])
+		i += size
+	}
+	return pushes, nil
+}
+
+// scriptNumToCsv decodes a little endian script number with sign bit into a csv
+func scriptNumToCsv(num []byte) (uint32, error) {
+	if len(num) > 5 {
+		return 0, fmt.Errorf("%w: csv too long", ErrNotSwapScript)
+	}
+	var value int64
+	for i, b := range num {
+		value |= int64(b) << (8 * uint(i))
+	}
+	if len(num) > 0 && num[len(num)-1]&0x80 != 0 {
+		return 0, fmt.Errorf("%w: negative csv", ErrNotSwapScript)
+	}
+	if value > int64(^uint32(0)) {
+		return 0, fmt.Errorf("%w: csv overflows", ErrNotSwapScript)
+	}
+	return uint32(value), nil
+}
+
+// SwapOutput is a p2wsh output of an opening transaction
+type SwapOutput struct {
+	Vout           uint32
+	WitnessProgram []byte
+	// AssetId and Amount are empty for blinded outputs
+	AssetId      string
+	Amount       uint64
+	Confidential bool
+}
+
+// PaysToScript returns whether the output pays to the swap script
+func (o *SwapOutput) PaysToScript(redeemScript []byte) bool {
+	witnessProgram := sha256.Sum256(redeemScript)
+	return bytes.Equal(o.WitnessProgram, witnessProgram[:])
+}
+
+// SwapOpening is a decoded opening transaction
+type SwapOpening struct {
+	TxId    string
+	Outputs []*SwapOutput
+}
+
+// ParseSwapOpening decodes the swap outputs of an opening transaction. The outputs only commit to the hash
+// of the swap script, so every p2wsh output is returned, OutputsForScript narrows them down to one swap
+func ParseSwapOpening(txHex string) (*SwapOpening, error) {
+	tx, err := transaction.NewTxFromHex(txHex)
+	if err != nil {
+		return nil, err
+	}
+	opening := &SwapOpening{TxId: tx.TxHash().String()}
+	for i, out := range tx.Outputs {
+		if !txscript.IsPayToWitnessScriptHash(out.Script) {
+			continue
+		}
+		output := &SwapOutput{
+			Vout:           uint32(i),
+			WitnessProgram: out.Script[2:],
+		}
+		if isExplicit(out.Asset) && isExplicit(out.Value) {
+			output.AssetId = hex.EncodeToString(elementsutil.ReverseBytes(out.Asset[1:]))
+			output.Amount, err = elementsutil.ElementsToSatoshiValue(out.Value)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			output.Confidential = true
+		}
+		opening.Outputs = append(opening.Outputs, output)
+	}
+	return opening, nil
+}
+
+// OutputsForScript returns the outputs paying to the swap script
+func (s *SwapOpening) OutputsForScript(redeemScript []byte) []*SwapOutput {
+	var outputs []*SwapOutput
+	for _, v := range s.Outputs {
+		if v.PaysToScript(redeemScript) {
+			outputs = append(outputs, v)
+		}
+	}
+	return outputs
+}
+
+// isExplicit returns whether an asset or value commitment is unblinded
+func isExplicit(commitment []byte) bool {
+	return len(commitment) > 0 && commitment[0] == 0x01
+}
@@ -0,0 +1,91 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
+	"github.com/vulpemventures/go-elements/network"
+)
+
+func TestParseOpeningTxScript(t *testing.T) {
+	maker, _ := btcec.NewPrivateKey(btcec.S256())
+	taker, _ := btcec.NewPrivateKey(btcec.S256())
+	pHash := bytes.Repeat([]byte{0xab}, 32)
+
+	for _, csv := range []uint32{0, 1, 16, 17, 127, 128, 255, 1024, 1 << 31} {
+		script, err := GetOpeningTxScript(taker.PubKey().SerializeCompressed(), maker.PubKey().SerializeCompressed(), pHash, csv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseOpeningTxScript(script)
+		if err != nil {
+			t.Fatalf("csv %v: %v", csv, err)
+		}
+		if !bytes.Equal(parsed.MakerPubkey, maker.PubKey().SerializeCompressed()) ||
+			!bytes.Equal(parsed.TakerPubkey, taker.PubKey().SerializeCompressed()) ||
+			!bytes.Equal(parsed.PaymentHash, pHash) || parsed.Csv != csv {
+			t.Fatalf("csv %v: unexpected script %+v", csv, parsed)
+		}
+	}
+
+	script, err := GetOpeningTxScript(taker.PubKey().SerializeCompressed(), maker.PubKey().SerializeCompressed(), pHash, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, script...)
+	tampered[len(tampered)-1] = 0x67
+	for _, invalid := range [][]byte{nil, script[:len(script)-1], tampered, append(script, 0x51)} {
+		_, err = ParseOpeningTxScript(invalid)
+		if !errors.Is(err, ErrNotSwapScript) {
+			t.Fatalf("expected ErrNotSwapScript for %x, got %v", invalid, err)
+		}
+	}
+}
+
+func TestParseSwapOpening(t *testing.T) {
+	maker, _ := btcec.NewPrivateKey(btcec.S256())
+	taker, _ := btcec.NewPrivateKey(btcec.S256())
+	pHash := bytes.Repeat([]byte{0xcd}, 32)
+	usdt := "f3d1ec678811398cd2ae277cbe3849c6f6dbd72c74bc542f7c4b11ff0e820958"
+
+	onchain := NewLiquidOnchain(&network.Regtest)
+	params := NewSwapOpeningParams(maker.PubKey().SerializeCompressed(), taker.PubKey().SerializeCompressed(), 60, pHash, []asset.AssetAmount{
+		asset.NewAssetAmount(network.Regtest.AssetID, 8, 500),
+		asset.NewAssetAmount(usdt, 8, 100000),
+	})
+	txHex, err := onchain.CreateUnfundedOpeningTransaction(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opening, err := ParseSwapOpening(txHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opening.Outputs) != 2 {
+		t.Fatalf("expected 2 swap outputs, got %v", len(opening.Outputs))
+	}
+	if out := opening.Outputs[0]; out.Vout != 0 || out.AssetId != network.Regtest.AssetID || out.Amount != 500 || out.Confidential {
+		t.Fatalf("unexpected fee output %+v", out)
+	}
+	if out := opening.Outputs[1]; out.Vout != 1 || out.AssetId != usdt || out.Amount != 100000 {
+		t.Fatalf("unexpected asset output %+v", out)
+	}
+
+	redeemScript, err := params.ToTxScript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(opening.OutputsForScript(redeemScript)); n != 2 {
+		t.Fatalf("expected 2 outputs for the script, got %v", n)
+	}
+	otherScript, err := GetOpeningTxScript(taker.PubKey().SerializeCompressed(), maker.PubKey().SerializeCompressed(), pHash, 61)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(opening.OutputsForScript(otherScript)); n != 0 {
+		t.Fatalf("expected no outputs for another script, got %v", n)
+	}
+}
@@ -0,0 +1,214 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+const (
+	// sequenceLockTimeDisabled, sequenceLockTimeIsSeconds and sequenceLockTimeMask are the bip68 fields of a sequence
+	sequenceLockTimeDisabled  = 1 << 31
+	sequenceLockTimeIsSeconds = 1 << 22
+	sequenceLockTimeMask      = 0x0000ffff
+)
+
+var (
+	ErrNoSwapInputs     = errors.New("transaction spends no output of the opening")
+	ErrInvalidWitness   = errors.New("invalid witness")
+	ErrScriptMismatch   = errors.New("witness script does not match the spent output")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrPreimageMismatch = errors.New("preimage does not match the payment hash")
+	ErrCsvNotSatisfied  = errors.New("csv not satisfied")
+)
+
+// SpendPath is the branch of the swap script an input spends
+type SpendPath int
+
+const (
+	// SpendPathClaim is the taker spending with the preimage
+	SpendPathClaim SpendPath = iota
+	// SpendPathCooperative is the taker and the maker spending together
+	SpendPathCooperative
+	// SpendPathRefund is the maker spending after the csv
+	SpendPathRefund
+)
+
+func (p SpendPath) String() string {
+	switch p {
+	case SpendPathClaim:
+		return "claim"
+	case SpendPathCooperative:
+		return "cooperative"
+	case SpendPathRefund:
+		return "refund"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(p))
+	}
+}
+
+// SpendVerifyError is a failed check of an input spending a swap output
+type SpendVerifyError struct {
+	InputIndex int
+	Err        error
+}
+
+func (e *SpendVerifyError) Error() string {
+	return fmt.Sprintf("input %v: %v", e.InputIndex, e.Err)
+}
+
+func (e *SpendVerifyError) Unwrap() error {
+	return e.Err
+}
+
+// SwapSpend is a verified input spending a swap output
+type SwapSpend struct {
+	InputIndex int
+	Vout       uint32
+	Path       SpendPath
+	Script     *OpeningScript
+}
+
+// VerifySwapSpend checks the inputs of the spending transaction that spend outputs of the opening transaction
+// without a node: the witness script has to be the swap script of the output, the signatures have to be valid
+// for the pubkeys of the script and the claim preimage has to match the payment hash. Refunds have to satisfy
+// the csv with the input sequence. Failed checks return a SpendVerifyError
+func VerifySwapSpend(spendingTxHex string, openingTxHex string) ([]*SwapSpend, error) {
+	spendingTx, err := transaction.NewTxFromHex(spendingTxHex)
+	if err != nil {
+		return nil, err
+	}
+	openingTx, err := transaction.NewTxFromHex(openingTxHex)
+	if err != nil {
+		return nil, err
+	}
+	openingHash := openingTx.TxHash()
+
+	var spends []*SwapSpend
+	for i, in := range spendingTx.Inputs {
+		if !bytes.Equal(in.Hash, openingHash[:]) {
+			continue
+		}
+		spend, err := verifySwapInput(spendingTx, i, openingTx)
+		if err != nil {
+			return nil, &SpendVerifyError{InputIndex: i, Err: err}
+		}
+		spends = append(spends, spend)
+	}
+	if len(spends) == 0 {
+		return nil, ErrNoSwapInputs
+	}
+	return spends, nil
+}
+
+// verifySwapInput checks one input spending an output of the opening transaction
+func verifySwapInput(tx *transaction.Transaction, inIndex int, openingTx *transaction.Transaction) (*SwapSpend, error) {
+	in := tx.Inputs[inIndex]
+	if int(in.Index) >= len(openingTx.Outputs) {
+		return nil, fmt.Errorf("%w: opening has no output %v", ErrScriptMismatch, in.Index)
+	}
+	prevout := openingTx.Outputs[in.Index]
+	if len(in.Witness) == 0 {
+		return nil, fmt.Errorf("%w: empty witness", ErrInvalidWitness)
+	}
+	redeemScript := in.Witness[len(in.Witness)-1]
+	witnessProgram := sha256.Sum256(redeemScript)
+	if !txscript.IsPayToWitnessScriptHash(prevout.Script) || !bytes.Equal(prevout.Script[2:], witnessProgram[:]) {
+		return nil, ErrScriptMismatch
+	}
+	script, err := ParseOpeningTxScript(redeemScript)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrScriptMismatch, err)
+	}
+
+	spend := &SwapSpend{InputIndex: inIndex, Vout: in.Index, Script: script}
+	checkSig := func(sig []byte, pubkey []byte) error {
+		return verifyWitnessSignature(tx, inIndex, redeemScript, prevout.Value, sig, pubkey)
+	}
+	stack := in.Witness[:len(in.Witness)-1]
+	switch {
+	case len(stack) == 1:
+		// <maker sig>, the first checksig succeeds and the else branch checks the csv
+		spend.Path = SpendPathRefund
+		if err := checkSig(stack[0], script.MakerPubkey); err != nil {
+			return nil, err
+		}
+		if err := verifyCsv(tx, in, script.Csv); err != nil {
+			return nil, err
+		}
+	case len(stack) == 3 && len(stack[2]) == 0:
+		// <taker sig> <maker sig> <>, the second checksig succeeds and skips the preimage check
+		spend.Path = SpendPathCooperative
+		if err := checkSig(stack[1], script.MakerPubkey); err != nil {
+			return nil, err
+		}
+		if err := checkSig(stack[0], script.TakerPubkey); err != nil {
+			return nil, err
+		}
+	case len(stack) == 4 && len(stack[2]) == 0 && len(stack[3]) == 0:
+		// <taker sig> <preimage> <> <>, both maker checksigs fail and the preimage is checked
+		spend.Path = SpendPathClaim
+		preimage := stack[1]
+		paymentHash := sha256.Sum256(preimage)
+		if len(preimage) != 32 || !bytes.Equal(paymentHash[:], script.PaymentHash) {
+			return nil, ErrPreimageMismatch
+		}
+		if err := checkSig(stack[0], script.TakerPubkey); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %v stack items", ErrInvalidWitness, len(stack))
+	}
+	return spend, nil
+}
+
+// verifyWitnessSignature checks a der signature with hash type of the input against the pubkey
+func verifyWitnessSignature(tx *transaction.Transaction, inIndex int, redeemScript []byte, value []byte, sig []byte, pubkey []byte) error {
+	if len(sig) == 0 {
+		return fmt.Errorf("%w: missing signature", ErrInvalidSignature)
+	}
+	hashType := txscript.SigHashType(sig[len(sig)-1])
+	signature, err := btcec.ParseDERSignature(sig[:len(sig)-1], btcec.S256())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	key, err := btcec.ParsePubKey(pubkey, btcec.S256())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	sigHash := tx.HashForWitnessV0(inIndex, redeemScript, value, hashType)
+	if !signature.Verify(sigHash[:], key) {
+		return fmt.Errorf("%w: signature does not match pubkey %x", ErrInvalidSignature, pubkey)
+	}
+	return nil
+}
+
+// verifyCsv checks the bip68 relative lock of the input against the csv of the script, the same way
+// OP_CHECKSEQUENCEVERIFY does
+func verifyCsv(tx *transaction.Transaction, in *transaction.TxInput, csv uint32) error {
+	if csv == 0 {
+		// the csv is left on the stack, a zero fails the script
+		return fmt.Errorf("%w: csv is zero", ErrCsvNotSatisfied)
+	}
+	if csv&sequenceLockTimeDisabled != 0 {
+		return nil
+	}
+	if tx.Version < 2 {
+		return fmt.Errorf("%w: tx version %v does not enforce sequence locks", ErrCsvNotSatisfied, tx.Version)
+	}
+	if in.Sequence&sequenceLockTimeDisabled != 0 {
+		return fmt.Errorf("%w: sequence %x disables the relative lock", ErrCsvNotSatisfied, in.Sequence)
+	}
+	if csv&sequenceLockTimeIsSeconds != in.Sequence&sequenceLockTimeIsSeconds {
+		return fmt.Errorf("%w: sequence %x and csv %v use different lock types", ErrCsvNotSatisfied, in.Sequence, csv)
+	}
+	if in.Sequence&sequenceLockTimeMask < csv&sequenceLockTimeMask {
+		return fmt.Errorf("%w: sequence %v is below csv %v", ErrCsvNotSatisfied, in.Sequence&sequenceLockTimeMask, csv&sequenceLockTimeMask)
+	}
+	return nil
+}
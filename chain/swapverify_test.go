@@ -0,0 +1,138 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
+	"github.com/vulpemventures/go-elements/network"
+	"github.com/vulpemventures/go-elements/payment"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+const testSwapCsv = 60
+
+type testSwap struct {
+	onchain      *LiquidOnchain
+	maker, taker *btcec.PrivateKey
+	preimage     []byte
+	pHash        []byte
+	amount       asset.AssetAmount
+	openingTxHex string
+	redeemScript []byte
+	address      string
+}
+
+func newTestSwap(t *testing.T, csv uint32) *testSwap {
+	return newTestSwapWithAmount(t, csv, asset.NewAssetAmount("f3d1ec678811398cd2ae277cbe3849c6f6dbd72c74bc542f7c4b11ff0e820958", 8, 100000))
+}
+
+func newTestSwapWithAmount(t *testing.T, csv uint32, amount asset.AssetAmount) *testSwap {
+	s := &testSwap{onchain: NewLiquidOnchain(&network.Regtest), amount: amount}
+	s.maker, _ = btcec.NewPrivateKey(btcec.S256())
+	s.taker, _ = btcec.NewPrivateKey(btcec.S256())
+	s.preimage = make([]byte, 32)
+	s.preimage[0] = 1
+	pHash := sha256.Sum256(s.preimage)
+	s.pHash = pHash[:]
+
+	params := NewSwapOpeningParams(s.maker.PubKey().SerializeCompressed(), s.taker.PubKey().SerializeCompressed(), csv, s.pHash, []asset.AssetAmount{
+		asset.NewAssetAmount(network.Regtest.AssetID, 8, 500),
+		s.amount,
+	})
+	var err error
+	s.openingTxHex, err = s.onchain.CreateUnfundedOpeningTransaction(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.redeemScript, err = params.ToTxScript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.address, err = payment.FromPublicKey(s.taker.PubKey(), &network.Regtest, nil).WitnessPubKeyHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (s *testSwap) claimParams(preimage []byte, signingKey *btcec.PrivateKey) ClaimParams {
+	return NewClaimParams(s.openingTxHex, s.address, s.amount, testSwapCsv,
+		s.maker.PubKey().SerializeCompressed(), s.taker.PubKey().SerializeCompressed(), preimage, s.pHash, signingKey)
+}
+
+func (s *testSwap) claim(t *testing.T, preimage []byte, signingKey *btcec.PrivateKey) string {
+	txHex, err := s.onchain.CreatePreimageSpendingTransaction(s.claimParams(preimage, signingKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return txHex
+}
+
+// refund spends the asset output of the opening back to the maker with the sequence
+func (s *testSwap) refund(t *testing.T, sequence uint32) string {
+	openingTx, err := transaction.NewTxFromHex(s.openingTxHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openingHash := openingTx.TxHash()
+	tx := transaction.NewTx(2)
+	in := transaction.NewTxInput(openingHash[:], 1)
+	in.Sequence = sequence
+	tx.Inputs = append(tx.Inputs, in)
+	tx.Outputs = append(tx.Outputs, transaction.NewTxOutput(openingTx.Outputs[1].Asset, openingTx.Outputs[1].Value, []byte{0x51}))
+	sigHash := tx.HashForWitnessV0(0, s.redeemScript, openingTx.Outputs[1].Value, txscript.SigHashAll)
+	sig, err := s.maker.Sign(sigHash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.Witness = [][]byte{append(sig.Serialize(), byte(txscript.SigHashAll)), s.redeemScript}
+	txHex, err := tx.ToHex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return txHex
+}
+
+func TestVerifySwapSpend(t *testing.T) {
+	s := newTestSwap(t, testSwapCsv)
+
+	spends, err := VerifySwapSpend(s.claim(t, s.preimage, s.taker), s.openingTxHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spends) != 2 || spends[0].Path != SpendPathClaim || spends[1].Path != SpendPathClaim || spends[0].Vout != 0 || spends[1].Vout != 1 {
+		t.Fatalf("unexpected spends %+v", spends)
+	}
+
+	var verifyErr *SpendVerifyError
+	_, err = VerifySwapSpend(s.claim(t, make([]byte, 32), s.taker), s.openingTxHex)
+	if !errors.Is(err, ErrPreimageMismatch) || !errors.As(err, &verifyErr) || verifyErr.InputIndex != 0 {
+		t.Fatalf("expected ErrPreimageMismatch of input 0, got %v", err)
+	}
+	_, err = VerifySwapSpend(s.claim(t, s.preimage, s.maker), s.openingTxHex)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+	_, err = VerifySwapSpend(s.claim(t, s.preimage, s.taker), newTestSwap(t, testSwapCsv).openingTxHex)
+	if !errors.Is(err, ErrNoSwapInputs) {
+		t.Fatalf("expected ErrNoSwapInputs, got %v", err)
+	}
+
+	spends, err = VerifySwapSpend(s.refund(t, testSwapCsv), s.openingTxHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spends) != 1 || spends[0].Path != SpendPathRefund {
+		t.Fatalf("unexpected spends %+v", spends)
+	}
+	for _, sequence := range []uint32{0, testSwapCsv - 1, transaction.DefaultSequence, testSwapCsv | sequenceLockTimeIsSeconds} {
+		_, err = VerifySwapSpend(s.refund(t, sequence), s.openingTxHex)
+		if !errors.Is(err, ErrCsvNotSatisfied) {
+			t.Fatalf("sequence %x: expected ErrCsvNotSatisfied, got %v", sequence, err)
+		}
+	}
+}
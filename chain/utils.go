@@ -8,16 +8,6 @@ import (
 )
 
 
-func GetUsdtAsset(network string) string {
-	if network == "testnet" {
-		return "38fca2d939696061a8f76d4e6b5eecd54e3b4221c846f24a6b279e79952850a5"
-	} else if network == "liquid" {
-		return "ce091c998b83c78bb71a632313ba3760f1763d9cfcffae02258ffa9865a37bd2"
-	}
-	return ""
-}
-
-
 func GetChainCfgParams(liquidNetwork *network.Network, chaincfg *chaincfg.Params) *chaincfg.Params {
 	chaincfg.Name = liquidNetwork.Name
 	chaincfg.Bech32HRPSegwit = liquidNetwork.Bech32
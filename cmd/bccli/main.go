@@ -4,7 +4,10 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
 	"github.com/sputn1ck/liquid-go-lightwallet/chain"
+	"github.com/sputn1ck/liquid-go-lightwallet/keystore"
 	"github.com/sputn1ck/liquid-go-lightwallet/swap"
 	"github.com/sputn1ck/liquid-go-lightwallet/wallet"
 	"github.com/vulpemventures/go-elements/network"
@@ -12,25 +15,86 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sputn1ck/liquid-go-lightwallet/swaprpc"
 )
 
 var liquidNetwork = &network.Regtest
 
-var usdt = "2dcf5a8834645654911964ec3602426fd3b9b4017554d3f9c19403e7fc1411d3"
+var assetRegistryFile = "regtest-assets.json"
 
-var seed = "blossom must cherry inform whale steak wish raw arm among run dog middle animal horse history sustain extra trend walnut orchard grass bid caution"
+// walletBackend is elementsd, esplora or electrum, the esplora and electrum wallets are derived from the seed
+var walletBackend = "elementsd"
 
-var helpMsg = "you need to provice a command (newaddress, sendtoaddress, receive 'amt in usdt'"
+var esploraUrl = "http://localhost:3001"
+
+// electrumUrl is the electrum server of the electrum backend, tcp://host:port or ssl://host:port
+var electrumUrl = "tcp://localhost:50001"
+
+// walletStateFile caches the synced addresses and utxos of the esplora wallet
+var walletStateFile = "bccli-wallet-state.json"
+
+// derivationScheme is legacy, bip84 or bip49, the legacy layout is kept for existing wallets
+var derivationScheme = "legacy"
+
+// watchOnlyDescriptor initializes the esplora wallet watch-only from a ct descriptor instead of the seed
+var watchOnlyDescriptor = ""
+
+// swapLabelsFile links wallet transactions to swaps for the history
+var swapLabelsFile = "bccli-swap-labels.json"
+
+// keystoreFile holds the encrypted mnemonic of the esplora wallet, see the create and restore commands. Set
+// BCCLI_KEYSTORE to use another file, e.g. to create the keystore of bcd
+var keystoreFile = "bccli-keystore.json"
+
+// passwordFd and passwordFile unlock the keystore without prompting if one is set, they are set with
+// BCCLI_PASSWORD_FD and BCCLI_PASSWORD_FILE
+var passwordFd = -1
+var passwordFile = ""
+
+// swapConfs is the number of confirmations of the opening before a swap is claimed, and of the claim before it completes
+var swapConfs uint32 = 1
+
+// notifierPollInterval is the poll interval of the chain notifier that watches the swap transactions
+var notifierPollInterval = time.Second
+
+// zmqPubHashBlock and zmqPubRawTx are the zmq endpoints of elementsd, the elementsd notifier only polls while they are unset or down
+var zmqPubHashBlock = ""
+var zmqPubRawTx = ""
+
+// claimBatchWindow batches the claims that are ready within the window into one transaction paying claimFeeRate sat/vbyte,
+// zero claims every swap with its own transaction. BCCLI_CLAIM_BATCH_WINDOW sets it, e.g. 30s
+var claimBatchWindow time.Duration = 0
+
+var claimFeeRate = 0.1
+
+var helpMsg = "you need to provice a command (create, restore, unlock, newaddress, sendtoaddress 'address' 'amt' '[asset]', sendall 'address' '[asset]', balance '[asset]', history '[count]' '[skip]', descriptor, createpset 'file' 'address' 'amt' '[asset]', signpset 'file', sendpset 'file', assets, receive 'amt' '[asset]', decodeswap 'txhex' '[redeemscript]'"
 
 func main() {
+	if err := loadEnv(); err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
 	if len(os.Args) < 2 {
 		log.Printf(helpMsg)
 		return
 	}
 
 	switch os.Args[1] {
+	case "create":
+		if err := createKeystore(); err != nil {
+			log.Printf("Error: %v", err)
+		}
+	case "restore":
+		if err := restoreKeystore(); err != nil {
+			log.Printf("Error: %v", err)
+		}
+	case "unlock":
+		if err := unlockKeystore(); err != nil {
+			log.Printf("Error: %v", err)
+		}
 	case "receive":
 		if err := receive(); err != nil {
 			log.Printf("Error: %v", err)
@@ -39,10 +103,46 @@ func main() {
 		if err := getAddress(); err != nil {
 			log.Printf("Error: %v", err)
 		}
+	case "sendtoaddress":
+		if err := sendToAddress(); err != nil {
+			log.Printf("Error: %v", err)
+		}
+	case "sendall":
+		if err := sendAll(); err != nil {
+			log.Printf("Error: %v", err)
+		}
 	case "balance":
 		if err := getBalance(); err != nil {
 			log.Printf("Error: %v", err)
 		}
+	case "history":
+		if err := listTransactions(); err != nil {
+			log.Printf("Error: %v", err)
+		}
+	case "descriptor":
+		if err := getDescriptor(); err != nil {
+			log.Printf("Error: %v", err)
+		}
+	case "createpset":
+		if err := createPset(); err != nil {
+			log.Printf("Error: %v", err)
+		}
+	case "signpset":
+		if err := signPset(); err != nil {
+			log.Printf("Error: %v", err)
+		}
+	case "sendpset":
+		if err := sendPset(); err != nil {
+			log.Printf("Error: %v", err)
+		}
+	case "assets":
+		if err := getAssets(); err != nil {
+			log.Printf("Error: %v", err)
+		}
+	case "decodeswap":
+		if err := decodeSwap(); err != nil {
+			log.Printf("Error: %v", err)
+		}
 	default:
 		log.Printf(helpMsg)
 	}
@@ -50,33 +150,566 @@ func main() {
 
 }
 
+type CliWallet interface {
+	swap.Wallet
+	SendToAddress(address string, amount asset.AssetAmount) (string, error)
+	GetBalance(assetEntry *asset.AssetEntry) (asset.AssetAmount, error)
+	GetBalances() (map[string]uint64, error)
+	ListTransactions(count int, skip int) ([]*wallet.WalletTx, error)
+	SetSwapLabels(swapLabels *wallet.SwapLabels)
+}
+
+var (
+	_ CliWallet = (*wallet.ElementsRpcWallet)(nil)
+	_ CliWallet = (*wallet.LiquidWallet)(nil)
+)
+
+// getWallet returns the wallet of the configured backend, which links its transactions to swaps with the swap labels
+func getWallet() (CliWallet, error) {
+	cliWallet, err := getBackendWallet()
+	if err != nil {
+		return nil, err
+	}
+	swapLabels, err := wallet.LoadSwapLabels(swapLabelsFile)
+	if err != nil {
+		return nil, err
+	}
+	cliWallet.SetSwapLabels(swapLabels)
+	return cliWallet, nil
+}
+
+// getChainApi returns the chain backend of the light wallet
+func getChainApi() (wallet.EsploraApi, error) {
+	if walletBackend == "electrum" {
+		return chain.NewElectrumClient(electrumUrl)
+	}
+	return chain.NewEsploraApi(esploraUrl), nil
+}
+
+// getBackendWallet returns the wallet of the configured backend
+func getBackendWallet() (CliWallet, error) {
+	switch walletBackend {
+	case "esplora", "electrum":
+		chainApi, err := getChainApi()
+		if err != nil {
+			return nil, err
+		}
+		chainParams := chaincfg.MainNetParams
+		liquidWallet := wallet.NewLiquidWallet(chainApi, chain.GetChainCfgParams(liquidNetwork, &chainParams), liquidNetwork)
+		liquidWallet.SetWalletStore(wallet.NewFileStore(walletStateFile))
+		scheme, err := wallet.GetDerivationScheme(derivationScheme)
+		if err != nil {
+			return nil, err
+		}
+		liquidWallet.SetDerivationScheme(scheme)
+		if watchOnlyDescriptor != "" {
+			err = liquidWallet.InitializeFromDescriptor(watchOnlyDescriptor)
+		} else {
+			var seed []byte
+			seed, err = unlockSeed()
+			if err != nil {
+				return nil, err
+			}
+			err = liquidWallet.Initialize(seed)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return liquidWallet, nil
+	case "elementsd":
+		rpcClient, err := wallet.NewElementsdClient("localhost:18884", "admin1", "123")
+		if err != nil {
+			return nil, err
+		}
+		return wallet.NewRpcWallet(rpcClient, "betterchivo-client")
+	default:
+		return nil, fmt.Errorf("unknown wallet backend %s", walletBackend)
+	}
+}
+
+// loadEnv sets the keystore file and the password source from the environment
+func loadEnv() error {
+	if path := os.Getenv("BCCLI_KEYSTORE"); path != "" {
+		keystoreFile = path
+	}
+	if path := os.Getenv("BCCLI_PASSWORD_FILE"); path != "" {
+		passwordFile = path
+	}
+	if fd := os.Getenv("BCCLI_PASSWORD_FD"); fd != "" {
+		var err error
+		passwordFd, err = strconv.Atoi(fd)
+		if err != nil || passwordFd < 0 {
+			return fmt.Errorf("invalid BCCLI_PASSWORD_FD %q", fd)
+		}
+	}
+	if window := os.Getenv("BCCLI_CLAIM_BATCH_WINDOW"); window != "" {
+		var err error
+		claimBatchWindow, err = time.ParseDuration(window)
+		if err != nil || claimBatchWindow < 0 {
+			return fmt.Errorf("invalid BCCLI_CLAIM_BATCH_WINDOW %q", window)
+		}
+	}
+	return nil
+}
+
+// unlockSeed decrypts the keystore with the password from the file descriptor, the password file or a prompt and returns the seed
+func unlockSeed() ([]byte, error) {
+	var password []byte
+	var err error
+	switch {
+	case passwordFd >= 0:
+		password, err = keystore.ReadPasswordFd(uintptr(passwordFd))
+	case passwordFile != "":
+		password, err = keystore.ReadPasswordFile(passwordFile)
+	default:
+		password, err = keystore.PromptPassword("keystore password: ")
+	}
+	if err != nil {
+		return nil, err
+	}
+	secret, err := keystore.Unlock(keystoreFile, password)
+	if err != nil {
+		return nil, err
+	}
+	return secret.Seed()
+}
+
+// createKeystore creates a keystore with a new mnemonic and prints the mnemonic for backup
+func createKeystore() error {
+	mnemonic, err := keystore.NewMnemonic()
+	if err != nil {
+		return err
+	}
+	password, err := keystore.PromptNewPassword()
+	if err != nil {
+		return err
+	}
+	err = keystore.Create(keystoreFile, &keystore.Secret{Mnemonic: mnemonic}, password)
+	if err != nil {
+		return err
+	}
+	log.Printf("created keystore %s, write down the mnemonic: %s", keystoreFile, mnemonic)
+	return nil
+}
+
+// restoreKeystore creates a keystore from an existing mnemonic and optional passphrase
+func restoreKeystore() error {
+	mnemonic, err := keystore.PromptLine("mnemonic: ")
+	if err != nil {
+		return err
+	}
+	passphrase, err := keystore.PromptPassword("bip39 passphrase (optional): ")
+	if err != nil {
+		return err
+	}
+	password, err := keystore.PromptNewPassword()
+	if err != nil {
+		return err
+	}
+	err = keystore.Create(keystoreFile, &keystore.Secret{Mnemonic: strings.TrimSpace(mnemonic), Passphrase: string(passphrase)}, password)
+	if err != nil {
+		return err
+	}
+	log.Printf("restored keystore %s", keystoreFile)
+	return nil
+}
+
+// unlockKeystore checks the keystore password and prints the descriptor of the unlocked wallet
+func unlockKeystore() error {
+	seed, err := unlockSeed()
+	if err != nil {
+		return err
+	}
+	chainParams := chaincfg.MainNetParams
+	liquidWallet := wallet.NewLiquidWallet(nil, chain.GetChainCfgParams(liquidNetwork, &chainParams), liquidNetwork)
+	scheme, err := wallet.GetDerivationScheme(derivationScheme)
+	if err != nil {
+		return err
+	}
+	liquidWallet.SetDerivationScheme(scheme)
+	err = liquidWallet.InitializeOffline(seed)
+	if err != nil {
+		return err
+	}
+	descriptor, err := liquidWallet.GetDescriptor()
+	if err != nil {
+		return err
+	}
+	log.Printf("unlocked keystore %s: %s", keystoreFile, descriptor)
+	return nil
+}
+
+// getAssetArg returns the registry entry for the asset given at the argument index, defaulting to USDt
+func getAssetArg(registry *asset.AssetRegistry, argIndex int) (*asset.AssetEntry, error) {
+	if len(os.Args) > argIndex {
+		return registry.Lookup(os.Args[argIndex])
+	}
+	return registry.GetByTicker("USDt")
+}
+
+// getRpcAssetArg returns the registry entry for the asset given at the argument index,
+// assets that are only labeled in elementsd are returned with the rpc precision
+func getRpcAssetArg(registry *asset.AssetRegistry, cliWallet CliWallet, argIndex int) (*asset.AssetEntry, error) {
+	entry, err := getAssetArg(registry, argIndex)
+	if err == nil || !errors.Is(err, asset.ErrAssetNotFound) {
+		return entry, err
+	}
+	liquidWallet, ok := cliWallet.(*wallet.ElementsRpcWallet)
+	if !ok {
+		return nil, err
+	}
+	assetId, err := liquidWallet.GetAssetIdByLabel(os.Args[argIndex])
+	if err != nil {
+		return nil, err
+	}
+	return &asset.AssetEntry{AssetId: assetId, Ticker: os.Args[argIndex], Precision: asset.RpcPrecision}, nil
+}
+
 func getBalance() error {
-	rpcClient, err := wallet.NewElementsdClient("localhost:18884", "admin1", "123")
+	registry, err := asset.LoadAssetRegistryOrDefault(assetRegistryFile, liquidNetwork)
 	if err != nil {
 		return err
 	}
 
-	liquidWallet, err := wallet.NewRpcWallet(rpcClient, "betterchivo-client")
+	liquidWallet, err := getWallet()
 	if err != nil {
 		return err
 	}
-	balance, err := liquidWallet.GetBalance(usdt)
+
+	if len(os.Args) > 2 {
+		entry, err := getRpcAssetArg(registry, liquidWallet, 2)
+		if err != nil {
+			return err
+		}
+		balance, err := liquidWallet.GetBalance(entry)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s Balance: %s", entry.Ticker, balance)
+		return nil
+	}
+
+	balances, err := liquidWallet.GetBalances()
 	if err != nil {
 		return err
 	}
-	log.Printf("USDT Balance: %v", balance)
+	for assetId, v := range balances {
+		entry, err := registry.Get(assetId)
+		if err != nil {
+			log.Printf("%s Balance: %s", assetId, asset.FormatDecimal(v, asset.RpcPrecision))
+			continue
+		}
+		log.Printf("%s Balance: %s", entry.Ticker, entry.NewAmount(v))
+	}
 	return nil
 }
-func getAddress() error {
-	rpcClient, err := wallet.NewElementsdClient("localhost:18884", "admin1","123")
+
+func sendToAddress() error {
+	if len(os.Args) < 4 {
+		return errors.New("expected address and amount")
+	}
+	registry, err := asset.LoadAssetRegistryOrDefault(assetRegistryFile, liquidNetwork)
+	if err != nil {
+		return err
+	}
+
+	liquidWallet, err := getWallet()
+	if err != nil {
+		return err
+	}
+
+	assetEntry, err := getRpcAssetArg(registry, liquidWallet, 4)
+	if err != nil {
+		return err
+	}
+	amount, err := assetEntry.ParseAmount(os.Args[3])
+	if err != nil {
+		return err
+	}
+
+	txId, err := liquidWallet.SendToAddress(os.Args[2], amount)
 	if err != nil {
 		return err
 	}
+	log.Printf("sent %s %s: %s", amount, assetEntry.Ticker, txId)
+	return nil
+}
 
-	liquidWallet,err := wallet.NewRpcWallet(rpcClient, "betterchivo-client")
+// sendAll sweeps all funds of an asset, only the esplora wallet supports sweeping
+func sendAll() error {
+	if len(os.Args) < 3 {
+		return errors.New("expected address")
+	}
+	registry, err := asset.LoadAssetRegistryOrDefault(assetRegistryFile, liquidNetwork)
 	if err != nil {
 		return err
 	}
+	assetEntry, err := getAssetArg(registry, 3)
+	if err != nil {
+		return err
+	}
+
+	esploraWallet, err := getEsploraWallet("sendall")
+	if err != nil {
+		return err
+	}
+
+	txId, err := esploraWallet.SendAllToAddress(os.Args[2], assetEntry.AssetId)
+	if err != nil {
+		return err
+	}
+	log.Printf("sent all %s: %s", assetEntry.Ticker, txId)
+	return nil
+}
+
+// listTransactions prints the wallet transactions with the net amount per asset, most recent first
+func listTransactions() error {
+	count, skip := 10, 0
+	var err error
+	if len(os.Args) > 2 {
+		count, err = strconv.Atoi(os.Args[2])
+		if err != nil {
+			return fmt.Errorf("invalid count: %w", err)
+		}
+	}
+	if len(os.Args) > 3 {
+		skip, err = strconv.Atoi(os.Args[3])
+		if err != nil {
+			return fmt.Errorf("invalid skip: %w", err)
+		}
+	}
+	registry, err := asset.LoadAssetRegistryOrDefault(assetRegistryFile, liquidNetwork)
+	if err != nil {
+		return err
+	}
+	cliWallet, err := getWallet()
+	if err != nil {
+		return err
+	}
+	walletTxs, err := cliWallet.ListTransactions(count, skip)
+	if err != nil {
+		return err
+	}
+	for _, v := range walletTxs {
+		status := "unconfirmed"
+		if v.Confirmations > 0 {
+			status = fmt.Sprintf("%v confirmations, block %v", v.Confirmations, v.BlockHeight)
+		}
+		log.Printf("%s (%s) fee: %v sat", v.TxId, status, v.Fee)
+		for assetId, amount := range v.Amounts {
+			log.Printf("  %s", formatNetAmount(registry, assetId, amount))
+		}
+		for _, swapId := range v.SwapIds {
+			log.Printf("  swap %s", swapId)
+		}
+	}
+	return nil
+}
+
+// formatNetAmount formats a signed amount with the precision and ticker of the asset, unknown assets are shown in base units
+func formatNetAmount(registry *asset.AssetRegistry, assetId string, amount int64) string {
+	sign := "+"
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	entry, err := registry.Get(assetId)
+	if err != nil {
+		return fmt.Sprintf("%s%v %s", sign, amount, assetId)
+	}
+	return fmt.Sprintf("%s%s %s", sign, entry.NewAmount(uint64(amount)), entry.Ticker)
+}
+
+// getDescriptor prints the ct descriptor a watch-only copy of the esplora wallet is initialized from
+func getDescriptor() error {
+	esploraWallet, err := getEsploraWallet("descriptor")
+	if err != nil {
+		return err
+	}
+	descriptor, err := esploraWallet.GetDescriptor()
+	if err != nil {
+		return err
+	}
+	log.Printf("%s", descriptor)
+	return nil
+}
+
+// getEsploraWallet returns the esplora wallet, which is the only one supporting psets
+func getEsploraWallet(command string) (*wallet.LiquidWallet, error) {
+	cliWallet, err := getWallet()
+	if err != nil {
+		return nil, err
+	}
+	esploraWallet, ok := cliWallet.(*wallet.LiquidWallet)
+	if !ok {
+		return nil, fmt.Errorf("%s is not supported by the %s wallet", command, walletBackend)
+	}
+	return esploraWallet, nil
+}
+
+// createPset writes an unsigned pset paying the address to the file as base64
+func createPset() error {
+	if len(os.Args) < 5 {
+		return errors.New("expected file, address and amount")
+	}
+	registry, err := asset.LoadAssetRegistryOrDefault(assetRegistryFile, liquidNetwork)
+	if err != nil {
+		return err
+	}
+	assetEntry, err := getAssetArg(registry, 5)
+	if err != nil {
+		return err
+	}
+	amount, err := assetEntry.ParseAmount(os.Args[4])
+	if err != nil {
+		return err
+	}
+
+	esploraWallet, err := getEsploraWallet("createpset")
+	if err != nil {
+		return err
+	}
+	psetBase64, err := esploraWallet.CreatePset([]*wallet.Recipient{{Address: os.Args[3], AssetId: amount.AssetId, Amount: amount.Amount}})
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(os.Args[2], []byte(psetBase64), 0600)
+	if err != nil {
+		return err
+	}
+	log.Printf("wrote pset sending %s %s to %s", amount, assetEntry.Ticker, os.Args[2])
+	return nil
+}
+
+// signPset signs the wallet inputs of the pset in the file, the wallet keys are derived without syncing,
+// so it works on an offline machine
+func signPset() error {
+	if len(os.Args) < 3 {
+		return errors.New("expected file")
+	}
+	psetBase64, err := os.ReadFile(os.Args[2])
+	if err != nil {
+		return err
+	}
+	chainParams := chaincfg.MainNetParams
+	liquidWallet := wallet.NewLiquidWallet(nil, chain.GetChainCfgParams(liquidNetwork, &chainParams), liquidNetwork)
+	seed, err := unlockSeed()
+	if err != nil {
+		return err
+	}
+	err = liquidWallet.InitializeOffline(seed)
+	if err != nil {
+		return err
+	}
+	signed, err := liquidWallet.SignPset(strings.TrimSpace(string(psetBase64)))
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(os.Args[2], []byte(signed), 0600)
+	if err != nil {
+		return err
+	}
+	log.Printf("signed pset %s", os.Args[2])
+	return nil
+}
+
+// sendPset finalizes the signed pset in the file and broadcasts the transaction
+func sendPset() error {
+	if len(os.Args) < 3 {
+		return errors.New("expected file")
+	}
+	psetBase64, err := os.ReadFile(os.Args[2])
+	if err != nil {
+		return err
+	}
+	esploraWallet, err := getEsploraWallet("sendpset")
+	if err != nil {
+		return err
+	}
+	txId, err := esploraWallet.FinalizeAndSendPset(strings.TrimSpace(string(psetBase64)))
+	if err != nil {
+		return err
+	}
+	log.Printf("sent pset: %s", txId)
+	return nil
+}
+
+func getAssets() error {
+	conn, err := getClientConn("localhost:42069")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	bcc := swap.NewBetterChivoClient(swaprpc.NewSwapServiceClient(conn), nil, nil)
+	assetInfos, err := bcc.GetSupportedAssets()
+	if err != nil {
+		return err
+	}
+	for _, v := range assetInfos {
+		log.Printf("%s: %s %s precision: %v rate: %v sat", v.Ticker, v.Name, v.AssetId, v.Precision, v.ExchangeRate)
+	}
+	return nil
+}
+// decodeSwap prints the swap outputs of an opening transaction, with a redeem script only the outputs
+// of that swap are printed along with the decoded script
+func decodeSwap() error {
+	if len(os.Args) < 3 {
+		return errors.New("usage: decodeswap 'txhex' '[redeemscript]'")
+	}
+	registry, err := asset.LoadAssetRegistryOrDefault(assetRegistryFile, liquidNetwork)
+	if err != nil {
+		return err
+	}
+	opening, err := chain.ParseSwapOpening(os.Args[2])
+	if err != nil {
+		return err
+	}
+	outputs := opening.Outputs
+	if len(os.Args) > 3 {
+		redeemScript, err := hex.DecodeString(os.Args[3])
+		if err != nil {
+			return fmt.Errorf("invalid redeem script: %w", err)
+		}
+		script, err := chain.ParseOpeningTxScript(redeemScript)
+		if err != nil {
+			return err
+		}
+		log.Printf("maker pubkey: %x", script.MakerPubkey)
+		log.Printf("taker pubkey: %x", script.TakerPubkey)
+		log.Printf("payment hash: %x", script.PaymentHash)
+		log.Printf("csv: %v", script.Csv)
+		outputs = opening.OutputsForScript(redeemScript)
+	}
+	log.Printf("%s", opening.TxId)
+	for _, v := range outputs {
+		if v.Confidential {
+			log.Printf("  vout %v: confidential, witness program %x", v.Vout, v.WitnessProgram)
+			continue
+		}
+		amount := fmt.Sprintf("%v %s", v.Amount, v.AssetId)
+		if entry, err := registry.Get(v.AssetId); err == nil {
+			amount = fmt.Sprintf("%s %s", entry.NewAmount(v.Amount), entry.Ticker)
+		}
+		log.Printf("  vout %v: %s, witness program %x", v.Vout, amount, v.WitnessProgram)
+	}
+	return nil
+}
+
+func getAddress() error {
+	liquidWallet, err := getWallet()
+	if err != nil {
+		return err
+	}
+	// the esplora wallet hands out confidential addresses, elementsd addresses are confidential by default
+	if esploraWallet, ok := liquidWallet.(*wallet.LiquidWallet); ok {
+		address, err := esploraWallet.GetConfidentialAddress()
+		if err != nil {
+			return err
+		}
+		log.Printf("%s", address)
+		return nil
+	}
 	address, err := liquidWallet.GetAddress()
 	if err != nil {
 		return err
@@ -91,7 +724,15 @@ func receive() error {
 	if len(os.Args) < 3 {
 		return errors.New("expected amount ")
 	}
-	amount, err := strconv.Atoi(os.Args[2])
+	registry, err := asset.LoadAssetRegistryOrDefault(assetRegistryFile, liquidNetwork)
+	if err != nil {
+		return err
+	}
+	assetEntry, err := getAssetArg(registry, 3)
+	if err != nil {
+		return err
+	}
+	amount, err := assetEntry.ParseAmount(os.Args[2])
 	if err != nil {
 		return err
 	}
@@ -104,12 +745,7 @@ func receive() error {
 
 	psClient := swaprpc.NewSwapServiceClient(conn)
 
-	rpcClient, err := wallet.NewElementsdClient("localhost:18884", "admin1","123")
-	if err != nil {
-		return err
-	}
-
-	liquidWallet,err := wallet.NewRpcWallet(rpcClient, "betterchivo-client")
+	liquidWallet, err := getWallet()
 	if err != nil {
 		return err
 	}
@@ -117,13 +753,23 @@ func receive() error {
 	blockchain := chain.NewLiquidOnchain(liquidNetwork)
 
 	bcc := swap.NewBetterChivoClient(psClient, liquidWallet, blockchain)
-
-	usdtBytes, err := hex.DecodeString(usdt)
+	notifier, err := getNotifier()
 	if err != nil {
 		return err
 	}
+	if notifier != nil {
+		err = notifier.Start()
+		if err != nil {
+			return err
+		}
+		defer notifier.Stop()
+		bcc.SetNotifier(notifier, swapConfs)
+	}
+	if claimBatchWindow > 0 {
+		bcc.SetClaimBatching(claimBatchWindow, claimFeeRate)
+	}
 
-	err = bcc.ReceiveUsdt(uint64(amount), blockchain.TranslateAsset(usdtBytes))
+	err = bcc.ReceiveUsdt(amount)
 	if err != nil {
 		return err
 	}
@@ -132,6 +778,27 @@ func receive() error {
 }
 
 
+// getNotifier returns the chain notifier of the configured backend, or nil if the swap transactions are not watched
+func getNotifier() (chain.Notifier, error) {
+	switch walletBackend {
+	case "esplora":
+		return chain.NewEsploraNotifier(chain.NewEsploraApi(esploraUrl), notifierPollInterval), nil
+	case "elementsd":
+		rpcClient, err := wallet.NewElementsdClient("localhost:18884", "admin1", "123")
+		if err != nil {
+			return nil, err
+		}
+		notifier := chain.NewElementsdNotifier(rpcClient, notifierPollInterval)
+		if zmqPubHashBlock != "" {
+			notifier.UseZmq(zmqPubHashBlock, zmqPubRawTx)
+		}
+		return notifier, nil
+	default:
+		log.Printf("no chain notifier for the %s backend, swap transactions are not watched", walletBackend)
+		return nil, nil
+	}
+}
+
 func getClientConn(address string) (*grpc.ClientConn, error) {
 
 	maxMsgRecvSize := grpc.MaxCallRecvMsgSize(1 * 1024 * 1024 * 200)
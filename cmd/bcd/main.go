@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
 	"github.com/sputn1ck/liquid-go-lightwallet/chain"
+	"github.com/sputn1ck/liquid-go-lightwallet/keystore"
 	"github.com/sputn1ck/liquid-go-lightwallet/lightning"
 	"github.com/sputn1ck/liquid-go-lightwallet/swap"
 	"github.com/sputn1ck/liquid-go-lightwallet/swaprpc"
@@ -13,7 +17,9 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 )
 
 var (
@@ -21,20 +27,196 @@ var (
 	lndconnect = "lndconnect://127.0.0.1:10001?cert=MIICJzCCAc2gAwIBAgIRAM8SaqbghgiYTb5ZLIMNKiMwCgYIKoZIzj0EAwIwMTEfMB0GA1UEChMWbG5kIGF1dG9nZW5lcmF0ZWQgY2VydDEOMAwGA1UEAxMFYWxpY2UwHhcNMjIwMjI0MTQwNzAzWhcNMjMwNDIxMTQwNzAzWjAxMR8wHQYDVQQKExZsbmQgYXV0b2dlbmVyYXRlZCBjZXJ0MQ4wDAYDVQQDEwVhbGljZTBZMBMGByqGSM49AgEGCCqGSM49AwEHA0IABBSvPfCLcRu9xss932o44ezvdG9ObGAamzt4QHaeuYN2hq8tZ34BXTi1PC73lHyWdNNG4r2Vk-KXG_cFHhwMKmOjgcUwgcIwDgYDVR0PAQH_BAQDAgKkMBMGA1UdJQQMMAoGCCsGAQUFBwMBMA8GA1UdEwEB_wQFMAMBAf8wHQYDVR0OBBYEFK46_ewGBrWfwQz1rSUYqHCBR5FYMGsGA1UdEQRkMGKCBWFsaWNlgglsb2NhbGhvc3SCBWFsaWNlgg5wb2xhci1uMS1hbGljZYIEdW5peIIKdW5peHBhY2tldIIHYnVmY29ubocEfwAAAYcQAAAAAAAAAAAAAAAAAAAAAYcErBUABDAKBggqhkjOPQQDAgNIADBFAiEA5_TuZG9JXVWGwVjvWLhjzI-lwnkemC25JhumAMVVCZUCICEFm2JhhCumljkx5UGFM-Lhjr-ChmfyJ_jcrdQUzjCk&macaroon=AgEDbG5kAvgBAwoQrKvUd3mu8R0buI_mOrP-1RIBMBoWCgdhZGRyZXNzEgRyZWFkEgV3cml0ZRoTCgRpbmZvEgRyZWFkEgV3cml0ZRoXCghpbnZvaWNlcxIEcmVhZBIFd3JpdGUaIQoIbWFjYXJvb24SCGdlbmVyYXRlEgRyZWFkEgV3cml0ZRoWCgdtZXNzYWdlEgRyZWFkEgV3cml0ZRoXCghvZmZjaGFpbhIEcmVhZBIFd3JpdGUaFgoHb25jaGFpbhIEcmVhZBIFd3JpdGUaFAoFcGVlcnMSBHJlYWQSBXdyaXRlGhgKBnNpZ25lchIIZ2VuZXJhdGUSBHJlYWQAAAYgexb5fqA5XsR6_DcvO6my1xaRs8xXzhTeqcA85A-XPms"
 )
 
+var (
+	assetRegistryFile = "regtest-assets.json"
+	supportedAssets = []string{"USDt"}
+	// usePsetFunding funds openings with walletcreatefundedpsbt, which requires elements 0.21 or newer
+	usePsetFunding = false
+	// walletBackend is elementsd, esplora or electrum, the esplora and electrum wallets are derived from the first account
+	walletBackend = "elementsd"
+	esploraUrl = "http://localhost:3001"
+	// electrumUrl is the electrum server of the electrum backend, tcp://host:port or ssl://host:port
+	electrumUrl = "tcp://localhost:50001"
+	// walletStateFile caches the synced addresses and utxos of the esplora wallet
+	walletStateFile = "bcd-wallet-state.json"
+	// derivationScheme is legacy, bip84 or bip49, the legacy layout is kept for existing wallets
+	derivationScheme = "legacy"
+	// swapLabelsFile links wallet transactions to swaps for the history
+	swapLabelsFile = "bcd-swap-labels.json"
+	// keystoreFile holds the encrypted mnemonic of the esplora wallet, set BCD_KEYSTORE to use another file. It is
+	// created with bccli, e.g. BCCLI_KEYSTORE=bcd-keystore.json bccli create
+	keystoreFile = "bcd-keystore.json"
+	// passwordFd and passwordFile unlock the keystore at startup, if neither is set the password is prompted.
+	// They are set with BCD_PASSWORD_FD and BCD_PASSWORD_FILE
+	passwordFd   = -1
+	passwordFile = ""
+	// settleConfs is the number of confirmations of the opening before a hold invoice is settled, 0 settles at once
+	settleConfs uint32 = 1
+	// notifierPollInterval is the poll interval of the chain notifier that tracks the openings
+	notifierPollInterval = 5 * time.Second
+	// zmqPubHashBlock and zmqPubRawTx are the zmq endpoints of elementsd, e.g. tcp://127.0.0.1:28332. If they
+	// are set the elementsd notifier is driven by zmq and only polls while the sockets are down
+	zmqPubHashBlock = ""
+	zmqPubRawTx     = ""
+	// pendingSettlementsFile keeps the received preimages until the openings confirm and the invoices are settled
+	pendingSettlementsFile = "bcd-pending-settlements.json"
+)
+
+type ServerWallet interface {
+	swap.SwapWallet
+	GetAddress() (string, error)
+	SetSwapLabels(swapLabels *wallet.SwapLabels)
+}
+
+var (
+	_ ServerWallet = (*wallet.ElementsRpcWallet)(nil)
+	_ ServerWallet = (*wallet.LiquidWallet)(nil)
+)
+
+// getSwapWallet returns the wallet of the configured backend, which links its transactions to swaps with the swap labels
+func getSwapWallet() (ServerWallet, error) {
+	serverWallet, err := getBackendWallet()
+	if err != nil {
+		return nil, err
+	}
+	swapLabels, err := wallet.LoadSwapLabels(swapLabelsFile)
+	if err != nil {
+		return nil, err
+	}
+	serverWallet.SetSwapLabels(swapLabels)
+	return serverWallet, nil
+}
+
+// getChainApi returns the chain backend of the light wallet
+func getChainApi() (wallet.EsploraApi, error) {
+	if walletBackend == "electrum" {
+		return chain.NewElectrumClient(electrumUrl)
+	}
+	return chain.NewEsploraApi(esploraUrl), nil
+}
+
+// getBackendWallet returns the wallet of the configured backend
+func getBackendWallet() (ServerWallet, error) {
+	switch walletBackend {
+	case "esplora", "electrum":
+		chainApi, err := getChainApi()
+		if err != nil {
+			return nil, err
+		}
+		chainParams := chaincfg.MainNetParams
+		liquidWallet := wallet.NewLiquidWallet(chainApi, chain.GetChainCfgParams(liquidNetwork, &chainParams), liquidNetwork)
+		liquidWallet.SetWalletStore(wallet.NewFileStore(walletStateFile))
+		scheme, err := wallet.GetDerivationScheme(derivationScheme)
+		if err != nil {
+			return nil, err
+		}
+		liquidWallet.SetDerivationScheme(scheme)
+		seed, err := unlockSeed()
+		if err != nil {
+			return nil, err
+		}
+		err = liquidWallet.Initialize(seed)
+		if err != nil {
+			return nil, err
+		}
+		if walletBackend == "electrum" {
+			// the electrum server pushes new blocks and wallet activity, so the wallet stays synced
+			go liquidWallet.WatchChain(context.Background(), func(err error) {
+				if err != nil {
+					log.Printf("wallet sync: %v", err)
+				}
+			})
+		}
+		return liquidWallet, nil
+	case "elementsd":
+		rpcClient, err := wallet.NewElementsdClient("localhost:18884", "admin1", "123")
+		if err != nil {
+			return nil, err
+		}
+		liquidWallet, err := wallet.NewRpcWallet(rpcClient, "betterchivo-server")
+		if err != nil {
+			return nil, err
+		}
+		liquidWallet.UsePsetFunding(usePsetFunding)
+		return liquidWallet, nil
+	default:
+		return nil, fmt.Errorf("unknown wallet backend %s", walletBackend)
+	}
+}
+
+// getNotifier returns the chain notifier of the configured backend, or nil if confirmations are not tracked.
+// Backends without a notifier are refused unless settleConfs is 0, so no invoice is settled unconfirmed by accident
+func getNotifier() (chain.Notifier, error) {
+	if settleConfs == 0 {
+		return nil, nil
+	}
+	switch walletBackend {
+	case "esplora":
+		return chain.NewEsploraNotifier(chain.NewEsploraApi(esploraUrl), notifierPollInterval), nil
+	case "elementsd":
+		rpcClient, err := wallet.NewElementsdClient("localhost:18884", "admin1", "123")
+		if err != nil {
+			return nil, err
+		}
+		notifier := chain.NewElementsdNotifier(rpcClient, notifierPollInterval)
+		if zmqPubHashBlock != "" {
+			notifier.UseZmq(zmqPubHashBlock, zmqPubRawTx)
+		}
+		return notifier, nil
+	default:
+		return nil, fmt.Errorf("no chain notifier for the %s backend, set settleConfs to 0 to settle invoices without confirmations", walletBackend)
+	}
+}
+
 func main() {
+	if err := loadEnv(); err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
 	if err := run(); err != nil {
 		log.Printf("Error: %v", err)
 	}
 }
 
-var (
-	accounts = []string{
-		"alter element derive simple area banana anxiety kiss action digital prevent people volume gaze public divorce box lock issue aspect shadow program furnace husband",
-		"mesh utility girl umbrella brief fatigue hamster garage egg olympic party keen vacant nation coconut run multiply talk supply muffin trumpet immune bunker envelope",
-		"blossom must cherry inform whale steak wish raw arm among run dog middle animal horse history sustain extra trend walnut orchard grass bid caution",
-		"veteran buzz mammal found sign sick steel butter message usage middle easy",
+// loadEnv sets the keystore file and the password source from the environment
+func loadEnv() error {
+	if path := os.Getenv("BCD_KEYSTORE"); path != "" {
+		keystoreFile = path
 	}
-)
+	if path := os.Getenv("BCD_PASSWORD_FILE"); path != "" {
+		passwordFile = path
+	}
+	if fd := os.Getenv("BCD_PASSWORD_FD"); fd != "" {
+		var err error
+		passwordFd, err = strconv.Atoi(fd)
+		if err != nil || passwordFd < 0 {
+			return fmt.Errorf("invalid BCD_PASSWORD_FD %q", fd)
+		}
+	}
+	return nil
+}
+
+// unlockSeed decrypts the keystore with the password from the file descriptor, the password file or a prompt
+func unlockSeed() ([]byte, error) {
+	var password []byte
+	var err error
+	switch {
+	case passwordFd >= 0:
+		password, err = keystore.ReadPasswordFd(uintptr(passwordFd))
+	case passwordFile != "":
+		password, err = keystore.ReadPasswordFile(passwordFile)
+	default:
+		password, err = keystore.PromptPassword("keystore password: ")
+	}
+	if err != nil {
+		return nil, err
+	}
+	secret, err := keystore.Unlock(keystoreFile, password)
+	if err != nil {
+		return nil, err
+	}
+	return secret.Seed()
+}
 
 
 func run() error {
@@ -59,27 +241,47 @@ func run() error {
 	if err != nil {
 		return err
 	}
-	rpcClient, err := wallet.NewElementsdClient("localhost:18884", "admin1","123")
+	liquidWallet, err := getSwapWallet()
 	if err != nil {
 		return err
 	}
 
-	liquidWallet,err := wallet.NewRpcWallet(rpcClient, "betterchivo-server")
+	unblindedAddr, err := liquidWallet.GetAddress()
 	if err != nil {
 		return err
 	}
 
-	unblindedAddr, err := liquidWallet.GetAddress()
+	log.Printf("Server unblinded address: %s", unblindedAddr)
+
+	registry, err := asset.LoadAssetRegistryOrDefault(assetRegistryFile, liquidNetwork)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Server unblinded address: %s", unblindedAddr)
-
 	liquidChain := chain.NewLiquidOnchain(liquidNetwork)
 	dummyCC := &DummyCurrencyConverter{}
 
-	swapServer := swap.NewBetterChivoServer(liquidWallet, lnd,liquidChain,dummyCC)
+	swapServer, err := swap.NewBetterChivoServer(liquidWallet, lnd,liquidChain,dummyCC, registry, supportedAssets)
+	if err != nil {
+		return err
+	}
+	notifier, err := getNotifier()
+	if err != nil {
+		return err
+	}
+	if notifier != nil {
+		err = notifier.Start()
+		if err != nil {
+			return err
+		}
+		defer notifier.Stop()
+		settlements, err := swap.LoadPendingSettlements(pendingSettlementsFile)
+		if err != nil {
+			return err
+		}
+		swapServer.SetNotifier(notifier, settleConfs, settlements)
+		swapServer.ResumeSettlements()
+	}
 	host := "localhost:42069"
 	lis, err := net.Listen("tcp", host)
 	if err != nil {
@@ -145,8 +347,8 @@ func run() error {
 
 type DummyCurrencyConverter struct {}
 
-func (d *DummyCurrencyConverter) GetSatAmt(asset string, amount uint64) (uint64, error) {
-	return amount, nil
+func (d *DummyCurrencyConverter) GetSatAmt(amount asset.AssetAmount) (uint64, error) {
+	return amount.Amount, nil
 }
 
 
@@ -0,0 +1,244 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/tyler-smith/go-bip39"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	keystoreVersion = 1
+
+	kdfArgon2id     = "argon2id"
+	cipherXChacha20 = "xchacha20-poly1305"
+
+	// argon2id parameters as recommended for interactive logins by rfc 9106
+	defaultArgon2Time    = 3
+	defaultArgon2Memory  = 64 * 1024
+	defaultArgon2Threads = 4
+
+	// upper bounds of the argon2id parameters accepted from a keystore file, a modified keystore must not
+	// make the key derivation allocate unbounded memory or run for hours before the password is checked
+	maxArgon2Time    = 64
+	maxArgon2Memory  = 4 * 1024 * 1024
+	maxArgon2Threads = 64
+
+	saltLen = 16
+	keyLen  = chacha20poly1305.KeySize
+
+	// mnemonicEntropyBits creates 24 word mnemonics
+	mnemonicEntropyBits = 256
+)
+
+var (
+	ErrWrongPassword  = errors.New("wrong keystore password")
+	ErrKeystoreExists = errors.New("keystore already exists")
+	ErrEmptyPassword  = errors.New("keystore password is empty")
+	ErrInvalidKdf     = errors.New("invalid keystore kdf parameters")
+)
+
+// Secret is the wallet secret that is encrypted in the keystore
+type Secret struct {
+	Mnemonic string `json:"mnemonic"`
+
+	// Passphrase is the optional bip39 passphrase
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// Seed returns the bip39 seed of the mnemonic and passphrase
+func (s *Secret) Seed() ([]byte, error) {
+	return bip39.NewSeedWithErrorChecking(s.Mnemonic, s.Passphrase)
+}
+
+// KdfParams are the argon2id parameters the encryption key is derived with
+type KdfParams struct {
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	Salt    []byte `json:"salt"`
+}
+
+// validate checks that the parameters are within the bounds of a keystore created by Encrypt
+func (k *KdfParams) validate() error {
+	if k.Time < 1 || k.Time > maxArgon2Time || k.Memory < 8*uint32(k.Threads) || k.Memory > maxArgon2Memory ||
+		k.Threads < 1 || k.Threads > maxArgon2Threads || len(k.Salt) != saltLen {
+		return fmt.Errorf("%w: time %v, memory %v, threads %v", ErrInvalidKdf, k.Time, k.Memory, k.Threads)
+	}
+	return nil
+}
+
+func (k *KdfParams) deriveKey(password []byte) []byte {
+	return argon2.IDKey(password, k.Salt, k.Time, k.Memory, k.Threads, keyLen)
+}
+
+// Keystore is the encrypted secret as it is stored on disk
+type Keystore struct {
+	Version    int       `json:"version"`
+	Kdf        string    `json:"kdf"`
+	KdfParams  KdfParams `json:"kdf_params"`
+	Cipher     string    `json:"cipher"`
+	Nonce      []byte    `json:"nonce"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+// additionalData authenticates the keystore header, so the kdf parameters can not be weakened unnoticed
+func (k *Keystore) additionalData() ([]byte, error) {
+	return json.Marshal(struct {
+		Version   int       `json:"version"`
+		Kdf       string    `json:"kdf"`
+		KdfParams KdfParams `json:"kdf_params"`
+		Cipher    string    `json:"cipher"`
+	}{k.Version, k.Kdf, k.KdfParams, k.Cipher})
+}
+
+// NewMnemonic returns a new 24 word bip39 mnemonic
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(mnemonicEntropyBits)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// Encrypt encrypts the secret with a key derived from the password
+func Encrypt(secret *Secret, password []byte) (*Keystore, error) {
+	if len(password) == 0 {
+		return nil, ErrEmptyPassword
+	}
+	if !bip39.IsMnemonicValid(secret.Mnemonic) {
+		return nil, errors.New("invalid mnemonic")
+	}
+	salt := make([]byte, saltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	k := &Keystore{
+		Version: keystoreVersion,
+		Kdf:     kdfArgon2id,
+		KdfParams: KdfParams{
+			Time:    defaultArgon2Time,
+			Memory:  defaultArgon2Memory,
+			Threads: defaultArgon2Threads,
+			Salt:    salt,
+		},
+		Cipher: cipherXChacha20,
+		Nonce:  nonce,
+	}
+
+	plaintext, err := json.Marshal(secret)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(plaintext)
+	aead, err := chacha20poly1305.NewX(k.KdfParams.deriveKey(password))
+	if err != nil {
+		return nil, err
+	}
+	additionalData, err := k.additionalData()
+	if err != nil {
+		return nil, err
+	}
+	k.Ciphertext = aead.Seal(nil, nonce, plaintext, additionalData)
+	return k, nil
+}
+
+// Decrypt decrypts the secret, a wrong password or a modified keystore return ErrWrongPassword
+func (k *Keystore) Decrypt(password []byte) (*Secret, error) {
+	if k.Version != keystoreVersion || k.Kdf != kdfArgon2id || k.Cipher != cipherXChacha20 {
+		return nil, fmt.Errorf("unsupported keystore version %v with %s and %s", k.Version, k.Kdf, k.Cipher)
+	}
+	err := k.KdfParams.validate()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(k.KdfParams.deriveKey(password))
+	if err != nil {
+		return nil, err
+	}
+	if len(k.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid keystore nonce")
+	}
+	additionalData, err := k.additionalData()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, k.Nonce, k.Ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	defer zero(plaintext)
+	var secret Secret
+	err = json.Unmarshal(plaintext, &secret)
+	if err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+// Create encrypts the secret and writes the keystore to a new file, existing keystores are never overwritten
+func Create(path string, secret *Secret, password []byte) error {
+	k, err := Encrypt(secret, password)
+	if err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return fmt.Errorf("%w: %s", ErrKeystoreExists, path)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load reads a keystore file
+func Load(path string) (*Keystore, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var k Keystore
+	err = json.Unmarshal(buf, &k)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// Unlock loads and decrypts a keystore file
+func Unlock(path string, password []byte) (*Secret, error) {
+	k, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return k.Decrypt(password)
+}
+
+// zero overwrites decrypted data, so it does not linger in memory
+func zero(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+}
@@ -0,0 +1,69 @@
+package keystore
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeystore(t *testing.T) {
+	mnemonic, err := NewMnemonic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := &Secret{Mnemonic: mnemonic, Passphrase: "extra"}
+	path := filepath.Join(t.TempDir(), "keystore.json")
+
+	err = Create(path, secret, []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Create(path, secret, []byte("password"))
+	if !errors.Is(err, ErrKeystoreExists) {
+		t.Fatalf("Create over existing keystore: %v, want %v", err, ErrKeystoreExists)
+	}
+
+	_, err = Unlock(path, []byte("wrong"))
+	if !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("Unlock with wrong password: %v, want %v", err, ErrWrongPassword)
+	}
+	unlocked, err := Unlock(path, []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *unlocked != *secret {
+		t.Fatalf("Unlock = %+v, want %+v", unlocked, secret)
+	}
+
+	// weakening the kdf parameters breaks the authentication
+	k, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.KdfParams.Time = 1
+	_, err = k.Decrypt([]byte("password"))
+	if !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("Decrypt with modified kdf params: %v, want %v", err, ErrWrongPassword)
+	}
+
+	// parameters beyond the bounds are rejected before the key derivation
+	for _, params := range []KdfParams{
+		{Time: 1 << 30, Memory: defaultArgon2Memory, Threads: defaultArgon2Threads},
+		{Time: defaultArgon2Time, Memory: 1 << 31, Threads: defaultArgon2Threads},
+		{Time: defaultArgon2Time, Memory: defaultArgon2Memory, Threads: 255},
+		{Time: 0, Memory: defaultArgon2Memory, Threads: defaultArgon2Threads},
+		{Time: defaultArgon2Time, Memory: defaultArgon2Memory, Threads: 0},
+	} {
+		params.Salt = k.KdfParams.Salt
+		k.KdfParams = params
+		_, err = k.Decrypt([]byte("password"))
+		if !errors.Is(err, ErrInvalidKdf) {
+			t.Fatalf("Decrypt with kdf params %+v: %v, want %v", params, err, ErrInvalidKdf)
+		}
+	}
+
+	_, err = Encrypt(&Secret{Mnemonic: "not a mnemonic"}, []byte("password"))
+	if err == nil {
+		t.Fatal("Encrypt should reject invalid mnemonics")
+	}
+}
@@ -0,0 +1,84 @@
+package keystore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// stdin is shared by the prompts, so input buffered by one prompt is not lost for the next
+var stdin = bufio.NewReader(os.Stdin)
+
+// PromptPassword reads a password from the terminal without echo, if stdin is no terminal a line is read instead
+func PromptPassword(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		return term.ReadPassword(fd)
+	}
+	return readLine(stdin)
+}
+
+// PromptNewPassword prompts for a new password twice and checks that both match
+func PromptNewPassword() ([]byte, error) {
+	password, err := PromptPassword("new keystore password: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(password) == 0 {
+		return nil, ErrEmptyPassword
+	}
+	confirmation, err := PromptPassword("confirm keystore password: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(password, confirmation) {
+		return nil, errors.New("passwords do not match")
+	}
+	return password, nil
+}
+
+// PromptLine prompts for a line of input, e.g. a mnemonic to restore
+func PromptLine(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := readLine(stdin)
+	if err != nil {
+		return "", err
+	}
+	return string(line), nil
+}
+
+// ReadPasswordFd reads the password from the first line of an open file descriptor, e.g. a pipe set up by a supervisor
+func ReadPasswordFd(fd uintptr) ([]byte, error) {
+	f := os.NewFile(fd, "password-fd")
+	if f == nil {
+		return nil, fmt.Errorf("invalid password file descriptor %v", fd)
+	}
+	defer f.Close()
+	return readLine(bufio.NewReader(f))
+}
+
+// ReadPasswordFile reads the password from the first line of a file
+func ReadPasswordFile(path string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return readLine(bufio.NewReader(bytes.NewReader(buf)))
+}
+
+// readLine reads the first line without the line ending
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
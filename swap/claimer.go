@@ -0,0 +1,149 @@
+package swap
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sputn1ck/liquid-go-lightwallet/chain"
+)
+
+// MAX_CLAIM_BATCH limits the swaps claimed by one transaction, a full batch is claimed without waiting for the window
+const MAX_CLAIM_BATCH = 50
+
+// pendingClaim is a claim waiting for the broadcast of its batch
+type pendingClaim struct {
+	swapId string
+	params chain.ClaimParams
+
+	// done is closed once the batch is broadcast or failed
+	done  chan struct{}
+	txId  string
+	txHex string
+	err   error
+}
+
+// claimScheduler collects the claims of concurrent swaps and claims them with one transaction once the
+// window after the first pending claim elapsed, so the swaps share the fee of one claim
+type claimScheduler struct {
+	wallet  Wallet
+	chain   Blockchain
+	window  time.Duration
+	feeRate float64
+
+	mu      sync.Mutex
+	pending []*pendingClaim
+	timer   *time.Timer
+}
+
+func newClaimScheduler(wallet Wallet, chain Blockchain, window time.Duration, feeRate float64) *claimScheduler {
+	return &claimScheduler{wallet: wallet, chain: chain, window: window, feeRate: feeRate}
+}
+
+// claim adds the claim to the pending batch and returns the broadcast batch transaction. A claim that is
+// cancelled before its batch is claimed is removed, afterwards the preimage is revealed and the result awaited
+func (s *claimScheduler) claim(ctx context.Context, swapId string, params chain.ClaimParams) (string, string, error) {
+	claim := &pendingClaim{swapId: swapId, params: params, done: make(chan struct{})}
+
+	s.mu.Lock()
+	s.pending = append(s.pending, claim)
+	if len(s.pending) >= MAX_CLAIM_BATCH {
+		batch := s.takePending()
+		s.mu.Unlock()
+		go s.flush(batch)
+	} else {
+		if s.timer == nil {
+			s.timer = time.AfterFunc(s.window, s.flushPending)
+		}
+		s.mu.Unlock()
+	}
+	log.Printf("[%s] claim scheduled", swapId)
+
+	select {
+	case <-claim.done:
+	case <-ctx.Done():
+		if s.remove(claim) {
+			return "", "", ctx.Err()
+		}
+		<-claim.done
+	}
+	return claim.txId, claim.txHex, claim.err
+}
+
+// takePending returns the pending claims and stops the window
+func (s *claimScheduler) takePending() []*pendingClaim {
+	batch := s.pending
+	s.pending = nil
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	return batch
+}
+
+// remove removes a claim that was not claimed yet
+func (s *claimScheduler) remove(claim *pendingClaim) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.pending {
+		if v == claim {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			if len(s.pending) == 0 && s.timer != nil {
+				s.timer.Stop()
+				s.timer = nil
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (s *claimScheduler) flushPending() {
+	s.mu.Lock()
+	batch := s.takePending()
+	s.mu.Unlock()
+	if len(batch) > 0 {
+		s.flush(batch)
+	}
+}
+
+// flush claims the batch, if the batch fails the claims are claimed one by one so a single invalid
+// claim does not fail the others
+func (s *claimScheduler) flush(batch []*pendingClaim) {
+	txId, txHex, err := s.claimBatch(batch)
+	if err != nil && len(batch) > 1 {
+		log.Printf("batch claim of %v swaps failed, claiming them one by one: %v", len(batch), err)
+		for _, claim := range batch {
+			claim.txId, claim.txHex, claim.err = s.claimBatch([]*pendingClaim{claim})
+			close(claim.done)
+		}
+		return
+	}
+	for _, claim := range batch {
+		claim.txId, claim.txHex, claim.err = txId, txHex, err
+		close(claim.done)
+	}
+}
+
+// claimBatch broadcasts one transaction claiming the batch to a new wallet address
+func (s *claimScheduler) claimBatch(batch []*pendingClaim) (string, string, error) {
+	address, err := s.wallet.GetAddress()
+	if err != nil {
+		return "", "", err
+	}
+	claims := make([]chain.ClaimParams, len(batch))
+	for i, v := range batch {
+		claims[i] = v.params
+	}
+	txHex, err := s.chain.CreateBatchClaimTransaction(chain.NewBatchClaimParams(address, s.feeRate, claims))
+	if err != nil {
+		return "", "", err
+	}
+	txId, err := s.wallet.SendRawTransaction(txHex)
+	if err != nil {
+		return "", "", err
+	}
+	log.Printf("claimed %v swaps with tx %s", len(batch), txId)
+	return txId, txHex, nil
+}
@@ -0,0 +1,286 @@
+package swap
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
+	"github.com/sputn1ck/liquid-go-lightwallet/chain"
+	"github.com/vulpemventures/go-elements/network"
+	"github.com/vulpemventures/go-elements/payment"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+const testAssetId = "f3d1ec678811398cd2ae277cbe3849c6f6dbd72c74bc542f7c4b11ff0e820958"
+
+// fakeBlockchain creates the claims with LiquidOnchain and records the number of swaps of every claim transaction
+type fakeBlockchain struct {
+	*chain.LiquidOnchain
+
+	mu      sync.Mutex
+	batches []int
+}
+
+func (f *fakeBlockchain) CreateBatchClaimTransaction(params chain.BatchClaimParams) (string, error) {
+	txHex, err := f.LiquidOnchain.CreateBatchClaimTransaction(params)
+	if err != nil {
+		return "", err
+	}
+	tx, err := transaction.NewTxFromHex(txHex)
+	if err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// every swap spends the fee and the asset output of its opening
+	f.batches = append(f.batches, len(tx.Inputs)/2)
+	return txHex, nil
+}
+
+func (f *fakeBlockchain) getBatches() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int{}, f.batches...)
+}
+
+// fakeWallet broadcasts transactions unless they spend a rejected opening
+type fakeWallet struct {
+	address string
+
+	mu       sync.Mutex
+	rejected map[string]bool
+	sent     []string
+}
+
+func (f *fakeWallet) GetAddress() (string, error) {
+	return f.address, nil
+}
+
+func (f *fakeWallet) SendRawTransaction(txHex string) (string, error) {
+	tx, err := transaction.NewTxFromHex(txHex)
+	if err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, in := range tx.Inputs {
+		if f.rejected[string(in.Hash)] {
+			return "", errors.New("bad-txns-inputs-missingorspent")
+		}
+	}
+	txId := tx.TxHash().String()
+	f.sent = append(f.sent, txId)
+	return txId, nil
+}
+
+func newTestScheduler(t *testing.T, window time.Duration) (*claimScheduler, *fakeWallet, *fakeBlockchain) {
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := payment.FromPublicKey(key.PubKey(), &network.Regtest, nil).WitnessPubKeyHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallet := &fakeWallet{address: address, rejected: make(map[string]bool)}
+	blockchain := &fakeBlockchain{LiquidOnchain: chain.NewLiquidOnchain(&network.Regtest)}
+	return newClaimScheduler(wallet, blockchain, window, 0.1), wallet, blockchain
+}
+
+// newTestClaim returns the claim of a new swap opening and the hash of the opening
+func newTestClaim(t *testing.T, address string) (chain.ClaimParams, []byte) {
+	maker, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	taker, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	preimage := make([]byte, 32)
+	copy(preimage, maker.Serialize())
+	pHash := sha256.Sum256(preimage)
+	amount := asset.NewAssetAmount(testAssetId, 8, 100000)
+
+	params := chain.NewSwapOpeningParams(maker.PubKey().SerializeCompressed(), taker.PubKey().SerializeCompressed(), 60, pHash[:], []asset.AssetAmount{
+		asset.NewAssetAmount(network.Regtest.AssetID, 8, 500),
+		amount,
+	})
+	openingTxHex, err := chain.NewLiquidOnchain(&network.Regtest).CreateUnfundedOpeningTransaction(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openingTx, err := transaction.NewTxFromHex(openingTxHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openingHash := openingTx.TxHash()
+	claim := chain.NewClaimParams(openingTxHex, address, amount, 60, maker.PubKey().SerializeCompressed(),
+		taker.PubKey().SerializeCompressed(), preimage, pHash[:], taker)
+	return claim, openingHash[:]
+}
+
+type claimResult struct {
+	txId string
+	err  error
+}
+
+// claimAll claims the swaps concurrently and returns the results in the order of the claims
+func claimAll(s *claimScheduler, claims []chain.ClaimParams) []claimResult {
+	results := make([]claimResult, len(claims))
+	var wg sync.WaitGroup
+	for i, v := range claims {
+		wg.Add(1)
+		go func(i int, claim chain.ClaimParams) {
+			defer wg.Done()
+			txId, _, err := s.claim(context.Background(), fmt.Sprintf("swap%v", i), claim)
+			results[i] = claimResult{txId, err}
+		}(i, v)
+	}
+	wg.Wait()
+	return results
+}
+
+// waitPending waits until the scheduler holds n pending claims
+func waitPending(t *testing.T, s *claimScheduler, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		pending := len(s.pending)
+		s.mu.Unlock()
+		if pending == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v pending claims, want %v", pending, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClaimSchedulerWindow(t *testing.T) {
+	s, wallet, blockchain := newTestScheduler(t, 200*time.Millisecond)
+	var claims []chain.ClaimParams
+	for i := 0; i < 3; i++ {
+		claim, _ := newTestClaim(t, wallet.address)
+		claims = append(claims, claim)
+	}
+
+	results := claimAll(s, claims)
+	for _, v := range results {
+		if v.err != nil || v.txId != results[0].txId {
+			t.Fatalf("expected one claim transaction, got %+v", results)
+		}
+	}
+	if batches := blockchain.getBatches(); !reflect.DeepEqual(batches, []int{3}) {
+		t.Fatalf("got batches %v, want [3]", batches)
+	}
+	if s.timer != nil || len(s.pending) != 0 {
+		t.Fatal("scheduler was not reset after the flush")
+	}
+}
+
+func TestClaimSchedulerFullBatch(t *testing.T) {
+	// the window never elapses, only a full batch is claimed
+	s, wallet, blockchain := newTestScheduler(t, time.Hour)
+	var claims []chain.ClaimParams
+	for i := 0; i < MAX_CLAIM_BATCH; i++ {
+		claim, _ := newTestClaim(t, wallet.address)
+		claims = append(claims, claim)
+	}
+
+	results := claimAll(s, claims)
+	for _, v := range results {
+		if v.err != nil || v.txId != results[0].txId {
+			t.Fatalf("expected one claim transaction, got %+v", v)
+		}
+	}
+	if batches := blockchain.getBatches(); !reflect.DeepEqual(batches, []int{MAX_CLAIM_BATCH}) {
+		t.Fatalf("got batches %v, want [%v]", batches, MAX_CLAIM_BATCH)
+	}
+	if s.timer != nil {
+		t.Fatal("window was not stopped by the full batch")
+	}
+}
+
+func TestClaimSchedulerCancel(t *testing.T) {
+	s, wallet, blockchain := newTestScheduler(t, time.Second)
+	cancelled, _ := newTestClaim(t, wallet.address)
+	claimed, _ := newTestClaim(t, wallet.address)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelledErr := make(chan error, 1)
+	go func() {
+		_, _, err := s.claim(ctx, "cancelled", cancelled)
+		cancelledErr <- err
+	}()
+	claimedRes := make(chan claimResult, 1)
+	go func() {
+		txId, _, err := s.claim(context.Background(), "claimed", claimed)
+		claimedRes <- claimResult{txId, err}
+	}()
+	waitPending(t, s, 2)
+
+	cancel()
+	if err := <-cancelledErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancelled claim to return context.Canceled, got %v", err)
+	}
+	if res := <-claimedRes; res.err != nil || res.txId == "" {
+		t.Fatalf("unexpected result of the remaining claim %+v", res)
+	}
+	if batches := blockchain.getBatches(); !reflect.DeepEqual(batches, []int{1}) {
+		t.Fatalf("got batches %v, want the remaining claim only", batches)
+	}
+
+	// the window of the last pending claim is stopped when it is cancelled
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		_, _, err := s.claim(ctx, "cancelled", cancelled)
+		cancelledErr <- err
+	}()
+	waitPending(t, s, 1)
+	cancel()
+	if err := <-cancelledErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	s.mu.Lock()
+	timer := s.timer
+	s.mu.Unlock()
+	if timer != nil {
+		t.Fatal("window of the empty batch was not stopped")
+	}
+}
+
+func TestClaimSchedulerFallback(t *testing.T) {
+	s, wallet, blockchain := newTestScheduler(t, 200*time.Millisecond)
+	var claims []chain.ClaimParams
+	for i := 0; i < 3; i++ {
+		claim, openingHash := newTestClaim(t, wallet.address)
+		claims = append(claims, claim)
+		if i == 1 {
+			// the opening of the second swap was double spent, so every transaction claiming it is rejected
+			wallet.rejected[string(openingHash)] = true
+		}
+	}
+
+	results := claimAll(s, claims)
+	if results[1].err == nil {
+		t.Fatal("expected the claim of the rejected opening to fail")
+	}
+	if results[0].err != nil || results[2].err != nil || results[0].txId == "" || results[0].txId == results[2].txId {
+		t.Fatalf("expected the other swaps to be claimed one by one, got %+v", results)
+	}
+	if batches := blockchain.getBatches(); !reflect.DeepEqual(batches, []int{3, 1, 1, 1}) {
+		t.Fatalf("got batches %v, want [3 1 1 1]", batches)
+	}
+	if len(wallet.sent) != 2 {
+		t.Fatalf("got %v broadcast transactions, want 2", len(wallet.sent))
+	}
+}
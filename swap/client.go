@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"github.com/btcsuite/btcd/btcec"
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
 	"github.com/sputn1ck/liquid-go-lightwallet/chain"
 	"github.com/sputn1ck/liquid-go-lightwallet/lightning"
 	"github.com/sputn1ck/liquid-go-lightwallet/swaprpc"
 	"log"
+	"time"
 )
 
 
@@ -18,23 +20,60 @@ type Wallet interface {
 
 type Blockchain interface {
 	CreatePreimageSpendingTransaction(params chain.ClaimParams) (string, error)
+	CreateBatchClaimTransaction(params chain.BatchClaimParams) (string, error)
 }
 
 type BetterChivoClient struct {
 	rpc swaprpc.SwapServiceClient
 	wallet Wallet
 	chain Blockchain
+
+	// notifier watches the opening and the claim for conflicts, the claim waits for claimConfs confirmations of the opening
+	notifier chain.Notifier
+	claimConfs uint32
+
+	// claimer batches the claims of concurrent swaps, without it every swap is claimed by its own transaction
+	claimer *claimScheduler
 }
 
 func NewBetterChivoClient(rpc swaprpc.SwapServiceClient, wallet Wallet, chain Blockchain) *BetterChivoClient {
 	return &BetterChivoClient{rpc: rpc, wallet: wallet, chain: chain}
 }
 
+// SetNotifier makes the client claim only once the opening has numConfs confirmations and watch the opening
+// and the claim for replacements and double spends. A confirmed conflict of the opening aborts the swap before
+// the preimage is revealed, a conflicting claim fails the swap
+func (client *BetterChivoClient) SetNotifier(notifier chain.Notifier, numConfs uint32) {
+	client.notifier = notifier
+	client.claimConfs = numConfs
+}
+
+// SetClaimBatching claims the swaps that are ready within the window after the first one with one transaction
+// paying feeRate sat/vbyte, the rest of the fee outputs of the openings is paid to the wallet. Batching only
+// pays off for a long-running client that receives concurrent swaps, a single swap just waits for the window
+func (client *BetterChivoClient) SetClaimBatching(window time.Duration, feeRate float64) {
+	client.claimer = newClaimScheduler(client.wallet, client.chain, window, feeRate)
+}
+
 
-func (client *BetterChivoClient) ReceiveUsdt(amount uint64, asset []byte) error{
+// GetSupportedAssets returns the assets the server is able to swap
+func (client *BetterChivoClient) GetSupportedAssets() ([]*swaprpc.AssetInfo, error) {
+	res, err := client.rpc.GetRates(context.Background(), &swaprpc.GetRatesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return res.AssetInfos, nil
+}
+
+func (client *BetterChivoClient) ReceiveUsdt(amount asset.AssetAmount) error{
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	txAsset, err := amount.TxAsset()
+	if err != nil {
+		return err
+	}
+
 	// create claim key
 	privkey, err := btcec.NewPrivateKey(btcec.S256())
 	if err != nil {
@@ -60,11 +99,11 @@ func (client *BetterChivoClient) ReceiveUsdt(amount uint64, asset []byte) error{
 
 	// send request
 	msg := &swaprpc.ReceivePaymentRequest{
-		Message: &swaprpc.ReceivePaymentRequest_StartReceive{&swaprpc.StartReceiveMessage{
+		Message: &swaprpc.ReceivePaymentRequest_StartReceive{StartReceive: &swaprpc.StartReceiveMessage{
 			PaymentHash: phash[:],
 			TakerPubkey:  pubkey,
-			Amount:      amount,
-			Asset:       asset,
+			Amount:      amount.Amount,
+			Asset:       txAsset,
 		}},
 	}
 	err = stream.Send(msg)
@@ -97,29 +136,44 @@ func (client *BetterChivoClient) ReceiveUsdt(amount uint64, asset []byte) error{
 		return errors.New("expected wait for payment message")
 	}
 
+	if client.notifier != nil {
+		tracker, err := newOpeningTracker(client.notifier, waitForPayment.SwapId, txopened.TxId, txopened.TxHex, client.claimConfs)
+		if err != nil {
+			return err
+		}
+		defer tracker.stop()
+		log.Printf("waiting for %v confirmations of opening tx %s", client.claimConfs, txopened.TxId)
+		// a confirmed conflict of the opening aborts the swap, the preimage is not revealed by a claim
+		err = tracker.whileState(ctx, StateConfirmed, func() error {
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	// we now claim the tx
 
-	// get address
-	address,err := client.wallet.GetAddress()
-	if err != nil {
-		return err
-	}
 	log.Printf("maker pubkey: %x, takerpubkey: %x paymenthash %x", txopened.MakerPubkey, pubkey, phash[:])
-
-	swapParams := chain.NewClaimParams(txopened.TxHex,address, amount, txopened.Csv,txopened.MakerPubkey, pubkey,preimage[:], phash[:],asset, privkey)
-	claimTxHex, err := client.chain.CreatePreimageSpendingTransaction(swapParams)
-	if err != nil {
-		return err
-	}
-	txId, err := client.wallet.SendRawTransaction(claimTxHex)
+	txId, claimTxHex, err := client.claim(ctx, waitForPayment.SwapId, txopened, amount, pubkey, preimage[:], phash[:], privkey)
 	if err != nil {
 		return err
 	}
 
 	log.Printf("claimed swap: %s", txId)
+	labelSwapTx(client.wallet, txId, waitForPayment.SwapId)
+
+	var claimConflicts *chain.ConflictEvent
+	if client.notifier != nil {
+		claimConflicts, err = client.notifier.RegisterConflictNtfn(claimTxHex)
+		if err != nil {
+			return err
+		}
+		defer claimConflicts.Cancel()
+	}
 
 	msg = &swaprpc.ReceivePaymentRequest{
-		Message: &swaprpc.ReceivePaymentRequest_PreimageMessage{&swaprpc.PreimageMessage{
+		Message: &swaprpc.ReceivePaymentRequest_PreimageMessage{PreimageMessage: &swaprpc.PreimageMessage{
 			Preimage: preimage[:],
 		}},
 	}
@@ -129,8 +183,62 @@ func (client *BetterChivoClient) ReceiveUsdt(amount uint64, asset []byte) error{
 		return err
 	}
 
-	log.Printf("swap completed, received %v of %x", amount, asset)
+	if claimConflicts != nil {
+		err = client.waitForClaim(ctx, txId, claimConflicts)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("swap completed, received %s of %s", amount, amount.AssetId)
 	return nil
 }
 
+// claim claims the opening with the claim scheduler if claims are batched, otherwise with its own transaction
+func (client *BetterChivoClient) claim(ctx context.Context, swapId string, txopened *swaprpc.TxOpenedMessage, amount asset.AssetAmount, pubkey []byte, preimage []byte, phash []byte, privkey *btcec.PrivateKey) (string, string, error) {
+	if client.claimer != nil {
+		// the batch pays to its own address
+		swapParams := chain.NewClaimParams(txopened.TxHex, "", amount, txopened.Csv, txopened.MakerPubkey, pubkey, preimage, phash, privkey)
+		return client.claimer.claim(ctx, swapId, swapParams)
+	}
+
+	address, err := client.wallet.GetAddress()
+	if err != nil {
+		return "", "", err
+	}
+	swapParams := chain.NewClaimParams(txopened.TxHex, address, amount, txopened.Csv, txopened.MakerPubkey, pubkey, preimage, phash, privkey)
+	claimTxHex, err := client.chain.CreatePreimageSpendingTransaction(swapParams)
+	if err != nil {
+		return "", "", err
+	}
+	txId, err := client.wallet.SendRawTransaction(claimTxHex)
+	if err != nil {
+		return "", "", err
+	}
+	return txId, claimTxHex, nil
+}
+
+// waitForClaim waits for the confirmations of the claim, a conflicting spend of the opening output fails the swap
+func (client *BetterChivoClient) waitForClaim(ctx context.Context, claimTxId string, conflicts *chain.ConflictEvent) error {
+	confirmed, err := client.notifier.RegisterConfirmationsNtfn(claimTxId, client.claimConfs)
+	if err != nil {
+		return err
+	}
+	defer confirmed.Cancel()
+	log.Printf("waiting for %v confirmations of claim tx %s", client.claimConfs, claimTxId)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-confirmed.Confirmed:
+			return nil
+		case <-confirmed.NegativeConf:
+			log.Printf("claim tx %s reorged, waiting for it to confirm again", claimTxId)
+		case conflict := <-conflicts.Conflicts:
+			log.Printf("claim tx %s was replaced or double spent by tx %s", claimTxId, conflict.ConflictingTxId)
+			return &ConflictError{Conflict: conflict}
+		}
+	}
+}
+
 
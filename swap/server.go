@@ -5,10 +5,14 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/btcsuite/btcd/btcec"
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
 	"github.com/sputn1ck/liquid-go-lightwallet/chain"
 	"github.com/sputn1ck/liquid-go-lightwallet/swaprpc"
+	"github.com/sputn1ck/liquid-go-lightwallet/wallet"
 	"log"
+	"math"
 )
 
 const (
@@ -22,15 +26,27 @@ type LightningWallet interface {
 }
 
 type CurrencyConverter interface {
-	GetSatAmt(asset string, amount uint64) (uint64, error)
+	GetSatAmt(amount asset.AssetAmount) (uint64, error)
 }
 
 type SwapWallet interface {
 	//AddWaitForPreimageReveal(txId string) (chan []byte)
-	SendToAddress(address string,  amount uint64, asset string) (string, error)
+	SendToAddress(address string, amount asset.AssetAmount) (string, error)
 	SendRawTransaction(txHex string) (string, error)
 	FundAndSignRawTransaction(unfundedRawTx string) (string, error)
-	GetBalance(asset string) (float64, error)
+	GetBalance(assetEntry *asset.AssetEntry) (asset.AssetAmount, error)
+}
+
+// labelSwapTx links the transaction to the swap in the wallet history, if the wallet keeps labels
+func labelSwapTx(w interface{}, txId string, swapId string) {
+	labeler, ok := w.(wallet.SwapTxLabeler)
+	if !ok {
+		return
+	}
+	err := labeler.LabelSwapTx(txId, swapId)
+	if err != nil {
+		log.Printf("[%s] unable to label tx %s: %v", swapId, txId, err)
+	}
 }
 
 type OpeningTxCreator interface {
@@ -45,15 +61,116 @@ type BetterChivoServer struct {
 	blockchain OpeningTxCreator
 	cc CurrencyConverter
 
+	registry *asset.AssetRegistry
+	supportedAssets []*asset.AssetEntry
+
+	// notifier tracks the openings, the invoice is settled once the opening has settleConfs confirmations.
+	// settlements keeps the received preimages until then
+	notifier chain.Notifier
+	settleConfs uint32
+	settlements *PendingSettlements
+
 	swaprpc.UnimplementedSwapServiceServer
 }
 
-func NewBetterChivoServer(wallet SwapWallet, node LightningWallet, blockchain OpeningTxCreator, cc CurrencyConverter) *BetterChivoServer {
-	return &BetterChivoServer{wallet: wallet, node: node, blockchain: blockchain, cc: cc}
+// NewBetterChivoServer returns a new server that swaps the supported assets, which are resolved by id or ticker from the registry
+func NewBetterChivoServer(wallet SwapWallet, node LightningWallet, blockchain OpeningTxCreator, cc CurrencyConverter, registry *asset.AssetRegistry, supportedAssets []string) (*BetterChivoServer, error) {
+	var supported []*asset.AssetEntry
+	for _, v := range supportedAssets {
+		entry, err := registry.Lookup(v)
+		if err != nil {
+			return nil, fmt.Errorf("supported asset %s: %w", v, err)
+		}
+		supported = append(supported, entry)
+	}
+	return &BetterChivoServer{wallet: wallet, node: node, blockchain: blockchain, cc: cc, registry: registry, supportedAssets: supported}, nil
+}
+
+// SetNotifier makes the server settle the hold invoice of a swap only while its opening transaction has
+// numConfs confirmations in the best chain, a reorg of the opening delays the settlement until it re-confirms.
+// The received preimages are kept in settlements until their invoices are settled, see ResumeSettlements
+func (b *BetterChivoServer) SetNotifier(notifier chain.Notifier, numConfs uint32, settlements *PendingSettlements) {
+	b.notifier = notifier
+	b.settleConfs = numConfs
+	b.settlements = settlements
+}
+
+// ResumeSettlements waits for the openings of the pending settlements in the background and settles their invoices,
+// e.g. after a restart. A settlement whose opening can not be tracked stays pending
+func (b *BetterChivoServer) ResumeSettlements() {
+	for _, v := range b.settlements.List() {
+		tracker, err := newOpeningTracker(b.notifier, v.SwapId, v.TxId, v.TxHex, b.settleConfs)
+		if err != nil {
+			log.Printf("[%s] unable to track opening tx %s, the settlement stays pending: %v", v.SwapId, v.TxId, err)
+			continue
+		}
+		go func(settlement *PendingSettlement) {
+			defer tracker.stop()
+			err := b.settleWhenConfirmed(tracker, settlement)
+			if err != nil {
+				log.Printf("[%s] unable to settle: %v", settlement.SwapId, err)
+			}
+		}(v)
+	}
+}
+
+// settleWhenConfirmed settles the invoice once the opening is confirmed and drops the pending settlement. The
+// preimage is already revealed, so the wait does not time out. It only gives up once a conflicting transaction
+// confirmed, the opening output is gone then
+func (b *BetterChivoServer) settleWhenConfirmed(tracker *openingTracker, settlement *PendingSettlement) error {
+	log.Printf("[%s] Waiting for %v confirmations of the opening tx before settling", settlement.SwapId, b.settleConfs)
+	err := tracker.whileState(context.Background(), StateConfirmed, func() error {
+		return b.node.SettleInvoice(settlement.Preimage)
+	})
+	var conflictErr *ConflictError
+	if errors.As(err, &conflictErr) {
+		removeErr := b.settlements.Remove(settlement.SwapId)
+		if removeErr != nil {
+			log.Printf("[%s] unable to remove the conflicting swap: %v", settlement.SwapId, removeErr)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	err = b.settlements.Remove(settlement.SwapId)
+	if err != nil {
+		log.Printf("[%s] unable to remove the settled swap: %v", settlement.SwapId, err)
+	}
+	return nil
 }
 
+// GetRates returns the registered assets supported by the server, the exchange rate is given in sats per whole unit of the asset
 func (b *BetterChivoServer) GetRates(ctx context.Context, request *swaprpc.GetRatesRequest) (*swaprpc.GetRatesResponse, error) {
-	panic("implement me")
+	var assetInfos []*swaprpc.AssetInfo
+	for _, v := range b.supportedAssets {
+		satAmt, err := b.cc.GetSatAmt(v.NewAmount(uint64(math.Pow10(int(v.Precision)))))
+		if err != nil {
+			return nil, err
+		}
+		assetInfos = append(assetInfos, &swaprpc.AssetInfo{
+			Name:         v.Name,
+			ExchangeRate: float32(satAmt),
+			AssetId:      v.AssetId,
+			Ticker:       v.Ticker,
+			Precision:    uint32(v.Precision),
+		})
+	}
+	return &swaprpc.GetRatesResponse{AssetInfos: assetInfos}, nil
+}
+
+// getSupportedAsset returns the registry entry of a transaction output asset if the server supports it
+func (b *BetterChivoServer) getSupportedAsset(txAsset []byte) (*asset.AssetEntry, error) {
+	assetId, err := asset.IdFromTxAsset(txAsset)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range b.supportedAssets {
+		if v.AssetId == assetId {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("asset %s is not supported", assetId)
 }
 
 func (b *BetterChivoServer) SendPayment(server swaprpc.SwapService_SendPaymentServer) error {
@@ -71,10 +188,15 @@ func (b *BetterChivoServer) ReceivePayment(server swaprpc.SwapService_ReceivePay
 	if startReceiveRequest == nil {
 		return errors.New("expected StartReceive message")
 	}
-	log.Printf("[%s] New receive request: Amount: %v Asset: %s" , swapId, startReceiveRequest.Amount, startReceiveRequest.Asset)
+	assetEntry, err := b.getSupportedAsset(startReceiveRequest.Asset)
+	if err != nil {
+		return err
+	}
+	amount := assetEntry.NewAmount(startReceiveRequest.Amount)
+	log.Printf("[%s] New receive request: Amount: %s Asset: %s" , swapId, amount, assetEntry)
 
 	// todo check balance
-	_, err = b.wallet.GetBalance(hex.EncodeToString(startReceiveRequest.Asset))
+	_, err = b.wallet.GetBalance(assetEntry)
 	if err != nil {
 		return err
 	}
@@ -87,7 +209,7 @@ func (b *BetterChivoServer) ReceivePayment(server swaprpc.SwapService_ReceivePay
 	pubkey := privkey.PubKey().SerializeCompressed()
 
 	// get satamt
-	satAmt, err := b.cc.GetSatAmt(hex.EncodeToString(startReceiveRequest.Asset), startReceiveRequest.Amount)
+	satAmt, err := b.cc.GetSatAmt(amount)
 	if err != nil {
 		return err
 	}
@@ -136,7 +258,11 @@ waitLoop:
 	log.Printf("[%s] Payment accepted", swapId)
 	// if payment has been accepted, we open the swap
 	log.Printf("maker pubkey: %x, takerpubkey: %x, paymenthash %x", pubkey, startReceiveRequest.TakerPubkey, startReceiveRequest.PaymentHash)
-	openingParams := chain.NewSwapOpeningParams(pubkey, startReceiveRequest.TakerPubkey, SWAP_CSV, startReceiveRequest.PaymentHash,[]chain.AssetAmountTuple{{b.blockchain.GetAsset(),500},{startReceiveRequest.Asset, startReceiveRequest.Amount}})
+	feeAmount, err := b.getFeeAmount(500)
+	if err != nil {
+		return err
+	}
+	openingParams := chain.NewSwapOpeningParams(pubkey, startReceiveRequest.TakerPubkey, SWAP_CSV, startReceiveRequest.PaymentHash,[]asset.AssetAmount{feeAmount, amount})
 	unfinishedTxHex, err := b.blockchain.CreateUnfundedOpeningTransaction(openingParams)
 	if err != nil {
 		return err
@@ -150,6 +276,16 @@ waitLoop:
 	if err != nil {
 		return err
 	}
+	labelSwapTx(b.wallet, txId, swapId)
+
+	var tracker *openingTracker
+	if b.notifier != nil {
+		tracker, err = newOpeningTracker(b.notifier, swapId, txId, finishedTxHex, b.settleConfs)
+		if err != nil {
+			return err
+		}
+		defer tracker.stop()
+	}
 
 	msg = &swaprpc.ReceivePaymentResponse {
 		Message: &swaprpc.ReceivePaymentResponse_TxOpened{
@@ -182,7 +318,17 @@ waitLoop:
 	}
 
 	log.Printf("[%s] Received invoice: %x",swapId, preimageMessage.Preimage)
-	err = b.node.SettleInvoice(preimageMessage.Preimage)
+	if tracker != nil {
+		// the preimage is persisted before the wait, the client may leave once it is sent
+		settlement := &PendingSettlement{SwapId: swapId, TxId: txId, TxHex: finishedTxHex, Preimage: preimageMessage.Preimage}
+		err = b.settlements.Add(settlement)
+		if err != nil {
+			log.Printf("[%s] unable to persist the preimage: %v", swapId, err)
+		}
+		err = b.settleWhenConfirmed(tracker, settlement)
+	} else {
+		err = b.node.SettleInvoice(preimageMessage.Preimage)
+	}
 	if err != nil {
 		return err
 	}
@@ -193,6 +339,19 @@ waitLoop:
 }
 
 
+// getFeeAmount returns an amount of the policy asset
+func (b *BetterChivoServer) getFeeAmount(sats uint64) (asset.AssetAmount, error) {
+	lbtcId, err := asset.IdFromTxAsset(b.blockchain.GetAsset())
+	if err != nil {
+		return asset.AssetAmount{}, err
+	}
+	lbtc, err := b.registry.Get(lbtcId)
+	if err != nil {
+		return asset.AssetAmount{}, err
+	}
+	return lbtc.NewAmount(sats), nil
+}
+
 // newSwapId returns a random 32 byte hex string
 func newSwapId() string {
 	idBytes := make([]byte, 16)
@@ -0,0 +1,109 @@
+package swap
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// PendingSettlement is a swap whose preimage was received, the hold invoice is settled once the opening confirms
+type PendingSettlement struct {
+	SwapId string `json:"swap_id"`
+	TxId   string `json:"tx_id"`
+	// TxHex is the opening transaction, its inputs are watched for conflicts
+	TxHex    string `json:"tx_hex"`
+	Preimage []byte `json:"preimage"`
+}
+
+// PendingSettlements keeps the received preimages until their invoices are settled, so a restart does not lose them
+type PendingSettlements struct {
+	// path is the json file the settlements are persisted in, they are only kept in memory if it is empty
+	path string
+
+	mu          sync.Mutex
+	settlements map[string]*PendingSettlement
+}
+
+// NewPendingSettlements returns settlements that are kept for the lifetime of the process
+func NewPendingSettlements() *PendingSettlements {
+	return &PendingSettlements{settlements: make(map[string]*PendingSettlement)}
+}
+
+// LoadPendingSettlements loads the settlements from a json file, which is created on the first settlement
+func LoadPendingSettlements(path string) (*PendingSettlements, error) {
+	p := &PendingSettlements{path: path, settlements: make(map[string]*PendingSettlement)}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &p.settlements)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Add persists the settlement
+func (p *PendingSettlements) Add(settlement *PendingSettlement) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.settlements[settlement.SwapId] = settlement
+	return p.save()
+}
+
+// Remove drops the settlement of the swap once its invoice is settled
+func (p *PendingSettlements) Remove(swapId string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.settlements[swapId]; !ok {
+		return nil
+	}
+	delete(p.settlements, swapId)
+	return p.save()
+}
+
+// List returns the pending settlements ordered by swap id
+func (p *PendingSettlements) List() []*PendingSettlement {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	settlements := make([]*PendingSettlement, 0, len(p.settlements))
+	for _, v := range p.settlements {
+		settlements = append(settlements, v)
+	}
+	sort.Slice(settlements, func(i, j int) bool {
+		return settlements[i].SwapId < settlements[j].SwapId
+	})
+	return settlements
+}
+
+// save writes the settlements to a temporary file that replaces the json file, so a crash keeps the old file
+func (p *PendingSettlements) save() error {
+	if p.path == "" {
+		return nil
+	}
+	buf, err := json.MarshalIndent(p.settlements, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(p.path), filepath.Base(p.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(buf)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), p.path)
+}
@@ -0,0 +1,216 @@
+package swap
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/sputn1ck/liquid-go-lightwallet/chain"
+)
+
+// SwapState is the on-chain state of a swap opening
+type SwapState int
+
+const (
+	// StateOpened is an opening transaction without the required confirmations, e.g. after a reorg removed it
+	StateOpened SwapState = iota
+	// StateConfirmed is an opening with the required confirmations in the best chain
+	StateConfirmed
+)
+
+func (s SwapState) String() string {
+	switch s {
+	case StateOpened:
+		return "opened"
+	case StateConfirmed:
+		return "confirmed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// ConflictError is a swap transaction that was replaced or double spent by another transaction
+type ConflictError struct {
+	Conflict *chain.TxConflict
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("tx %s conflicts with tx %s spending %s", e.Conflict.TxId, e.Conflict.ConflictingTxId, e.Conflict.Outpoint)
+}
+
+// openingTracker follows the confirmations of an opening transaction. The state rolls back if a reorg
+// removes the transaction from the best chain and advances again once it re-confirms. A conflicting spend
+// of an input of the opening aborts the waits once the conflicting transaction has confirmed, a conflict
+// in the mempool may still lose against the opening
+type openingTracker struct {
+	swapId string
+	txId   string
+
+	notifier  chain.Notifier
+	numConfs  uint32
+	confirmed *chain.ConfirmationEvent
+	conflicts *chain.ConflictEvent
+
+	mu    sync.Mutex
+	state SwapState
+	// inChain is true if the opening is in a block of the best chain, a mempool opening may be replaced
+	inChain bool
+	// conflict is a confirmed conflicting transaction
+	conflict *chain.TxConflict
+	// changed is closed and replaced on every state change
+	changed chan struct{}
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newOpeningTracker registers the opening transaction for numConfs confirmations and its inputs for conflicts
+func newOpeningTracker(notifier chain.Notifier, swapId string, txId string, txHex string, numConfs uint32) (*openingTracker, error) {
+	confirmed, err := notifier.RegisterConfirmationsNtfn(txId, numConfs)
+	if err != nil {
+		return nil, err
+	}
+	conflicts, err := notifier.RegisterConflictNtfn(txHex)
+	if err != nil {
+		confirmed.Cancel()
+		return nil, err
+	}
+	t := &openingTracker{
+		swapId:    swapId,
+		txId:      txId,
+		notifier:  notifier,
+		numConfs:  numConfs,
+		confirmed: confirmed,
+		conflicts: conflicts,
+		state:     StateOpened,
+		changed:   make(chan struct{}),
+		quit:      make(chan struct{}),
+	}
+	t.wg.Add(1)
+	go t.run()
+	return t, nil
+}
+
+func (t *openingTracker) run() {
+	defer t.wg.Done()
+	for {
+		select {
+		case <-t.quit:
+			return
+		case conf := <-t.confirmed.Confirmed:
+			t.setState(StateConfirmed, conf.BlockHeight > 0)
+		case <-t.confirmed.NegativeConf:
+			t.setState(StateOpened, false)
+		case conflict := <-t.conflicts.Conflicts:
+			t.watchConflict(conflict)
+		}
+	}
+}
+
+// watchConflict waits for the conflicting transaction to confirm, numConfs confirmations of the
+// conflict settle the race against the opening just like they settle the opening
+func (t *openingTracker) watchConflict(conflict *chain.TxConflict) {
+	if conflict.SpendingHeight > 0 {
+		t.setConflict(conflict)
+		return
+	}
+	log.Printf("[%s] opening tx %s was replaced or double spent by tx %s in the mempool, waiting for one of them to confirm", t.swapId, t.txId, conflict.ConflictingTxId)
+	numConfs := t.numConfs
+	if numConfs == 0 {
+		numConfs = 1
+	}
+	confirmed, err := t.notifier.RegisterConfirmationsNtfn(conflict.ConflictingTxId, numConfs)
+	if err != nil {
+		log.Printf("[%s] unable to watch conflicting tx %s: %v", t.swapId, conflict.ConflictingTxId, err)
+		return
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer confirmed.Cancel()
+		select {
+		case <-t.quit:
+		case conf := <-confirmed.Confirmed:
+			confirmedConflict := *conflict
+			confirmedConflict.SpendingHeight = conf.BlockHeight
+			t.setConflict(&confirmedConflict)
+		}
+	}()
+}
+
+func (t *openingTracker) setState(state SwapState, inChain bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inChain = inChain
+	if inChain && t.conflict != nil {
+		log.Printf("[%s] opening tx %s confirmed, conflicting tx %s is invalid", t.swapId, t.txId, t.conflict.ConflictingTxId)
+		t.conflict = nil
+	}
+	if state == t.state {
+		return
+	}
+	if state < t.state {
+		log.Printf("[%s] opening tx %s reorged, state rolled back from %s to %s", t.swapId, t.txId, t.state, state)
+	} else {
+		log.Printf("[%s] opening tx %s %s", t.swapId, t.txId, state)
+	}
+	t.state = state
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+func (t *openingTracker) setConflict(conflict *chain.TxConflict) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.inChain {
+		// the conflict matters only if a reorg removes the opening from the best chain
+		log.Printf("[%s] ignoring conflicting tx %s of the confirmed opening tx %s", t.swapId, conflict.ConflictingTxId, t.txId)
+		return
+	}
+	log.Printf("[%s] opening tx %s was double spent by tx %s confirmed at height %v", t.swapId, t.txId, conflict.ConflictingTxId, conflict.SpendingHeight)
+	t.conflict = conflict
+	t.state = StateOpened
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// State returns the current state of the opening
+func (t *openingTracker) State() SwapState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+// whileState waits until the opening reached the state and calls fn. The state is not rolled back while
+// fn runs, so fn acts on an opening that is in the best chain as far as the notifier knows. A confirmed
+// conflict of the opening returns a ConflictError
+func (t *openingTracker) whileState(ctx context.Context, state SwapState, fn func() error) error {
+	for {
+		t.mu.Lock()
+		if t.conflict != nil {
+			t.mu.Unlock()
+			return &ConflictError{Conflict: t.conflict}
+		}
+		if t.state >= state {
+			defer t.mu.Unlock()
+			return fn()
+		}
+		changed := t.changed
+		t.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// stop cancels the registrations
+func (t *openingTracker) stop() {
+	close(t.quit)
+	t.wg.Wait()
+	t.confirmed.Cancel()
+	t.conflicts.Cancel()
+}
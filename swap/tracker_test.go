@@ -0,0 +1,267 @@
+package swap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sputn1ck/liquid-go-lightwallet/chain"
+)
+
+// fakeNotifier hands out confirmation registrations whose events the test sends
+type fakeNotifier struct {
+	mu        sync.Mutex
+	confs     map[string]*fakeConfRegistration
+	conflicts chan *chain.TxConflict
+}
+
+type fakeConfRegistration struct {
+	confirmed    chan *chain.TxConfirmation
+	negativeConf chan uint32
+}
+
+func newFakeNotifier() *fakeNotifier {
+	return &fakeNotifier{confs: make(map[string]*fakeConfRegistration), conflicts: make(chan *chain.TxConflict)}
+}
+
+func (f *fakeNotifier) Start() error {
+	return nil
+}
+
+func (f *fakeNotifier) Stop() error {
+	return nil
+}
+
+func (f *fakeNotifier) RegisterConfirmationsNtfn(txId string, numConfs uint32) (*chain.ConfirmationEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	registration := &fakeConfRegistration{confirmed: make(chan *chain.TxConfirmation), negativeConf: make(chan uint32)}
+	f.confs[txId] = registration
+	return &chain.ConfirmationEvent{
+		Confirmed:    registration.confirmed,
+		NegativeConf: registration.negativeConf,
+		Cancel:       func() {},
+	}, nil
+}
+
+func (f *fakeNotifier) RegisterSpendNtfn(outpoint chain.Outpoint) (*chain.SpendEvent, error) {
+	return &chain.SpendEvent{Spend: make(chan *chain.SpendDetail), Cancel: func() {}}, nil
+}
+
+func (f *fakeNotifier) RegisterBlockEpochNtfn() (*chain.BlockEpochEvent, error) {
+	return &chain.BlockEpochEvent{Epochs: make(chan *chain.BlockEpoch), Cancel: func() {}}, nil
+}
+
+func (f *fakeNotifier) RegisterConflictNtfn(txHex string) (*chain.ConflictEvent, error) {
+	return &chain.ConflictEvent{Conflicts: f.conflicts, Cancel: func() {}}, nil
+}
+
+// registration waits for the registration of the transaction, the tracker registers conflicts in the background
+func (f *fakeNotifier) registration(t *testing.T, txId string) *fakeConfRegistration {
+	deadline := time.Now().Add(time.Second)
+	for {
+		f.mu.Lock()
+		registration, ok := f.confs[txId]
+		f.mu.Unlock()
+		if ok {
+			return registration
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tx %s is not registered", txId)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// confirm sends a confirmation of the transaction, it blocks until the tracker reads it
+func (f *fakeNotifier) confirm(t *testing.T, txId string) {
+	f.registration(t, txId).confirmed <- &chain.TxConfirmation{TxId: txId, NumConfs: 1}
+}
+
+// reorg sends a negative confirmation of the transaction, it blocks until the tracker reads it
+func (f *fakeNotifier) reorg(t *testing.T, txId string) {
+	f.registration(t, txId).negativeConf <- 1
+}
+
+// waitForState waits until the tracker reached the state
+func waitForState(t *testing.T, tracker *openingTracker, state SwapState) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for tracker.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("got state %s, want %s", tracker.State(), state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOpeningTrackerReorg(t *testing.T) {
+	notifier := newFakeNotifier()
+	tracker, err := newOpeningTracker(notifier, "swap", "opening", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.stop()
+
+	notifier.confirm(t, "opening")
+	waitForState(t, tracker, StateConfirmed)
+
+	// the reorg rolls the opening back, it has to confirm again before fn is called
+	notifier.reorg(t, "opening")
+	waitForState(t, tracker, StateOpened)
+	called := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- tracker.whileState(context.Background(), StateConfirmed, func() error {
+			close(called)
+			return nil
+		})
+	}()
+	select {
+	case <-called:
+		t.Fatal("fn called before the opening re-confirmed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	notifier.confirm(t, "opening")
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatal("fn not called after the opening re-confirmed")
+	}
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpeningTrackerCanceled(t *testing.T) {
+	notifier := newFakeNotifier()
+	tracker, err := newOpeningTracker(notifier, "swap", "opening", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = tracker.whileState(ctx, StateConfirmed, func() error {
+		t.Fatal("fn called on an unconfirmed opening")
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestOpeningTrackerConflict(t *testing.T) {
+	notifier := newFakeNotifier()
+	tracker, err := newOpeningTracker(notifier, "swap", "opening", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.stop()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- tracker.whileState(context.Background(), StateConfirmed, func() error {
+			return errors.New("fn called on a double spent opening")
+		})
+	}()
+
+	// the conflict in the mempool may still lose against the opening
+	notifier.conflicts <- &chain.TxConflict{TxId: "opening", ConflictingTxId: "conflict"}
+	select {
+	case err := <-done:
+		t.Fatalf("gave up on a mempool conflict: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	notifier.confirm(t, "conflict")
+	select {
+	case err := <-done:
+		var conflictErr *ConflictError
+		if !errors.As(err, &conflictErr) || conflictErr.Conflict.ConflictingTxId != "conflict" {
+			t.Fatalf("got %v, want a ConflictError", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the confirmed conflict did not abort the wait")
+	}
+}
+
+// fakeLightningWallet records the settled preimages
+type fakeLightningWallet struct {
+	settled chan []byte
+}
+
+func (f *fakeLightningWallet) CreateHodlInvoice(pHash []byte, amount uint64) (string, error) {
+	return "", nil
+}
+
+func (f *fakeLightningWallet) WaitforPaymentAccepted(pHash []byte) error {
+	return nil
+}
+
+func (f *fakeLightningWallet) SettleInvoice(preimage []byte) error {
+	f.settled <- preimage
+	return nil
+}
+
+func TestResumeSettlements(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settlements.json")
+	settlements, err := LoadPendingSettlements(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preimage := bytes.Repeat([]byte{1}, 32)
+	err = settlements.Add(&PendingSettlement{SwapId: "swap", TxId: "opening", Preimage: preimage})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted server loads the preimage and settles once the opening confirms
+	settlements, err = LoadPendingSettlements(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := newFakeNotifier()
+	node := &fakeLightningWallet{settled: make(chan []byte, 1)}
+	server := &BetterChivoServer{node: node}
+	server.SetNotifier(notifier, 1, settlements)
+	server.ResumeSettlements()
+
+	notifier.reorg(t, "opening")
+	select {
+	case <-node.settled:
+		t.Fatal("settled an unconfirmed opening")
+	case <-time.After(50 * time.Millisecond):
+	}
+	notifier.confirm(t, "opening")
+	select {
+	case settled := <-node.settled:
+		if !bytes.Equal(settled, preimage) {
+			t.Fatalf("settled preimage %x, want %x", settled, preimage)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("invoice not settled after the opening confirmed")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(settlements.List()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the settled swap is still pending")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	reloaded, err := LoadPendingSettlements(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.List()) != 0 {
+		t.Fatalf("got pending settlements %+v after the settlement", reloaded.List())
+	}
+}
@@ -0,0 +1,153 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	// DefaultFeeRate is the fee rate in sat/vbyte, which is the minimum relay fee of liquid
+	DefaultFeeRate = 0.1
+
+	// DefaultMinConfirmations allows spending unconfirmed utxos, e.g. the change of the last transaction
+	DefaultMinConfirmations = 0
+
+	// minChangeValue is the smallest policy asset change output, smaller change is paid as fee
+	minChangeValue = 546
+
+	// maxBnbTries bounds the branch and bound search
+	maxBnbTries = 100000
+
+	// estimated virtual sizes of the transaction parts
+	txOverheadVsize         = 11
+	p2wpkhInputVsize        = 68
+	explicitOutputVsize     = 67
+	confidentialOutputVsize = 1200
+	feeOutputVsize          = 44
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+)
+
+// Confirmations returns the number of confirmations at the tip height, unconfirmed utxos have none
+func (e *EsploraUtxo) Confirmations(tipHeight uint32) uint32 {
+	if e.Status == nil || !e.Status.Confirmed || e.Status.BlockHeight > tipHeight {
+		return 0
+	}
+	return tipHeight - e.Status.BlockHeight + 1
+}
+
+// SetFeeRate sets the fee rate in sat/vbyte used for wallet transactions
+func (l *LiquidWallet) SetFeeRate(feeRate float64) {
+	l.feeRate = feeRate
+}
+
+// SetMinConfirmations sets the number of confirmations a utxo needs to be selected
+func (l *LiquidWallet) SetMinConfirmations(minConfirmations uint32) {
+	l.minConfirmations = minConfirmations
+}
+
+// feeForVsize returns the fee in sats for a transaction of the virtual size
+func (l *LiquidWallet) feeForVsize(vsize int) uint64 {
+	return uint64(math.Ceil(l.feeRate * float64(vsize)))
+}
+
+// selectCoins selects utxos whose value covers the target plus inputFee for every selected input.
+// Branch and bound searches for an input set that exceeds the target by at most costOfChange, so no change
+// output is needed. If there is none, the largest utxos are selected until the target is reached
+func selectCoins(utxos []*EsploraUtxo, target uint64, inputFee uint64, costOfChange uint64) ([]*EsploraUtxo, uint64, error) {
+	if target == 0 {
+		return nil, 0, nil
+	}
+
+	// utxos that do not pay for their own input are never selected
+	var candidates []*EsploraUtxo
+	for _, v := range utxos {
+		if v.SatAmt > inputFee {
+			candidates = append(candidates, v)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].SatAmt > candidates[j].SatAmt
+	})
+
+	selected := selectBranchAndBound(candidates, target, inputFee, costOfChange)
+	if selected == nil {
+		var effectiveValue uint64
+		for _, v := range candidates {
+			if effectiveValue >= target {
+				break
+			}
+			selected = append(selected, v)
+			effectiveValue += v.SatAmt - inputFee
+		}
+		if effectiveValue < target {
+			return nil, 0, fmt.Errorf("%w: %v available, %v needed", ErrInsufficientFunds, effectiveValue, target)
+		}
+	}
+
+	var total uint64
+	for _, v := range selected {
+		total += v.SatAmt
+	}
+	return selected, total, nil
+}
+
+// selectBranchAndBound searches the utxos, sorted by descending value, depth first for the input set with the
+// least effective value in [target, target+costOfChange]. It returns nil if no such set is found
+func selectBranchAndBound(utxos []*EsploraUtxo, target uint64, inputFee uint64, costOfChange uint64) []*EsploraUtxo {
+	var available uint64
+	for _, v := range utxos {
+		available += v.SatAmt - inputFee
+	}
+	if available < target {
+		return nil
+	}
+
+	var (
+		tries     int
+		current   []int
+		best      []int
+		bestValue uint64
+	)
+	var search func(depth int, value uint64, remaining uint64)
+	search = func(depth int, value uint64, remaining uint64) {
+		tries++
+		if tries > maxBnbTries || (best != nil && bestValue == target) {
+			return
+		}
+		if value > target+costOfChange || value+remaining < target {
+			return
+		}
+		if value >= target {
+			if best == nil || value < bestValue {
+				best = append([]int{}, current...)
+				bestValue = value
+			}
+			return
+		}
+		if depth == len(utxos) {
+			return
+		}
+		effectiveValue := utxos[depth].SatAmt - inputFee
+
+		current = append(current, depth)
+		search(depth+1, value+effectiveValue, remaining-effectiveValue)
+		current = current[:len(current)-1]
+
+		search(depth+1, value, remaining-effectiveValue)
+	}
+	search(0, 0, available)
+
+	if best == nil {
+		return nil
+	}
+	var selected []*EsploraUtxo
+	for _, i := range best {
+		selected = append(selected, utxos[i])
+	}
+	return selected
+}
@@ -0,0 +1,59 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSelectCoins(t *testing.T) {
+	tests := []struct {
+		name         string
+		values       []uint64
+		target       uint64
+		inputFee     uint64
+		costOfChange uint64
+		want         uint64
+		err          error
+	}{
+		{"exact match", []uint64{100000, 30000, 20000}, 50000, 0, 0, 50000, nil},
+		{"exact match over larger coin", []uint64{1000000, 30000, 20000}, 50000, 0, 0, 50000, nil},
+		{"within cost of change", []uint64{60000, 50005, 10000}, 50000, 0, 10, 50005, nil},
+		{"input fees are covered", []uint64{25007, 25007, 100000}, 50000, 7, 0, 50014, nil},
+		{"fallback to largest coins", []uint64{70000, 40000, 5000}, 100000, 0, 0, 110000, nil},
+		{"coins below input fee are ignored", []uint64{50000, 6}, 50000, 7, 0, 0, ErrInsufficientFunds},
+		{"insufficient funds", []uint64{10000, 20000}, 50000, 0, 0, 0, ErrInsufficientFunds},
+		{"zero target", []uint64{10000}, 0, 0, 0, 0, nil},
+	}
+	for _, tt := range tests {
+		var utxos []*EsploraUtxo
+		for _, v := range tt.values {
+			utxos = append(utxos, &EsploraUtxo{SatAmt: v})
+		}
+		_, total, err := selectCoins(utxos, tt.target, tt.inputFee, tt.costOfChange)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("%s: error = %v, want %v", tt.name, err, tt.err)
+		}
+		if total != tt.want {
+			t.Fatalf("%s: total = %v, want %v", tt.name, total, tt.want)
+		}
+	}
+}
+
+func TestConfirmations(t *testing.T) {
+	tests := []struct {
+		status *Status
+		want   uint32
+	}{
+		{nil, 0},
+		{&Status{Confirmed: false}, 0},
+		{&Status{Confirmed: true, BlockHeight: 100}, 1},
+		{&Status{Confirmed: true, BlockHeight: 91}, 10},
+		{&Status{Confirmed: true, BlockHeight: 101}, 0},
+	}
+	for _, tt := range tests {
+		utxo := &EsploraUtxo{Status: tt.status}
+		if got := utxo.Confirmations(100); got != tt.want {
+			t.Fatalf("Confirmations(%+v) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
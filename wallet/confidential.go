@@ -0,0 +1,283 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
+	elemaddr "github.com/vulpemventures/go-elements/address"
+	"github.com/vulpemventures/go-elements/confidential"
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/pset"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+var (
+	ErrNothingToBlind = errors.New("confidential inputs require a blinded output or a fee output")
+	ErrForeignInputs  = errors.New("unable to blind transactions with foreign inputs")
+)
+
+// getBlindingKey returns the slip77 blinding private key of an output script
+func (l *LiquidWallet) getBlindingKey(script []byte) ([]byte, error) {
+	privkey, _, err := l.masterBlindingKey.DeriveKey(script)
+	if err != nil {
+		return nil, err
+	}
+	return privkey.Serialize(), nil
+}
+
+// getBlindingPubkey returns the slip77 blinding public key of an output script
+func (l *LiquidWallet) getBlindingPubkey(script []byte) ([]byte, error) {
+	_, pubkey, err := l.masterBlindingKey.DeriveKey(script)
+	if err != nil {
+		return nil, err
+	}
+	return pubkey.SerializeCompressed(), nil
+}
+
+// GetConfidentialAddress returns the first unused address as confidential address
+func (l *LiquidWallet) GetConfidentialAddress() (string, error) {
+	addrState, err := l.nextUnusedAddress(ReceiveBranch)
+	if err != nil {
+		return "", err
+	}
+	return l.deriveConfidentialAddress(addrState.Account, addrState.Branch, addrState.Index)
+}
+
+// unblindUtxos unblinds the confidential utxos using the range proofs of the funding transactions,
+// utxos that are not blinded to the wallet are dropped
+func (l *LiquidWallet) unblindUtxos(utxos []*EsploraUtxo) ([]*EsploraUtxo, error) {
+	txCache := make(map[string]*transaction.Transaction)
+	var unblinded []*EsploraUtxo
+	for _, v := range utxos {
+		if !v.IsConfidential() || v.AssetBlinder != "" {
+			unblinded = append(unblinded, v)
+			continue
+		}
+		tx, ok := txCache[v.TxId]
+		if !ok {
+			txHex, err := l.esplora.GetTxHex(v.TxId)
+			if err != nil {
+				return nil, err
+			}
+			tx, err = transaction.NewTxFromHex(txHex)
+			if err != nil {
+				return nil, err
+			}
+			txCache[v.TxId] = tx
+		}
+		if int(v.Vout) >= len(tx.Outputs) {
+			return nil, fmt.Errorf("vout %v not found in %s", v.Vout, v.TxId)
+		}
+		out := tx.Outputs[v.Vout]
+		blindingKey, err := l.getBlindingKey(out.Script)
+		if err != nil {
+			return nil, err
+		}
+		res, err := confidential.UnblindOutputWithKey(out, blindingKey)
+		if err != nil || res == nil {
+			log.Printf("unable to unblind %s:%v: %v", v.TxId, v.Vout, err)
+			continue
+		}
+		v.SatAmt = res.Value
+		v.Asset = b2h(elementsutil.ReverseBytes(res.Asset))
+		v.ValueBlinder = b2h(res.ValueBlindingFactor)
+		v.AssetBlinder = b2h(res.AssetBlindingFactor)
+		unblinded = append(unblinded, v)
+	}
+	return unblinded, nil
+}
+
+// prevoutValue returns the value of the output as it appears in the funding transaction
+func (e *EsploraUtxo) prevoutValue() ([]byte, error) {
+	if e.IsConfidential() {
+		return h2b(e.ValueCommitment), nil
+	}
+	return elementsutil.SatoshiToElementsValue(e.SatAmt)
+}
+
+// prevout returns the output as it appears in the funding transaction
+func (e *EsploraUtxo) prevout() (*transaction.TxOutput, error) {
+	script, err := elemaddr.ToOutputScript(e.Address)
+	if err != nil {
+		return nil, err
+	}
+	value, err := e.prevoutValue()
+	if err != nil {
+		return nil, err
+	}
+	if e.IsConfidential() {
+		out := transaction.NewTxOutput(h2b(e.AssetCommitment), value, script)
+		out.Nonce = h2b(e.NonceCommitment)
+		return out, nil
+	}
+	txAsset, err := asset.TxAssetFromId(e.Asset)
+	if err != nil {
+		return nil, err
+	}
+	return transaction.NewTxOutput(txAsset, value, script), nil
+}
+
+// blindingData returns the unblinded value, asset and blinders of the output
+func (e *EsploraUtxo) blindingData() pset.BlindingData {
+	valueBlinder, assetBlinder := make([]byte, 32), make([]byte, 32)
+	if e.IsConfidential() {
+		valueBlinder, assetBlinder = h2b(e.ValueBlinder), h2b(e.AssetBlinder)
+	}
+	return pset.BlindingData{
+		Value:               e.SatAmt,
+		Asset:               elementsutil.ReverseBytes(h2b(e.Asset)),
+		ValueBlindingFactor: valueBlinder,
+		AssetBlindingFactor: assetBlinder,
+	}
+}
+
+// getAddressBlindingKey returns the blinding pubkey of a confidential address, or nil for unconfidential addresses
+func getAddressBlindingKey(address string) ([]byte, error) {
+	isConfidential, err := elemaddr.IsConfidential(address)
+	if err != nil {
+		return nil, err
+	}
+	if !isConfidential {
+		return nil, nil
+	}
+	confAddr, err := elemaddr.FromConfidential(address)
+	if err != nil {
+		return nil, err
+	}
+	return confAddr.BlindingKey, nil
+}
+
+// newDummyBlindedOutput moves a satoshi of the fee to an OP_RETURN output that is blinded to the wallet.
+// The blinder can not blind empty outputs, so the fee pays for the output
+func (l *LiquidWallet) newDummyBlindedOutput(tx *transaction.Transaction) (*transaction.TxOutput, []byte, error) {
+	var feeOutput *transaction.TxOutput
+	for _, v := range tx.Outputs {
+		if len(v.Script) == 0 && bytes.Equal(v.Asset, l.lbtcAsset) {
+			feeOutput = v
+		}
+	}
+	if feeOutput == nil {
+		return nil, nil, ErrNothingToBlind
+	}
+	fee, err := elementsutil.ElementsToSatoshiValue(feeOutput.Value)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fee < 2 {
+		return nil, nil, ErrNothingToBlind
+	}
+	feeOutput.Value, _ = elementsutil.SatoshiToElementsValue(fee - 1)
+
+	script := []byte{txscript.OP_RETURN}
+	blindingPubkey, err := l.getBlindingPubkey(script)
+	if err != nil {
+		return nil, nil, err
+	}
+	value, _ := elementsutil.SatoshiToElementsValue(1)
+	return transaction.NewTxOutput(l.lbtcAsset, value, script), blindingPubkey, nil
+}
+
+// blindAndSign blinds the transaction and signs the wallet inputs
+func (l *LiquidWallet) blindAndSign(tx *transaction.Transaction, offset int, walletInputs []*EsploraUtxo, receiverKeys map[*transaction.TxOutput][]byte, changeOutputs []*transaction.TxOutput) (*transaction.Transaction, error) {
+	tx, err := l.blindTransaction(tx, offset, walletInputs, receiverKeys, changeOutputs)
+	if err != nil {
+		return nil, err
+	}
+	err = l.signInputs(tx, offset, walletInputs)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// blindTransaction blinds the outputs if a wallet input is confidential or a receiver is blinded.
+// receiverKeys holds the blinding pubkeys of confidential receivers, change outputs are blinded to the wallet.
+// Blinded outputs are moved in front of the explicit outputs. If confidential inputs are spent to explicit outputs only,
+// a blinded OP_RETURN output paid from the fee balances their blinders.
+// The wallet inputs start at offset. Blinding balances the blinders of all inputs, so transactions with foreign
+// inputs in front of the wallet inputs are only supported if no output has to be blinded, they fail with ErrForeignInputs
+func (l *LiquidWallet) blindTransaction(tx *transaction.Transaction, offset int, walletInputs []*EsploraUtxo, receiverKeys map[*transaction.TxOutput][]byte, changeOutputs []*transaction.TxOutput) (*transaction.Transaction, error) {
+	needsBlinding := len(receiverKeys) > 0
+	for _, v := range walletInputs {
+		if v.IsConfidential() {
+			needsBlinding = true
+		}
+	}
+	if !needsBlinding {
+		return tx, nil
+	}
+	if offset > 0 {
+		return nil, ErrForeignInputs
+	}
+
+	outputKeys := make(map[*transaction.TxOutput][]byte)
+	for k, v := range receiverKeys {
+		outputKeys[k] = v
+	}
+	for _, v := range changeOutputs {
+		blindingPubkey, err := l.getBlindingPubkey(v.Script)
+		if err != nil {
+			return nil, err
+		}
+		outputKeys[v] = blindingPubkey
+	}
+	if len(outputKeys) == 0 {
+		// the blinders of confidential inputs have to be balanced by a blinded output
+		dummyOutput, dummyKey, err := l.newDummyBlindedOutput(tx)
+		if err != nil {
+			return nil, err
+		}
+		tx.Outputs = append(tx.Outputs, dummyOutput)
+		outputKeys[dummyOutput] = dummyKey
+	}
+
+	// the blinder expects the outputs to blind at the first indexes
+	var blindedOutputs, explicitOutputs []*transaction.TxOutput
+	for _, v := range tx.Outputs {
+		if _, ok := outputKeys[v]; ok {
+			blindedOutputs = append(blindedOutputs, v)
+		} else {
+			explicitOutputs = append(explicitOutputs, v)
+		}
+	}
+	tx.Outputs = append(blindedOutputs, explicitOutputs...)
+	blindingKeysByIndex := make(map[int][]byte)
+	for i, v := range blindedOutputs {
+		blindingKeysByIndex[i] = outputKeys[v]
+	}
+
+	p, err := pset.NewPsetFromUnsignedTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	updater, err := pset.NewUpdater(p)
+	if err != nil {
+		return nil, err
+	}
+	var inputBlindingData []pset.BlindingDataLike
+	for i, v := range walletInputs {
+		prevout, err := v.prevout()
+		if err != nil {
+			return nil, err
+		}
+		err = updater.AddInWitnessUtxo(prevout, i)
+		if err != nil {
+			return nil, err
+		}
+		inputBlindingData = append(inputBlindingData, v.blindingData())
+	}
+	blinder, err := pset.NewBlinder(p, inputBlindingData, blindingKeysByIndex, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = blinder.Blind()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.UnsignedTx, nil
+}
@@ -0,0 +1,260 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
+	elemaddr "github.com/vulpemventures/go-elements/address"
+	"github.com/vulpemventures/go-elements/confidential"
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/network"
+	"github.com/vulpemventures/go-elements/payment"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+// unblindOutput returns the value and asset id of an output blinded to the key
+func unblindOutput(t *testing.T, out *transaction.TxOutput, blindingKey []byte) (uint64, string) {
+	res, err := confidential.UnblindOutputWithKey(out, blindingKey)
+	if err != nil || res == nil {
+		t.Fatalf("unable to unblind output: %v", err)
+	}
+	return res.Value, b2h(elementsutil.ReverseBytes(res.Asset))
+}
+
+// findOutput returns the output paying to the address
+func findOutput(t *testing.T, tx *transaction.Transaction, address string) *transaction.TxOutput {
+	script, err := elemaddr.ToOutputScript(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range tx.Outputs {
+		if bytes.Equal(v.Script, script) {
+			return v
+		}
+	}
+	t.Fatalf("no output pays to %s", address)
+	return nil
+}
+
+// verifyInput checks the p2wpkh signature of a wallet input against its prevout
+func verifyInput(t *testing.T, tx *transaction.Transaction, i int, prevout *transaction.TxOutput) {
+	witness := tx.Inputs[i].Witness
+	if len(witness) != 2 {
+		t.Fatalf("input %v is not signed", i)
+	}
+	pubkey, err := btcec.ParsePubKey(witness[1], btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := btcec.ParseDERSignature(witness[0][:len(witness[0])-1], btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	scriptCode := payment.FromPublicKey(pubkey, &network.Regtest, nil).Script
+	sigHash := tx.HashForWitnessV0(i, scriptCode, prevout.Value, txscript.SigHashAll)
+	if !signature.Verify(sigHash[:], pubkey) {
+		t.Fatalf("invalid signature of input %v", i)
+	}
+}
+
+func TestUnblindUtxos(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	l := newTestWallet(t, esplora, Bip84Derivation)
+	lbtc := network.Regtest.AssetID
+	address, err := l.deriveAddress(0, ReceiveBranch, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confAddress, err := l.deriveConfidentialAddress(0, ReceiveBranch, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an output to the wallet script blinded to another key can not be spent by the wallet
+	foreignAddress, err := elemaddr.ToConfidential(&elemaddr.ConfidentialAddress{
+		Address:     address,
+		BlindingKey: newTestKey(t).PubKey().SerializeCompressed(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fundingTxId := esplora.addTx(t, 101, nil, fakeOutput{confAddress, lbtc, 100000}, fakeOutput{confAddress, testAssetId, 5000},
+		fakeOutput{address, lbtc, 700})
+	foreignTxId := esplora.addTx(t, 102, nil, fakeOutput{foreignAddress, lbtc, 3000})
+
+	utxos, err := l.GetUtxos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		value        uint64
+		assetId      string
+		confidential bool
+	}{
+		{100000, lbtc, true},
+		{5000, testAssetId, true},
+		{700, lbtc, false},
+	}
+	if len(utxos) != len(want) {
+		t.Fatalf("got %v utxos, want %v", len(utxos), len(want))
+	}
+	for i, v := range utxos {
+		if v.TxId != fundingTxId || v.SatAmt != want[i].value || v.Asset != want[i].assetId || v.IsConfidential() != want[i].confidential {
+			t.Fatalf("unexpected utxo %v", v)
+		}
+		if v.IsConfidential() && (len(h2b(v.ValueBlinder)) != 32 || len(h2b(v.AssetBlinder)) != 32) {
+			t.Fatalf("missing blinders of utxo %v", v)
+		}
+	}
+	// the funding transaction is fetched once for all of its outputs
+	if esplora.txCalls[fundingTxId] != 1 || esplora.txCalls[foreignTxId] != 1 {
+		t.Fatalf("fetched the funding transactions %v and %v times", esplora.txCalls[fundingTxId], esplora.txCalls[foreignTxId])
+	}
+
+	// unblinded utxos are not fetched again
+	_, err = l.unblindUtxos(utxos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if esplora.txCalls[fundingTxId] != 1 {
+		t.Fatal("fetched the transaction of an unblinded utxo")
+	}
+}
+
+func TestBlindAndSignConfidential(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	l := newTestWallet(t, esplora, Bip84Derivation)
+	lbtc := network.Regtest.AssetID
+	confAddress, err := l.deriveConfidentialAddress(0, ReceiveBranch, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fundingTxId := esplora.addTx(t, 101, nil, fakeOutput{confAddress, lbtc, 100000})
+	balances, err := l.GetBalances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balances[lbtc] != 100000 {
+		t.Fatalf("got balance %v, want 100000", balances[lbtc])
+	}
+
+	receiver, receiverKey := externalConfidentialAddress(t)
+	_, err = l.SendToAddress(receiver, asset.NewAssetAmount(lbtc, 8, 40000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := esplora.lastPosted(t)
+	value, assetId := unblindOutput(t, findOutput(t, tx, receiver), receiverKey.Serialize())
+	if value != 40000 || assetId != lbtc {
+		t.Fatalf("receiver got %v of %s", value, assetId)
+	}
+
+	// the change is blinded to the wallet
+	changeAddress, err := l.deriveAddress(0, ChangeBranch, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change := findOutput(t, tx, changeAddress)
+	changeKey, err := l.getBlindingKey(change.Script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee := tx.Outputs[len(tx.Outputs)-1]
+	if len(fee.Script) != 0 || fee.IsConfidential() {
+		t.Fatal("the fee output is not explicit")
+	}
+	value, assetId = unblindOutput(t, change, changeKey)
+	if value != 100000-40000-txFee(t, tx) || assetId != lbtc {
+		t.Fatalf("change got %v of %s", value, assetId)
+	}
+	// the wallet input commits to the value commitment of its prevout
+	verifyInput(t, tx, 0, esplora.txs[fundingTxId].Outputs[0])
+}
+
+func TestBlindAndSignExplicit(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	l := newTestWallet(t, esplora, Bip84Derivation)
+	lbtc := network.Regtest.AssetID
+	address, err := l.deriveAddress(0, ReceiveBranch, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fundingTxId := esplora.addTx(t, 101, nil, fakeOutput{address, lbtc, 100000})
+	_, err = l.GetBalances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// explicit inputs paying an unconfidential address are not blinded
+	receiver := externalAddress(t)
+	_, err = l.SendToAddress(receiver, asset.NewAssetAmount(lbtc, 8, 40000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := esplora.lastPosted(t)
+	for i, v := range tx.Outputs {
+		if v.IsConfidential() {
+			t.Fatalf("output %v is blinded", i)
+		}
+	}
+	if value, _ := elementsutil.ElementsToSatoshiValue(findOutput(t, tx, receiver).Value); value != 40000 {
+		t.Fatalf("receiver got %v", value)
+	}
+	verifyInput(t, tx, 0, esplora.txs[fundingTxId].Outputs[0])
+}
+
+func TestBlindAndSignForeignInputs(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	l := newTestWallet(t, esplora, Bip84Derivation)
+	lbtc := network.Regtest.AssetID
+	address, err := l.deriveAddress(0, ReceiveBranch, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confAddress, err := l.deriveConfidentialAddress(0, ReceiveBranch, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	explicitTxId := esplora.addTx(t, 101, nil, fakeOutput{address, lbtc, 100000})
+	esplora.addTx(t, 102, nil, fakeOutput{confAddress, lbtc, 100000})
+	utxos, err := l.GetUtxos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.GetBalances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newTx := func(walletInput *EsploraUtxo) *transaction.Transaction {
+		tx := transaction.NewTx(2)
+		tx.Inputs = append(tx.Inputs, transaction.NewTxInput(make([]byte, 32), 0), EsploraUtxoToTxInput(walletInput))
+		script, err := elemaddr.ToOutputScript(externalAddress(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, _ := elementsutil.SatoshiToElementsValue(100000)
+		tx.Outputs = append(tx.Outputs, transaction.NewTxOutput(l.lbtcAsset, value, script))
+		return tx
+	}
+
+	// the wallet inputs after the foreign inputs are signed if nothing is blinded
+	tx, err := l.blindAndSign(newTx(utxos[0]), 1, utxos[:1], nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Inputs[0].Witness) != 0 {
+		t.Fatal("signed the foreign input")
+	}
+	verifyInput(t, tx, 1, esplora.txs[explicitTxId].Outputs[0])
+
+	// the blinders of foreign inputs are unknown
+	_, err = l.blindAndSign(newTx(utxos[1]), 1, utxos[1:], nil, nil)
+	if !errors.Is(err, ErrForeignInputs) {
+		t.Fatalf("got %v, want ErrForeignInputs", err)
+	}
+}
@@ -0,0 +1,281 @@
+package wallet
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/vulpemventures/go-elements/network"
+	"github.com/vulpemventures/go-elements/payment"
+)
+
+// AddressType is the script type of the wallet addresses
+type AddressType int
+
+const (
+	P2wpkhAddress AddressType = iota
+	P2shP2wpkhAddress
+)
+
+const (
+	// LiquidCoinType is the slip44 coin type of liquid
+	LiquidCoinType = 1776
+
+	// TestnetCoinType is the slip44 coin type shared by all test networks
+	TestnetCoinType = 1
+
+	ReceiveBranch = 0
+	ChangeBranch  = 1
+
+	// maxAccounts bounds the account discovery
+	maxAccounts = 100
+
+	p2shP2wpkhInputVsize = 91
+)
+
+// DerivationScheme describes how the wallet keys and addresses are derived from the seed
+type DerivationScheme struct {
+	Name string
+
+	// Purpose is the bip43 purpose, the legacy layout has none
+	Purpose uint32
+
+	AddressType AddressType
+}
+
+var (
+	// LegacyDerivation is the layout of earlier versions, a single account on m/0'/0 that also receives the change
+	LegacyDerivation = &DerivationScheme{Name: "legacy", AddressType: P2wpkhAddress}
+
+	// Bip84Derivation derives native segwit addresses on m/84'/coin'/account'
+	Bip84Derivation = &DerivationScheme{Name: "bip84", Purpose: 84, AddressType: P2wpkhAddress}
+
+	// Bip49Derivation derives nested segwit addresses on m/49'/coin'/account'
+	Bip49Derivation = &DerivationScheme{Name: "bip49", Purpose: 49, AddressType: P2shP2wpkhAddress}
+)
+
+// GetDerivationScheme returns the derivation scheme by name
+func GetDerivationScheme(name string) (*DerivationScheme, error) {
+	for _, v := range []*DerivationScheme{LegacyDerivation, Bip84Derivation, Bip49Derivation} {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown derivation scheme %s", name)
+}
+
+// CoinType returns the slip44 coin type of the network
+func CoinType(liquidNetwork *network.Network) uint32 {
+	if liquidNetwork.Name == network.Liquid.Name {
+		return LiquidCoinType
+	}
+	return TestnetCoinType
+}
+
+func (d *DerivationScheme) isLegacy() bool {
+	return d.Purpose == 0
+}
+
+// branches returns the address branches of an account, legacy wallets only have a receive branch
+func (d *DerivationScheme) branches() []uint32 {
+	if d.isLegacy() {
+		return []uint32{ReceiveBranch}
+	}
+	return []uint32{ReceiveBranch, ChangeBranch}
+}
+
+// changeBranch returns the branch change addresses are derived from
+func (d *DerivationScheme) changeBranch() uint32 {
+	if d.isLegacy() {
+		return ReceiveBranch
+	}
+	return ChangeBranch
+}
+
+// maxAccounts returns the number of accounts the discovery scans at most, watch-only wallets only know one account
+func (l *LiquidWallet) maxAccounts() uint32 {
+	if l.IsWatchOnly() {
+		return 1
+	}
+	return l.scheme.maxAccounts()
+}
+
+func (d *DerivationScheme) maxAccounts() uint32 {
+	if d.isLegacy() {
+		return 1
+	}
+	return maxAccounts
+}
+
+// inputVsize returns the estimated virtual size of an input spending an address of the scheme
+func (d *DerivationScheme) inputVsize() int {
+	if d.AddressType == P2shP2wpkhAddress {
+		return p2shP2wpkhInputVsize
+	}
+	return p2wpkhInputVsize
+}
+
+// accountPath returns the hardened path of an account, m/purpose'/coin'/account' or m/0' for the legacy layout
+func (d *DerivationScheme) accountPath(liquidNetwork *network.Network, account uint32) ([]uint32, error) {
+	if d.isLegacy() {
+		if account != 0 {
+			return nil, fmt.Errorf("legacy derivation has no account %v", account)
+		}
+		return []uint32{hdkeychain.HardenedKeyStart + 0}, nil
+	}
+	return []uint32{
+		hdkeychain.HardenedKeyStart + d.Purpose,
+		hdkeychain.HardenedKeyStart + CoinType(liquidNetwork),
+		hdkeychain.HardenedKeyStart + account,
+	}, nil
+}
+
+// accountKey derives the key of an account from the master key
+func (d *DerivationScheme) accountKey(masterKey *hdkeychain.ExtendedKey, liquidNetwork *network.Network, account uint32) (*hdkeychain.ExtendedKey, error) {
+	path, err := d.accountPath(liquidNetwork, account)
+	if err != nil {
+		return nil, err
+	}
+	key := masterKey
+	for _, v := range path {
+		key, err = key.Derive(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// payment returns the payment of the address type for the public key
+func (d *DerivationScheme) payment(pubkey *btcec.PublicKey, liquidNetwork *network.Network, blindingKey *btcec.PublicKey) (*payment.Payment, error) {
+	p2wpkh := payment.FromPublicKey(pubkey, liquidNetwork, blindingKey)
+	if d.AddressType == P2shP2wpkhAddress {
+		return payment.FromPayment(p2wpkh)
+	}
+	return p2wpkh, nil
+}
+
+// outputScript returns the output script of a payment of the address type
+func (d *DerivationScheme) outputScript(p *payment.Payment) []byte {
+	if d.AddressType == P2shP2wpkhAddress {
+		return p.Script
+	}
+	return p.WitnessScript
+}
+
+// address encodes the payment as address, blech32 or confidential base58 if it has a blinding key
+func (d *DerivationScheme) address(p *payment.Payment) (string, error) {
+	switch {
+	case d.AddressType == P2shP2wpkhAddress && p.BlindingKey != nil:
+		return p.ConfidentialScriptHash()
+	case d.AddressType == P2shP2wpkhAddress:
+		return p.ScriptHash()
+	case p.BlindingKey != nil:
+		return p.ConfidentialWitnessPubKeyHash()
+	default:
+		return p.WitnessPubKeyHash()
+	}
+}
+
+// SetDerivationScheme sets the derivation scheme of the wallet keys, it needs to be set before Initialize
+func (l *LiquidWallet) SetDerivationScheme(scheme *DerivationScheme) {
+	l.scheme = scheme
+}
+
+type branchId struct {
+	account uint32
+	branch  uint32
+}
+
+// getAccountKey returns the key of an account, watch-only wallets only know the key of their account
+func (l *LiquidWallet) getAccountKey(account uint32) (*hdkeychain.ExtendedKey, error) {
+	if l.IsWatchOnly() {
+		if account != 0 {
+			return nil, fmt.Errorf("watch-only wallet has no account %v", account)
+		}
+		return l.watchOnlyKey, nil
+	}
+	return l.scheme.accountKey(l.masterKey, l.liquidNetwork, account)
+}
+
+// getBranchKey returns the cached key of an address branch, m/purpose'/coin'/account'/branch
+// or m/0'/0 for the legacy layout
+func (l *LiquidWallet) getBranchKey(account uint32, branch uint32) (*hdkeychain.ExtendedKey, error) {
+	l.keyMu.Lock()
+	defer l.keyMu.Unlock()
+	id := branchId{account: account, branch: branch}
+	if key, ok := l.branchKeys[id]; ok {
+		return key, nil
+	}
+	if l.scheme.isLegacy() && branch != ReceiveBranch {
+		return nil, fmt.Errorf("legacy derivation has no branch %v", branch)
+	}
+	accountKey, err := l.getAccountKey(account)
+	if err != nil {
+		return nil, err
+	}
+	key, err := accountKey.Derive(branch)
+	if err != nil {
+		return nil, err
+	}
+	l.branchKeys[id] = key
+	return key, nil
+}
+
+// deriveKey returns the key of an address
+func (l *LiquidWallet) deriveKey(account uint32, branch uint32, index uint32) (*hdkeychain.ExtendedKey, error) {
+	branchKey, err := l.getBranchKey(account, branch)
+	if err != nil {
+		return nil, err
+	}
+	return branchKey.Derive(index)
+}
+
+// deriveAddress returns the unconfidential address of the key at the index
+func (l *LiquidWallet) deriveAddress(account uint32, branch uint32, index uint32) (string, error) {
+	key, err := l.deriveKey(account, branch, index)
+	if err != nil {
+		return "", err
+	}
+	pubkey, err := key.ECPubKey()
+	if err != nil {
+		return "", err
+	}
+	p, err := l.scheme.payment(pubkey, l.liquidNetwork, nil)
+	if err != nil {
+		return "", err
+	}
+	return l.scheme.address(p)
+}
+
+// deriveConfidentialAddress returns the address of the key at the index, blinded with its slip77 key
+func (l *LiquidWallet) deriveConfidentialAddress(account uint32, branch uint32, index uint32) (string, error) {
+	key, err := l.deriveKey(account, branch, index)
+	if err != nil {
+		return "", err
+	}
+	pubkey, err := key.ECPubKey()
+	if err != nil {
+		return "", err
+	}
+	p, err := l.scheme.payment(pubkey, l.liquidNetwork, nil)
+	if err != nil {
+		return "", err
+	}
+	_, blindingPubkey, err := l.masterBlindingKey.DeriveKey(l.scheme.outputScript(p))
+	if err != nil {
+		return "", err
+	}
+	p, err = l.scheme.payment(pubkey, l.liquidNetwork, blindingPubkey)
+	if err != nil {
+		return "", err
+	}
+	return l.scheme.address(p)
+}
+
+// nestedSegwitScriptSig returns the script sig of a p2sh-p2wpkh input, which pushes the witness program
+func nestedSegwitScriptSig(pubkey *btcec.PublicKey, liquidNetwork *network.Network) ([]byte, error) {
+	p2wpkh := payment.FromPublicKey(pubkey, liquidNetwork, nil)
+	return txscript.NewScriptBuilder().AddData(p2wpkh.WitnessScript).Script()
+}
@@ -0,0 +1,163 @@
+package wallet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/tyler-smith/go-bip39"
+	elemaddr "github.com/vulpemventures/go-elements/address"
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/network"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+// TestDeriveAddress checks the first receive and change addresses of the test mnemonic. The regtest bip84 and bip49
+// programs are the ones of the bip84 and bip49 testnet vectors, tb1q6rz28mcfaxtmd6v789l9rrlrusdprr9pqcpvkl and
+// 2Mww8dCYPUpKHofjgcXcBCEGmniw9CoaiD2, liquid derives them with coin type 1776
+func TestDeriveAddress(t *testing.T) {
+	tests := []struct {
+		liquidNetwork *network.Network
+		scheme        *DerivationScheme
+		receive       string
+		change        string
+	}{
+		{&network.Regtest, LegacyDerivation, "ert1qgv52mt89gpev6p56huggl970sppqkgftkxpvvn", ""},
+		{&network.Regtest, Bip84Derivation, "ert1q6rz28mcfaxtmd6v789l9rrlrusdprr9p69dllk", "ert1q9u62588spffmq4dzjxsr5l297znf3z6jwuxfww"},
+		{&network.Regtest, Bip49Derivation, "XG39V4UXZzFGrDsCemJcX4Ladgm1CcEkwZ", "XEjVZwjBkCpmGopg1WdKtvKofrHRpf1kFj"},
+		{&network.Liquid, LegacyDerivation, "ex1qgv52mt89gpev6p56huggl970sppqkgftv5t5nf", ""},
+		{&network.Liquid, Bip84Derivation, "ex1qyuh42lps6t6jpdk54cwmmhd27zrs3yulrc7t5a", "ex1qqyy6uywz3xcee5f73gd24yduagwfx9uhgwgul5"},
+		{&network.Liquid, Bip49Derivation, "Gyzr4nj1BLpKaEQdnpRazFP7tCefPmnK5M", "GmQumR1YuQL699Xdk3w5GRb87RG9JBHF4m"},
+	}
+	seed, err := bip39.NewSeedWithErrorChecking(testMnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		l := NewLiquidWallet(newFakeEsplora(100), testChainParams(), tt.liquidNetwork)
+		l.SetDerivationScheme(tt.scheme)
+		err := l.Initialize(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		receive, err := l.deriveAddress(0, ReceiveBranch, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if receive != tt.receive {
+			t.Fatalf("%s %s: receive address = %s, want %s", tt.liquidNetwork.Name, tt.scheme.Name, receive, tt.receive)
+		}
+		change, err := l.deriveAddress(0, l.scheme.changeBranch(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.change == "" {
+			// legacy wallets receive their change on the receive branch
+			tt.change = tt.receive
+		}
+		if change != tt.change {
+			t.Fatalf("%s %s: change address = %s, want %s", tt.liquidNetwork.Name, tt.scheme.Name, change, tt.change)
+		}
+	}
+}
+
+func TestSyncDiscoversAccounts(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	l := newTestWallet(t, esplora, Bip84Derivation)
+	lbtc := network.Regtest.AssetID
+	account0 := testAddresses(t, l, 0, ChangeBranch, 1)
+	account1 := testAddresses(t, l, 1, ReceiveBranch, 1)
+	account2 := testAddresses(t, l, 2, ReceiveBranch, 1)
+	account3 := testAddresses(t, l, 3, ReceiveBranch, 1)
+
+	esplora.addTx(t, 101, nil, fakeOutput{account0[0], lbtc, 1000})
+	esplora.addTx(t, 102, nil, fakeOutput{account1[0], lbtc, 2000})
+	utxos, err := l.GetUtxos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 2 {
+		t.Fatalf("got %v utxos, want the utxos of both accounts", len(utxos))
+	}
+	if addrInfo := l.addressToUtxoMap[account1[0]]; addrInfo == nil || addrInfo.Account != 1 || len(addrInfo.Utxos) != 1 {
+		t.Fatalf("unexpected address info %+v", addrInfo)
+	}
+	// the discovery stops after the first unused account
+	if esplora.statsCalls[account2[0]] != 1 || esplora.statsCalls[account3[0]] != 0 {
+		t.Fatalf("scanned account 2 %v times and account 3 %v times, want 1 and 0", esplora.statsCalls[account2[0]], esplora.statsCalls[account3[0]])
+	}
+}
+
+func TestSignNestedSegwitInput(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	l := newTestWallet(t, esplora, Bip49Derivation)
+	receive := testAddresses(t, l, 0, ReceiveBranch, 1)
+	fundingTxId := esplora.addTx(t, 101, nil, fakeOutput{receive[0], network.Regtest.AssetID, 100000})
+	utxos, err := l.GetUtxos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 1 {
+		t.Fatalf("got %v utxos, want 1", len(utxos))
+	}
+
+	prevout := esplora.txs[fundingTxId].Outputs[0]
+	tx := transaction.NewTx(2)
+	tx.Inputs = append(tx.Inputs, EsploraUtxoToTxInput(utxos[0]))
+	outScript, err := elemaddr.ToOutputScript(externalAddress(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := elementsutil.SatoshiToElementsValue(99000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Outputs = append(tx.Outputs, transaction.NewTxOutput(prevout.Asset, value, outScript))
+	feeValue, err := elementsutil.SatoshiToElementsValue(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Outputs = append(tx.Outputs, transaction.NewTxOutput(prevout.Asset, feeValue, []byte{}))
+
+	err = l.signInputs(tx, 0, utxos)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the script sig pushes the p2wpkh redeem script of the p2sh output
+	in := tx.Inputs[0]
+	if len(in.Script) != 23 || in.Script[0] != 22 {
+		t.Fatalf("unexpected script sig %x", in.Script)
+	}
+	redeemScript := in.Script[1:]
+	if !txscript.IsPayToScriptHash(prevout.Script) || !bytes.Equal(btcutil.Hash160(redeemScript), prevout.Script[2:22]) {
+		t.Fatalf("redeem script %x does not match the output script %x", redeemScript, prevout.Script)
+	}
+	if len(in.Witness) != 2 || !bytes.Equal(append([]byte{0x00, 0x14}, btcutil.Hash160(in.Witness[1])...), redeemScript) {
+		t.Fatalf("witness pubkey does not match the redeem script %x", redeemScript)
+	}
+
+	pubkey, err := btcec.ParsePubKey(in.Witness[1], btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := in.Witness[0]
+	if txscript.SigHashType(sig[len(sig)-1]) != txscript.SigHashAll {
+		t.Fatalf("unexpected sighash type %x", sig[len(sig)-1])
+	}
+	signature, err := btcec.ParseDERSignature(sig[:len(sig)-1], btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	scriptCode, err := txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).AddOp(txscript.OP_HASH160).
+		AddData(btcutil.Hash160(in.Witness[1])).AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigHash := tx.HashForWitnessV0(0, scriptCode, prevout.Value, txscript.SigHashAll)
+	if !signature.Verify(sigHash[:], pubkey) {
+		t.Fatal("invalid signature")
+	}
+}
@@ -5,24 +5,36 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
 	"github.com/ybbus/jsonrpc"
 	"log"
 	"net/url"
 	"strings"
+	"sync"
 )
 
+// ElementsdClient is safe for concurrent use, the wallet endpoint may be switched while calls are in flight
 type ElementsdClient struct {
 	baseUrl string
 	username string
 	password string
-	Rpc jsonrpc.RPCClient
+
+	mu  sync.RWMutex
+	rpc jsonrpc.RPCClient
+}
+
+// Rpc returns the rpc client for the currently selected wallet
+func (e *ElementsdClient) Rpc() jsonrpc.RPCClient {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.rpc
 }
 
 type GetAddressInfo struct {
 	Unconfidential string `json:"unconfidential"`
 }
 func (e *ElementsdClient) GetNewAddress(addrType int) (string, error) {
-	res, err := e.Rpc.Call("getnewaddress")
+	res, err := e.Rpc().Call("getnewaddress")
 	if err != nil {
 		return "", err
 	}
@@ -31,7 +43,7 @@ func (e *ElementsdClient) GetNewAddress(addrType int) (string, error) {
 		return "", err
 	}
 	var addrInfo *GetAddressInfo
-	err = e.Rpc.CallFor(&addrInfo, "getaddressinfo", addr)
+	err = e.Rpc().CallFor(&addrInfo, "getaddressinfo", addr)
 	if err != nil {
 		return "", err
 	}
@@ -43,9 +55,12 @@ type FundRawtransactionRes struct {
 	TxHex string `json:"hex"`
 }
 
+// FundRawTransaction funds a transaction from the wallet, the selected inputs are locked until they are unlocked or spent
 func (e *ElementsdClient) FundRawTransaction(unfundedTxHex string) (string, error) {
 	var fundRes *FundRawtransactionRes
-	err := e.Rpc.CallFor(&fundRes, "fundrawtransaction", unfundedTxHex)
+	err := e.Rpc().CallFor(&fundRes, "fundrawtransaction", unfundedTxHex, map[string]interface{}{
+		"lockUnspents": true,
+	})
 	if err != nil {
 		return "", err
 	}
@@ -53,9 +68,86 @@ func (e *ElementsdClient) FundRawTransaction(unfundedTxHex string) (string, erro
 	return fundRes.TxHex, nil
 }
 
+type Outpoint struct {
+	TxId string `json:"txid"`
+	Vout uint32 `json:"vout"`
+}
+
+func (o Outpoint) String() string {
+	return fmt.Sprintf("%s:%v", o.TxId, o.Vout)
+}
+
+// LockUnspent locks or unlocks outpoints of the wallet for coin selection
+func (e *ElementsdClient) LockUnspent(unlock bool, outpoints []Outpoint) error {
+	var success bool
+	err := e.Rpc().CallFor(&success, "lockunspent", unlock, outpoints)
+	if err != nil {
+		return err
+	}
+	if !success {
+		return fmt.Errorf("unable to change lock of %v", outpoints)
+	}
+	return nil
+}
+
+// ListLockUnspent returns the currently locked outpoints
+func (e *ElementsdClient) ListLockUnspent() ([]Outpoint, error) {
+	var outpoints []Outpoint
+	err := e.Rpc().CallFor(&outpoints, "listlockunspent")
+	if err != nil {
+		return nil, err
+	}
+	return outpoints, nil
+}
+
+type DecodedTxIn struct {
+	TxId string `json:"txid"`
+	Vout uint32 `json:"vout"`
+}
+
+type DecodedScriptPubKey struct {
+	Hex       string   `json:"hex"`
+	Type      string   `json:"type"`
+	Address   string   `json:"address"`
+	Addresses []string `json:"addresses"`
+}
+
+// GetAddress returns the address of the script, older elementsd versions return a list of addresses
+func (d *DecodedScriptPubKey) GetAddress() string {
+	if d.Address != "" {
+		return d.Address
+	}
+	if len(d.Addresses) == 1 {
+		return d.Addresses[0]
+	}
+	return ""
+}
+
+type DecodedTxOut struct {
+	Value        json.Number          `json:"value"`
+	Asset        string               `json:"asset"`
+	N            uint32               `json:"n"`
+	ScriptPubKey *DecodedScriptPubKey `json:"scriptPubKey"`
+}
+
+type DecodedTx struct {
+	TxId string          `json:"txid"`
+	Vin  []*DecodedTxIn  `json:"vin"`
+	Vout []*DecodedTxOut `json:"vout"`
+}
+
+func (e *ElementsdClient) DecodeRawTransaction(txHex string) (*DecodedTx, error) {
+	var decodedTx *DecodedTx
+	err := e.Rpc().CallFor(&decodedTx, "decoderawtransaction", txHex)
+	if err != nil {
+		return nil, err
+	}
+	return decodedTx, nil
+}
+
 func (e *ElementsdClient) SignRawTransactionWithWallet(unsignedTxhex string) (string, error) {
 	var signRes *FundRawtransactionRes
-	err := e.Rpc.CallFor(&signRes, "signrawtransactionwithwallet", unsignedTxhex)
+	err := e.Rpc().CallFor(&signRes, "signrawtransactionwithwallet", unsignedTxhex)
 	if err != nil {
 		return "", err
 	}
@@ -65,7 +157,7 @@ func (e *ElementsdClient) SignRawTransactionWithWallet(unsignedTxhex string) (st
 
 func (e *ElementsdClient) BlindRawTransaction(unsignedTxhex string) (string, error) {
 	var signRes *string
-	err := e.Rpc.CallFor(&signRes, "blindrawtransaction", unsignedTxhex)
+	err := e.Rpc().CallFor(&signRes, "blindrawtransaction", unsignedTxhex)
 	if err != nil {
 		return "", err
 	}
@@ -73,11 +165,19 @@ func (e *ElementsdClient) BlindRawTransaction(unsignedTxhex string) (string, err
 	return *signRes, nil
 }
 
-func (e *ElementsdClient) SendToAddress(address string, asset string, amount string) (string, error) {
+// SendToAddress sends an amount of the asset to an address
+func (e *ElementsdClient) SendToAddress(address string, amount asset.AssetAmount) (string, error) {
+	return e.SendAssetToAddress(address, amount.RpcString(), amount.AssetId)
+}
+
+// SendAssetToAddress sends an rpc formatted amount to an address, the asset is given by hex id or by its elementsd label
+func (e *ElementsdClient) SendAssetToAddress(address string, amount string, assetLabel string) (string, error) {
 	params := map[string]interface{}{
-		"mempool_sequence": true,
+		"address":    address,
+		"amount":     json.Number(amount),
+		"assetlabel": assetLabel,
 	}
-	res, err := e.Rpc.Call("sendtoaddress", address, amount, params)
+	res, err := e.Rpc().Call("sendtoaddress", params)
 	if err != nil {
 		return "", err
 	}
@@ -87,35 +187,233 @@ func (e *ElementsdClient) SendToAddress(address string, asset string, amount str
 	return res.GetString()
 }
 
-type BalanceRes struct {
-	Assets map[string]float64 `json:"bitcoin"`
+// GetBalance returns the balance of an asset in base units
+func (e *ElementsdClient) GetBalance(assetEntry *asset.AssetEntry) (asset.AssetAmount, error) {
+	var balance json.Number
+	err := e.Rpc().CallFor(&balance, "getbalance", map[string]interface{}{
+		"assetlabel": assetEntry.AssetId,
+	})
+	if err != nil {
+		return asset.AssetAmount{}, err
+	}
+
+	baseUnits, err := asset.ParseRpcAmount(balance.String())
+	if err != nil {
+		return asset.AssetAmount{}, err
+	}
+	return assetEntry.NewAmount(baseUnits), nil
 }
-func (e *ElementsdClient) GetBalance(asset string) (float64, error) {
-	//var balanceRes *BalanceRes
-	res, err := e.Rpc.Call("getbalance")
+
+// GetBalances returns the balances of all assets in base units, indexed by asset id
+func (e *ElementsdClient) GetBalances() (map[string]uint64, error) {
+	var balanceRes map[string]json.Number
+	err := e.Rpc().CallFor(&balanceRes, "getbalance")
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	if res.Error != nil {
-		return 0, res.Error
+	labels, err := e.DumpAssetLabels()
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make(map[string]uint64)
+	for k, v := range balanceRes {
+		assetId := k
+		if id, ok := labels[k]; ok {
+			assetId = id
+		}
+		baseUnits, err := asset.ParseRpcAmount(v.String())
+		if err != nil {
+			return nil, err
+		}
+		balances[assetId] = baseUnits
+	}
+	return balances, nil
+}
+
+// DumpAssetLabels returns the asset ids of all labeled assets, indexed by label
+func (e *ElementsdClient) DumpAssetLabels() (map[string]string, error) {
+	var labels map[string]string
+	err := e.Rpc().CallFor(&labels, "dumpassetlabels")
+	if err != nil {
+		return nil, err
 	}
+	return labels, nil
+}
 
+type ListUnspentRes struct {
+	TxId          string      `json:"txid"`
+	Vout          uint32      `json:"vout"`
+	Address       string      `json:"address"`
+	ScriptPubKey  string      `json:"scriptPubKey"`
+	Amount        json.Number `json:"amount"`
+	Asset         string      `json:"asset"`
+	Confirmations int64       `json:"confirmations"`
+	Spendable     bool        `json:"spendable"`
+	Safe          bool        `json:"safe"`
+}
 
-	balanceMap := res.Result.(map[string]interface{})
-	var val interface{}
-	var ok bool
+// BaseUnits returns the amount of the unspent output in base units
+func (l *ListUnspentRes) BaseUnits() (uint64, error) {
+	return asset.ParseRpcAmount(l.Amount.String())
+}
 
-	if val, ok = balanceMap[asset]; !ok {
-		return 0, nil
+// ListUnspent returns the unspent outputs of an asset with at least minConf confirmations
+func (e *ElementsdClient) ListUnspent(assetId string, minConf int) ([]*ListUnspentRes, error) {
+	var unspents []*ListUnspentRes
+	err := e.Rpc().CallFor(&unspents, "listunspent", map[string]interface{}{
+		"minconf": minConf,
+		"query_options": map[string]interface{}{
+			"asset": assetId,
+		},
+	})
+	if err != nil {
+		return nil, err
 	}
-	var number json.Number
-	if number, ok = val.(json.Number); !ok {
-		return 0,nil
+	return unspents, nil
+}
+
+type ListTransactionsRes struct {
+	TxId          string          `json:"txid"`
+	Category      string          `json:"category"`
+	Amount        json.Number     `json:"amount"`
+	Asset         string          `json:"asset"`
+	Fee           json.RawMessage `json:"fee"`
+	Confirmations int64           `json:"confirmations"`
+	BlockHeight   uint32          `json:"blockheight"`
+	BlockTime     uint64          `json:"blocktime"`
+}
+
+// ListTransactions returns the wallet transaction entries of the count most recent transactions after
+// skipping skip entries, the entries are ordered oldest first as in elementsd
+func (e *ElementsdClient) ListTransactions(count int, skip int) ([]*ListTransactionsRes, error) {
+	var entries []*ListTransactionsRes
+	err := e.Rpc().CallFor(&entries, "listtransactions", "*", count, skip, true)
+	if err != nil {
+		return nil, err
 	}
+	return entries, nil
+}
 
+type BlockchainInfo struct {
+	Chain         string `json:"chain"`
+	Blocks        uint32 `json:"blocks"`
+	BestBlockHash string `json:"bestblockhash"`
+}
 
+func (e *ElementsdClient) GetBlockchainInfo() (*BlockchainInfo, error) {
+	var info *BlockchainInfo
+	err := e.Rpc().CallFor(&info, "getblockchaininfo")
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (e *ElementsdClient) GetBlockHash(height uint32) (string, error) {
+	var blockHash string
+	err := e.Rpc().CallFor(&blockHash, "getblockhash", height)
+	if err != nil {
+		return "", err
+	}
+	return blockHash, nil
+}
+
+type BlockHeaderRes struct {
+	Hash              string `json:"hash"`
+	Height            uint32 `json:"height"`
+	Confirmations     int64  `json:"confirmations"`
+	PreviousBlockHash string `json:"previousblockhash"`
+}
+
+// GetBlockHeader returns the header of a block, a block of a stale branch has -1 confirmations
+func (e *ElementsdClient) GetBlockHeader(blockHash string) (*BlockHeaderRes, error) {
+	var header *BlockHeaderRes
+	err := e.Rpc().CallFor(&header, "getblockheader", blockHash, true)
+	if err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+type RawTransactionRes struct {
+	TxId          string              `json:"txid"`
+	Hex           string              `json:"hex"`
+	Vin           []*RawTransactionIn `json:"vin"`
+	BlockHash     string              `json:"blockhash"`
+	Confirmations int64               `json:"confirmations"`
+}
+
+type RawTransactionIn struct {
+	TxId     string `json:"txid"`
+	Vout     uint32 `json:"vout"`
+	Coinbase string `json:"coinbase"`
+}
+
+type BlockRes struct {
+	Hash              string               `json:"hash"`
+	Height            uint32               `json:"height"`
+	PreviousBlockHash string               `json:"previousblockhash"`
+	Tx                []*RawTransactionRes `json:"tx"`
+}
+
+// GetBlock returns a block with its decoded transactions
+func (e *ElementsdClient) GetBlock(blockHash string) (*BlockRes, error) {
+	var block *BlockRes
+	err := e.Rpc().CallFor(&block, "getblock", blockHash, 2)
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (e *ElementsdClient) GetRawMempool() ([]string, error) {
+	var txIds []string
+	err := e.Rpc().CallFor(&txIds, "getrawmempool")
+	if err != nil {
+		return nil, err
+	}
+	return txIds, nil
+}
+
+// GetRawTransaction returns a decoded mempool transaction, confirmed transactions are only found with -txindex
+func (e *ElementsdClient) GetRawTransaction(txId string) (*RawTransactionRes, error) {
+	var tx *RawTransactionRes
+	err := e.Rpc().CallFor(&tx, "getrawtransaction", txId, true)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+type TxOutRes struct {
+	BestBlock     string `json:"bestblock"`
+	Confirmations int64  `json:"confirmations"`
+}
+
+// GetTxOut returns the unspent output, or nil if the output is spent or unknown
+func (e *ElementsdClient) GetTxOut(txId string, vout uint32, includeMempool bool) (*TxOutRes, error) {
+	var txOut *TxOutRes
+	err := e.Rpc().CallFor(&txOut, "gettxout", txId, vout, includeMempool)
+	if err != nil {
+		return nil, err
+	}
+	return txOut, nil
+}
+
+type IndexInfoRes struct {
+	Synced          bool   `json:"synced"`
+	BestBlockHeight uint32 `json:"best_block_height"`
+}
 
-	return number.Float64()
+// GetIndexInfo returns the optional indexes of elementsd by name, e.g. txindex
+func (e *ElementsdClient) GetIndexInfo() (map[string]*IndexInfoRes, error) {
+	var indexes map[string]*IndexInfoRes
+	err := e.Rpc().CallFor(&indexes, "getindexinfo")
+	if err != nil {
+		return nil, err
+	}
+	return indexes, nil
 }
 
 type WalletRes struct {
@@ -123,7 +421,7 @@ type WalletRes struct {
 }
 func (e *ElementsdClient) LoadWallet(filename string) (string, error) {
 	var walletRes *WalletRes
-	err := e.Rpc.CallFor(&walletRes, "loadwallet", filename)
+	err := e.Rpc().CallFor(&walletRes, "loadwallet", filename)
 	if err != nil {
 		return "", err
 	}
@@ -132,7 +430,7 @@ func (e *ElementsdClient) LoadWallet(filename string) (string, error) {
 
 func (e *ElementsdClient) CreateWallet(walletname string) (string, error) {
 	var walletRes *WalletRes
-	err := e.Rpc.CallFor(&walletRes, "createwallet", walletname)
+	err := e.Rpc().CallFor(&walletRes, "createwallet", walletname)
 	if err != nil {
 		return "", err
 	}
@@ -141,7 +439,7 @@ func (e *ElementsdClient) CreateWallet(walletname string) (string, error) {
 
 func (e *ElementsdClient) ListWallets() ([]string, error) {
 	wallets := []string{}
-	res, err := e.Rpc.Call("listwallets")
+	res, err := e.Rpc().Call("listwallets")
 	if err != nil {
 		return nil, err
 	}
@@ -155,7 +453,7 @@ func (e *ElementsdClient) ListWallets() ([]string, error) {
 
 func (e *ElementsdClient) SendRawTransaction(txHex string) (string, error) {
 	log.Printf("calling sendraw")
-	res, err := e.Rpc.Call("sendrawtransaction", txHex)
+	res, err := e.Rpc().Call("sendrawtransaction", txHex)
 	if err != nil {
 		return "", err
 	}
@@ -182,7 +480,7 @@ func NewElementsdClient(baseUrl, user, password string) (*ElementsdClient, error
 			"Authorization": string(authHeader),
 		},
 	})
-	return &ElementsdClient{Rpc: rpcClient, username: user, baseUrl: baseUrl, password: password}, nil
+	return &ElementsdClient{rpc: rpcClient, username: user, baseUrl: baseUrl, password: password}, nil
 }
 
 func (e *ElementsdClient) SetRpcWallet(walletname string) error {
@@ -197,11 +495,14 @@ func (e *ElementsdClient) SetRpcWallet(walletname string) error {
 	authHeader := []byte("Basic ")
 	authHeader = append(authHeader, []byte(authPairb64)...)
 
-	e.Rpc = jsonrpc.NewClientWithOpts(serviceURL.String(), &jsonrpc.RPCClientOpts{
+	rpcClient := jsonrpc.NewClientWithOpts(serviceURL.String(), &jsonrpc.RPCClientOpts{
 		CustomHeaders: map[string]string{
 			"Authorization": string(authHeader),
 		},
 	})
+	e.mu.Lock()
+	e.rpc = rpcClient
+	e.mu.Unlock()
 	return nil
 }
 
@@ -212,16 +513,24 @@ var (
 )
 
 
-// ElementsRpcWallet uses the elementsd rpc wallet
+// ElementsRpcWallet uses the elementsd rpc wallet, it is safe to fund transactions concurrently
 type ElementsRpcWallet struct {
 	walletName string
 	rpcClient  *ElementsdClient
+
+	leaser *utxoLeaser
+	// usePset selects the walletcreatefundedpsbt workflow instead of fundrawtransaction
+	usePset bool
+
+	swapLabels *SwapLabels
 }
 
 func NewRpcWallet(rpcClient *ElementsdClient, walletName string) (*ElementsRpcWallet, error) {
 	rpcWallet := &ElementsRpcWallet{
 		walletName: walletName,
 		rpcClient:  rpcClient,
+		leaser:     newUtxoLeaser(rpcClient, DefaultLeaseTimeout),
+		swapLabels: NewSwapLabels(),
 	}
 	err := rpcWallet.setupWallet()
 	if err != nil {
@@ -263,11 +572,11 @@ func (r *ElementsRpcWallet) setupWallet() error {
 	return nil
 }
 
-// GetBalance returns the balance in sats
-func (r *ElementsRpcWallet) GetBalance(asset string) (float64, error) {
-	balance, err := r.rpcClient.GetBalance(asset)
+// GetBalance returns the balance in base units of the asset
+func (r *ElementsRpcWallet) GetBalance(assetEntry *asset.AssetEntry) (asset.AssetAmount, error) {
+	balance, err := r.rpcClient.GetBalance(assetEntry)
 	if err != nil {
-		return 0, err
+		return asset.AssetAmount{}, err
 	}
 	return balance, nil
 }
@@ -282,39 +591,96 @@ func (r *ElementsRpcWallet) GetAddress() (string, error) {
 }
 
 // SendToAddress sends an amount to an address
-func (r *ElementsRpcWallet) SendToAddress(address string, amount uint64, asset string) (string, error) {
-	txId, err := r.rpcClient.SendToAddress(address, satsToAmountString(amount), asset)
+func (r *ElementsRpcWallet) SendToAddress(address string, amount asset.AssetAmount) (string, error) {
+	txId, err := r.rpcClient.SendToAddress(address, amount)
 	if err != nil {
 		return "", err
 	}
 	return txId, nil
 }
 
+// GetBalances returns the balances of all assets in base units, indexed by asset id
+func (r *ElementsRpcWallet) GetBalances() (map[string]uint64, error) {
+	return r.rpcClient.GetBalances()
+}
+
+// ListUnspent returns the unspent outputs of an asset
+func (r *ElementsRpcWallet) ListUnspent(assetId string, minConf int) ([]*ListUnspentRes, error) {
+	return r.rpcClient.ListUnspent(assetId, minConf)
+}
+
+// GetAssetIdByLabel returns the asset id of an asset labeled in elementsd
+func (r *ElementsRpcWallet) GetAssetIdByLabel(label string) (string, error) {
+	labels, err := r.rpcClient.DumpAssetLabels()
+	if err != nil {
+		return "", err
+	}
+	assetId, ok := labels[label]
+	if !ok {
+		return "", fmt.Errorf("unknown asset label %s", label)
+	}
+	return assetId, nil
+}
+
+// FundAndSignRawTransaction funds, blinds and signs a transaction. The selected inputs stay leased
+// until the transaction is broadcast via SendRawTransaction, released or the lease expires
 func (r *ElementsRpcWallet) FundAndSignRawTransaction(unfundedRawTx string) (string, error) {
+	if r.usePset {
+		return r.fundAndSignPset(unfundedRawTx)
+	}
 	fundedTx, err := r.rpcClient.FundRawTransaction(unfundedRawTx)
 	if err != nil {
 		return "", err
 	}
+	err = r.leaser.leaseTx(fundedTx)
+	if err != nil {
+		// fundrawtransaction already locked the inputs
+		if unlockErr := r.unlockTxInputs(fundedTx); unlockErr != nil {
+			log.Printf("unable to unlock the inputs of the funded transaction: %v", unlockErr)
+		}
+		return "", err
+	}
 
 	blindedRawTx, err := r.rpcClient.BlindRawTransaction(fundedTx)
 	if err != nil {
+		_ = r.leaser.release(fundedTx)
 		return "", err
 	}
 	signedTx, err := r.rpcClient.SignRawTransactionWithWallet(blindedRawTx)
 	if err != nil {
+		_ = r.leaser.release(fundedTx)
 		return "", err
 	}
 
 	return signedTx, nil
 }
 
-func (r *ElementsRpcWallet) SendRawTransaction(txHex string) (string, error) {
-	return r.rpcClient.SendRawTransaction(txHex)
+// unlockTxInputs unlocks the inputs of a funded transaction that could not be leased, the transaction is decoded
+// by elementsd as it may not be parseable locally
+func (r *ElementsRpcWallet) unlockTxInputs(txHex string) error {
+	decodedTx, err := r.rpcClient.DecodeRawTransaction(txHex)
+	if err != nil {
+		return err
+	}
+	var outpoints []Outpoint
+	for _, v := range decodedTx.Vin {
+		outpoints = append(outpoints, Outpoint{TxId: v.TxId, Vout: v.Vout})
+	}
+	return r.rpcClient.LockUnspent(true, outpoints)
 }
 
-// satsToAmountString returns the amount in btc from sats
-func satsToAmountString(sats uint64) string {
-	bitcoinAmt := float64(sats) / 100000000
-	return fmt.Sprintf("%f", bitcoinAmt)
+// ReleaseInputs unlocks the leased inputs of a funded transaction that will not be broadcast
+func (r *ElementsRpcWallet) ReleaseInputs(txHex string) error {
+	return r.leaser.release(txHex)
+}
+
+func (r *ElementsRpcWallet) SendRawTransaction(txHex string) (string, error) {
+	txId, err := r.rpcClient.SendRawTransaction(txHex)
+	if err != nil {
+		_ = r.leaser.release(txHex)
+		return "", err
+	}
+	_ = r.leaser.complete(txHex)
+	return txId, nil
 }
 
@@ -0,0 +1,281 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/pset"
+)
+
+const (
+	psetGlobalInputCount = 0x04
+	psetInPreviousTxId   = 0x0e
+	psetInOutputIndex    = 0x0f
+)
+
+type WalletCreateFundedPsbtRes struct {
+	Psbt      string      `json:"psbt"`
+	Fee       json.Number `json:"fee"`
+	ChangePos int         `json:"changepos"`
+}
+
+// WalletCreateFundedPsbt creates a pset v2 paying to the outputs and funded from the wallet, the selected inputs are locked
+func (e *ElementsdClient) WalletCreateFundedPsbt(outputs []map[string]interface{}) (*WalletCreateFundedPsbtRes, error) {
+	var fundRes *WalletCreateFundedPsbtRes
+	err := e.Rpc().CallFor(&fundRes, "walletcreatefundedpsbt", []interface{}{}, outputs, 0, map[string]interface{}{
+		"lockUnspents": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fundRes, nil
+}
+
+type PsbtRes struct {
+	Psbt     string `json:"psbt"`
+	Hex      string `json:"hex"`
+	Complete bool   `json:"complete"`
+}
+
+// WalletProcessPsbt fills in the wallet data of a pset and blinds it, inputs are only signed if sign is set
+func (e *ElementsdClient) WalletProcessPsbt(pset string, sign bool) (*PsbtRes, error) {
+	var processRes *PsbtRes
+	err := e.Rpc().CallFor(&processRes, "walletprocesspsbt", pset, sign, "ALL")
+	if err != nil {
+		return nil, err
+	}
+	return processRes, nil
+}
+
+// FinalizePsbt finalizes the inputs of a pset and extracts the transaction if it is complete
+func (e *ElementsdClient) FinalizePsbt(pset string) (*PsbtRes, error) {
+	var finalizeRes *PsbtRes
+	err := e.Rpc().CallFor(&finalizeRes, "finalizepsbt", pset, true)
+	if err != nil {
+		return nil, err
+	}
+	return finalizeRes, nil
+}
+
+type decodedPsbtInput struct {
+	PreviousTxId string `json:"previous_txid"`
+	PreviousVout uint32 `json:"previous_vout"`
+}
+
+type decodedPsbt struct {
+	Inputs []*decodedPsbtInput `json:"inputs"`
+}
+
+// GetPsbtInputs returns the outpoints spent by a pset v2 as decoded by elementsd
+func (e *ElementsdClient) GetPsbtInputs(pset string) ([]Outpoint, error) {
+	var decoded *decodedPsbt
+	err := e.Rpc().CallFor(&decoded, "decodepsbt", pset)
+	if err != nil {
+		return nil, err
+	}
+	var outpoints []Outpoint
+	for _, v := range decoded.Inputs {
+		outpoints = append(outpoints, Outpoint{TxId: v.PreviousTxId, Vout: v.PreviousVout})
+	}
+	return outpoints, nil
+}
+
+// psetInputOutpoints decodes the outpoints spent by a pset locally. go-elements decodes psets that carry the unsigned
+// transaction, the inputs of a pset v2 as created by elementsd 0.21 are read from its input maps
+func psetInputOutpoints(psetBase64 string) ([]Outpoint, error) {
+	p, err := pset.NewPsetFromBase64(psetBase64)
+	if err == nil {
+		var outpoints []Outpoint
+		for _, v := range p.UnsignedTx.Inputs {
+			outpoints = append(outpoints, Outpoint{
+				TxId: b2h(elementsutil.ReverseBytes(v.Hash)),
+				Vout: v.Index,
+			})
+		}
+		return outpoints, nil
+	}
+	psetBytes, err := base64.StdEncoding.DecodeString(psetBase64)
+	if err != nil {
+		return nil, err
+	}
+	return psetV2InputOutpoints(psetBytes)
+}
+
+func psetV2InputOutpoints(psetBytes []byte) ([]Outpoint, error) {
+	r := bytes.NewReader(psetBytes)
+	magic := make([]byte, 5)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "pset\xff" {
+		return nil, errors.New("invalid pset magic")
+	}
+	global, err := readPsetMap(r)
+	if err != nil {
+		return nil, err
+	}
+	inputCount, ok := global[psetGlobalInputCount]
+	if !ok {
+		return nil, errors.New("pset has no input count")
+	}
+	count, err := wire.ReadVarInt(bytes.NewReader(inputCount), 0)
+	if err != nil {
+		return nil, err
+	}
+	var outpoints []Outpoint
+	for i := uint64(0); i < count; i++ {
+		input, err := readPsetMap(r)
+		if err != nil {
+			return nil, err
+		}
+		txId, index := input[psetInPreviousTxId], input[psetInOutputIndex]
+		if len(txId) != 32 || len(index) != 4 {
+			return nil, fmt.Errorf("pset input %v has no previous outpoint", i)
+		}
+		outpoints = append(outpoints, Outpoint{
+			TxId: b2h(elementsutil.ReverseBytes(txId)),
+			Vout: binary.LittleEndian.Uint32(index),
+		})
+	}
+	return outpoints, nil
+}
+
+// readPsetMap reads a pset key value map and returns the values of the keys without key data, indexed by key type
+func readPsetMap(r *bytes.Reader) (map[byte][]byte, error) {
+	values := make(map[byte][]byte)
+	for {
+		keyLen, err := wire.ReadVarInt(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		if keyLen == 0 {
+			return values, nil
+		}
+		if keyLen > uint64(r.Len()) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		key := make([]byte, keyLen)
+		if _, err := io.ReadFull(r, key); err != nil {
+			return nil, err
+		}
+		value, err := wire.ReadVarBytes(r, 0, uint32(r.Len()), "pset value")
+		if err != nil {
+			return nil, err
+		}
+		if keyLen == 1 {
+			values[key[0]] = value
+		}
+	}
+}
+
+// UsePsetFunding switches FundAndSignRawTransaction to the pset workflow
+func (r *ElementsRpcWallet) UsePsetFunding(usePset bool) {
+	r.usePset = usePset
+}
+
+// FundPset converts the outputs of an unfunded raw transaction into a pset that is funded and blinded,
+// but not signed by the wallet. elementsd locks the inputs while funding, they are decoded from the pset
+// and leased like in FundAndSignRawTransaction
+func (r *ElementsRpcWallet) FundPset(unfundedRawTx string) (string, error) {
+	decodedTx, err := r.rpcClient.DecodeRawTransaction(unfundedRawTx)
+	if err != nil {
+		return "", err
+	}
+	var outputs []map[string]interface{}
+	for _, v := range decodedTx.Vout {
+		if v.ScriptPubKey == nil || v.ScriptPubKey.GetAddress() == "" {
+			return "", fmt.Errorf("output %v has no address", v.N)
+		}
+		if v.Asset == "" {
+			return "", fmt.Errorf("output %v is blinded", v.N)
+		}
+		outputs = append(outputs, map[string]interface{}{
+			v.ScriptPubKey.GetAddress(): v.Value,
+			"asset":                     v.Asset,
+		})
+	}
+
+	fundRes, err := r.rpcClient.WalletCreateFundedPsbt(outputs)
+	if err != nil {
+		return "", err
+	}
+	inputs, err := psetInputOutpoints(fundRes.Psbt)
+	if err != nil {
+		// walletcreatefundedpsbt already locked the inputs
+		if unlockErr := r.unlockPsetInputs(fundRes.Psbt); unlockErr != nil {
+			log.Printf("unable to unlock the inputs of the funded pset: %v", unlockErr)
+		}
+		return "", fmt.Errorf("unable to decode the funded pset: %w", err)
+	}
+	r.leaser.lease(inputs)
+
+	blindRes, err := r.rpcClient.WalletProcessPsbt(fundRes.Psbt, false)
+	if err != nil {
+		_ = r.leaser.finish(inputs, true)
+		return "", err
+	}
+	return blindRes.Psbt, nil
+}
+
+// SignPset signs the wallet inputs of a pset
+func (r *ElementsRpcWallet) SignPset(pset string) (string, error) {
+	signRes, err := r.rpcClient.WalletProcessPsbt(pset, true)
+	if err != nil {
+		return "", err
+	}
+	return signRes.Psbt, nil
+}
+
+// FinalizePset returns the raw transaction of a fully signed pset
+func (r *ElementsRpcWallet) FinalizePset(pset string) (string, error) {
+	finalizeRes, err := r.rpcClient.FinalizePsbt(pset)
+	if err != nil {
+		return "", err
+	}
+	if !finalizeRes.Complete {
+		return "", errors.New("pset is not fully signed")
+	}
+	return finalizeRes.Hex, nil
+}
+
+// ReleasePset unlocks the leased inputs of a pset that will not be broadcast
+func (r *ElementsRpcWallet) ReleasePset(pset string) error {
+	inputs, err := psetInputOutpoints(pset)
+	if err != nil {
+		return err
+	}
+	return r.leaser.finish(inputs, true)
+}
+
+// unlockPsetInputs unlocks the inputs of a funded pset that could not be decoded locally, as decoded by elementsd
+func (r *ElementsRpcWallet) unlockPsetInputs(pset string) error {
+	inputs, err := r.rpcClient.GetPsbtInputs(pset)
+	if err != nil {
+		return err
+	}
+	return r.rpcClient.LockUnspent(true, inputs)
+}
+
+// fundAndSignPset funds, blinds and signs an unfunded raw transaction using the pset workflow
+func (r *ElementsRpcWallet) fundAndSignPset(unfundedRawTx string) (string, error) {
+	pset, err := r.FundPset(unfundedRawTx)
+	if err != nil {
+		return "", err
+	}
+	signedPset, err := r.SignPset(pset)
+	if err != nil {
+		_ = r.ReleasePset(pset)
+		return "", err
+	}
+	txHex, err := r.FinalizePset(signedPset)
+	if err != nil {
+		_ = r.ReleasePset(pset)
+		return "", err
+	}
+	return txHex, nil
+}
@@ -0,0 +1,150 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/pset"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+// newTestPset returns a pset with the unsigned transaction spending the outpoint, as decoded by go-elements
+func newTestPset(t *testing.T, outpoint Outpoint) string {
+	hash, err := hex.DecodeString(outpoint.TxId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := pset.New([]*transaction.TxInput{transaction.NewTxInput(elementsutil.ReverseBytes(hash), outpoint.Vout)}, nil, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	psetBase64, err := p.ToBase64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return psetBase64
+}
+
+// newTestPsetV2 returns a pset v2 spending the outpoint, as created by elementsd 0.21
+func newTestPsetV2(t *testing.T, outpoint Outpoint) string {
+	hash, err := hex.DecodeString(outpoint.TxId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	writePair := func(key []byte, value []byte) {
+		b.WriteByte(byte(len(key)))
+		b.Write(key)
+		b.WriteByte(byte(len(value)))
+		b.Write(value)
+	}
+	index := make([]byte, 4)
+	binary.LittleEndian.PutUint32(index, outpoint.Vout)
+
+	b.WriteString("pset\xff")
+	writePair([]byte{0x02}, []byte{2, 0, 0, 0})
+	writePair([]byte{0x04}, []byte{1})
+	writePair([]byte{0x05}, []byte{0})
+	writePair([]byte{0xfb}, []byte{2, 0, 0, 0})
+	b.WriteByte(0)
+	writePair([]byte{0x01}, make([]byte, 42))
+	writePair([]byte{0x0e}, elementsutil.ReverseBytes(hash))
+	writePair([]byte{0x0f}, index)
+	// proprietary keys carry key data and are skipped
+	writePair(append([]byte{0xfc, 0x08}, []byte("elements")...), []byte{1})
+	b.WriteByte(0)
+	return base64.StdEncoding.EncodeToString(b.Bytes())
+}
+
+func TestPsetInputOutpoints(t *testing.T) {
+	input := testOutpoint(4)
+	for _, psetBase64 := range []string{newTestPset(t, input), newTestPsetV2(t, input)} {
+		outpoints, err := psetInputOutpoints(psetBase64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(outpoints, []Outpoint{input}) {
+			t.Fatalf("got inputs %v, want %v", outpoints, input)
+		}
+	}
+	if _, err := psetInputOutpoints(base64.StdEncoding.EncodeToString([]byte("pset\xff\x00"))); err == nil {
+		t.Fatal("expected the pset without inputs count to be rejected")
+	}
+}
+
+func TestFundPsetLeasesDecodedInputs(t *testing.T) {
+	input := Outpoint{TxId: "6b5f7a5d3b1f6d4f0e0c8b3d6a7e0e5c2b1a9f8e7d6c5b4a3928170605040302", Vout: 1}
+	tests := []struct {
+		name       string
+		psbt       string
+		processErr error
+		unlocked   []Outpoint
+		leased     bool
+	}{
+		{"funded", newTestPset(t, input), nil, nil, true},
+		{"funded pset v2", newTestPsetV2(t, input), nil, nil, true},
+		{"pset not decodable locally", base64.StdEncoding.EncodeToString([]byte("pset\xff")), nil, []Outpoint{input}, false},
+		{"blinding fails", newTestPset(t, input), errors.New("Unable to blind"), []Outpoint{input}, false},
+	}
+	for _, tt := range tests {
+		rpc, unlocked := newLockRpc()
+		rpc.handlers["decoderawtransaction"] = func(params []json.RawMessage) (interface{}, error) {
+			return json.RawMessage(`{"txid": "unfunded", "vout": [{"value": 0.001, "asset": "` + testAssetId + `", "n": 0, "scriptPubKey": {"address": "ert1q6rz28mcfaxtmd6v789l9rrlrusdprr9p69dllk"}}]}`), nil
+		}
+		rpc.handlers["walletcreatefundedpsbt"] = func(params []json.RawMessage) (interface{}, error) {
+			var options map[string]bool
+			json.Unmarshal(params[3], &options)
+			if !options["lockUnspents"] {
+				t.Errorf("%s: walletcreatefundedpsbt does not lock the inputs", tt.name)
+			}
+			return map[string]interface{}{"psbt": tt.psbt, "fee": 0.00000300, "changepos": 1}, nil
+		}
+		rpc.handlers["decodepsbt"] = func(params []json.RawMessage) (interface{}, error) {
+			return json.RawMessage(`{"inputs": [{"previous_txid": "` + input.TxId + `", "previous_vout": 1}]}`), nil
+		}
+		rpc.handlers["walletprocesspsbt"] = func(params []json.RawMessage) (interface{}, error) {
+			if tt.processErr != nil {
+				return nil, tt.processErr
+			}
+			return map[string]interface{}{"psbt": "blinded", "complete": false}, nil
+		}
+		rpcClient := newFakeRpcClient(t, rpc)
+		r := &ElementsRpcWallet{rpcClient: rpcClient, leaser: newUtxoLeaser(rpcClient, time.Hour)}
+
+		blinded, err := r.FundPset("unfunded")
+		if tt.leased {
+			if err != nil || blinded != "blinded" {
+				t.Fatalf("%s: got pset %s, %v", tt.name, blinded, err)
+			}
+		} else if err == nil {
+			t.Fatalf("%s: expected an error", tt.name)
+		}
+		if got := unlocked(); !reflect.DeepEqual(got, tt.unlocked) {
+			t.Fatalf("%s: unlocked %v, want %v", tt.name, got, tt.unlocked)
+		}
+		if n := r.leaser.leaseCount(); (n == 1) != tt.leased {
+			t.Fatalf("%s: got %v leased inputs, leased = %v", tt.name, n, tt.leased)
+		}
+		if tt.leased {
+			// the inputs of a pset that will not be broadcast are decoded locally to release them
+			err = r.ReleasePset(tt.psbt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := unlocked(); !reflect.DeepEqual(got, []Outpoint{input}) || r.leaser.leaseCount() != 0 {
+				t.Fatalf("%s: released %v, want %v", tt.name, got, input)
+			}
+		}
+		if rpc.methodCalls("decodepsbt") > 0 && tt.leased {
+			t.Fatalf("%s: decoded a locally decodable pset with elementsd", tt.name)
+		}
+	}
+}
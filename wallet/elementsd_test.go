@@ -0,0 +1,140 @@
+package wallet
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
+	"github.com/vulpemventures/go-elements/network"
+)
+
+const testAssetId = "f3d1ec678811398cd2ae277cbe3849c6f6dbd72c74bc542f7c4b11ff0e820958"
+
+// fakeRpc serves json rpc methods from handlers and records the calls. Named parameters are passed to the handler
+// as its only parameter
+type fakeRpc struct {
+	mu       sync.Mutex
+	handlers map[string]func(params []json.RawMessage) (interface{}, error)
+	calls    []string
+}
+
+func (f *fakeRpc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Id     int             `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var params []json.RawMessage
+	if strings.HasPrefix(string(req.Params), "{") {
+		params = []json.RawMessage{req.Params}
+	} else if len(req.Params) > 0 {
+		err = json.Unmarshal(req.Params, &params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	f.mu.Lock()
+	f.calls = append(f.calls, req.Method)
+	handler, ok := f.handlers[req.Method]
+	f.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown method "+req.Method, http.StatusNotFound)
+		return
+	}
+	res := map[string]interface{}{"jsonrpc": "2.0", "id": req.Id}
+	result, err := handler(params)
+	if err != nil {
+		res["error"] = map[string]interface{}{"code": -22, "message": err.Error()}
+	} else {
+		res["result"] = result
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (f *fakeRpc) methodCalls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, v := range f.calls {
+		if v == method {
+			n++
+		}
+	}
+	return n
+}
+
+// newFakeRpcClient returns an elementsd client of the fake rpc server
+func newFakeRpcClient(t *testing.T, rpc *fakeRpc) *ElementsdClient {
+	server := httptest.NewServer(rpc)
+	t.Cleanup(server.Close)
+	rpcClient, err := NewElementsdClient(strings.TrimPrefix(server.URL, "http://"), "user", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rpcClient
+}
+
+func TestElementsdClientNamedParams(t *testing.T) {
+	params := make(map[string]map[string]interface{})
+	handler := func(method string, result interface{}) func(p []json.RawMessage) (interface{}, error) {
+		return func(p []json.RawMessage) (interface{}, error) {
+			if len(p) != 1 {
+				t.Errorf("%s: got %v positional params, want named params", method, len(p))
+				return nil, nil
+			}
+			var named map[string]interface{}
+			d := json.NewDecoder(strings.NewReader(string(p[0])))
+			d.UseNumber()
+			if err := d.Decode(&named); err != nil {
+				t.Errorf("%s: %v", method, err)
+			}
+			params[method] = named
+			return result, nil
+		}
+	}
+	rpc := &fakeRpc{handlers: map[string]func(params []json.RawMessage) (interface{}, error){
+		"sendtoaddress": handler("sendtoaddress", "txid"),
+		"getbalance":    handler("getbalance", json.Number("0.00012345")),
+		"listunspent": handler("listunspent", json.RawMessage(`[{"txid": "txid", "vout": 1, "amount": 0.5, "asset": "`+
+			testAssetId+`", "confirmations": 2, "spendable": true, "safe": true}]`)),
+	}}
+	rpcClient := newFakeRpcClient(t, rpc)
+
+	txId, err := rpcClient.SendToAddress("address", asset.NewAssetAmount(testAssetId, 8, 150000001))
+	if err != nil || txId != "txid" {
+		t.Fatalf("got %s, %v", txId, err)
+	}
+	balance, err := rpcClient.GetBalance(&asset.AssetEntry{AssetId: network.Regtest.AssetID, Ticker: "L-BTC", Precision: 8})
+	if err != nil || balance.Amount != 12345 || balance.AssetId != network.Regtest.AssetID {
+		t.Fatalf("got balance %+v, %v", balance, err)
+	}
+	unspents, err := rpcClient.ListUnspent(testAssetId, 1)
+	if err != nil || len(unspents) != 1 {
+		t.Fatalf("got unspents %+v, %v", unspents, err)
+	}
+	if baseUnits, err := unspents[0].BaseUnits(); err != nil || baseUnits != 50000000 {
+		t.Fatalf("got %v base units, %v", baseUnits, err)
+	}
+
+	want := map[string]map[string]interface{}{
+		"sendtoaddress": {"address": "address", "amount": json.Number("1.50000001"), "assetlabel": testAssetId},
+		"getbalance":    {"assetlabel": network.Regtest.AssetID},
+		"listunspent": {"minconf": json.Number("1"), "query_options": map[string]interface{}{
+			"asset": testAssetId,
+		}},
+	}
+	if !reflect.DeepEqual(params, want) {
+		t.Fatalf("got named params %v, want %v", params, want)
+	}
+}
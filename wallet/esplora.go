@@ -4,17 +4,21 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sync"
+
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcutil"
-	"github.com/btcsuite/btcutil/coinset"
 	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
 	elemaddr "github.com/vulpemventures/go-elements/address"
 	"github.com/vulpemventures/go-elements/elementsutil"
 	"github.com/vulpemventures/go-elements/network"
 	"github.com/vulpemventures/go-elements/payment"
+	"github.com/vulpemventures/go-elements/slip77"
 	"github.com/vulpemventures/go-elements/transaction"
 )
 
@@ -37,6 +41,18 @@ type EsploraUtxo struct {
 	Asset string `json:"Asset"`
 	Status *Status `json:"Status"`
 	Address string
+
+	// commitments of confidential outputs, the value and asset are filled in after unblinding
+	ValueCommitment string `json:"valuecommitment,omitempty"`
+	AssetCommitment string `json:"assetcommitment,omitempty"`
+	NonceCommitment string `json:"noncecommitment,omitempty"`
+	ValueBlinder string `json:"value_blinder,omitempty"`
+	AssetBlinder string `json:"asset_blinder,omitempty"`
+}
+
+// IsConfidential returns true if the output is blinded
+func (e *EsploraUtxo) IsConfidential() bool {
+	return e.ValueCommitment != ""
 }
 
 func (e *EsploraUtxo) Hash() *chainhash.Hash {
@@ -49,8 +65,7 @@ func (e *EsploraUtxo) Index() uint32 {
 }
 
 func (e *EsploraUtxo) Value() btcutil.Amount {
-	amt, _ := btcutil.NewAmount(float64(e.SatAmt))
-	return amt
+	return btcutil.Amount(e.SatAmt)
 }
 
 func (e *EsploraUtxo) PkScript() []byte {
@@ -58,14 +73,6 @@ func (e *EsploraUtxo) PkScript() []byte {
 	return pkScript
 }
 
-func (e *EsploraUtxo) NumConfs() int64 {
-	return 1
-}
-
-func (e *EsploraUtxo) ValueAge() int64 {
-	return 0
-}
-
 func (e *EsploraUtxo) String() string {
 	bytes,_ := json.Marshal(e)
 	return string(bytes)
@@ -78,6 +85,8 @@ type Status struct {
 }
 
 type AddressInfo struct {
+	Account uint32
+	Branch uint32
 	Derivation uint32
 	Address string
 	Utxos []*EsploraUtxo
@@ -87,324 +96,504 @@ type EsploraApi interface {
 	GetUtxosFromAddress(address string) ([]*EsploraUtxo, error)
 	PostRawtransaction(rawTx string) (string, error)
 	GetAddressStats(address string) (*AddressStats, error)
+	GetTxHex(txId string) (string, error)
+	GetBlockHeight() (uint32, error)
+	// GetAddressTxs returns the mempool and the first confirmed transactions of an address,
+	// or the confirmed transactions after lastSeenTxId
+	GetAddressTxs(address string, lastSeenTxId string) ([]*EsploraTx, error)
 }
 
-
 type LiquidWallet struct {
 	esplora EsploraApi
 
 	chaincfg *chaincfg.Params
 	liquidNetwork *network.Network
 
-	addressesWithUtxos []string
-
-	unblindedAddrKey *hdkeychain.ExtendedKey
-
-	blindedAddrKey *hdkeychain.ExtendedKey
-	blinderKeys *hdkeychain.ExtendedKey
+	scheme *DerivationScheme
+	masterKey *hdkeychain.ExtendedKey
+	// watchOnlyKey is the account xpub of watch-only wallets, which have no master key
+	watchOnlyKey *hdkeychain.ExtendedKey
+	// watchOnlyOrigin is the key origin of the watch-only account key, if it is known
+	watchOnlyOrigin *keyOrigin
+	keyMu sync.Mutex
+	branchKeys map[branchId]*hdkeychain.ExtendedKey
+
+	// masterBlindingKey derives the slip77 blinding keys of the wallet scripts
+	masterBlindingKey *slip77.Slip77
+
+	// mu guards the sync state, the address map and the utxos
+	mu sync.Mutex
+	store WalletStore
+	state *SyncState
+	// synced is set after the first sync of the loaded state checked every address
+	synced bool
+	gapLimit int
+	syncConcurrency int
 
 	addressToUtxoMap map[string]*AddressInfo
 	utxos []*EsploraUtxo
+	tipHeight uint32
+
+	feeRate float64
+	minConfirmations uint32
+
+	swapLabels *SwapLabels
 
 	lbtcAsset []byte
 }
 
 func NewLiquidWallet(esplora EsploraApi, chaincfg *chaincfg.Params, liquidNetwork *network.Network) *LiquidWallet {
-	return &LiquidWallet{esplora: esplora, chaincfg: chaincfg, liquidNetwork: liquidNetwork, lbtcAsset: append(
-	[]byte{0x01},
-		elementsutil.ReverseBytes(h2b(liquidNetwork.AssetID))...,
-	)}
+	return &LiquidWallet{
+		esplora: esplora,
+		chaincfg: chaincfg,
+		liquidNetwork: liquidNetwork,
+		scheme: LegacyDerivation,
+		branchKeys: make(map[branchId]*hdkeychain.ExtendedKey),
+		store: NewMemoryStore(),
+		gapLimit: DefaultGapLimit,
+		syncConcurrency: DefaultSyncConcurrency,
+		feeRate: DefaultFeeRate,
+		minConfirmations: DefaultMinConfirmations,
+		swapLabels: NewSwapLabels(),
+		lbtcAsset: append(
+			[]byte{0x01},
+			elementsutil.ReverseBytes(h2b(liquidNetwork.AssetID))...,
+		),
+	}
 }
 
 func (l *LiquidWallet) Initialize(seed []byte) error {
-	baseKey, err := l.getBasekey(seed)
+	err := l.InitializeOffline(seed)
 	if err != nil {
 		return err
 	}
 
-	// Derive the extended key for the account unblinded addresses.  This gives
-	// the path:
-	//   m/0H/0
-	unblindedAddrKey, err := baseKey.Derive(0)
+	err = l.loadSyncState()
 	if err != nil {
 		return err
 	}
-	l.unblindedAddrKey = unblindedAddrKey
 
-	// Derive the extended key for the account unblinded addresses.  This gives
-	// the path:
-	//   m/0H/1
-	blindedAddrKey, err := baseKey.Derive(1)
+	err = l.Sync()
 	if err != nil {
 		return err
 	}
-	l.blindedAddrKey = blindedAddrKey
 
-	// Derive the extended key for the account blinding keys.  This gives
-	// the path:
-	//   m/0H/2
-	blinderKeys, err := baseKey.Derive(2)
+	return nil
+}
+
+// InitializeOffline derives the wallet keys from the seed without loading or syncing the wallet state,
+// which is enough to sign psets on an offline machine
+func (l *LiquidWallet) InitializeOffline(seed []byte) error {
+	// Generate a new master node using the seed, the address keys are derived
+	// from it by the derivation scheme
+	masterKey, err := hdkeychain.NewMaster(seed, l.chaincfg)
 	if err != nil {
 		return err
 	}
-	l.blindedAddrKey = blinderKeys
-
-	l.addressToUtxoMap = make(map[string]*AddressInfo)
-	l.utxos = []*EsploraUtxo{}
+	l.masterKey = masterKey
 
-	err = l.resetUtxos()
+	// The blinding keys of the addresses are derived from the seed as in slip77
+	masterBlindingKey, err := slip77.FromSeed(seed)
 	if err != nil {
 		return err
 	}
-
+	l.masterBlindingKey = masterBlindingKey
 	return nil
 }
 
-// getBasekey returns the basekey for the wallet from a given bit39 seed
-func (l *LiquidWallet) getBasekey(seed []byte) (*hdkeychain.ExtendedKey, error) {
-	// Generate a new master node using the seed.
-	masterKey, err := hdkeychain.NewMaster(seed, l.chaincfg)
+// GetUtxos syncs the wallet and returns its utxos
+func (l *LiquidWallet) GetUtxos() ([]*EsploraUtxo, error) {
+	err := l.Sync()
 	if err != nil {
 		return nil, err
 	}
+	// the utxos are replaced by concurrent syncs
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*EsploraUtxo{}, l.utxos...), nil
+}
 
-	// Derive the extended key for account 0.  This gives the path:
-	//   m/0H
-	acct0, err := masterKey.Derive(hdkeychain.HardenedKeyStart + 0)
+// GetAddress returns the first unused address after the last used one
+func (l *LiquidWallet) GetAddress() (string, error) {
+	addrState, err := l.nextUnusedAddress(ReceiveBranch)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-
-	return acct0, nil
+	return addrState.Address, nil
 }
 
-// getSpendAddresses returns all addresses with utxos on them, as well as the current address with no funds
-func (l *LiquidWallet) getSpendAddresses(depth int) (map[string]*AddressInfo,[]*EsploraUtxo, error) {
-	startingDerivation := uint32(0)
-	addrToInfoMap := make(map[string]*AddressInfo)
-	var allUtxos []*EsploraUtxo
-	for i := 0; i < depth; i++  {
-		addr, err := l.getUnblindedAddressFromKey(l.unblindedAddrKey, startingDerivation)
-		if err != nil {
-			return nil, nil,err
-		}
-		utxos, err :=l.esplora.GetUtxosFromAddress(addr)
-		if err != nil {
-			return nil, nil, err
-		}
-		addrToInfoMap[addr] = &AddressInfo{
-			Derivation: startingDerivation,
-			Address: addr,
-			Utxos: utxos,
-		}
-		allUtxos = append(allUtxos, utxos...)
-		startingDerivation++
+// SendToAddress sends an amount of an asset, the fee is paid with separate policy asset inputs
+func (l *LiquidWallet) SendToAddress(address string, amount asset.AssetAmount) (string, error) {
+	if l.IsWatchOnly() {
+		return "", ErrWatchOnly
 	}
-	return addrToInfoMap, allUtxos,  nil
-}
-
-
-func (l *LiquidWallet) getUnblindedAddressFromKey(key *hdkeychain.ExtendedKey, i uint32) (string,error) {
-	acct, err := key.Derive(i)
+	err := l.Sync()
 	if err != nil {
 		return "", err
 	}
-	addrPrivkey,err := acct.ECPrivKey()
+	receiverOutput, receiverKeys, err := newReceiverOutput(address, amount.AssetId, amount.Amount)
 	if err != nil {
 		return "", err
 	}
 
-	addrPubkey := addrPrivkey.PubKey()
+	tx := transaction.NewTx(2)
+	tx.Outputs = []*transaction.TxOutput{receiverOutput}
 
-	p2wpkh := payment.FromPublicKey(addrPubkey, l.liquidNetwork, nil)
-	addr,err := p2wpkh.WitnessPubKeyHash()
+	walletInputs, changeOutputs, err := l.fundTransaction(tx, tx.Outputs, receiverKeys)
 	if err != nil {
 		return "", err
 	}
-	return addr, nil
+	tx, err = l.blindAndSign(tx, 0, walletInputs, receiverKeys, changeOutputs)
+	if err != nil {
+		return "", err
+	}
+	return l.broadcastTransaction(tx)
 }
 
-
-func (l *LiquidWallet) updateUtxoMap() error {
-	addressesWithUtxos,utxos, err := l.getSpendAddresses(200)
+// SendAllToAddress sweeps all utxos of an asset to the address. The fee is deducted from the sweep
+// for the policy asset, other assets are swept completely and the fee is paid with separate inputs
+func (l *LiquidWallet) SendAllToAddress(address string, assetId string) (string, error) {
+	if l.IsWatchOnly() {
+		return "", ErrWatchOnly
+	}
+	err := l.Sync()
 	if err != nil {
-		return err
+		return "", err
 	}
-	l.utxos = append(l.utxos, utxos...)
-	for k,v := range addressesWithUtxos {
-		l.addressToUtxoMap[k] = v
+	inputs := l.spendableUtxos(assetId)
+	if len(inputs) == 0 {
+		return "", fmt.Errorf("no spendable utxos of asset %s", assetId)
+	}
+	var totalInputValue uint64
+	for _, v := range inputs {
+		totalInputValue += v.SatAmt
 	}
-	return nil
-}
 
-func (l *LiquidWallet) resetUtxos() error {
-	addressesWithUtxos,utxos, err := l.getSpendAddresses(200)
+	receiverBlindingKey, err := getAddressBlindingKey(address)
 	if err != nil {
-		return err
+		return "", err
 	}
-	l.addressToUtxoMap = addressesWithUtxos
-	l.utxos = utxos
-	return nil
-}
+	isPolicyAsset := assetId == l.liquidNetwork.AssetID
+	receiverVsize := explicitOutputVsize
+	if receiverBlindingKey != nil {
+		receiverVsize = confidentialOutputVsize
+	} else if isPolicyAsset && hasConfidentialInputs(inputs) {
+		// the blinders of the inputs are balanced by a blinded OP_RETURN output
+		receiverVsize += confidentialOutputVsize
+	}
+	fee := l.feeForVsize(txOverheadVsize + len(inputs)*l.scheme.inputVsize() + receiverVsize + feeOutputVsize)
 
-func (l *LiquidWallet) GetUtxos() ([]*EsploraUtxo, error) {
-	_,utxos, err := l.getSpendAddresses(200)
+	sweepValue := totalInputValue
+	if isPolicyAsset {
+		if totalInputValue <= fee {
+			return "", fmt.Errorf("%w: balance of %v does not cover the fee of %v", ErrInsufficientFunds, totalInputValue, fee)
+		}
+		sweepValue -= fee
+	}
+	receiverOutput, receiverKeys, err := newReceiverOutput(address, assetId, sweepValue)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return utxos, nil
-}
 
-func (l *LiquidWallet) GetAddress() (string, error) {
-	return l.getFirstUnusedAddress(200, 0)
+	tx := transaction.NewTx(2)
+	tx.Outputs = []*transaction.TxOutput{receiverOutput}
+	for _, v := range inputs {
+		tx.Inputs = append(tx.Inputs, EsploraUtxoToTxInput(v))
+	}
+
+	var changeOutputs []*transaction.TxOutput
+	if isPolicyAsset {
+		feeValue, _ := elementsutil.SatoshiToElementsValue(fee)
+		tx.Outputs = append(tx.Outputs, transaction.NewTxOutput(l.lbtcAsset, feeValue, []byte{}))
+	} else {
+		feeInputs, feeChangeOutputs, err := l.fundTransaction(tx, nil, receiverKeys)
+		if err != nil {
+			return "", err
+		}
+		inputs = append(inputs, feeInputs...)
+		changeOutputs = feeChangeOutputs
+	}
+
+	tx, err = l.blindAndSign(tx, 0, inputs, receiverKeys, changeOutputs)
+	if err != nil {
+		return "", err
+	}
+	return l.broadcastTransaction(tx)
 }
 
+// newReceiverOutput returns the output paying to the address and the blinding key for confidential addresses
+func newReceiverOutput(address string, assetId string, value uint64) (*transaction.TxOutput, map[*transaction.TxOutput][]byte, error) {
+	receiverScript, err := elemaddr.ToOutputScript(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	txAsset, err := asset.TxAssetFromId(assetId)
+	if err != nil {
+		return nil, nil, err
+	}
+	receiverValue, err := elementsutil.SatoshiToElementsValue(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	receiverOutput := transaction.NewTxOutput(txAsset, receiverValue, receiverScript)
 
-func (l *LiquidWallet) getFirstUnusedAddress(maxDepth int, startingDepth int) (string, error) {
-	// fixme this seems weird
-	if maxDepth >= 100000 {
-		return "", errors.New("max depth seems excessively large")
+	receiverKeys := make(map[*transaction.TxOutput][]byte)
+	receiverBlindingKey, err := getAddressBlindingKey(address)
+	if err != nil {
+		return nil, nil, err
 	}
+	if receiverBlindingKey != nil {
+		receiverKeys[receiverOutput] = receiverBlindingKey
+	}
+	return receiverOutput, receiverKeys, nil
+}
 
-	if startingDepth > maxDepth {
-		return "", errors.New("maxDepth must be larger than startingDepth")
+// broadcastTransaction posts the signed transaction to esplora
+func (l *LiquidWallet) broadcastTransaction(tx *transaction.Transaction) (string, error) {
+	txHex, err := tx.ToHex()
+	if err != nil {
+		return "", err
 	}
+	return l.esplora.PostRawtransaction(txHex)
+}
 
-	unusedAddr := ""
-	for i := startingDepth;i < maxDepth;i++ {
-		addr, err := l.getUnblindedAddressFromKey(l.unblindedAddrKey, uint32(i))
+// fundTransaction adds wallet inputs and change outputs for every asset of the outputs to fund.
+// The fee is estimated from the virtual size of the transaction and funded with policy asset inputs,
+// which get their own change, and added as fee output. receiverKeys marks the outputs that will be blinded
+func (l *LiquidWallet) fundTransaction(tx *transaction.Transaction, outputs []*transaction.TxOutput, receiverKeys map[*transaction.TxOutput][]byte) ([]*EsploraUtxo, []*transaction.TxOutput, error) {
+	lbtcId := l.liquidNetwork.AssetID
+	needed := make(map[string]uint64)
+	var assetOrder []string
+	for _, v := range outputs {
+		assetId, err := asset.IdFromTxAsset(v.Asset)
 		if err != nil {
-			return "",err
+			return nil, nil, fmt.Errorf("unable to fund blinded output: %w", err)
 		}
-		addrStats, err := l.esplora.GetAddressStats(addr)
+		value, err := elementsutil.ElementsToSatoshiValue(v.Value)
 		if err != nil {
-			return "",err
+			return nil, nil, err
 		}
-		if addrStats.ChainStats.TxCount == 0 && addrStats.MempoolStats.TxCount == 0 {
-			unusedAddr = addrStats.Address
-			break
+		if assetId != lbtcId && !containsString(assetOrder, assetId) {
+			assetOrder = append(assetOrder, assetId)
 		}
+		needed[assetId] += value
 	}
-	if unusedAddr == "" {
-		return l.getFirstUnusedAddress(maxDepth + 100, startingDepth+maxDepth)
-	}
-	return unusedAddr, nil
 
-}
+	// change outputs are blinded if any output or spent utxo is confidential
+	changeVsize := explicitOutputVsize
+	if len(receiverKeys) > 0 || l.hasConfidentialUtxos(append(assetOrder, lbtcId)) {
+		changeVsize = confidentialOutputVsize
+	}
+	inputVsize := l.scheme.inputVsize()
+	vsize := txOverheadVsize + feeOutputVsize + len(tx.Inputs)*inputVsize
+	for _, v := range tx.Outputs {
+		if _, ok := receiverKeys[v]; ok {
+			vsize += confidentialOutputVsize
+		} else {
+			vsize += explicitOutputVsize
+		}
+	}
 
-func (l *LiquidWallet) SendToAddress(address string, asset string, value uint64) (string, error) {
-	inputs, totalInputValue, err := l.GetInputs(asset, value)
+	changeAddr, err := l.nextUnusedAddress(l.scheme.changeBranch())
 	if err != nil {
-		return "", err
+		return nil, nil, err
+	}
+	changeScript, err := elemaddr.ToOutputScript(changeAddr.Address)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	var txInputs []*transaction.TxInput
-	var inputSigner []*hdkeychain.ExtendedKey
-
-	for _,v := range inputs {
-		txInputs = append(txInputs, EsploraUtxoToTxInput(v))
-		key, err := l.unblindedAddrKey.Derive(l.addressToUtxoMap[v.Address].Derivation)
+	var walletInputs []*EsploraUtxo
+	var changeOutputs []*transaction.TxOutput
+	addInputs := func(inputs []*EsploraUtxo) {
+		for _, v := range inputs {
+			tx.Inputs = append(tx.Inputs, EsploraUtxoToTxInput(v))
+		}
+		walletInputs = append(walletInputs, inputs...)
+		vsize += len(inputs) * inputVsize
+	}
+	addChange := func(assetId string, changeValue uint64) error {
+		txAsset, err := asset.TxAssetFromId(assetId)
 		if err != nil {
-			return "", err
+			return err
 		}
-
-		inputSigner = append(inputSigner, key)
+		changeValueBytes, err := elementsutil.SatoshiToElementsValue(changeValue)
+		if err != nil {
+			return err
+		}
+		changeOutput := transaction.NewTxOutput(txAsset, changeValueBytes, changeScript)
+		tx.Outputs = append(tx.Outputs, changeOutput)
+		changeOutputs = append(changeOutputs, changeOutput)
+		vsize += changeVsize
+		return nil
 	}
-	// todo get esplora fee
-	fee := uint64(500)
-	changeValue := totalInputValue - value - fee
 
-	receiverOutputScript,err := elemaddr.ToOutputScript(address)
-	if err != nil {
-		return "", err
+	// other assets only pay for the size of their inputs and change, so they are selected first
+	for _, assetId := range assetOrder {
+		inputs, totalInputValue, err := selectCoins(l.spendableUtxos(assetId), needed[assetId], 0, 0)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to fund %v of %s: %w", needed[assetId], assetId, err)
+		}
+		addInputs(inputs)
+		if totalInputValue > needed[assetId] {
+			err = addChange(assetId, totalInputValue-needed[assetId])
+			if err != nil {
+				return nil, nil, err
+			}
+		}
 	}
-	receiverValue, _ := elementsutil.SatoshiToElementsValue(value)
-	receiverScript := receiverOutputScript
-	receiverOutput := transaction.NewTxOutput(l.lbtcAsset, receiverValue, receiverScript)
 
-	nextAddr, err := l.GetAddress()
+	// a change output costs its own fee and the fee of spending it later
+	costOfChange := l.feeForVsize(changeVsize + inputVsize)
+	target := needed[lbtcId] + l.feeForVsize(vsize)
+	inputs, totalInputValue, err := selectCoins(l.spendableUtxos(lbtcId), target, l.feeForVsize(inputVsize), costOfChange)
 	if err != nil {
-		return "", err
+		return nil, nil, fmt.Errorf("unable to fund %v of %s: %w", target, lbtcId, err)
 	}
+	addInputs(inputs)
 
-	feeValue, _ := elementsutil.SatoshiToElementsValue(fee)
-	feeScript := []byte{}
-	feeOutput := transaction.NewTxOutput(l.lbtcAsset, feeValue, feeScript)
-
-	outputs := []*transaction.TxOutput{receiverOutput, feeOutput}
-
-	if changeValue > 0 {
-		changeOutputScript, err := elemaddr.ToOutputScript(nextAddr)
+	// the excess is paid as fee if a change output would cost more than it is worth
+	fee := totalInputValue - needed[lbtcId]
+	changeFee := l.feeForVsize(vsize + changeVsize)
+	if excess := fee - l.feeForVsize(vsize); excess > costOfChange && fee-changeFee >= minChangeValue {
+		err = addChange(lbtcId, fee-changeFee)
 		if err != nil {
-			return "", err
+			return nil, nil, err
 		}
-		changeValueBytes, _ := elementsutil.SatoshiToElementsValue(changeValue)
-		changeScript := changeOutputScript
-		changeOutput := transaction.NewTxOutput(l.lbtcAsset, changeValueBytes, changeScript)
-		outputs = append(outputs, changeOutput)
+		fee = changeFee
 	}
 
-	tx := transaction.NewTx(2)
+	feeValue, _ := elementsutil.SatoshiToElementsValue(fee)
+	tx.Outputs = append(tx.Outputs, transaction.NewTxOutput(l.lbtcAsset, feeValue, []byte{}))
+	return walletInputs, changeOutputs, nil
+}
 
-	tx.Inputs = txInputs
-	tx.Outputs = outputs
-
-	//pset, err := pset2.New(txInputs, outputs, 2,0)
-	//if err != nil {
-	//	return "", err
-	//}
-	//updater, err := pset2.NewUpdater(pset)
-	//if err != nil {
-	//	return "", err
-	//}
-	//var sigs [][]byte
-	for i, privkey := range inputSigner {
-		//if err := updater.AddInSighashType(txscript.SigHashAll, i); err != nil {
-		//	return "", err
-		//}
-		outputScript, err := elemaddr.ToOutputScript(inputs[i].Address)
+// signInputs signs the p2wpkh and p2sh-p2wpkh wallet inputs of the transaction starting at the input offset
+func (l *LiquidWallet) signInputs(tx *transaction.Transaction, offset int, inputs []*EsploraUtxo) error {
+	if l.IsWatchOnly() {
+		return ErrWatchOnly
+	}
+	for j, input := range inputs {
+		i := offset + j
+		addrInfo, ok := l.getAddressInfo(input.Address)
+		if !ok {
+			return fmt.Errorf("unknown input address %s", input.Address)
+		}
+		privkey, err := l.deriveKey(addrInfo.Account, addrInfo.Branch, addrInfo.Derivation)
+		if err != nil {
+			return err
+		}
+		pubkey, err := privkey.ECPubKey()
 		if err!= nil {
-			return "",err
+			return err
 		}
-		p2wpkhPayment, err := payment.FromScript(outputScript, l.liquidNetwork,nil)
+		outputScript, err := elemaddr.ToOutputScript(input.Address)
 		if err!= nil {
-			return "",err
+			return err
+		}
+		switch elemaddr.GetScriptType(outputScript) {
+		case elemaddr.P2WpkhScript:
+		case elemaddr.P2ShScript:
+			scriptSig, err := nestedSegwitScriptSig(pubkey, l.liquidNetwork)
+			if err != nil {
+				return err
+			}
+			tx.Inputs[i].Script = scriptSig
+		default:
+			return errors.New("input should be p2wpkh or p2sh-p2wpkh")
 		}
-		if elemaddr.GetScriptType(outputScript) != elemaddr.P2WpkhScript {
-			return "", errors.New("input should be p2wpkh")
+		// the script code of both input types is the p2pkh script of the key
+		scriptCode := payment.FromPublicKey(pubkey, l.liquidNetwork, nil).Script
+		inputValue, err := input.prevoutValue()
+		if err != nil {
+			return err
 		}
-		inputValue,_ := elementsutil.SatoshiToElementsValue(inputs[i].SatAmt)
-		sigHash := tx.HashForWitnessV0(i,p2wpkhPayment.Script, inputValue, txscript.SigHashAll)
+		sigHash := tx.HashForWitnessV0(i, scriptCode, inputValue, txscript.SigHashAll)
 		signer, err := privkey.ECPrivKey()
 		if err != nil {
-			return "", err
+			return err
 		}
 		signature, err := signer.Sign(sigHash[:])
 		if err!= nil {
-			return "",err
+			return err
 		}
 		sigWithHashType := append(signature.Serialize(), byte(txscript.SigHashAll))
 
-		pubkey, err := privkey.ECPubKey()
-		if err!= nil {
-			return "",err
-		}
 		tx.Inputs[i].Witness = [][]byte{ sigWithHashType,pubkey.SerializeCompressed()}
 	}
-	txString, err := tx.ToHex()
+	return nil
+}
+
+// FundAndSignRawTransaction adds wallet inputs for every asset of the unfunded transaction, pays the fee
+// in the policy asset, adds change outputs and signs the added inputs
+func (l *LiquidWallet) FundAndSignRawTransaction(unfundedRawTx string) (string, error) {
+	if l.IsWatchOnly() {
+		return "", ErrWatchOnly
+	}
+	tx, err := transaction.NewTxFromHex(unfundedRawTx)
+	if err != nil {
+		return "", err
+	}
+	err = l.Sync()
 	if err != nil {
 		return "", err
 	}
 
-	txId, err := l.esplora.PostRawtransaction(txString)
+	offset := len(tx.Inputs)
+	walletInputs, changeOutputs, err := l.fundTransaction(tx, tx.Outputs, nil)
 	if err != nil {
 		return "", err
 	}
 
-	return txId,nil
+	tx, err = l.blindAndSign(tx, offset, walletInputs, nil, changeOutputs)
+	if err != nil {
+		return "", err
+	}
+	return tx.ToHex()
 }
 
+// SendRawTransaction broadcasts a transaction through esplora
+func (l *LiquidWallet) SendRawTransaction(txHex string) (string, error) {
+	return l.esplora.PostRawtransaction(txHex)
+}
 
+// GetBalance returns the balance of an asset in base units
+func (l *LiquidWallet) GetBalance(assetEntry *asset.AssetEntry) (asset.AssetAmount, error) {
+	balances, err := l.GetBalances()
+	if err != nil {
+		return asset.AssetAmount{}, err
+	}
+	return assetEntry.NewAmount(balances[assetEntry.AssetId]), nil
+}
+
+// GetBalances returns the balances of all assets in base units, indexed by asset id
+func (l *LiquidWallet) GetBalances() (map[string]uint64, error) {
+	err := l.Sync()
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	balances := make(map[string]uint64)
+	for _, v := range l.utxos {
+		if v.Asset == "" {
+			continue
+		}
+		balances[v.Asset] += v.SatAmt
+	}
+	return balances, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Signer signs the sighash of an input with a key it holds, e.g. on a hardware wallet, see SignPsetInput
 type Signer interface {
 	Sign(hash []byte) (*btcec.Signature, error)
 	PubKey() (*btcec.PublicKey)
@@ -416,30 +605,46 @@ func EsploraUtxoToTxInput(esploraUtxo *EsploraUtxo) (*transaction.TxInput) {
 	return txInput
 }
 
-func (l *LiquidWallet) GetInputs(asset string, value uint64) ([]*EsploraUtxo,uint64, error) {
-	selector := &coinset.MaxValueAgeCoinSelector{
-		MaxInputs:       10,
-		MinChangeAmount: 5000,
-	}
-	var coins []coinset.Coin
-	for _,v := range l.utxos {
-		if paymentType,err := GetPaymentType(v.Address); err != nil || paymentType != elemaddr.P2WpkhScript || v.Asset != asset {
+// GetInputs selects utxos of the asset covering the amount, preferring input sets that need no change
+func (l *LiquidWallet) GetInputs(amount asset.AssetAmount) ([]*EsploraUtxo,uint64, error) {
+	return selectCoins(l.spendableUtxos(amount.AssetId), amount.Amount, 0, 0)
+}
+
+// spendableUtxos returns the utxos of an asset the wallet can sign for and that have the minimum confirmations
+func (l *LiquidWallet) spendableUtxos(assetId string) []*EsploraUtxo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var utxos []*EsploraUtxo
+	for _, v := range l.utxos {
+		if paymentType, err := GetPaymentType(v.Address); err != nil || (paymentType != elemaddr.P2WpkhScript && paymentType != elemaddr.P2ShScript) || v.Asset != assetId {
 			continue
 		}
-		coins = append(coins, v)
+		if v.Confirmations(l.tipHeight) < l.minConfirmations {
+			continue
+		}
+		utxos = append(utxos, v)
 	}
-	amt,_ := btcutil.NewAmount(float64(value+2000))
-	coinSet, err := selector.CoinSelect(amt, coins)
-	if err != nil {
-		return nil,0, err
+	return utxos
+}
+
+// hasConfidentialInputs returns true if any of the utxos is confidential
+func hasConfidentialInputs(utxos []*EsploraUtxo) bool {
+	for _, v := range utxos {
+		if v.IsConfidential() {
+			return true
+		}
 	}
-	var totalValue uint64
-	var utxos []*EsploraUtxo
-	for _,v := range coinSet.Coins() {
-		utxos = append(utxos, v.(*EsploraUtxo))
-		totalValue += uint64(v.Value().ToBTC())
+	return false
+}
+
+// hasConfidentialUtxos returns true if any spendable utxo of the assets is confidential
+func (l *LiquidWallet) hasConfidentialUtxos(assetIds []string) bool {
+	for _, assetId := range assetIds {
+		if hasConfidentialInputs(l.spendableUtxos(assetId)) {
+			return true
+		}
 	}
-	return  utxos,totalValue, nil
+	return false
 }
 
 func GetPaymentType(address string ) (int, error) {
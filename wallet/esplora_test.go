@@ -0,0 +1,551 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
+	"github.com/tyler-smith/go-bip39"
+	elemaddr "github.com/vulpemventures/go-elements/address"
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/network"
+	"github.com/vulpemventures/go-elements/payment"
+	"github.com/vulpemventures/go-elements/pset"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+// fakeEsplora serves the esplora api from in memory transactions
+type fakeEsplora struct {
+	mu       sync.Mutex
+	txs      map[string]*transaction.Transaction
+	statuses map[string]*Status
+	// scripts maps the output scripts of the transactions to their unconfidential addresses
+	scripts map[string]string
+	// addrTxs are the transaction ids of an address, most recent first
+	addrTxs map[string][]string
+	utxos   map[string][]*EsploraUtxo
+	// posted are the transactions broadcast by the wallet
+	posted []*transaction.Transaction
+	height uint32
+
+	statsCalls map[string]int
+	utxoCalls  map[string]int
+	txCalls    map[string]int
+	pageCalls  map[string]int
+
+	// nonce makes the external inputs of funding transactions unique
+	nonce uint32
+}
+
+// fakeOutput pays value of the asset to the address, an empty address is the fee output.
+// Outputs to confidential addresses are blinded to the blinding key of the address
+type fakeOutput struct {
+	address string
+	assetId string
+	value   uint64
+}
+
+func newFakeEsplora(height uint32) *fakeEsplora {
+	return &fakeEsplora{
+		height:     height,
+		txs:        make(map[string]*transaction.Transaction),
+		statuses:   make(map[string]*Status),
+		scripts:    make(map[string]string),
+		addrTxs:    make(map[string][]string),
+		utxos:      make(map[string][]*EsploraUtxo),
+		statsCalls: make(map[string]int),
+		utxoCalls:  make(map[string]int),
+		txCalls:    make(map[string]int),
+		pageCalls:  make(map[string]int),
+	}
+}
+
+// addTx adds a transaction spending the outpoints to the outputs, a height of 0 adds it to the mempool.
+// Only funding transactions, which spend unknown external outputs, may have confidential outputs,
+// which have to be in front of the explicit outputs
+func (f *fakeEsplora) addTx(t *testing.T, height uint32, inputs []*Outpoint, outputs ...fakeOutput) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tx := transaction.NewTx(2)
+	status := &Status{}
+	if height > 0 {
+		status = &Status{Confirmed: true, BlockHeight: height, BlockTime: uint64(height) * 60}
+	}
+	addresses := make(map[string]bool)
+	for _, v := range inputs {
+		prevTx, ok := f.txs[v.TxId]
+		if !ok {
+			t.Fatalf("unknown tx %s", v.TxId)
+		}
+		prevHash := prevTx.TxHash()
+		tx.Inputs = append(tx.Inputs, transaction.NewTxInput(prevHash[:], v.Vout))
+		address := f.scripts[b2h(prevTx.Outputs[v.Vout].Script)]
+		addresses[address] = true
+		utxos := f.utxos[address]
+		for i, utxo := range utxos {
+			if utxo.TxId == v.TxId && utxo.Vout == v.Vout {
+				f.utxos[address] = append(utxos[:i:i], utxos[i+1:]...)
+				break
+			}
+		}
+	}
+
+	unconfidential := make([]string, len(outputs))
+	blindingKeys := make(map[int][]byte)
+	for i, v := range outputs {
+		unconfidential[i] = v.address
+		script := []byte{}
+		if v.address != "" {
+			blindingKey, err := getAddressBlindingKey(v.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if blindingKey != nil {
+				if len(blindingKeys) != i || len(inputs) > 0 {
+					t.Fatal("only the first outputs of funding transactions can be blinded")
+				}
+				blindingKeys[i] = blindingKey
+				confAddr, err := elemaddr.FromConfidential(v.address)
+				if err != nil {
+					t.Fatal(err)
+				}
+				unconfidential[i] = confAddr.Address
+			}
+			script, err = elemaddr.ToOutputScript(v.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		txAsset, err := asset.TxAssetFromId(v.assetId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := elementsutil.SatoshiToElementsValue(v.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.Outputs = append(tx.Outputs, transaction.NewTxOutput(txAsset, value, script))
+	}
+	if len(inputs) == 0 {
+		// funding transactions spend an unknown external output of every asset
+		tx = f.fundExternal(t, tx, outputs, blindingKeys)
+	}
+
+	if height > f.height {
+		f.height = height
+	}
+	txHash := tx.TxHash()
+	txId := txHash.String()
+	f.txs[txId] = tx
+	f.statuses[txId] = status
+	for i, v := range unconfidential {
+		if v == "" {
+			continue
+		}
+		out := tx.Outputs[i]
+		f.scripts[b2h(out.Script)] = v
+		addresses[v] = true
+		utxo := &EsploraUtxo{TxId: txId, Vout: uint32(i), Status: status, Address: v}
+		if out.IsConfidential() {
+			utxo.ValueCommitment = b2h(out.Value)
+			utxo.AssetCommitment = b2h(out.Asset)
+			utxo.NonceCommitment = b2h(out.Nonce)
+		} else {
+			utxo.SatAmt = outputs[i].value
+			utxo.Asset = outputs[i].assetId
+		}
+		f.utxos[v] = append(f.utxos[v], utxo)
+	}
+	for address := range addresses {
+		f.addrTxs[address] = append([]string{txId}, f.addrTxs[address]...)
+	}
+	return txId
+}
+
+// fundExternal adds an external input for every asset paying the outputs and blinds the outputs with a blinding key
+func (f *fakeEsplora) fundExternal(t *testing.T, tx *transaction.Transaction, outputs []fakeOutput, blindingKeys map[int][]byte) *transaction.Transaction {
+	var assetIds []string
+	values := make(map[string]uint64)
+	for _, v := range outputs {
+		if _, ok := values[v.assetId]; !ok {
+			assetIds = append(assetIds, v.assetId)
+		}
+		values[v.assetId] += v.value
+	}
+	var prevouts []*transaction.TxOutput
+	var inputBlindingData []pset.BlindingDataLike
+	for _, v := range assetIds {
+		f.nonce++
+		prevHash := sha256.Sum256([]byte(fmt.Sprintf("external%v", f.nonce)))
+		tx.Inputs = append(tx.Inputs, transaction.NewTxInput(prevHash[:], 0))
+		txAsset, err := asset.TxAssetFromId(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := elementsutil.SatoshiToElementsValue(values[v])
+		if err != nil {
+			t.Fatal(err)
+		}
+		prevouts = append(prevouts, transaction.NewTxOutput(txAsset, value, h2b("0014"+b2h(prevHash[:20]))))
+		inputBlindingData = append(inputBlindingData, pset.BlindingData{
+			Value:               values[v],
+			Asset:               elementsutil.ReverseBytes(h2b(v)),
+			ValueBlindingFactor: make([]byte, 32),
+			AssetBlindingFactor: make([]byte, 32),
+		})
+	}
+	if len(blindingKeys) == 0 {
+		return tx
+	}
+
+	p, err := pset.NewPsetFromUnsignedTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updater, err := pset.NewUpdater(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range prevouts {
+		err = updater.AddInWitnessUtxo(v, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	blinder, err := pset.NewBlinder(p, inputBlindingData, blindingKeys, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = blinder.Blind()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.UnsignedTx
+}
+
+func (f *fakeEsplora) GetUtxosFromAddress(address string) ([]*EsploraUtxo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.utxoCalls[address]++
+	var utxos []*EsploraUtxo
+	for _, v := range f.utxos[address] {
+		// the wallet fills in the unblinded values
+		utxo := *v
+		utxos = append(utxos, &utxo)
+	}
+	return utxos, nil
+}
+
+func (f *fakeEsplora) PostRawtransaction(rawTx string) (string, error) {
+	tx, err := transaction.NewTxFromHex(rawTx)
+	if err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.posted = append(f.posted, tx)
+	return tx.TxHash().String(), nil
+}
+
+func (f *fakeEsplora) GetAddressStats(address string) (*AddressStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statsCalls[address]++
+	stats := &AddressStats{Address: address, ChainStats: &AddressUtxoInfos{}, MempoolStats: &AddressUtxoInfos{}}
+	for _, v := range f.addrTxs[address] {
+		if f.statuses[v].Confirmed {
+			stats.ChainStats.TxCount++
+		} else {
+			stats.MempoolStats.TxCount++
+		}
+	}
+	return stats, nil
+}
+
+func (f *fakeEsplora) GetTxHex(txId string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.txCalls[txId]++
+	tx, ok := f.txs[txId]
+	if !ok {
+		return "", fmt.Errorf("unknown tx %s", txId)
+	}
+	return tx.ToHex()
+}
+
+func (f *fakeEsplora) GetBlockHeight() (uint32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.height, nil
+}
+
+func (f *fakeEsplora) GetAddressTxs(address string, lastSeenTxId string) ([]*EsploraTx, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pageCalls[address]++
+	var page, confirmed []*EsploraTx
+	for _, v := range f.addrTxs[address] {
+		esploraTx := f.esploraTx(v)
+		if esploraTx.blockHeight() > 0 {
+			confirmed = append(confirmed, esploraTx)
+		} else if lastSeenTxId == "" {
+			page = append(page, esploraTx)
+		}
+	}
+	for i, v := range confirmed {
+		if v.TxId == lastSeenTxId {
+			confirmed = confirmed[i+1:]
+			break
+		}
+	}
+	if len(confirmed) > esploraChainTxsPerPage {
+		confirmed = confirmed[:esploraChainTxsPerPage]
+	}
+	return append(page, confirmed...), nil
+}
+
+// esploraTx returns the transaction with the prevout scripts of its inputs as esplora serves it
+func (f *fakeEsplora) esploraTx(txId string) *EsploraTx {
+	status := *f.statuses[txId]
+	esploraTx := &EsploraTx{TxId: txId, Status: &status}
+	for _, in := range f.txs[txId].Inputs {
+		prevTxId := chainhash.Hash{}
+		copy(prevTxId[:], in.Hash)
+		vin := &EsploraTxIn{TxId: prevTxId.String(), Vout: in.Index, Prevout: &EsploraTxOut{}}
+		if prevTx, ok := f.txs[vin.TxId]; ok {
+			vin.Prevout.ScriptPubKey = b2h(prevTx.Outputs[in.Index].Script)
+		}
+		esploraTx.Vin = append(esploraTx.Vin, vin)
+	}
+	return esploraTx
+}
+
+// lastPosted returns the last transaction broadcast by the wallet
+func (f *fakeEsplora) lastPosted(t *testing.T) *transaction.Transaction {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.posted) == 0 {
+		t.Fatal("no transaction was broadcast")
+	}
+	return f.posted[len(f.posted)-1]
+}
+
+// txFee returns the value of the fee output of the transaction
+func txFee(t *testing.T, tx *transaction.Transaction) uint64 {
+	for _, v := range tx.Outputs {
+		if len(v.Script) == 0 {
+			fee, err := elementsutil.ElementsToSatoshiValue(v.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return fee
+		}
+	}
+	t.Fatal("missing fee output")
+	return 0
+}
+
+// newTestWallet returns a regtest wallet of the test mnemonic using the derivation scheme
+func newTestWallet(t *testing.T, esplora EsploraApi, scheme *DerivationScheme) *LiquidWallet {
+	seed, err := bip39.NewSeedWithErrorChecking(testMnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLiquidWallet(esplora, testChainParams(), &network.Regtest)
+	l.SetDerivationScheme(scheme)
+	l.SetGapLimit(5)
+	err = l.Initialize(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// newTestKey returns a new private key
+func newTestKey(t *testing.T) *btcec.PrivateKey {
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// externalAddress returns an address that does not belong to the test wallet
+func externalAddress(t *testing.T) string {
+	address, err := payment.FromPublicKey(newTestKey(t).PubKey(), &network.Regtest, nil).WitnessPubKeyHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return address
+}
+
+// externalConfidentialAddress returns a confidential address that does not belong to the test wallet and its blinding key
+func externalConfidentialAddress(t *testing.T) (string, *btcec.PrivateKey) {
+	blindingKey := newTestKey(t)
+	address, err := payment.FromPublicKey(newTestKey(t).PubKey(), &network.Regtest, blindingKey.PubKey()).ConfidentialWitnessPubKeyHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return address, blindingKey
+}
+
+// testChainParams returns the chain params of the liquid regtest network as bcd and bccli set them up
+func testChainParams() *chaincfg.Params {
+	chainParams := chaincfg.MainNetParams
+	chainParams.Name = network.Regtest.Name
+	chainParams.Bech32HRPSegwit = network.Regtest.Bech32
+	chainParams.HDPrivateKeyID = network.Regtest.HDPrivateKey
+	chainParams.HDPublicKeyID = network.Regtest.HDPublicKey
+	chainParams.PubKeyHashAddrID = network.Regtest.PubKeyHash
+	chainParams.ScriptHashAddrID = network.Regtest.ScriptHash
+	chainParams.PrivateKeyID = network.Regtest.Wif
+	return &chainParams
+}
+
+func TestSendAssetWithFeeChange(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	l := newTestWallet(t, esplora, Bip84Derivation)
+	lbtc := network.Regtest.AssetID
+	address, err := l.GetAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fundingTxId := esplora.addTx(t, 101, nil, fakeOutput{address, testAssetId, 10000}, fakeOutput{address, lbtc, 100000})
+
+	// the asset change and the fee change are separate outputs
+	receiver := externalAddress(t)
+	_, err = l.SendToAddress(receiver, asset.NewAssetAmount(testAssetId, 8, 3000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := esplora.lastPosted(t)
+	changeAddress, err := l.deriveAddress(0, ChangeBranch, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changeScript, err := elemaddr.ToOutputScript(changeAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiverScript, err := elemaddr.ToOutputScript(receiver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		script  []byte
+		assetId string
+		value   uint64
+	}{
+		{receiverScript, testAssetId, 3000},
+		{changeScript, testAssetId, 7000},
+		{changeScript, lbtc, 100000 - txFee(t, tx)},
+		{[]byte{}, lbtc, txFee(t, tx)},
+	}
+	if len(tx.Outputs) != len(want) {
+		t.Fatalf("got %v outputs, want %v", len(tx.Outputs), len(want))
+	}
+	for i, v := range tx.Outputs {
+		assetId, err := asset.IdFromTxAsset(v.Asset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := elementsutil.ElementsToSatoshiValue(v.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(v.Script, want[i].script) || assetId != want[i].assetId || value != want[i].value {
+			t.Fatalf("output %v pays %v of %s, want %v of %s", i, value, assetId, want[i].value, want[i].assetId)
+		}
+	}
+	fundingTx := esplora.txs[fundingTxId]
+	verifyInput(t, tx, 0, fundingTx.Outputs[0])
+	verifyInput(t, tx, 1, fundingTx.Outputs[1])
+}
+
+func TestSendAllConfidential(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	l := newTestWallet(t, esplora, Bip84Derivation)
+	lbtc := network.Regtest.AssetID
+	confAddress, err := l.GetConfidentialAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fundingTxId := esplora.addTx(t, 101, nil, fakeOutput{confAddress, lbtc, 60000}, fakeOutput{confAddress, lbtc, 40000})
+
+	receiver, receiverKey := externalConfidentialAddress(t)
+	_, err = l.SendAllToAddress(receiver, lbtc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := esplora.lastPosted(t)
+	if len(tx.Outputs) != 2 {
+		t.Fatalf("got %v outputs, want the sweep and the fee", len(tx.Outputs))
+	}
+	value, assetId := unblindOutput(t, findOutput(t, tx, receiver), receiverKey.Serialize())
+	if value != 100000-txFee(t, tx) || assetId != lbtc {
+		t.Fatalf("receiver got %v of %s", value, assetId)
+	}
+	fundingTx := esplora.txs[fundingTxId]
+	verifyInput(t, tx, 0, fundingTx.Outputs[0])
+	verifyInput(t, tx, 1, fundingTx.Outputs[1])
+}
+
+func TestSendAllConfidentialToUnconfidential(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	l := newTestWallet(t, esplora, Bip84Derivation)
+	lbtc := network.Regtest.AssetID
+	confAddress, err := l.GetConfidentialAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the blinders of all outputs of a funding transaction with explicit inputs add up to zero,
+	// so the wallet only gets some of them
+	external, _ := externalConfidentialAddress(t)
+	fundingTxId := esplora.addTx(t, 101, nil, fakeOutput{confAddress, lbtc, 60000}, fakeOutput{confAddress, lbtc, 40000},
+		fakeOutput{external, lbtc, 1000})
+
+	// the blinders of the inputs are balanced by a blinded OP_RETURN output paid from the fee
+	receiver := externalAddress(t)
+	_, err = l.SendAllToAddress(receiver, lbtc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := esplora.lastPosted(t)
+	if len(tx.Outputs) != 3 {
+		t.Fatalf("got %v outputs, want the sweep, the blinded OP_RETURN and the fee", len(tx.Outputs))
+	}
+	// the fee estimate includes the OP_RETURN output
+	fee := l.feeForVsize(txOverheadVsize + 2*p2wpkhInputVsize + explicitOutputVsize + confidentialOutputVsize + feeOutputVsize)
+	receiverOutput := findOutput(t, tx, receiver)
+	if value, _ := elementsutil.ElementsToSatoshiValue(receiverOutput.Value); receiverOutput.IsConfidential() || value != 100000-fee {
+		t.Fatalf("receiver got %v", value)
+	}
+	dummyOutput := tx.Outputs[0]
+	if !bytes.Equal(dummyOutput.Script, []byte{txscript.OP_RETURN}) {
+		t.Fatal("missing the blinded OP_RETURN output")
+	}
+	dummyKey, err := l.getBlindingKey(dummyOutput.Script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, assetId := unblindOutput(t, dummyOutput, dummyKey)
+	if value != 1 || assetId != lbtc {
+		t.Fatalf("OP_RETURN output got %v of %s", value, assetId)
+	}
+	if txFee(t, tx) != fee-1 {
+		t.Fatalf("got fee %v, want %v", txFee(t, tx), fee-1)
+	}
+	fundingTx := esplora.txs[fundingTxId]
+	verifyInput(t, tx, 0, fundingTx.Outputs[0])
+	verifyInput(t, tx, 1, fundingTx.Outputs[1])
+}
@@ -0,0 +1,424 @@
+package wallet
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
+	elemaddr "github.com/vulpemventures/go-elements/address"
+	"github.com/vulpemventures/go-elements/confidential"
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+// esploraChainTxsPerPage is the number of confirmed transactions esplora returns per address page
+const esploraChainTxsPerPage = 25
+
+// WalletTx is a wallet transaction with the net change of the wallet balance per asset
+type WalletTx struct {
+	TxId string
+
+	// Amounts is the net change of the wallet balance in base units indexed by asset id,
+	// the policy asset amount includes the fee of transactions the wallet funded
+	Amounts map[string]int64
+
+	// Fee is the transaction fee in the policy asset, it is only set if the wallet funded the transaction
+	Fee uint64
+
+	// Confirmations is zero for mempool transactions
+	Confirmations uint32
+	BlockHeight   uint32
+	BlockTime     uint64
+
+	// SwapIds are the swaps the transaction belongs to
+	SwapIds []string
+}
+
+// EsploraTx is a transaction as listed by the esplora address endpoints
+type EsploraTx struct {
+	TxId   string         `json:"txid"`
+	Fee    uint64         `json:"fee"`
+	Status *Status        `json:"status"`
+	Vin    []*EsploraTxIn `json:"vin"`
+}
+
+type EsploraTxIn struct {
+	TxId       string        `json:"txid"`
+	Vout       uint32        `json:"vout"`
+	IsCoinbase bool          `json:"is_coinbase"`
+	Prevout    *EsploraTxOut `json:"prevout"`
+}
+
+type EsploraTxOut struct {
+	ScriptPubKey string `json:"scriptpubkey"`
+}
+
+// SetSwapLabels sets the labels linking wallet transactions to swaps
+func (l *LiquidWallet) SetSwapLabels(swapLabels *SwapLabels) {
+	l.swapLabels = swapLabels
+}
+
+// LabelSwapTx links a wallet transaction to a swap
+func (l *LiquidWallet) LabelSwapTx(txId string, swapId string) error {
+	return l.swapLabels.LabelSwapTx(txId, swapId)
+}
+
+// ListTransactions returns count wallet transactions after skipping the skip most recent ones,
+// mempool transactions come first and confirmed transactions are sorted by descending height
+func (l *LiquidWallet) ListTransactions(count int, skip int) ([]*WalletTx, error) {
+	err := l.Sync()
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	var usedAddresses []*AddressState
+	for _, branchState := range l.state.Branches {
+		for _, v := range branchState.Addresses {
+			if v.isUsed() {
+				usedAddresses = append(usedAddresses, v)
+			}
+		}
+	}
+	walletScripts := make(map[string]bool)
+	for address := range l.addressToUtxoMap {
+		script, err := elemaddr.ToOutputScript(address)
+		if err != nil {
+			l.mu.Unlock()
+			return nil, err
+		}
+		walletScripts[b2h(script)] = true
+	}
+	tipHeight := l.tipHeight
+	l.mu.Unlock()
+
+	// the most recent skip+count transactions of the wallet are among the most recent skip+count of every address
+	limit := -1
+	if count >= 0 {
+		limit = skip + count
+	}
+	esploraTxs := make(map[string]*EsploraTx)
+	for _, v := range usedAddresses {
+		addrTxs, err := l.getAddressTxs(v, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range addrTxs {
+			esploraTxs[tx.TxId] = tx
+		}
+	}
+	var sorted []*EsploraTx
+	for _, v := range esploraTxs {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		hi, hj := sorted[i].blockHeight(), sorted[j].blockHeight()
+		if hi != hj {
+			// mempool transactions have no height and come first
+			return hi == 0 || (hj != 0 && hi > hj)
+		}
+		return sorted[i].TxId < sorted[j].TxId
+	})
+
+	if skip >= len(sorted) {
+		return []*WalletTx{}, nil
+	}
+	sorted = sorted[skip:]
+	if count >= 0 && count < len(sorted) {
+		sorted = sorted[:count]
+	}
+
+	txCache := make(map[string]*transaction.Transaction)
+	walletTxs := []*WalletTx{}
+	for _, v := range sorted {
+		walletTx, err := l.getWalletTx(v, walletScripts, tipHeight, txCache)
+		if err != nil {
+			return nil, err
+		}
+		walletTxs = append(walletTxs, walletTx)
+	}
+	return walletTxs, nil
+}
+
+func (e *EsploraTx) blockHeight() uint32 {
+	if e.Status == nil || !e.Status.Confirmed {
+		return 0
+	}
+	return e.Status.BlockHeight
+}
+
+// getAddressTxs pages through the transactions of an address until all confirmed transactions are fetched.
+// With a limit of zero or more it stops once limit transactions are fetched and the transactions of the block
+// of the last one are complete, as the history orders transactions of the same block by id
+func (l *LiquidWallet) getAddressTxs(addrState *AddressState, limit int) ([]*EsploraTx, error) {
+	var txs []*EsploraTx
+	var confirmed uint32
+	lastSeenTxId := ""
+	for {
+		page, err := l.esplora.GetAddressTxs(addrState.Address, lastSeenTxId)
+		if err != nil {
+			return nil, err
+		}
+		pageConfirmed := 0
+		for _, v := range page {
+			txs = append(txs, v)
+			if v.blockHeight() > 0 {
+				pageConfirmed++
+				lastSeenTxId = v.TxId
+			}
+		}
+		confirmed += uint32(pageConfirmed)
+		if pageConfirmed < esploraChainTxsPerPage || confirmed >= addrState.ChainTxCount {
+			return txs, nil
+		}
+		if limit >= 0 && len(txs) >= limit && (limit == 0 || txs[limit-1].blockHeight() == 0 ||
+			txs[len(txs)-1].blockHeight() < txs[limit-1].blockHeight()) {
+			return txs, nil
+		}
+	}
+}
+
+// getWalletTx computes the net change of the wallet balance from the unblinded wallet inputs and outputs
+func (l *LiquidWallet) getWalletTx(esploraTx *EsploraTx, walletScripts map[string]bool, tipHeight uint32, txCache map[string]*transaction.Transaction) (*WalletTx, error) {
+	tx, err := l.getTx(esploraTx.TxId, txCache)
+	if err != nil {
+		return nil, err
+	}
+	amounts := make(map[string]int64)
+	walletFunded := false
+	for _, v := range esploraTx.Vin {
+		if v.IsCoinbase || v.Prevout == nil || !walletScripts[v.Prevout.ScriptPubKey] {
+			continue
+		}
+		prevTx, err := l.getTx(v.TxId, txCache)
+		if err != nil {
+			return nil, err
+		}
+		if int(v.Vout) >= len(prevTx.Outputs) {
+			return nil, fmt.Errorf("vout %v not found in %s", v.Vout, v.TxId)
+		}
+		assetId, value, err := l.unblindOutput(prevTx.Outputs[v.Vout])
+		if err != nil {
+			return nil, err
+		}
+		amounts[assetId] -= int64(value)
+		walletFunded = true
+	}
+
+	var fee uint64
+	for _, out := range tx.Outputs {
+		if len(out.Script) == 0 {
+			// the fee output is always explicit
+			value, err := elementsutil.ElementsToSatoshiValue(out.Value)
+			if err != nil {
+				return nil, err
+			}
+			fee += value
+			continue
+		}
+		if !walletScripts[b2h(out.Script)] {
+			continue
+		}
+		assetId, value, err := l.unblindOutput(out)
+		if err != nil {
+			return nil, err
+		}
+		amounts[assetId] += int64(value)
+	}
+	for k, v := range amounts {
+		if v == 0 {
+			delete(amounts, k)
+		}
+	}
+
+	walletTx := &WalletTx{
+		TxId:    esploraTx.TxId,
+		Amounts: amounts,
+		SwapIds: l.swapLabels.SwapIds(esploraTx.TxId),
+	}
+	if walletFunded {
+		walletTx.Fee = fee
+	}
+	if esploraTx.blockHeight() > 0 {
+		walletTx.BlockHeight = esploraTx.Status.BlockHeight
+		walletTx.BlockTime = esploraTx.Status.BlockTime
+		if tipHeight >= walletTx.BlockHeight {
+			walletTx.Confirmations = tipHeight - walletTx.BlockHeight + 1
+		}
+	}
+	return walletTx, nil
+}
+
+// getTx returns the transaction from the cache or fetches it from esplora
+func (l *LiquidWallet) getTx(txId string, txCache map[string]*transaction.Transaction) (*transaction.Transaction, error) {
+	if tx, ok := txCache[txId]; ok {
+		return tx, nil
+	}
+	txHex, err := l.esplora.GetTxHex(txId)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := transaction.NewTxFromHex(txHex)
+	if err != nil {
+		return nil, err
+	}
+	txCache[txId] = tx
+	return tx, nil
+}
+
+// unblindOutput returns the asset id and value of a wallet output, confidential outputs are unblinded with the slip77 key
+func (l *LiquidWallet) unblindOutput(out *transaction.TxOutput) (string, uint64, error) {
+	if !out.IsConfidential() {
+		value, err := elementsutil.ElementsToSatoshiValue(out.Value)
+		if err != nil {
+			return "", 0, err
+		}
+		return b2h(elementsutil.ReverseBytes(out.Asset[1:])), value, nil
+	}
+	blindingKey, err := l.getBlindingKey(out.Script)
+	if err != nil {
+		return "", 0, err
+	}
+	res, err := confidential.UnblindOutputWithKey(out, blindingKey)
+	if err != nil {
+		return "", 0, fmt.Errorf("unable to unblind wallet output: %w", err)
+	}
+	return b2h(elementsutil.ReverseBytes(res.Asset)), res.Value, nil
+}
+
+// listTransactionsBatch is the number of entries requested per listtransactions call
+const listTransactionsBatch = 100
+
+// SetSwapLabels sets the labels linking wallet transactions to swaps
+func (r *ElementsRpcWallet) SetSwapLabels(swapLabels *SwapLabels) {
+	r.swapLabels = swapLabels
+}
+
+// LabelSwapTx links a wallet transaction to a swap
+func (r *ElementsRpcWallet) LabelSwapTx(txId string, swapId string) error {
+	return r.swapLabels.LabelSwapTx(txId, swapId)
+}
+
+// ListTransactions returns count wallet transactions after skipping the skip most recent ones, most recent first.
+// elementsd lists one entry per wallet output, so the entries are grouped by transaction
+func (r *ElementsRpcWallet) ListTransactions(count int, skip int) ([]*WalletTx, error) {
+	labels, err := r.rpcClient.DumpAssetLabels()
+	if err != nil {
+		return nil, err
+	}
+	policyAsset := labels["bitcoin"]
+
+	var order []string
+	walletTxs := make(map[string]*WalletTx)
+	for entrySkip := 0; ; entrySkip += listTransactionsBatch {
+		entries, err := r.rpcClient.ListTransactions(listTransactionsBatch, entrySkip)
+		if err != nil {
+			return nil, err
+		}
+		// the batch is ordered oldest first
+		for i := len(entries) - 1; i >= 0; i-- {
+			err = addListTransactionsEntry(walletTxs, &order, entries[i], policyAsset)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// one transaction more than needed makes sure the entries of the last one are complete
+		if len(entries) < listTransactionsBatch || (count >= 0 && len(order) > skip+count) {
+			break
+		}
+	}
+
+	res := []*WalletTx{}
+	for i, txId := range order {
+		if i < skip {
+			continue
+		}
+		if count >= 0 && len(res) == count {
+			break
+		}
+		walletTx := walletTxs[txId]
+		for k, v := range walletTx.Amounts {
+			if v == 0 {
+				delete(walletTx.Amounts, k)
+			}
+		}
+		walletTx.SwapIds = r.swapLabels.SwapIds(txId)
+		res = append(res, walletTx)
+	}
+	return res, nil
+}
+
+// addListTransactionsEntry adds the amount of an entry to its transaction, the fee is repeated on every send entry
+// and only subtracted once
+func addListTransactionsEntry(walletTxs map[string]*WalletTx, order *[]string, entry *ListTransactionsRes, policyAsset string) error {
+	walletTx, ok := walletTxs[entry.TxId]
+	if !ok {
+		walletTx = &WalletTx{
+			TxId:        entry.TxId,
+			Amounts:     make(map[string]int64),
+			BlockHeight: entry.BlockHeight,
+			BlockTime:   entry.BlockTime,
+		}
+		if entry.Confirmations > 0 {
+			walletTx.Confirmations = uint32(entry.Confirmations)
+		}
+		walletTxs[entry.TxId] = walletTx
+		*order = append(*order, entry.TxId)
+	}
+	amount, err := parseSignedRpcAmount(entry.Amount.String())
+	if err != nil {
+		return err
+	}
+	walletTx.Amounts[entry.Asset] += amount
+
+	if walletTx.Fee == 0 && len(entry.Fee) > 0 {
+		fee, err := parseRpcFee(entry.Fee)
+		if err != nil {
+			return err
+		}
+		walletTx.Fee = uint64(-fee)
+		walletTx.Amounts[policyAsset] += fee
+	}
+	return nil
+}
+
+// parseRpcFee parses the negative fee of a send entry, which is a number or an amount per asset label
+func parseRpcFee(raw json.RawMessage) (int64, error) {
+	var fee json.Number
+	if err := json.Unmarshal(raw, &fee); err == nil {
+		return parseSignedRpcAmount(fee.String())
+	}
+	var fees map[string]json.Number
+	err := json.Unmarshal(raw, &fees)
+	if err != nil {
+		return 0, fmt.Errorf("invalid fee %s", raw)
+	}
+	var sum int64
+	for _, v := range fees {
+		fee, err := parseSignedRpcAmount(v.String())
+		if err != nil {
+			return 0, err
+		}
+		sum += fee
+	}
+	return sum, nil
+}
+
+// parseSignedRpcAmount parses an rpc amount that is negative for sends into base units
+func parseSignedRpcAmount(amount string) (int64, error) {
+	negative := strings.HasPrefix(amount, "-")
+	baseUnits, err := asset.ParseRpcAmount(strings.TrimPrefix(amount, "-"))
+	if err != nil {
+		return 0, err
+	}
+	if baseUnits > math.MaxInt64 {
+		return 0, asset.ErrAmountOverflow
+	}
+	if negative {
+		return -int64(baseUnits), nil
+	}
+	return int64(baseUnits), nil
+}
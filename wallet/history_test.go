@@ -0,0 +1,324 @@
+package wallet
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/vulpemventures/go-elements/network"
+)
+
+func TestParseRpcFee(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    int64
+		wantErr bool
+	}{
+		{`-0.0001`, -10000, false},
+		{`0`, 0, false},
+		{`{"bitcoin": -0.00002}`, -2000, false},
+		{`{"bitcoin": -0.00002, "other": -0.00000001}`, -2001, false},
+		{`"fee"`, 0, true},
+		{`{"bitcoin": "fee"}`, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRpcFee(json.RawMessage(tt.raw))
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseRpcFee(%s) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Fatalf("parseRpcFee(%s) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+// testListTransactions are the oldest listtransactions entries of the fake elementsd wallet: a receive, a self-send
+// with a send and a receive entry, a send to two outputs that repeats the fee on both entries and an asset send
+// with the fee as an amount per asset label
+var testListTransactions = `[
+	{"txid": "receive", "category": "receive", "amount": 1.0, "asset": "5ac9f65c0efcc4775e0baec4ec03abdde22473cd3cf33c0419ca290e0751b225", "confirmations": 104, "blockheight": 101, "blocktime": 6060},
+	{"txid": "self", "category": "send", "amount": -0.5, "asset": "5ac9f65c0efcc4775e0baec4ec03abdde22473cd3cf33c0419ca290e0751b225", "fee": -0.00001, "confirmations": 103, "blockheight": 102, "blocktime": 6120},
+	{"txid": "self", "category": "receive", "amount": 0.5, "asset": "5ac9f65c0efcc4775e0baec4ec03abdde22473cd3cf33c0419ca290e0751b225", "confirmations": 103, "blockheight": 102, "blocktime": 6120},
+	{"txid": "multi", "category": "send", "amount": -0.1, "asset": "5ac9f65c0efcc4775e0baec4ec03abdde22473cd3cf33c0419ca290e0751b225", "fee": -0.00002, "confirmations": 102, "blockheight": 103, "blocktime": 6180},
+	{"txid": "multi", "category": "send", "amount": -0.2, "asset": "5ac9f65c0efcc4775e0baec4ec03abdde22473cd3cf33c0419ca290e0751b225", "fee": -0.00002, "confirmations": 102, "blockheight": 103, "blocktime": 6180},
+	{"txid": "asset", "category": "send", "amount": -10, "asset": "f3d1ec678811398cd2ae277cbe3849c6f6dbd72c74bc542f7c4b11ff0e820958", "fee": {"bitcoin": -0.00003}, "confirmations": 101, "blockheight": 104, "blocktime": 6240}
+]`
+
+// testListTransactionsReceives is the number of receives after testListTransactions, it puts the first batch boundary
+// between the two entries of the multi send
+const testListTransactionsReceives = 98
+
+// fakeElementsd serves dumpassetlabels and listtransactions of an elementsd wallet
+type fakeElementsd struct {
+	entries []json.RawMessage
+	calls   int
+}
+
+func newFakeElementsd(t *testing.T) *fakeElementsd {
+	f := &fakeElementsd{}
+	err := json.Unmarshal([]byte(testListTransactions), &f.entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < testListTransactionsReceives; i++ {
+		height := 105 + i
+		f.entries = append(f.entries, json.RawMessage(fmt.Sprintf(
+			`{"txid": "receive%v", "category": "receive", "amount": 0.001, "asset": "%s", "confirmations": %v, "blockheight": %v, "blocktime": %v}`,
+			i, network.Regtest.AssetID, 205-height, height, height*60)))
+	}
+	return f
+}
+
+func (f *fakeElementsd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Id     int               `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var result interface{}
+	switch req.Method {
+	case "dumpassetlabels":
+		result = map[string]string{"bitcoin": network.Regtest.AssetID}
+	case "listtransactions":
+		f.calls++
+		var count, skip int
+		json.Unmarshal(req.Params[1], &count)
+		json.Unmarshal(req.Params[2], &skip)
+		// elementsd skips the most recent entries and returns the next count entries oldest first
+		end := len(f.entries) - skip
+		if end < 0 {
+			end = 0
+		}
+		start := end - count
+		if start < 0 {
+			start = 0
+		}
+		result = f.entries[start:end]
+	default:
+		http.Error(w, "unknown method "+req.Method, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": result})
+}
+
+func TestElementsRpcWalletListTransactions(t *testing.T) {
+	elementsd := newFakeElementsd(t)
+	server := httptest.NewServer(elementsd)
+	defer server.Close()
+	rpcClient, err := NewElementsdClient(strings.TrimPrefix(server.URL, "http://"), "user", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &ElementsRpcWallet{rpcClient: rpcClient, swapLabels: NewSwapLabels()}
+
+	lbtc := network.Regtest.AssetID
+	expected := map[string]*WalletTx{
+		"receive": {TxId: "receive", Amounts: map[string]int64{lbtc: 100000000}, Confirmations: 104, BlockHeight: 101, BlockTime: 6060},
+		"self":    {TxId: "self", Amounts: map[string]int64{lbtc: -1000}, Fee: 1000, Confirmations: 103, BlockHeight: 102, BlockTime: 6120},
+		"multi":   {TxId: "multi", Amounts: map[string]int64{lbtc: -30002000}, Fee: 2000, Confirmations: 102, BlockHeight: 103, BlockTime: 6180},
+		"asset":   {TxId: "asset", Amounts: map[string]int64{testAssetId: -1000000000, lbtc: -3000}, Fee: 3000, Confirmations: 101, BlockHeight: 104, BlockTime: 6240},
+	}
+	tests := []struct {
+		name        string
+		count, skip int
+		txIds       []string
+		calls       int
+	}{
+		{"first batch", 3, 0, []string{"receive97", "receive96", "receive95"}, 1},
+		{"send across the batch boundary", 2, 98, []string{"asset", "multi"}, 2},
+		{"last of the first batch", 1, 98, []string{"asset"}, 1},
+		{"oldest", 5, 101, []string{"receive"}, 2},
+		{"skip all", 5, 102, []string{}, 2},
+	}
+	for _, tt := range tests {
+		elementsd.calls = 0
+		walletTxs, err := r.ListTransactions(tt.count, tt.skip)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		txIds := []string{}
+		for _, v := range walletTxs {
+			txIds = append(txIds, v.TxId)
+			if want, ok := expected[v.TxId]; ok && !reflect.DeepEqual(v, want) {
+				t.Fatalf("%s: got %+v, want %+v", tt.name, v, want)
+			}
+		}
+		if !reflect.DeepEqual(txIds, tt.txIds) {
+			t.Fatalf("%s: got txs %v, want %v", tt.name, txIds, tt.txIds)
+		}
+		if elementsd.calls != tt.calls {
+			t.Fatalf("%s: got %v listtransactions calls, want %v", tt.name, elementsd.calls, tt.calls)
+		}
+	}
+
+	walletTxs, err := r.ListTransactions(-1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(walletTxs) != len(expected)+testListTransactionsReceives {
+		t.Fatalf("got %v txs, want %v", len(walletTxs), len(expected)+testListTransactionsReceives)
+	}
+	if got := walletTxs[0].Amounts[lbtc]; got != 100000 {
+		t.Fatalf("got receive of %v, want 100000", got)
+	}
+}
+
+func TestLiquidWalletListTransactions(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	l := newTestWallet(t, esplora, Bip84Derivation)
+	lbtc := network.Regtest.AssetID
+
+	var receive, change []string
+	for i := uint32(0); i < 3; i++ {
+		address, err := l.deriveAddress(0, ReceiveBranch, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		receive = append(receive, address)
+		address, err = l.deriveAddress(0, ChangeBranch, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		change = append(change, address)
+	}
+
+	lbtcFunding := esplora.addTx(t, 101, nil, fakeOutput{receive[0], lbtc, 100000})
+	assetFunding := esplora.addTx(t, 102, nil, fakeOutput{receive[0], testAssetId, 5000})
+	selfSend := esplora.addTx(t, 103, []*Outpoint{{lbtcFunding, 0}},
+		fakeOutput{receive[1], lbtc, 99000}, fakeOutput{"", lbtc, 1000})
+	multiSend := esplora.addTx(t, 104, []*Outpoint{{selfSend, 0}},
+		fakeOutput{externalAddress(t), lbtc, 20000}, fakeOutput{externalAddress(t), lbtc, 30000},
+		fakeOutput{change[0], lbtc, 48500}, fakeOutput{"", lbtc, 500})
+	assetSend := esplora.addTx(t, 105, []*Outpoint{{assetFunding, 0}, {multiSend, 2}},
+		fakeOutput{externalAddress(t), testAssetId, 5000}, fakeOutput{change[1], lbtc, 48200}, fakeOutput{"", lbtc, 300})
+	mempoolReceive := esplora.addTx(t, 0, nil, fakeOutput{receive[2], lbtc, 7000})
+
+	expected := []*WalletTx{
+		{TxId: mempoolReceive, Amounts: map[string]int64{lbtc: 7000}},
+		{TxId: assetSend, Amounts: map[string]int64{testAssetId: -5000, lbtc: -300}, Fee: 300, Confirmations: 1, BlockHeight: 105, BlockTime: 6300},
+		{TxId: multiSend, Amounts: map[string]int64{lbtc: -50500}, Fee: 500, Confirmations: 2, BlockHeight: 104, BlockTime: 6240},
+		{TxId: selfSend, Amounts: map[string]int64{lbtc: -1000}, Fee: 1000, Confirmations: 3, BlockHeight: 103, BlockTime: 6180},
+		{TxId: assetFunding, Amounts: map[string]int64{testAssetId: 5000}, Confirmations: 4, BlockHeight: 102, BlockTime: 6120},
+		{TxId: lbtcFunding, Amounts: map[string]int64{lbtc: 100000}, Confirmations: 5, BlockHeight: 101, BlockTime: 6060},
+	}
+	tests := []struct {
+		name        string
+		count, skip int
+		want        []*WalletTx
+	}{
+		{"all", -1, 0, expected},
+		{"mempool first", 2, 0, expected[:2]},
+		{"window", 3, 2, expected[2:5]},
+		{"past the end", 3, 5, expected[5:]},
+		{"skip all", 3, 6, []*WalletTx{}},
+	}
+	for _, tt := range tests {
+		walletTxs, err := l.ListTransactions(tt.count, tt.skip)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(walletTxs) != len(tt.want) {
+			t.Fatalf("%s: got %v txs, want %v", tt.name, len(walletTxs), len(tt.want))
+		}
+		for i, v := range walletTxs {
+			if !reflect.DeepEqual(v, tt.want[i]) {
+				t.Fatalf("%s: tx %v: got %+v, want %+v", tt.name, i, v, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestLiquidWalletListTransactionsPages(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	l := newTestWallet(t, esplora, Bip84Derivation)
+	address, err := l.deriveAddress(0, ReceiveBranch, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one page more than esplora returns at once
+	var txIds []string
+	for i := 0; i < esploraChainTxsPerPage+5; i++ {
+		txIds = append([]string{esplora.addTx(t, uint32(101+i), nil, fakeOutput{address, network.Regtest.AssetID, uint64(1000 + i)})}, txIds...)
+	}
+
+	walletTxs, err := l.ListTransactions(-1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(walletTxs) != len(txIds) {
+		t.Fatalf("got %v txs, want %v", len(walletTxs), len(txIds))
+	}
+	for i, v := range walletTxs {
+		if v.TxId != txIds[i] || v.Amounts[network.Regtest.AssetID] != int64(1000+len(txIds)-1-i) {
+			t.Fatalf("tx %v: got %+v, want %s", i, v, txIds[i])
+		}
+	}
+	if esplora.pageCalls[address] != 2 {
+		t.Fatalf("got %v page requests, want 2", esplora.pageCalls[address])
+	}
+
+	// the most recent transactions are on the first page
+	esplora.pageCalls[address] = 0
+	walletTxs, err = l.ListTransactions(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(walletTxs) != 3 || walletTxs[0].TxId != txIds[2] || esplora.pageCalls[address] != 1 {
+		t.Fatalf("got %+v after %v page requests, want 3 txs after 1", walletTxs, esplora.pageCalls[address])
+	}
+
+	esplora.pageCalls[address] = 0
+	walletTxs, err = l.ListTransactions(2, esploraChainTxsPerPage-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(walletTxs) != 2 || walletTxs[0].TxId != txIds[esploraChainTxsPerPage-1] || walletTxs[1].TxId != txIds[esploraChainTxsPerPage] {
+		t.Fatalf("unexpected txs across the page boundary %+v", walletTxs)
+	}
+	if esplora.pageCalls[address] != 2 {
+		t.Fatalf("got %v page requests, want 2", esplora.pageCalls[address])
+	}
+}
+
+func TestLiquidWalletListTransactionsSameBlock(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	l := newTestWallet(t, esplora, Bip84Derivation)
+	address, err := l.deriveAddress(0, ReceiveBranch, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last transaction of the first page shares its block with the transactions of the second page
+	var txIds []string
+	for i := 0; i < esploraChainTxsPerPage+3; i++ {
+		height := uint32(101 + i)
+		if i < 4 {
+			height = 101
+		}
+		txIds = append(txIds, esplora.addTx(t, height, nil, fakeOutput{address, network.Regtest.AssetID, uint64(1000 + i)}))
+	}
+	sameBlock := append([]string{}, txIds[:4]...)
+	sort.Strings(sameBlock)
+
+	walletTxs, err := l.ListTransactions(1, esploraChainTxsPerPage-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(walletTxs) != 1 || walletTxs[0].TxId != sameBlock[0] {
+		t.Fatalf("got %+v, want %s", walletTxs, sameBlock[0])
+	}
+	if esplora.pageCalls[address] != 2 {
+		t.Fatalf("got %v page requests, want 2", esplora.pageCalls[address])
+	}
+}
@@ -0,0 +1,69 @@
+package wallet
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// SwapTxLabeler records the swap a wallet transaction belongs to, so the transaction history can link it
+type SwapTxLabeler interface {
+	LabelSwapTx(txId string, swapId string) error
+}
+
+// SwapLabels maps wallet transactions to the ids of the swaps they belong to
+type SwapLabels struct {
+	// path is the json file the labels are persisted in, labels are only kept in memory if it is empty
+	path string
+
+	mu     sync.Mutex
+	labels map[string][]string
+}
+
+// NewSwapLabels returns labels that are kept for the lifetime of the process
+func NewSwapLabels() *SwapLabels {
+	return &SwapLabels{labels: make(map[string][]string)}
+}
+
+// LoadSwapLabels loads the labels from a json file, which is created on the first label
+func LoadSwapLabels(path string) (*SwapLabels, error) {
+	s := &SwapLabels{path: path, labels: make(map[string][]string)}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &s.labels)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LabelSwapTx links the transaction to the swap
+func (s *SwapLabels) LabelSwapTx(txId string, swapId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if containsString(s.labels[txId], swapId) {
+		return nil
+	}
+	s.labels[txId] = append(s.labels[txId], swapId)
+	if s.path == "" {
+		return nil
+	}
+	buf, err := json.MarshalIndent(s.labels, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, buf)
+}
+
+// SwapIds returns the ids of the swaps the transaction belongs to
+func (s *SwapLabels) SwapIds(txId string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.labels[txId]...)
+}
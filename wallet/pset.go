@@ -0,0 +1,255 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	elemaddr "github.com/vulpemventures/go-elements/address"
+	"github.com/vulpemventures/go-elements/payment"
+	"github.com/vulpemventures/go-elements/pset"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+var (
+	ErrNoWalletInputs = errors.New("pset has no inputs of the wallet")
+)
+
+// Recipient is an output of a pset created by the wallet
+type Recipient struct {
+	Address string
+	AssetId string
+	Amount  uint64
+}
+
+// CreatePset funds, and if needed blinds, a transaction paying the recipients and returns it as unsigned base64 pset.
+// The wallet inputs carry their bip32 derivation, so offline wallets and hardware signers can find their keys.
+// Watch-only wallets only add derivations if their descriptor has a key origin
+func (l *LiquidWallet) CreatePset(recipients []*Recipient) (string, error) {
+	if len(recipients) == 0 {
+		return "", errors.New("expected at least one recipient")
+	}
+	err := l.Sync()
+	if err != nil {
+		return "", err
+	}
+
+	tx := transaction.NewTx(2)
+	receiverKeys := make(map[*transaction.TxOutput][]byte)
+	for _, v := range recipients {
+		receiverOutput, keys, err := newReceiverOutput(v.Address, v.AssetId, v.Amount)
+		if err != nil {
+			return "", err
+		}
+		tx.Outputs = append(tx.Outputs, receiverOutput)
+		for k, v := range keys {
+			receiverKeys[k] = v
+		}
+	}
+
+	walletInputs, changeOutputs, err := l.fundTransaction(tx, tx.Outputs, receiverKeys)
+	if err != nil {
+		return "", err
+	}
+	tx, err = l.blindTransaction(tx, 0, walletInputs, receiverKeys, changeOutputs)
+	if err != nil {
+		return "", err
+	}
+	p, err := l.newWalletPset(tx, walletInputs)
+	if err != nil {
+		return "", err
+	}
+	return p.ToBase64()
+}
+
+// newWalletPset creates a pset of the transaction with the prevouts, redeem scripts and derivations of the wallet inputs
+func (l *LiquidWallet) newWalletPset(tx *transaction.Transaction, walletInputs []*EsploraUtxo) (*pset.Pset, error) {
+	p, err := pset.NewPsetFromUnsignedTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	updater, err := pset.NewUpdater(p)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range walletInputs {
+		addrInfo, ok := l.getAddressInfo(v.Address)
+		if !ok {
+			return nil, fmt.Errorf("unknown input address %s", v.Address)
+		}
+		prevout, err := v.prevout()
+		if err != nil {
+			return nil, err
+		}
+		err = updater.AddInWitnessUtxo(prevout, i)
+		if err != nil {
+			return nil, err
+		}
+		err = updater.AddInSighashType(txscript.SigHashAll, i)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := l.deriveKey(addrInfo.Account, addrInfo.Branch, addrInfo.Derivation)
+		if err != nil {
+			return nil, err
+		}
+		pubkey, err := key.ECPubKey()
+		if err != nil {
+			return nil, err
+		}
+		if elemaddr.GetScriptType(prevout.Script) == elemaddr.P2ShScript {
+			p2wpkh := payment.FromPublicKey(pubkey, l.liquidNetwork, nil)
+			err = updater.AddInRedeemScript(p2wpkh.WitnessScript, i)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		origin, err := l.accountOrigin(addrInfo.Account)
+		if err != nil {
+			return nil, err
+		}
+		if origin == nil {
+			continue
+		}
+		origin = origin.child(addrInfo.Branch, addrInfo.Derivation)
+		err = updater.AddInBip32Derivation(origin.psbtFingerprint(), origin.path, pubkey.SerializeCompressed(), i)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// SignPset signs the inputs of the pset whose bip32 derivation belongs to the wallet master key.
+// It does not need the wallet to be synced, so it works on an offline machine
+func (l *LiquidWallet) SignPset(psetBase64 string) (string, error) {
+	if l.IsWatchOnly() {
+		return "", ErrWatchOnly
+	}
+	p, err := pset.NewPsetFromBase64(psetBase64)
+	if err != nil {
+		return "", err
+	}
+	fingerprint, err := l.masterFingerprint()
+	if err != nil {
+		return "", err
+	}
+	origin := &keyOrigin{fingerprint: fingerprint}
+
+	signed := 0
+	for i, input := range p.Inputs {
+		for _, derivation := range input.Bip32Derivation {
+			if derivation.MasterKeyFingerprint != origin.psbtFingerprint() {
+				continue
+			}
+			key := l.masterKey
+			for _, v := range derivation.Bip32Path {
+				key, err = key.Derive(v)
+				if err != nil {
+					return "", err
+				}
+			}
+			privkey, err := key.ECPrivKey()
+			if err != nil {
+				return "", err
+			}
+			if !bytes.Equal(privkey.PubKey().SerializeCompressed(), derivation.PubKey) {
+				return "", fmt.Errorf("input %v: derivation %v does not match its pubkey", i, origin.child(derivation.Bip32Path...))
+			}
+			err = SignPsetInput(p, i, &keySigner{privkey: privkey})
+			if err != nil {
+				return "", err
+			}
+			signed++
+		}
+	}
+	if signed == 0 {
+		return "", ErrNoWalletInputs
+	}
+	return p.ToBase64()
+}
+
+// SignPsetInput adds the signature of a p2wpkh or p2sh-p2wpkh input to the pset, the signer holds the key of the input
+func SignPsetInput(p *pset.Pset, inIndex int, signer Signer) error {
+	if inIndex >= len(p.Inputs) {
+		return fmt.Errorf("pset has no input %v", inIndex)
+	}
+	input := p.Inputs[inIndex]
+	if input.WitnessUtxo == nil {
+		return fmt.Errorf("input %v: missing witness utxo", inIndex)
+	}
+	p2wpkh := payment.FromPublicKey(signer.PubKey(), nil, nil)
+
+	var redeemScript []byte
+	switch elemaddr.GetScriptType(input.WitnessUtxo.Script) {
+	case elemaddr.P2WpkhScript:
+	case elemaddr.P2ShScript:
+		redeemScript = p2wpkh.WitnessScript
+	default:
+		return fmt.Errorf("input %v: input should be p2wpkh or p2sh-p2wpkh", inIndex)
+	}
+
+	// the script code of both input types is the p2pkh script of the key
+	sigHash := p.UnsignedTx.HashForWitnessV0(inIndex, p2wpkh.Script, input.WitnessUtxo.Value, txscript.SigHashAll)
+	signature, err := signer.Sign(sigHash[:])
+	if err != nil {
+		return err
+	}
+	updater, err := pset.NewUpdater(p)
+	if err != nil {
+		return err
+	}
+	sigWithHashType := append(signature.Serialize(), byte(txscript.SigHashAll))
+	_, err = updater.Sign(inIndex, sigWithHashType, signer.PubKey().SerializeCompressed(), redeemScript, nil)
+	return err
+}
+
+// FinalizePset validates the signatures of a pset signed by an external signer and extracts the transaction hex
+func FinalizePset(psetBase64 string) (string, error) {
+	p, err := pset.NewPsetFromBase64(psetBase64)
+	if err != nil {
+		return "", err
+	}
+	valid, err := p.ValidateAllSignatures()
+	if err != nil {
+		return "", err
+	}
+	if !valid {
+		return "", errors.New("pset has invalid signatures")
+	}
+	err = pset.FinalizeAll(p)
+	if err != nil {
+		return "", err
+	}
+	tx, err := pset.Extract(p)
+	if err != nil {
+		return "", err
+	}
+	return tx.ToHex()
+}
+
+// FinalizeAndSendPset finalizes a signed pset and broadcasts the transaction
+func (l *LiquidWallet) FinalizeAndSendPset(psetBase64 string) (string, error) {
+	txHex, err := FinalizePset(psetBase64)
+	if err != nil {
+		return "", err
+	}
+	return l.SendRawTransaction(txHex)
+}
+
+// keySigner signs with a private key held in memory
+type keySigner struct {
+	privkey *btcec.PrivateKey
+}
+
+func (k *keySigner) Sign(hash []byte) (*btcec.Signature, error) {
+	return k.privkey.Sign(hash)
+}
+
+func (k *keySigner) PubKey() *btcec.PublicKey {
+	return k.privkey.PubKey()
+}
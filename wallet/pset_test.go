@@ -0,0 +1,133 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/vulpemventures/go-elements/network"
+	"github.com/vulpemventures/go-elements/pset"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+// testFingerprint is the master key fingerprint of the test mnemonic
+const testFingerprint = "73c5da0a"
+
+func TestPsetRoundTrip(t *testing.T) {
+	for _, scheme := range []*DerivationScheme{Bip84Derivation, Bip49Derivation} {
+		esplora := newFakeEsplora(100)
+		seeded := newTestWallet(t, esplora, scheme)
+		lbtc := network.Regtest.AssetID
+		receive := testAddresses(t, seeded, 0, ReceiveBranch, 1)
+		fundingTxId := esplora.addTx(t, 101, nil, fakeOutput{receive[0], lbtc, 100000})
+
+		// the watch-only wallet creates the pset, the seeded wallet signs it offline
+		descriptor, err := seeded.GetDescriptor()
+		if err != nil {
+			t.Fatal(err)
+		}
+		watchOnly := NewLiquidWallet(esplora, testChainParams(), &network.Regtest)
+		watchOnly.SetGapLimit(5)
+		err = watchOnly.InitializeFromDescriptor(descriptor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		receiver := externalAddress(t)
+		psetBase64, err := watchOnly.CreatePset([]*Recipient{{Address: receiver, AssetId: lbtc, Amount: 40000}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := pset.NewPsetFromBase64(psetBase64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Inputs) != 1 || len(p.Inputs[0].Bip32Derivation) != 1 {
+			t.Fatalf("%s: expected one input with its derivation", scheme.Name)
+		}
+		prevout := esplora.txs[fundingTxId].Outputs[0]
+		input := p.Inputs[0]
+		derivation := input.Bip32Derivation[0]
+
+		// the fingerprint is serialized in the byte order of the key origin, followed by the little endian path
+		fingerprint := h2b(testFingerprint)
+		if derivation.MasterKeyFingerprint != binary.LittleEndian.Uint32(fingerprint) {
+			t.Fatalf("%s: got fingerprint %08x, want %s", scheme.Name, derivation.MasterKeyFingerprint, testFingerprint)
+		}
+		wantPath := []uint32{hdkeychain.HardenedKeyStart + scheme.Purpose, hdkeychain.HardenedKeyStart + CoinType(&network.Regtest),
+			hdkeychain.HardenedKeyStart, ReceiveBranch, 0}
+		keyPath := append([]byte{}, fingerprint...)
+		for i, v := range wantPath {
+			if i >= len(derivation.Bip32Path) || derivation.Bip32Path[i] != v {
+				t.Fatalf("%s: got path %v, want %v", scheme.Name, derivation.Bip32Path, wantPath)
+			}
+			keyPath = append(keyPath, make([]byte, 4)...)
+			binary.LittleEndian.PutUint32(keyPath[len(keyPath)-4:], v)
+		}
+		raw, err := base64.StdEncoding.DecodeString(psetBase64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(raw, keyPath) {
+			t.Fatalf("%s: pset does not contain the key origin %x", scheme.Name, keyPath)
+		}
+
+		// nested segwit inputs carry the p2wpkh redeem script of the p2sh output
+		redeemScript := append([]byte{0x00, 0x14}, btcutil.Hash160(derivation.PubKey)...)
+		if scheme == Bip49Derivation {
+			if !bytes.Equal(input.RedeemScript, redeemScript) || !bytes.Equal(btcutil.Hash160(input.RedeemScript), prevout.Script[2:22]) {
+				t.Fatalf("got redeem script %x, want %x", input.RedeemScript, redeemScript)
+			}
+		} else if len(input.RedeemScript) != 0 {
+			t.Fatalf("%s: unexpected redeem script %x", scheme.Name, input.RedeemScript)
+		}
+
+		_, err = watchOnly.SignPset(psetBase64)
+		if err != ErrWatchOnly {
+			t.Fatalf("%s: got %v, want ErrWatchOnly", scheme.Name, err)
+		}
+		signed, err := seeded.SignPset(psetBase64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txHex, err := FinalizePset(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, err := transaction.NewTxFromHex(txHex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := tx.Inputs[0]
+		if scheme == Bip49Derivation {
+			if !bytes.Equal(in.Script, append([]byte{byte(len(redeemScript))}, redeemScript...)) {
+				t.Fatalf("got script sig %x, want the redeem script push", in.Script)
+			}
+		} else {
+			verifyInput(t, tx, 0, prevout)
+		}
+		if len(in.Witness) != 2 || !bytes.Equal(in.Witness[1], derivation.PubKey) {
+			t.Fatalf("%s: unexpected witness", scheme.Name)
+		}
+		sig := in.Witness[0]
+		if txscript.SigHashType(sig[len(sig)-1]) != txscript.SigHashAll {
+			t.Fatalf("%s: unexpected sighash type %x", scheme.Name, sig[len(sig)-1])
+		}
+
+		_, err = watchOnly.FinalizeAndSendPset(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		posted, err := esplora.lastPosted(t).ToHex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if posted != txHex {
+			t.Fatalf("%s: broadcast a different transaction", scheme.Name)
+		}
+	}
+}
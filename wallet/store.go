@@ -0,0 +1,104 @@
+package wallet
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// WalletStore persists the sync state of a LiquidWallet between runs
+type WalletStore interface {
+	// LoadSyncState returns the stored sync state or nil if nothing was stored yet
+	LoadSyncState() (*SyncState, error)
+	SaveSyncState(state *SyncState) error
+}
+
+// FileStore stores the sync state as json file
+type FileStore struct {
+	path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (f *FileStore) LoadSyncState() (*SyncState, error) {
+	stateBytes, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state *SyncState
+	err = json.Unmarshal(stateBytes, &state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// SaveSyncState writes the state to a temporary file first, so a crash does not leave a corrupted store behind
+func (f *FileStore) SaveSyncState(state *SyncState) error {
+	stateBytes, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.path, stateBytes)
+}
+
+// writeFileAtomic replaces the file by renaming a completely written temporary file
+func writeFileAtomic(path string, data []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(data)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// MemoryStore keeps the sync state for the lifetime of the process
+type MemoryStore struct {
+	mu         sync.Mutex
+	stateBytes []byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (m *MemoryStore) LoadSyncState() (*SyncState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stateBytes == nil {
+		return nil, nil
+	}
+	var state *SyncState
+	err := json.Unmarshal(m.stateBytes, &state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (m *MemoryStore) SaveSyncState(state *SyncState) error {
+	stateBytes, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stateBytes = stateBytes
+	return nil
+}
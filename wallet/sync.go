@@ -0,0 +1,430 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	// DefaultGapLimit is the number of consecutive unused addresses after which a scan stops
+	DefaultGapLimit = 20
+
+	// DefaultSyncConcurrency is the maximum number of parallel esplora requests during a sync
+	DefaultSyncConcurrency = 8
+)
+
+// BatchChainApi is implemented by backends that fetch the activity of many addresses in one round trip, e.g. electrum.
+// The results are in the order of the addresses
+type BatchChainApi interface {
+	GetAddressesStats(addresses []string) ([]*AddressStats, error)
+	GetUtxosFromAddresses(addresses []string) ([][]*EsploraUtxo, error)
+}
+
+// ChainSubscriber is implemented by backends that push activity of subscribed addresses and new blocks, e.g. electrum
+type ChainSubscriber interface {
+	// SubscribeAddresses subscribes to the addresses, addresses that are already subscribed are skipped
+	SubscribeAddresses(addresses []string) error
+
+	// ChainUpdates is signalled when a subscribed address has new activity or a block is connected
+	ChainUpdates() <-chan struct{}
+}
+
+// AddressState is the cached activity of a wallet address
+type AddressState struct {
+	Account        uint32         `json:"account"`
+	Branch         uint32         `json:"branch"`
+	Index          uint32         `json:"index"`
+	Address        string         `json:"address"`
+	ChainTxCount   uint32         `json:"chain_tx_count"`
+	MempoolTxCount uint32         `json:"mempool_tx_count"`
+	Utxos          []*EsploraUtxo `json:"utxos"`
+}
+
+func (a *AddressState) isUsed() bool {
+	return a.ChainTxCount+a.MempoolTxCount > 0
+}
+
+// isActive returns true if the address can still change, that is it is unused, holds utxos or has unconfirmed
+// transactions. Addresses that were used and spent are only scanned again by a full sync
+func (a *AddressState) isActive() bool {
+	return !a.isUsed() || len(a.Utxos) > 0 || a.MempoolTxCount > 0
+}
+
+// BranchState holds the addresses of an account branch
+type BranchState struct {
+	Account uint32 `json:"account"`
+	Branch  uint32 `json:"branch"`
+
+	// Addresses are indexed by their derivation index
+	Addresses []*AddressState `json:"addresses"`
+}
+
+// lastUsedIndex returns the derivation index of the last address with activity, or -1 for an unused branch
+func (b *BranchState) lastUsedIndex() int {
+	for i := len(b.Addresses) - 1; i >= 0; i-- {
+		if b.Addresses[i].isUsed() {
+			return i
+		}
+	}
+	return -1
+}
+
+// SyncState is the view of the wallet addresses that is persisted between syncs
+type SyncState struct {
+	// Scheme and FirstAddress identify the wallet the state belongs to
+	Scheme       string `json:"scheme"`
+	FirstAddress string `json:"first_address"`
+
+	Branches []*BranchState `json:"branches"`
+}
+
+// getBranch returns the state of an account branch, adding it if it is not known yet
+func (s *SyncState) getBranch(account uint32, branch uint32) *BranchState {
+	for _, v := range s.Branches {
+		if v.Account == account && v.Branch == branch {
+			return v
+		}
+	}
+	branchState := &BranchState{Account: account, Branch: branch}
+	s.Branches = append(s.Branches, branchState)
+	return branchState
+}
+
+// SetWalletStore sets the store the sync state is persisted in, it needs to be set before Initialize
+func (l *LiquidWallet) SetWalletStore(store WalletStore) {
+	l.store = store
+}
+
+// SetGapLimit sets the number of consecutive unused addresses after which a scan stops
+func (l *LiquidWallet) SetGapLimit(gapLimit int) {
+	l.gapLimit = gapLimit
+}
+
+// SetSyncConcurrency sets the maximum number of parallel esplora requests during a sync
+func (l *LiquidWallet) SetSyncConcurrency(syncConcurrency int) {
+	l.syncConcurrency = syncConcurrency
+}
+
+// loadSyncState loads the sync state from the store, states of other wallets are discarded
+func (l *LiquidWallet) loadSyncState() error {
+	firstAddress, err := l.deriveAddress(0, ReceiveBranch, 0)
+	if err != nil {
+		return err
+	}
+	state, err := l.store.LoadSyncState()
+	if err != nil {
+		return err
+	}
+	if state == nil || state.Scheme != l.scheme.Name || state.FirstAddress != firstAddress {
+		state = &SyncState{Scheme: l.scheme.Name, FirstAddress: firstAddress}
+	}
+	l.state = state
+	l.synced = false
+	l.updateUtxoMap()
+	return nil
+}
+
+// Sync scans the wallet addresses of every branch up to the gap limit after the last used address
+// and refreshes the utxos of the addresses with new activity. Accounts are discovered until the first unused one.
+// The first sync after the state was loaded checks every address, later syncs only check the active addresses
+func (l *LiquidWallet) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tipHeight, err := l.esplora.GetBlockHeight()
+	if err != nil {
+		return err
+	}
+	l.tipHeight = tipHeight
+
+	for account := uint32(0); account < l.maxAccounts(); account++ {
+		accountUsed := false
+		for _, branch := range l.scheme.branches() {
+			branchState := l.state.getBranch(account, branch)
+			err := l.syncBranch(branchState, !l.synced)
+			if err != nil {
+				return err
+			}
+			if branchState.lastUsedIndex() >= 0 {
+				accountUsed = true
+			}
+		}
+		if !accountUsed {
+			break
+		}
+	}
+	l.synced = true
+	l.updateUtxoMap()
+	err = l.store.SaveSyncState(l.state)
+	if err != nil {
+		return err
+	}
+	return l.subscribeAddresses()
+}
+
+// subscribeAddresses subscribes to the scanned addresses if the backend pushes updates
+func (l *LiquidWallet) subscribeAddresses() error {
+	subscriber, ok := l.esplora.(ChainSubscriber)
+	if !ok {
+		return nil
+	}
+	addresses := make([]string, 0, len(l.addressToUtxoMap))
+	for k := range l.addressToUtxoMap {
+		addresses = append(addresses, k)
+	}
+	return subscriber.SubscribeAddresses(addresses)
+}
+
+// WatchChain syncs the wallet whenever the backend pushes activity of a wallet address or a new block,
+// onSync is called with the result of every sync. It returns once ctx is done, or at once if the backend
+// does not push updates
+func (l *LiquidWallet) WatchChain(ctx context.Context, onSync func(err error)) error {
+	subscriber, ok := l.esplora.(ChainSubscriber)
+	if !ok {
+		return errors.New("chain backend does not push updates")
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-subscriber.ChainUpdates():
+		}
+		// the update may be for a spent address, which only a full sync checks
+		l.mu.Lock()
+		l.synced = false
+		l.mu.Unlock()
+		err := l.Sync()
+		if onSync != nil {
+			onSync(err)
+		}
+	}
+}
+
+// syncBranch scans the addresses of a branch until the gap limit is reached, inactive addresses are skipped unless all is set
+func (l *LiquidWallet) syncBranch(branchState *BranchState, all bool) error {
+	start := 0
+	for {
+		end := branchState.lastUsedIndex() + 1 + l.gapLimit
+		if start >= end {
+			return nil
+		}
+		err := l.syncAddresses(branchState, start, end, all)
+		if err != nil {
+			return err
+		}
+		start = end
+	}
+}
+
+// syncAddresses refreshes the addresses of a branch from index start up to end, in one batch if the backend
+// supports it or else concurrently. Inactive addresses are skipped unless all is set
+func (l *LiquidWallet) syncAddresses(branchState *BranchState, start, end int, all bool) error {
+	var addrStates []*AddressState
+	for i := start; i < end; i++ {
+		addrState, err := l.getAddressState(branchState, uint32(i))
+		if err != nil {
+			return err
+		}
+		if all || addrState.isActive() {
+			addrStates = append(addrStates, addrState)
+		}
+	}
+	if batchApi, ok := l.esplora.(BatchChainApi); ok {
+		return l.refreshAddressesBatch(batchApi, addrStates)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, l.syncConcurrency)
+	for _, v := range addrStates {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(addrState *AddressState) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := l.refreshAddress(addrState)
+			if err != nil {
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMu.Unlock()
+			}
+		}(v)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// refreshAddressesBatch refreshes the addresses like refreshAddress with one request for the stats and one for the utxos
+func (l *LiquidWallet) refreshAddressesBatch(batchApi BatchChainApi, addrStates []*AddressState) error {
+	addresses := make([]string, len(addrStates))
+	for i, v := range addrStates {
+		addresses[i] = v.Address
+	}
+	stats, err := batchApi.GetAddressesStats(addresses)
+	if err != nil {
+		return err
+	}
+	if len(stats) != len(addrStates) {
+		return fmt.Errorf("expected stats of %v addresses, got %v", len(addrStates), len(stats))
+	}
+
+	// the states are only updated once the utxos are fetched, so a failed refresh is retried on the next sync
+	type refresh struct {
+		addrState      *AddressState
+		chainTxCount   uint32
+		mempoolTxCount uint32
+		utxos          []*EsploraUtxo
+	}
+	var refreshes []*refresh
+	var usedAddresses []string
+	var used []*refresh
+	for i, v := range addrStates {
+		chainTxCount, mempoolTxCount := stats[i].txCounts()
+		if chainTxCount == v.ChainTxCount && mempoolTxCount == v.MempoolTxCount {
+			continue
+		}
+		r := &refresh{addrState: v, chainTxCount: chainTxCount, mempoolTxCount: mempoolTxCount}
+		refreshes = append(refreshes, r)
+		if chainTxCount+mempoolTxCount > 0 {
+			used = append(used, r)
+			usedAddresses = append(usedAddresses, v.Address)
+		}
+	}
+
+	if len(used) > 0 {
+		utxos, err := batchApi.GetUtxosFromAddresses(usedAddresses)
+		if err != nil {
+			return err
+		}
+		if len(utxos) != len(used) {
+			return fmt.Errorf("expected utxos of %v addresses, got %v", len(used), len(utxos))
+		}
+		for i, v := range used {
+			v.utxos, err = l.unblindUtxos(utxos[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, v := range refreshes {
+		v.addrState.ChainTxCount = v.chainTxCount
+		v.addrState.MempoolTxCount = v.mempoolTxCount
+		v.addrState.Utxos = v.utxos
+	}
+	return nil
+}
+
+// txCounts returns the number of confirmed and mempool transactions of the address
+func (a *AddressStats) txCounts() (uint32, uint32) {
+	var chainTxCount, mempoolTxCount uint32
+	if a.ChainStats != nil {
+		chainTxCount = a.ChainStats.TxCount
+	}
+	if a.MempoolStats != nil {
+		mempoolTxCount = a.MempoolStats.TxCount
+	}
+	return chainTxCount, mempoolTxCount
+}
+
+// refreshAddress fetches the address stats and only fetches the utxos if the activity changed since the last sync
+func (l *LiquidWallet) refreshAddress(addrState *AddressState) error {
+	addrStats, err := l.esplora.GetAddressStats(addrState.Address)
+	if err != nil {
+		return err
+	}
+	chainTxCount, mempoolTxCount := addrStats.txCounts()
+	if chainTxCount == addrState.ChainTxCount && mempoolTxCount == addrState.MempoolTxCount {
+		return nil
+	}
+
+	var utxos []*EsploraUtxo
+	if chainTxCount+mempoolTxCount > 0 {
+		utxos, err = l.esplora.GetUtxosFromAddress(addrState.Address)
+		if err != nil {
+			return err
+		}
+		utxos, err = l.unblindUtxos(utxos)
+		if err != nil {
+			return err
+		}
+	}
+	addrState.ChainTxCount = chainTxCount
+	addrState.MempoolTxCount = mempoolTxCount
+	addrState.Utxos = utxos
+	return nil
+}
+
+// getAddressState returns the state of the address at the derivation index, deriving new addresses as needed
+func (l *LiquidWallet) getAddressState(branchState *BranchState, index uint32) (*AddressState, error) {
+	for i := uint32(len(branchState.Addresses)); i <= index; i++ {
+		addr, err := l.deriveAddress(branchState.Account, branchState.Branch, i)
+		if err != nil {
+			return nil, err
+		}
+		branchState.Addresses = append(branchState.Addresses, &AddressState{
+			Account: branchState.Account,
+			Branch:  branchState.Branch,
+			Index:   i,
+			Address: addr,
+		})
+	}
+	return branchState.Addresses[index], nil
+}
+
+// nextUnusedAddress returns the first address of the first account branch after the last used one. It checks
+// the address for activity since the last sync, so an address is not handed out again after it was paid to
+func (l *LiquidWallet) nextUnusedAddress(branch uint32) (*AddressState, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	branchState := l.state.getBranch(0, branch)
+	for {
+		addrState, err := l.getAddressState(branchState, uint32(branchState.lastUsedIndex()+1))
+		if err != nil {
+			return nil, err
+		}
+		err = l.refreshAddress(addrState)
+		if err != nil {
+			return nil, err
+		}
+		if !addrState.isUsed() {
+			l.updateUtxoMap()
+			return addrState, l.store.SaveSyncState(l.state)
+		}
+	}
+}
+
+// getAddressInfo returns the derivation and the utxos of a wallet address
+func (l *LiquidWallet) getAddressInfo(address string) (*AddressInfo, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	addrInfo, ok := l.addressToUtxoMap[address]
+	return addrInfo, ok
+}
+
+// updateUtxoMap rebuilds the address map and utxo list from the sync state
+func (l *LiquidWallet) updateUtxoMap() {
+	addressToUtxoMap := make(map[string]*AddressInfo)
+	utxos := []*EsploraUtxo{}
+	for _, branchState := range l.state.Branches {
+		for _, v := range branchState.Addresses {
+			addressToUtxoMap[v.Address] = &AddressInfo{
+				Account:    v.Account,
+				Branch:     v.Branch,
+				Derivation: v.Index,
+				Address:    v.Address,
+				Utxos:      v.Utxos,
+			}
+			utxos = append(utxos, v.Utxos...)
+		}
+	}
+	l.addressToUtxoMap = addressToUtxoMap
+	l.utxos = utxos
+}
@@ -0,0 +1,216 @@
+package wallet
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/tyler-smith/go-bip39"
+	"github.com/vulpemventures/go-elements/network"
+)
+
+// newStoredTestWallet returns a bip84 test wallet that persists its sync state in the file
+func newStoredTestWallet(t *testing.T, esplora EsploraApi, path string) *LiquidWallet {
+	seed, err := bip39.NewSeedWithErrorChecking(testMnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLiquidWallet(esplora, testChainParams(), &network.Regtest)
+	l.SetDerivationScheme(Bip84Derivation)
+	l.SetGapLimit(5)
+	l.SetWalletStore(NewFileStore(path))
+	err = l.Initialize(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// testAddresses derives the first addresses of an account branch of the wallet
+func testAddresses(t *testing.T, l *LiquidWallet, account uint32, branch uint32, count uint32) []string {
+	var addresses []string
+	for i := uint32(0); i < count; i++ {
+		address, err := l.deriveAddress(account, branch, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+func TestSyncGapLimit(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	l := newTestWallet(t, esplora, Bip84Derivation)
+	receive := testAddresses(t, l, 0, ReceiveBranch, 11)
+	change := testAddresses(t, l, 0, ChangeBranch, 6)
+	account1 := testAddresses(t, l, 1, ReceiveBranch, 1)
+
+	// an unused wallet scans the gap limit of both branches of the first account
+	for i := 0; i < 5; i++ {
+		if esplora.statsCalls[receive[i]] != 1 || esplora.statsCalls[change[i]] != 1 {
+			t.Fatalf("address %v was not scanned", i)
+		}
+	}
+	if esplora.statsCalls[receive[5]] != 0 || esplora.statsCalls[change[5]] != 0 || esplora.statsCalls[account1[0]] != 0 {
+		t.Fatal("scanned past the gap limit")
+	}
+
+	// a used address extends the scan to the gap limit after it
+	esplora.addTx(t, 101, nil, fakeOutput{receive[4], network.Regtest.AssetID, 1000})
+	err := l.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if esplora.statsCalls[receive[5]] != 1 || esplora.statsCalls[receive[9]] != 1 || esplora.statsCalls[receive[10]] != 0 {
+		t.Fatal("addresses up to the gap limit after the used address were not scanned")
+	}
+	if len(l.state.getBranch(0, ReceiveBranch).Addresses) != 10 {
+		t.Fatalf("got %v receive addresses, want 10", len(l.state.getBranch(0, ReceiveBranch).Addresses))
+	}
+	if esplora.statsCalls[change[5]] != 0 {
+		t.Fatal("scanned the unused change branch past the gap limit")
+	}
+	if esplora.statsCalls[account1[0]] != 1 {
+		t.Fatal("the account after the used account was not discovered")
+	}
+}
+
+func TestSyncRefreshesActiveAddresses(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	path := filepath.Join(t.TempDir(), "wallet.json")
+	l := newStoredTestWallet(t, esplora, path)
+	receive := testAddresses(t, l, 0, ReceiveBranch, 2)
+	lbtc := network.Regtest.AssetID
+
+	fundingTxId := esplora.addTx(t, 101, nil, fakeOutput{receive[0], lbtc, 1000})
+	utxos, err := l.GetUtxos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 1 || utxos[0].SatAmt != 1000 || esplora.utxoCalls[receive[0]] != 1 {
+		t.Fatalf("unexpected utxos %+v", utxos)
+	}
+
+	// the utxos of addresses without new transactions are not fetched again
+	utxos, err = l.GetUtxos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 1 || esplora.utxoCalls[receive[0]] != 1 || esplora.statsCalls[receive[0]] != 3 {
+		t.Fatalf("unchanged address was refreshed, %v utxo requests", esplora.utxoCalls[receive[0]])
+	}
+	if esplora.utxoCalls[receive[1]] != 0 {
+		t.Fatal("fetched the utxos of an unused address")
+	}
+
+	mempoolTxId := esplora.addTx(t, 0, nil, fakeOutput{receive[0], lbtc, 2000})
+	balances, err := l.GetBalances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balances[lbtc] != 3000 || esplora.utxoCalls[receive[0]] != 2 {
+		t.Fatalf("got balance %v after %v utxo requests, want 3000 after 2", balances[lbtc], esplora.utxoCalls[receive[0]])
+	}
+
+	// a spent address is refreshed until its transactions confirm
+	esplora.addTx(t, 102, []*Outpoint{{TxId: fundingTxId, Vout: 0}}, fakeOutput{externalAddress(t), lbtc, 1000})
+	spendTxId := esplora.addTx(t, 0, []*Outpoint{{TxId: mempoolTxId, Vout: 0}}, fakeOutput{externalAddress(t), lbtc, 2000})
+	err = l.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statsCalls := esplora.statsCalls[receive[0]]
+	err = l.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if esplora.statsCalls[receive[0]] != statsCalls+1 {
+		t.Fatal("the address with unconfirmed transactions was not refreshed")
+	}
+	esplora.mu.Lock()
+	for _, v := range []string{mempoolTxId, spendTxId} {
+		esplora.statuses[v].Confirmed = true
+		esplora.statuses[v].BlockHeight = 103
+	}
+	esplora.mu.Unlock()
+	err = l.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the spent address has no utxos and confirmed transactions only, it is not checked again
+	statsCalls = esplora.statsCalls[receive[0]]
+	balances, err = l.GetBalances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balances[lbtc] != 0 || esplora.statsCalls[receive[0]] != statsCalls {
+		t.Fatalf("got balance %v, spent address was checked %v times", balances[lbtc], esplora.statsCalls[receive[0]]-statsCalls)
+	}
+	if esplora.statsCalls[receive[1]] == 0 {
+		t.Fatal("the unused address was not checked")
+	}
+
+	// the first sync of a restarted wallet checks every address
+	utxoCalls := esplora.utxoCalls[receive[0]]
+	newStoredTestWallet(t, esplora, path)
+	if esplora.statsCalls[receive[0]] != statsCalls+1 || esplora.utxoCalls[receive[0]] != utxoCalls {
+		t.Fatal("the full sync did not check the spent address")
+	}
+}
+
+func TestSyncStateReload(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	path := filepath.Join(t.TempDir(), "wallet.json")
+	lbtc := network.Regtest.AssetID
+
+	l := newStoredTestWallet(t, esplora, path)
+	receive := testAddresses(t, l, 0, ReceiveBranch, 1)
+	esplora.addTx(t, 101, nil, fakeOutput{receive[0], lbtc, 1000})
+	err := l.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted wallet reuses the stored utxos of unchanged addresses
+	restarted := newStoredTestWallet(t, esplora, path)
+	utxos, err := restarted.GetUtxos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 1 || utxos[0].SatAmt != 1000 || esplora.utxoCalls[receive[0]] != 1 {
+		t.Fatalf("got utxos %+v after %v utxo requests, want the stored utxo", utxos, esplora.utxoCalls[receive[0]])
+	}
+}
+
+func TestSyncConcurrentReads(t *testing.T) {
+	esplora := newFakeEsplora(100)
+	l := newTestWallet(t, esplora, Bip84Derivation)
+	receive := testAddresses(t, l, 0, ReceiveBranch, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			esplora.addTx(t, 0, nil, fakeOutput{receive[0], network.Regtest.AssetID, uint64(1000 + i)})
+			if err := l.Sync(); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := l.GetUtxos(); err != nil {
+				t.Error(err)
+			}
+			if _, err := l.GetBalances(); err != nil {
+				t.Error(err)
+			}
+			if _, err := l.GetAddress(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
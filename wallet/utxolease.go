@@ -0,0 +1,135 @@
+package wallet
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+const (
+	DefaultLeaseTimeout = 10 * time.Minute
+)
+
+// utxoLease holds the inputs locked for a funded transaction until it is broadcast or the lease expires
+type utxoLease struct {
+	outpoints []Outpoint
+	timer     *time.Timer
+}
+
+// utxoLeaser keeps track of the outpoints locked in elementsd for transactions that are not yet broadcast
+type utxoLeaser struct {
+	rpcClient *ElementsdClient
+	timeout   time.Duration
+
+	mu     sync.Mutex
+	leases map[Outpoint]*utxoLease
+}
+
+func newUtxoLeaser(rpcClient *ElementsdClient, timeout time.Duration) *utxoLeaser {
+	return &utxoLeaser{rpcClient: rpcClient, timeout: timeout, leases: make(map[Outpoint]*utxoLease)}
+}
+
+// leaseTx registers the inputs of a funded transaction, they are unlocked automatically after the timeout
+func (u *utxoLeaser) leaseTx(txHex string) error {
+	outpoints, err := txInputOutpoints(txHex)
+	if err != nil {
+		return err
+	}
+	u.lease(outpoints)
+	return nil
+}
+
+// lease registers locked outpoints, they are unlocked automatically after the timeout
+func (u *utxoLeaser) lease(outpoints []Outpoint) {
+	lease := &utxoLease{outpoints: outpoints}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, v := range outpoints {
+		u.leases[v] = lease
+	}
+	lease.timer = time.AfterFunc(u.timeout, func() {
+		log.Printf("utxo lease expired, unlocking %v", outpoints)
+		u.releaseLease(lease, true)
+	})
+}
+
+// release unlocks the leased inputs of a transaction that will not be broadcast
+func (u *utxoLeaser) release(txHex string) error {
+	outpoints, err := txInputOutpoints(txHex)
+	if err != nil {
+		return err
+	}
+	return u.finish(outpoints, true)
+}
+
+// complete forgets the leased inputs of a transaction that has been broadcast, as elementsd spent them
+func (u *utxoLeaser) complete(txHex string) error {
+	outpoints, err := txInputOutpoints(txHex)
+	if err != nil {
+		return err
+	}
+	return u.finish(outpoints, false)
+}
+
+func (u *utxoLeaser) finish(outpoints []Outpoint, unlock bool) error {
+	var err error
+	leases := make(map[*utxoLease]struct{})
+	u.mu.Lock()
+	for _, v := range outpoints {
+		if lease, ok := u.leases[v]; ok {
+			leases[lease] = struct{}{}
+		}
+	}
+	u.mu.Unlock()
+
+	for lease := range leases {
+		err = u.releaseLease(lease, unlock)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *utxoLeaser) releaseLease(lease *utxoLease, unlock bool) error {
+	u.mu.Lock()
+	var outpoints []Outpoint
+	for _, v := range lease.outpoints {
+		if u.leases[v] == lease {
+			delete(u.leases, v)
+			outpoints = append(outpoints, v)
+		}
+	}
+	lease.timer.Stop()
+	u.mu.Unlock()
+
+	if !unlock || len(outpoints) == 0 {
+		return nil
+	}
+	err := u.rpcClient.LockUnspent(true, outpoints)
+	if err != nil {
+		log.Printf("unable to unlock %v: %v", outpoints, err)
+		return err
+	}
+	return nil
+}
+
+// txInputOutpoints returns the outpoints spent by a transaction
+func txInputOutpoints(txHex string) ([]Outpoint, error) {
+	tx, err := transaction.NewTxFromHex(txHex)
+	if err != nil {
+		return nil, err
+	}
+	var outpoints []Outpoint
+	for _, v := range tx.Inputs {
+		outpoints = append(outpoints, Outpoint{
+			TxId: b2h(elementsutil.ReverseBytes(v.Hash)),
+			Vout: v.Index,
+		})
+	}
+	return outpoints, nil
+}
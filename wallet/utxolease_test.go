@@ -0,0 +1,179 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vulpemventures/go-elements/elementsutil"
+	"github.com/vulpemventures/go-elements/transaction"
+)
+
+// newLockRpc returns a fake rpc that records the unlocked outpoints
+func newLockRpc() (*fakeRpc, func() []Outpoint) {
+	var mu sync.Mutex
+	var unlocked []Outpoint
+	rpc := &fakeRpc{handlers: map[string]func(params []json.RawMessage) (interface{}, error){
+		"lockunspent": func(params []json.RawMessage) (interface{}, error) {
+			var unlock bool
+			var outpoints []Outpoint
+			json.Unmarshal(params[0], &unlock)
+			json.Unmarshal(params[1], &outpoints)
+			if unlock {
+				mu.Lock()
+				unlocked = append(unlocked, outpoints...)
+				mu.Unlock()
+			}
+			return true, nil
+		},
+	}}
+	return rpc, func() []Outpoint {
+		mu.Lock()
+		defer mu.Unlock()
+		var res []Outpoint
+		res = append(res, unlocked...)
+		sort.Slice(res, func(i, j int) bool {
+			return res[i].String() < res[j].String()
+		})
+		return res
+	}
+}
+
+// testOutpoint returns a distinct outpoint for every index
+func testOutpoint(i int) Outpoint {
+	return Outpoint{TxId: fmt.Sprintf("%064x", i+1), Vout: uint32(i % 3)}
+}
+
+// newTestTxHex returns a transaction spending the outpoints
+func newTestTxHex(t *testing.T, outpoints ...Outpoint) string {
+	tx := transaction.NewTx(2)
+	for _, v := range outpoints {
+		hash, err := hex.DecodeString(v.TxId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.AddInput(transaction.NewTxInput(elementsutil.ReverseBytes(hash), v.Vout))
+	}
+	txHex, err := tx.ToHex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return txHex
+}
+
+func (u *utxoLeaser) leaseCount() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.leases)
+}
+
+func TestUtxoLeaserExpiry(t *testing.T) {
+	rpc, unlocked := newLockRpc()
+	leaser := newUtxoLeaser(newFakeRpcClient(t, rpc), 50*time.Millisecond)
+	expiring := []Outpoint{testOutpoint(0), testOutpoint(1)}
+	completed := []Outpoint{testOutpoint(2)}
+
+	err := leaser.leaseTx(newTestTxHex(t, expiring...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	completedTx := newTestTxHex(t, completed...)
+	err = leaser.leaseTx(completedTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the broadcast transaction is not unlocked when its lease would have expired
+	err = leaser.complete(completedTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for leaser.leaseCount() != 0 || len(unlocked()) != len(expiring) {
+		if time.Now().After(deadline) {
+			t.Fatalf("lease did not expire, unlocked %v", unlocked())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := unlocked(); !reflect.DeepEqual(got, expiring) {
+		t.Fatalf("unlocked %v, want %v", got, expiring)
+	}
+}
+
+func TestUtxoLeaserConcurrent(t *testing.T) {
+	rpc, unlocked := newLockRpc()
+	leaser := newUtxoLeaser(newFakeRpcClient(t, rpc), time.Hour)
+
+	var wg sync.WaitGroup
+	var released []Outpoint
+	for i := 0; i < 20; i++ {
+		outpoints := []Outpoint{testOutpoint(2 * i), testOutpoint(2*i + 1)}
+		if i%2 == 0 {
+			released = append(released, outpoints...)
+		}
+		wg.Add(1)
+		go func(i int, txHex string) {
+			defer wg.Done()
+			if err := leaser.leaseTx(txHex); err != nil {
+				t.Error(err)
+				return
+			}
+			var err error
+			if i%2 == 0 {
+				err = leaser.release(txHex)
+			} else {
+				err = leaser.complete(txHex)
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		}(i, newTestTxHex(t, outpoints...))
+	}
+	wg.Wait()
+
+	if n := leaser.leaseCount(); n != 0 {
+		t.Fatalf("%v outpoints are still leased", n)
+	}
+	sort.Slice(released, func(i, j int) bool {
+		return released[i].String() < released[j].String()
+	})
+	if got := unlocked(); !reflect.DeepEqual(got, released) {
+		t.Fatalf("unlocked %v, want the released outpoints %v", got, released)
+	}
+}
+
+func TestFundAndSignRawTransactionUnlocksUnleasedInputs(t *testing.T) {
+	rpc, unlocked := newLockRpc()
+	input := testOutpoint(0)
+	rpc.handlers["fundrawtransaction"] = func(params []json.RawMessage) (interface{}, error) {
+		var options map[string]bool
+		json.Unmarshal(params[1], &options)
+		if !options["lockUnspents"] {
+			t.Error("fundrawtransaction does not lock the inputs")
+		}
+		// a funded transaction that can not be parsed locally
+		return map[string]interface{}{"hex": "02"}, nil
+	}
+	rpc.handlers["decoderawtransaction"] = func(params []json.RawMessage) (interface{}, error) {
+		return json.RawMessage(`{"txid": "funded", "vin": [{"txid": "` + input.TxId + `", "vout": 0}]}`), nil
+	}
+	rpcClient := newFakeRpcClient(t, rpc)
+	r := &ElementsRpcWallet{rpcClient: rpcClient, leaser: newUtxoLeaser(rpcClient, time.Hour)}
+
+	_, err := r.FundAndSignRawTransaction(newTestTxHex(t))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := unlocked(); !reflect.DeepEqual(got, []Outpoint{input}) {
+		t.Fatalf("unlocked %v, want the funded input %v", got, input)
+	}
+	if rpc.methodCalls("blindrawtransaction") != 0 {
+		t.Fatal("blinded a transaction that was not leased")
+	}
+}
@@ -0,0 +1,280 @@
+package wallet
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/vulpemventures/go-elements/slip77"
+)
+
+var (
+	ErrWatchOnly = errors.New("watch-only wallet is unable to sign transactions")
+)
+
+// CtDescriptor is an elements confidential transaction descriptor of a single account,
+// e.g. ct(slip77(<master blinding key>),elwpkh([d34db33f/84'/1776'/0']xpub.../<0;1>/*))
+type CtDescriptor struct {
+	// MasterBlindingKey is the slip77 master blinding key
+	MasterBlindingKey []byte
+
+	// AccountXpub is the extended public key of the account, the branches are derived from it
+	AccountXpub string
+
+	// KeyOrigin is the [fingerprint/path] of the account key, or empty if unknown
+	KeyOrigin string
+
+	Scheme *DerivationScheme
+}
+
+// ParseCtDescriptor parses a ct descriptor of elwpkh or elsh(wpkh) account keys with a slip77 blinding key.
+// The account key needs to end in /<0;1>/* or, for single branch elwpkh accounts like the legacy layout, in /0/*.
+// A trailing checksum is ignored
+func ParseCtDescriptor(descriptor string) (*CtDescriptor, error) {
+	desc := descriptor
+	if i := strings.Index(desc, "#"); i >= 0 {
+		desc = desc[:i]
+	}
+	desc = strings.TrimSpace(desc)
+	if !strings.HasPrefix(desc, "ct(slip77(") || !strings.HasSuffix(desc, ")") {
+		return nil, fmt.Errorf("unsupported descriptor %s, expected ct(slip77(...),...)", descriptor)
+	}
+	desc = strings.TrimSuffix(strings.TrimPrefix(desc, "ct(slip77("), ")")
+
+	parts := strings.SplitN(desc, "),", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid descriptor %s", descriptor)
+	}
+	masterBlindingKey, err := hex.DecodeString(parts[0])
+	if err != nil || len(masterBlindingKey) != 32 {
+		return nil, fmt.Errorf("invalid slip77 master blinding key %s", parts[0])
+	}
+
+	var scheme *DerivationScheme
+	var key string
+	switch script := parts[1]; {
+	case strings.HasPrefix(script, "elwpkh(") && strings.HasSuffix(script, ")"):
+		scheme = Bip84Derivation
+		key = strings.TrimSuffix(strings.TrimPrefix(script, "elwpkh("), ")")
+	case strings.HasPrefix(script, "elsh(wpkh(") && strings.HasSuffix(script, "))"):
+		scheme = Bip49Derivation
+		key = strings.TrimSuffix(strings.TrimPrefix(script, "elsh(wpkh("), "))")
+	default:
+		return nil, fmt.Errorf("unsupported descriptor script %s, expected elwpkh or elsh(wpkh)", script)
+	}
+
+	// the key origin lets signers find their keys, the account key is used as is
+	var origin string
+	if strings.HasPrefix(key, "[") {
+		i := strings.Index(key, "]")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid key origin %s", key)
+		}
+		origin = key[:i+1]
+		if _, err := parseKeyOrigin(origin); err != nil {
+			return nil, err
+		}
+		key = key[i+1:]
+	}
+	var accountXpub string
+	switch {
+	case strings.HasSuffix(key, "/<0;1>/*"):
+		accountXpub = strings.TrimSuffix(key, "/<0;1>/*")
+	case strings.HasSuffix(key, "/0/*") && scheme == Bip84Derivation:
+		// a single receive branch that also receives the change
+		accountXpub = strings.TrimSuffix(key, "/0/*")
+		scheme = LegacyDerivation
+	default:
+		return nil, fmt.Errorf("unsupported key derivation %s, expected /<0;1>/* or /0/*", key)
+	}
+	accountKey, err := hdkeychain.NewKeyFromString(accountXpub)
+	if err != nil {
+		return nil, err
+	}
+	if accountKey.IsPrivate() {
+		return nil, errors.New("descriptor contains a private key, expected an xpub")
+	}
+
+	return &CtDescriptor{
+		MasterBlindingKey: masterBlindingKey,
+		AccountXpub:       accountXpub,
+		KeyOrigin:         origin,
+		Scheme:            scheme,
+	}, nil
+}
+
+func (d *CtDescriptor) String() string {
+	return formatCtDescriptor(d.MasterBlindingKey, d.AccountXpub, d.KeyOrigin, d.Scheme)
+}
+
+func formatCtDescriptor(masterBlindingKey []byte, accountXpub string, keyOrigin string, scheme *DerivationScheme) string {
+	// the legacy layout has no change branch
+	branches := "<0;1>"
+	if scheme.isLegacy() {
+		branches = "0"
+	}
+	key := fmt.Sprintf("%s%s/%s/*", keyOrigin, accountXpub, branches)
+	if scheme.AddressType == P2shP2wpkhAddress {
+		return fmt.Sprintf("ct(slip77(%s),elsh(wpkh(%s)))", b2h(masterBlindingKey), key)
+	}
+	return fmt.Sprintf("ct(slip77(%s),elwpkh(%s))", b2h(masterBlindingKey), key)
+}
+
+// InitializeWatchOnly initializes the wallet from the xpub of an account and the slip77 master blinding key.
+// The wallet tracks the balance and addresses of the account, but is unable to sign
+func (l *LiquidWallet) InitializeWatchOnly(accountXpub string, masterBlindingKey []byte) error {
+	accountKey, err := hdkeychain.NewKeyFromString(accountXpub)
+	if err != nil {
+		return err
+	}
+	// never hold private keys in a watch-only wallet
+	accountKey, err = accountKey.Neuter()
+	if err != nil {
+		return err
+	}
+	l.watchOnlyKey = accountKey
+
+	l.masterBlindingKey, err = slip77.FromMasterKey(masterBlindingKey)
+	if err != nil {
+		return err
+	}
+
+	err = l.loadSyncState()
+	if err != nil {
+		return err
+	}
+	return l.Sync()
+}
+
+// InitializeFromDescriptor initializes a watch-only wallet from a ct descriptor, the derivation scheme
+// is set by the descriptor script
+func (l *LiquidWallet) InitializeFromDescriptor(descriptor string) error {
+	desc, err := ParseCtDescriptor(descriptor)
+	if err != nil {
+		return err
+	}
+	l.SetDerivationScheme(desc.Scheme)
+	if desc.KeyOrigin != "" {
+		l.watchOnlyOrigin, err = parseKeyOrigin(desc.KeyOrigin)
+		if err != nil {
+			return err
+		}
+	}
+	return l.InitializeWatchOnly(desc.AccountXpub, desc.MasterBlindingKey)
+}
+
+// IsWatchOnly returns true if the wallet only holds the public keys of an account
+func (l *LiquidWallet) IsWatchOnly() bool {
+	return l.masterKey == nil && l.watchOnlyKey != nil
+}
+
+// GetDescriptor returns the ct descriptor of the first account, which initializes a watch-only copy of the wallet
+func (l *LiquidWallet) GetDescriptor() (string, error) {
+	if l.masterBlindingKey == nil {
+		return "", errors.New("wallet is not initialized")
+	}
+	accountKey, err := l.getAccountKey(0)
+	if err != nil {
+		return "", err
+	}
+	accountXpub, err := accountKey.Neuter()
+	if err != nil {
+		return "", err
+	}
+	origin, err := l.accountOrigin(0)
+	if err != nil {
+		return "", err
+	}
+	return formatCtDescriptor(l.masterBlindingKey.MasterKey, accountXpub.String(), origin.String(), l.scheme), nil
+}
+
+// keyOrigin is the master key fingerprint and derivation path of a key
+type keyOrigin struct {
+	fingerprint []byte
+	path        []uint32
+}
+
+// String formats the key origin as in descriptors, [fingerprint/path]
+func (k *keyOrigin) String() string {
+	if k == nil {
+		return ""
+	}
+	origin := b2h(k.fingerprint)
+	for _, v := range k.path {
+		if v >= hdkeychain.HardenedKeyStart {
+			origin += fmt.Sprintf("/%v'", v-hdkeychain.HardenedKeyStart)
+		} else {
+			origin += fmt.Sprintf("/%v", v)
+		}
+	}
+	return "[" + origin + "]"
+}
+
+// psbtFingerprint returns the fingerprint in the byte order of pset bip32 derivations
+func (k *keyOrigin) psbtFingerprint() uint32 {
+	return binary.LittleEndian.Uint32(k.fingerprint)
+}
+
+// child returns the origin of a key derived from the key
+func (k *keyOrigin) child(path ...uint32) *keyOrigin {
+	return &keyOrigin{
+		fingerprint: k.fingerprint,
+		path:        append(append([]uint32{}, k.path...), path...),
+	}
+}
+
+// parseKeyOrigin parses a descriptor key origin, hardened steps are marked with ' or h
+func parseKeyOrigin(str string) (*keyOrigin, error) {
+	if !strings.HasPrefix(str, "[") || !strings.HasSuffix(str, "]") {
+		return nil, fmt.Errorf("invalid key origin %s", str)
+	}
+	parts := strings.Split(strings.Trim(str, "[]"), "/")
+	fingerprint, err := hex.DecodeString(parts[0])
+	if err != nil || len(fingerprint) != 4 {
+		return nil, fmt.Errorf("invalid key origin fingerprint %s", parts[0])
+	}
+	origin := &keyOrigin{fingerprint: fingerprint}
+	for _, v := range parts[1:] {
+		var offset uint32
+		if strings.HasSuffix(v, "'") || strings.HasSuffix(v, "h") {
+			offset = hdkeychain.HardenedKeyStart
+			v = v[:len(v)-1]
+		}
+		index, err := strconv.ParseUint(v, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key origin path %s", str)
+		}
+		origin.path = append(origin.path, uint32(index)+offset)
+	}
+	return origin, nil
+}
+
+// masterFingerprint returns the fingerprint of the master key
+func (l *LiquidWallet) masterFingerprint() ([]byte, error) {
+	masterPubkey, err := l.masterKey.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	return btcutil.Hash160(masterPubkey.SerializeCompressed())[:4], nil
+}
+
+// accountOrigin returns the key origin of an account, which is nil for watch-only wallets without origin
+func (l *LiquidWallet) accountOrigin(account uint32) (*keyOrigin, error) {
+	if l.IsWatchOnly() {
+		return l.watchOnlyOrigin, nil
+	}
+	fingerprint, err := l.masterFingerprint()
+	if err != nil {
+		return nil, err
+	}
+	path, err := l.scheme.accountPath(l.liquidNetwork, account)
+	if err != nil {
+		return nil, err
+	}
+	return &keyOrigin{fingerprint: fingerprint, path: path}, nil
+}
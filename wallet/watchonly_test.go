@@ -0,0 +1,112 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/sputn1ck/liquid-go-lightwallet/asset"
+	"github.com/vulpemventures/go-elements/network"
+)
+
+func TestParseCtDescriptor(t *testing.T) {
+	blindingKey := "d2612f69d42e5381baa1566d6a79286008e1210e40bb575b667e56164734f201"
+	xpub := "tpubDDTdc5jr92Ukn6u2tNodPXWqdEHBxDjpwzsbdpNdJXXMDKU9EDhGuh1v4kQNvuhLgoVPHFfdTrWfeGMPgtaiuAFNfHytEjSvMHMCvft9Ap8"
+	tests := []struct {
+		descriptor string
+		scheme     *DerivationScheme
+		valid      bool
+	}{
+		{"ct(slip77(" + blindingKey + "),elwpkh(" + xpub + "/<0;1>/*))", Bip84Derivation, true},
+		{"ct(slip77(" + blindingKey + "),elwpkh([729c0d85/84'/1'/0']" + xpub + "/<0;1>/*))#abcdefgh", Bip84Derivation, true},
+		{"ct(slip77(" + blindingKey + "),elwpkh(" + xpub + "/0/*))", LegacyDerivation, true},
+		{"ct(slip77(" + blindingKey + "),elsh(wpkh(" + xpub + "/<0;1>/*)))", Bip49Derivation, true},
+		{"ct(slip77(" + blindingKey + "),elsh(wpkh(" + xpub + "/0/*)))", nil, false},
+		{"ct(slip77(" + blindingKey + "),elpkh(" + xpub + "/<0;1>/*))", nil, false},
+		{"ct(slip77(" + blindingKey + "),elwpkh(" + xpub + "/0/1))", nil, false},
+		{"ct(slip77(abcd),elwpkh(" + xpub + "/<0;1>/*))", nil, false},
+		{"elwpkh(" + xpub + "/<0;1>/*)", nil, false},
+	}
+	for _, tt := range tests {
+		desc, err := ParseCtDescriptor(tt.descriptor)
+		if !tt.valid {
+			if err == nil {
+				t.Fatalf("ParseCtDescriptor(%s) should fail", tt.descriptor)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ParseCtDescriptor(%s): %v", tt.descriptor, err)
+		}
+		if desc.Scheme != tt.scheme || desc.AccountXpub != xpub || b2h(desc.MasterBlindingKey) != blindingKey {
+			t.Fatalf("ParseCtDescriptor(%s) = %+v", tt.descriptor, desc)
+		}
+	}
+}
+
+func TestWatchOnlyWallet(t *testing.T) {
+	for _, scheme := range []*DerivationScheme{LegacyDerivation, Bip84Derivation, Bip49Derivation} {
+		esplora := newFakeEsplora(100)
+		seeded := newTestWallet(t, esplora, scheme)
+		lbtc := network.Regtest.AssetID
+		receive := testAddresses(t, seeded, 0, ReceiveBranch, 2)
+		confAddress, err := seeded.deriveConfidentialAddress(0, ReceiveBranch, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		esplora.addTx(t, 101, nil, fakeOutput{receive[0], lbtc, 100000}, fakeOutput{receive[1], testAssetId, 5000})
+		esplora.addTx(t, 102, nil, fakeOutput{confAddress, lbtc, 20000})
+
+		descriptor, err := seeded.GetDescriptor()
+		if err != nil {
+			t.Fatal(err)
+		}
+		watchOnly := NewLiquidWallet(esplora, testChainParams(), &network.Regtest)
+		watchOnly.SetGapLimit(5)
+		err = watchOnly.InitializeFromDescriptor(descriptor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !watchOnly.IsWatchOnly() || watchOnly.scheme != scheme {
+			t.Fatalf("%s: descriptor %s initialized a %s wallet", scheme.Name, descriptor, watchOnly.scheme.Name)
+		}
+
+		// the watch-only wallet derives the same addresses and unblinds the same utxos
+		for _, getAddress := range []func(l *LiquidWallet) (string, error){
+			(*LiquidWallet).GetAddress,
+			(*LiquidWallet).GetConfidentialAddress,
+		} {
+			want, err := getAddress(seeded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := getAddress(watchOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Fatalf("%s: watch-only address %s, want %s", scheme.Name, got, want)
+			}
+		}
+		want, err := seeded.GetBalances()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := watchOnly.GetBalances()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) || got[lbtc] != 120000 || got[testAssetId] != 5000 {
+			t.Fatalf("%s: watch-only balances %v, want %v", scheme.Name, got, want)
+		}
+
+		_, err = watchOnly.SendToAddress(externalAddress(t), asset.NewAssetAmount(lbtc, 8, 1000))
+		if !errors.Is(err, ErrWatchOnly) {
+			t.Fatalf("%s: got %v, want ErrWatchOnly", scheme.Name, err)
+		}
+		_, err = watchOnly.SendAllToAddress(externalAddress(t), lbtc)
+		if !errors.Is(err, ErrWatchOnly) {
+			t.Fatalf("%s: got %v, want ErrWatchOnly", scheme.Name, err)
+		}
+	}
+}